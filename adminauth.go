@@ -0,0 +1,61 @@
+package courier
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// hasAdminCredentials returns whether the passed in request has one of our admin credentials, either our AdminToken as
+// a bearer token or our StatusUsername and StatusPassword with basic auth
+func (s *server) hasAdminCredentials(r *http.Request) bool {
+	if s.config.AdminToken != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") && secretsEqual(strings.TrimPrefix(header, "Bearer "), s.config.AdminToken) {
+			return true
+		}
+	}
+
+	if s.config.StatusUsername != "" {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			// compare both so how long we take doesn't give away which was wrong
+			userMatches := secretsEqual(user, s.config.StatusUsername)
+			passMatches := secretsEqual(pass, s.config.StatusPassword)
+			if userMatches && passMatches {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requireAdminAuth wraps the passed in handler, responding with a 401 to any request without our admin credentials
+// if we have an AdminToken. Without one, only the pages which have always needed our status credentials check for them,
+// so that health checks made without them keep working.
+func (s *server) requireAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" && !s.hasAdminCredentials(r) {
+			s.writeAdminUnauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writes a 401 asking for whichever of our admin credentials we have
+func (s *server) writeAdminUnauthorized(w http.ResponseWriter) {
+	if s.config.StatusUsername != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Authenticate"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Authenticate"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Unauthorised.\n"))
+}
+
+// compares the passed in secrets in constant time
+func secretsEqual(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
@@ -0,0 +1,89 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuth(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{}))
+
+	// our admin pages share our main port with our channel endpoints
+	config := NewConfig()
+	config.EnableMetrics = true
+	config.EnablePprof = true
+	config.AdminToken = "sesame"
+	s := NewServerWithLogger(config, mb, logrus.New()).(*server)
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "nothing to do")
+	})
+
+	request := func(method string, url string, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader("text=hello"))
+		if auth != nil {
+			auth(r)
+		}
+		s.httpServer.Handler.ServeHTTP(w, r)
+		return w
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+
+	adminPages := []string{"/status", "/health", "/ready", "/channels", "/spool", "/metrics", "/debug/pprof/"}
+
+	// our admin pages need our token
+	for _, page := range adminPages {
+		w := request("GET", page, nil)
+		assert.Equal(t, 401, w.Code, "%s should need our token", page)
+		assert.Equal(t, `Bearer realm="Authenticate"`, w.Header().Get("WWW-Authenticate"))
+
+		assert.Equal(t, 401, request("GET", page, bearer("open")).Code, "%s should need the right token", page)
+		assert.Equal(t, 401, request("GET", page, bearer("sesam")).Code, "%s should need the whole token", page)
+		assert.Equal(t, 401, request("GET", page, basic("admin", "sesame")).Code, "%s should need the token as a bearer token", page)
+		assert.Equal(t, 200, request("GET", page, bearer("sesame")).Code, "%s should be served with our token", page)
+	}
+	assert.Equal(t, 401, request("POST", "/spool/flush", nil).Code)
+	assert.Equal(t, 401, request("DELETE", "/spool?older_than=24h", nil).Code)
+
+	// but our index and channel endpoints don't
+	assert.Equal(t, 200, request("GET", "/", nil).Code)
+	assert.Equal(t, 200, request("POST", "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil).Code)
+
+	// with status credentials, basic auth works too
+	s.config.StatusUsername = "admin"
+	s.config.StatusPassword = "letmein"
+	assert.Equal(t, 200, request("GET", "/health", basic("admin", "letmein")).Code)
+	assert.Equal(t, 200, request("GET", "/health", bearer("sesame")).Code)
+	assert.Equal(t, 401, request("GET", "/health", basic("admin", "sesame")).Code)
+	assert.Equal(t, 401, request("GET", "/health", basic("root", "letmein")).Code)
+	assert.Equal(t, `Basic realm="Authenticate"`, request("GET", "/health", nil).Header().Get("WWW-Authenticate"))
+
+	// without a token, only the pages which have always needed our status credentials need them
+	s.config.AdminToken = ""
+	assert.Equal(t, 200, request("GET", "/health", nil).Code)
+	assert.Equal(t, 200, request("GET", "/debug/pprof/", nil).Code)
+	assert.Equal(t, 401, request("GET", "/status", nil).Code)
+	assert.Equal(t, 401, request("GET", "/spool", nil).Code)
+	assert.Equal(t, 200, request("GET", "/status", basic("admin", "letmein")).Code)
+
+	// and with neither our admin pages are open
+	s.config.StatusUsername = ""
+	assert.Equal(t, 200, request("GET", "/status", nil).Code)
+	assert.Equal(t, 200, request("GET", "/spool", nil).Code)
+}
@@ -0,0 +1,119 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigAllowedIPs is a list of CIDRs (or single IPs) that requests to a channel's endpoints must come from
+const ConfigAllowedIPs = "allowed_ips"
+
+// parseCIDRs parses the passed in list of CIDRs, single IPs are treated as a network of just that address
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func networksContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// savePeerAddr is middleware which saves the address of the connecting peer in the request context before
+// anything has a chance to rewrite it from proxy headers
+func savePeerAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextPeerAddr, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP returns the IP address the passed in request came from. X-Forwarded-For is only honored when the
+// connecting peer is one of our trusted proxies, in which case we use the last address which isn't also a proxy.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	peerAddr, _ := r.Context().Value(contextPeerAddr).(string)
+	if peerAddr == "" {
+		peerAddr = r.RemoteAddr
+	}
+
+	host, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		host = peerAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !networksContain(trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fwdIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fwdIP == nil {
+			break
+		}
+		ip = fwdIP
+		if !networksContain(trustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// checkAllowedIP returns an error if the passed in channel has an IP allowlist which the request isn't from
+func (s *server) checkAllowedIP(channel Channel, r *http.Request) error {
+	// some handlers don't have a channel for every request, e.g. webhook verification
+	if channel == nil {
+		return nil
+	}
+
+	allowed := configStringList(channel, ConfigAllowedIPs)
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	nets, err := parseCIDRs(allowed)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("invalid channel IP allowlist")
+		return fmt.Errorf("invalid IP allowlist for channel")
+	}
+
+	ip := clientIP(r, s.trustedProxies)
+	if ip == nil || !networksContain(nets, ip) {
+		return fmt.Errorf("requests not allowed from %s", ip)
+	}
+	return nil
+}
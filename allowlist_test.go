@@ -0,0 +1,77 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type allowlistHandler struct {
+	dummyHandler
+	channel Channel
+}
+
+func (h *allowlistHandler) ChannelType() ChannelType { return ChannelType("AL") }
+
+func (h *allowlistHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
+	return h.channel, nil
+}
+
+func TestIPAllowlist(t *testing.T) {
+	config := NewConfig()
+	config.TrustedProxies = "10.0.0.0/8, 192.168.1.1"
+	s := NewServer(config, NewMockBackend()).(*server)
+
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "AL", "2020", "US", map[string]interface{}{})
+	handler := &allowlistHandler{channel: channel}
+	s.AddHandlerRoute(handler, http.MethodGet, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		w.Write([]byte("ok"))
+		return nil, nil
+	})
+
+	request := func(remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/c/al/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	// no allowlist, everything goes through
+	assert.Equal(t, 200, request("1.2.3.4:5000", "").Code)
+	assert.Equal(t, 200, request("10.1.1.1:5000", "5.6.7.8").Code)
+
+	channel.SetConfig(ConfigAllowedIPs, []interface{}{"1.2.3.0/24", "2001:db8::/32"})
+
+	// direct connections
+	assert.Equal(t, 200, request("1.2.3.4:5000", "").Code)
+	assert.Equal(t, 200, request("[2001:db8::1]:5000", "").Code)
+	w := request("5.6.7.8:5000", "")
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), "requests not allowed from 5.6.7.8")
+
+	// forwarded addresses are only honored from trusted proxies
+	assert.Equal(t, 403, request("5.6.7.8:5000", "1.2.3.4").Code)
+	assert.Equal(t, 200, request("10.1.1.1:5000", "1.2.3.4").Code)
+	assert.Equal(t, 200, request("192.168.1.1:5000", "1.2.3.4, 10.2.2.2").Code)
+	assert.Equal(t, 403, request("10.1.1.1:5000", "5.6.7.8").Code)
+	assert.Equal(t, 403, request("192.168.1.2:5000", "1.2.3.4").Code)
+
+	// spoofed addresses before an untrusted hop are ignored
+	assert.Equal(t, 403, request("10.1.1.1:5000", "1.2.3.4, 5.6.7.8").Code)
+
+	// a single string works too
+	channel.SetConfig(ConfigAllowedIPs, "5.6.7.8")
+	assert.Equal(t, 200, request("5.6.7.8:5000", "").Code)
+	assert.Equal(t, 403, request("1.2.3.4:5000", "").Code)
+
+	// an invalid allowlist rejects everything
+	channel.SetConfig(ConfigAllowedIPs, []interface{}{"not an ip"})
+	assert.Equal(t, 403, request("1.2.3.4:5000", "").Code)
+}
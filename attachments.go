@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// content types providers declare when they don't know what their media is
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/binary":       true,
+	"application/unknown":      true,
+}
+
+// ErrAttachmentTooLarge is returned when an attachment is bigger than the configured MaxAttachmentSize
+var ErrAttachmentTooLarge = errors.New("attachment exceeds maximum size")
+
+// ReadAttachment reads the passed in attachment body, returning ErrAttachmentTooLarge without reading
+// everything if it is bigger than maxSize bytes. A maxSize of 0 means there is no limit.
+func ReadAttachment(body io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	return data, nil
+}
+
+// FetchAndSaveAttachments downloads each of the passed in media URLs and saves them using our backend, returning
+// the attachments (in the format content-type:url) to add to the msg. Media which can't be fetched or which is
+// bigger than our MaxAttachmentSize is logged and skipped.
+func FetchAndSaveAttachments(ctx context.Context, s Server, channel Channel, mediaURLs []string) []string {
+	attachments := make([]string, 0, len(mediaURLs))
+	for _, mediaURL := range mediaURLs {
+		attachment, err := fetchAndSaveAttachment(ctx, s, channel, mediaURL)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("media_url", mediaURL).Error("unable to save attachment, skipping")
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+func fetchAndSaveAttachment(ctx context.Context, s Server, channel Channel, mediaURL string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// download through our backend so we share its limit on how many downloads are in flight at once
+	resp, data, err := s.Backend().FetchMedia(ctx, req, s.Config().MaxAttachmentSize)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("received non 200 status: %d", resp.StatusCode)
+	}
+
+	contentType := attachmentContentType(resp.Header.Get("Content-Type"), data)
+
+	url, err := s.Backend().SaveAttachment(ctx, channel, contentType, data)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", contentType, url), nil
+}
+
+// attachmentContentType returns the content type to save the passed in attachment data with. The type declared by our
+// provider is used if it is specific and plausible, otherwise we sniff it from the first 512 bytes of the data. The
+// declared type is implausible if sniffing finds the data is a different kind of media, e.g. an image declared as audio.
+func attachmentContentType(declared string, data []byte) string {
+	declared, _, _ = mime.ParseMediaType(declared)
+	declared = strings.ToLower(declared)
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+
+	if declared == "" || genericContentTypes[declared] {
+		return sniffed
+	}
+
+	// sniffing only recognizes some formats, anything else is octet-stream or plain text which tell us nothing
+	if !genericContentTypes[sniffed] && sniffed != "text/plain" && mediaType(sniffed) != mediaType(declared) {
+		return sniffed
+	}
+	return declared
+}
+
+// returns the top level media type of the passed in content type, e.g. image for image/jpeg
+func mediaType(contentType string) string {
+	return strings.SplitN(contentType, "/", 2)[0]
+}
@@ -0,0 +1,116 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAttachment(t *testing.T) {
+	data, err := ReadAttachment(strings.NewReader("0123456789"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	_, err = ReadAttachment(strings.NewReader("0123456789"), 9)
+	assert.Equal(t, ErrAttachmentTooLarge, err)
+
+	data, err = ReadAttachment(strings.NewReader("0123456789"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+}
+
+// the start of a JPEG, enough for it to be sniffed as one
+var jpegData = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+
+func TestAttachmentContentType(t *testing.T) {
+	tcs := []struct {
+		declared    string
+		data        []byte
+		contentType string
+	}{
+		{"image/jpeg", jpegData, "image/jpeg"},
+		{"", jpegData, "image/jpeg"},
+		{"application/octet-stream", jpegData, "image/jpeg"},
+		{"binary/octet-stream; charset=binary", jpegData, "image/jpeg"},
+		{"audio/mpeg", jpegData, "image/jpeg"},
+		{"image/png", jpegData, "image/png"},
+		{"Audio/AMR", []byte("#!AMR\n"), "audio/amr"},
+		{"application/pdf", []byte("some text"), "application/pdf"},
+		{"", []byte("some text"), "text/plain"},
+		{"application/octet-stream", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.contentType, attachmentContentType(tc.declared, tc.data), "content type mismatch for declared %s", tc.declared)
+	}
+}
+
+// fetchCountingBackend counts the media fetched through it
+type fetchCountingBackend struct {
+	*MockBackend
+	fetches int
+}
+
+func (b *fetchCountingBackend) FetchMedia(ctx context.Context, req *http.Request, maxSize int) (*http.Response, []byte, error) {
+	b.fetches++
+	return b.MockBackend.FetchMedia(ctx, req, maxSize)
+}
+
+func TestFetchAndSaveAttachments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("jpegdata"))
+		case "/sniffed":
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("GIF87aandstuff"))
+		case "/octet":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(jpegData)
+		case "/mislabelled.mp3":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write(jpegData)
+		case "/huge.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(bytes.Repeat([]byte("a"), 2048))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.MaxAttachmentSize = 1024
+
+	mb := NewMockBackend()
+	fb := &fetchCountingBackend{MockBackend: mb}
+	s := NewServer(config, fb)
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+
+	attachments := FetchAndSaveAttachments(context.Background(), s, channel, []string{
+		server.URL + "/photo.jpg",
+		server.URL + "/huge.mp4",
+		server.URL + "/missing.png",
+		server.URL + "/sniffed",
+		server.URL + "/octet",
+		server.URL + "/mislabelled.mp3",
+	})
+
+	// oversized and missing media are skipped
+	assert.Equal(t, []string{
+		"image/jpeg:https://backend.com/attachments/1",
+		"image/gif:https://backend.com/attachments/2",
+		"image/jpeg:https://backend.com/attachments/3",
+		"image/jpeg:https://backend.com/attachments/4",
+	}, attachments)
+	assert.Equal(t, [][]byte{[]byte("jpegdata"), []byte("GIF87aandstuff"), jpegData, jpegData}, mb.attachments)
+
+	// and every download goes through our backend, so is limited along with its own
+	assert.Equal(t, 6, fb.fetches)
+}
@@ -3,7 +3,9 @@ package courier
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/nyaruka/gocommon/urns"
@@ -44,6 +46,13 @@ type Backend interface {
 	// WriteMsg writes the passed in message to our backend
 	WriteMsg(context.Context, Msg) error
 
+	// SaveAttachment saves the passed in attachment data to our storage, returning its URL
+	SaveAttachment(ctx context.Context, channel Channel, contentType string, data []byte) (string, error)
+
+	// FetchMedia makes the passed in request for media, returning the response and its body, which can't be bigger than
+	// maxSize bytes. Downloads are limited by MaxConcurrentMediaDownloads and counted in MediaDownloads.
+	FetchMedia(ctx context.Context, req *http.Request, maxSize int) (*http.Response, []byte, error)
+
 	// NewMsgStatusForID creates a new Status object for the given message id
 	NewMsgStatusForID(Channel, MsgID, MsgStatusValue) MsgStatus
 
@@ -53,6 +62,10 @@ type Backend interface {
 	// WriteMsgStatus writes the passed in status update to our backend
 	WriteMsgStatus(context.Context, MsgStatus) error
 
+	// WriteMsgStatuses writes the passed in status updates to our backend in order, as a single batch if possible. If
+	// any updates fail to be written a *MsgStatusesError is returned identifying which ones
+	WriteMsgStatuses(context.Context, []MsgStatus) error
+
 	// NewChannelEvent creates a new channel event for the given channel and event type
 	NewChannelEvent(Channel, ChannelEventType, urns.URN) ChannelEvent
 
@@ -74,20 +87,47 @@ type Backend interface {
 	// implement their own logic to implement this.
 	IsMsgLoop(ctx context.Context, msg Msg) (bool, error)
 
+	// IsURNStopped returns whether the passed in URN has stopped receiving messages on the passed in channel, e.g. because
+	// they texted STOP to it or blocked it. Messages to stopped URNs are failed without being sent.
+	IsURNStopped(context.Context, ChannelUUID, urns.URN) (bool, error)
+
+	// SetURNStopped records whether the passed in URN has stopped receiving messages on the passed in channel
+	SetURNStopped(context.Context, ChannelUUID, urns.URN, bool) error
+
 	// MarkOutgoingMsgComplete marks the passed in message as having been processed. Note this should be called even in the case
 	// of errors during sending as it will manage the number of active workers per channel. The optional status parameter can be
 	// used to determine any sort of deduping of msg sends
 	MarkOutgoingMsgComplete(context.Context, Msg, MsgStatus)
 
+	// RequeueOutgoingMsg puts the passed in message back on the queue to be sent after the passed in delay, this should be
+	// called instead of MarkOutgoingMsgComplete for messages we decide not to send yet
+	RequeueOutgoingMsg(context.Context, Msg, time.Duration) error
+
+	// OutgoingQueueDepth returns the number of outgoing messages waiting to be sent
+	OutgoingQueueDepth(context.Context) (int, error)
+
+	// MediaDownloads returns the number of attachments of incoming messages currently being downloaded
+	MediaDownloads() int
+
+	// GetOutgoingMsgs returns the outgoing messages matching the passed in query, e.g. those which errored so they can be replayed
+	GetOutgoingMsgs(context.Context, *OutgoingMsgQuery) ([]Msg, error)
+
+	// QueueOutgoingMsg puts the passed in outgoing message, which isn't one we popped, onto the queue of messages to send
+	QueueOutgoingMsg(context.Context, Msg) error
+
 	// Check if external ID has been seen in a period
 	CheckExternalIDSeen(Msg) Msg
 
 	// Mark a external ID as seen for a period
 	WriteExternalIDSeen(Msg)
 
-	// Health returns a string describing any health problems the backend has, or empty string if all is well
+	// Health returns a string describing any health problems the backend has, or empty string if all is well. This
+	// should be the result of HealthDetails formatted with FormatHealth
 	Health() string
 
+	// HealthDetails checks each of the services the backend depends on (e.g. redis, database), returning their health by name
+	HealthDetails() map[string]HealthStatus
+
 	// Status returns a string describing the current status, this can detail queue sizes or other attributes
 	Status() string
 
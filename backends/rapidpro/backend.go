@@ -6,10 +6,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/url"
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -18,6 +20,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/garyburd/redigo/redis"
+	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/batch"
@@ -36,6 +39,9 @@ const msgQueueName = "msgs"
 // the name of our set for tracking sends
 const sentSetName = "msgs_sent_%s"
 
+// the name of our set for tracking the URNs which have stopped receiving messages on a channel
+const stoppedSetName = "stopped_urns:%s"
+
 // constants used in org configs for chatbase
 const chatbaseAPIKey = "CHATBASE_API_KEY"
 const chatbaseVersion = "CHATBASE_VERSION"
@@ -44,6 +50,9 @@ const chatbaseMessageType = "agent"
 // our timeout for backend operations
 const backendTimeout = time.Second * 20
 
+// errDBDown is what writes which go straight to our spool because our db is down fail with
+var errDBDown = errors.New("database is down")
+
 // number of messages for loop detection
 const msgLoopThreshold = 20
 
@@ -252,6 +261,34 @@ func (b *backend) IsMsgLoop(ctx context.Context, msg courier.Msg) (bool, error)
 	return false, nil
 }
 
+// IsURNStopped returns whether the passed in URN has stopped receiving messages on the passed in channel
+func (b *backend) IsURNStopped(ctx context.Context, channelUUID courier.ChannelUUID, urn urns.URN) (bool, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	stopped, err := redis.Bool(rc.Do("SISMEMBER", fmt.Sprintf(stoppedSetName, channelUUID), urn.Identity().String()))
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking whether urn is stopped")
+	}
+	return stopped, nil
+}
+
+// SetURNStopped records whether the passed in URN has stopped receiving messages on the passed in channel
+func (b *backend) SetURNStopped(ctx context.Context, channelUUID courier.ChannelUUID, urn urns.URN, stopped bool) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	command := "SREM"
+	if stopped {
+		command = "SADD"
+	}
+	_, err := rc.Do(command, fmt.Sprintf(stoppedSetName, channelUUID), urn.Identity().String())
+	if err != nil {
+		return errors.Wrapf(err, "error setting whether urn is stopped")
+	}
+	return nil
+}
+
 // MarkOutgoingMsgComplete marks the passed in message as having completed processing, freeing up a worker for that channel
 func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, msg courier.Msg, status courier.MsgStatus) {
 	rc := b.redisPool.Get()
@@ -291,6 +328,66 @@ func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, msg courier.Msg,
 	}
 }
 
+// RequeueOutgoingMsg marks the passed in msg as complete for its worker and pushes it back onto its queue, to be
+// popped again after the passed in delay
+func (b *backend) RequeueOutgoingMsg(ctx context.Context, msg courier.Msg, delay time.Duration) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	dbMsg := msg.(*DBMsg)
+
+	err := queue.MarkComplete(rc, msgQueueName, dbMsg.workerToken)
+	if err != nil {
+		return errors.Wrapf(err, "error marking msg complete")
+	}
+
+	return pushOutgoingMsg(rc, dbMsg, delay)
+}
+
+// pushOutgoingMsg pushes the passed in msg onto the queue for its channel, in the format msgs:uuid|tps, which is the
+// same queue RapidPro pushes it onto, to be popped after the passed in delay
+func pushOutgoingMsg(rc redis.Conn, msg *DBMsg, delay time.Duration) error {
+	msgJSON, err := json.Marshal([]interface{}{msg})
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling msg")
+	}
+
+	priority := queue.LowPriority
+	switch msg.Priority() {
+	case courier.MsgPriorityHigh:
+		priority = queue.UrgentPriority
+	case courier.MsgPriorityNormal:
+		priority = queue.HighPriority
+	}
+
+	return queue.PushOntoQueueWithDelay(rc, msgQueueName, msg.channel.UUID().String(), msg.channel.TPS(), string(msgJSON), queue.Priority(priority), delay)
+}
+
+// GetOutgoingMsgs returns the outgoing msgs in our database matching the passed in query
+func (b *backend) GetOutgoingMsgs(ctx context.Context, query *courier.OutgoingMsgQuery) ([]courier.Msg, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	dbMsgs, err := loadOutgoingMsgsFromDB(timeout, b, query)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, len(dbMsgs))
+	for i := range dbMsgs {
+		msgs[i] = dbMsgs[i]
+	}
+	return msgs, nil
+}
+
+// QueueOutgoingMsg pushes the passed in msg onto the queue for its channel
+func (b *backend) QueueOutgoingMsg(ctx context.Context, msg courier.Msg) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return pushOutgoingMsg(rc, msg.(*DBMsg), 0)
+}
+
 // WriteMsg writes the passed in message to our store
 func (b *backend) WriteMsg(ctx context.Context, m courier.Msg) error {
 	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
@@ -299,6 +396,18 @@ func (b *backend) WriteMsg(ctx context.Context, m courier.Msg) error {
 	return writeMsg(timeout, b, m)
 }
 
+// SaveAttachment saves the passed in attachment to S3, returning its URL
+func (b *backend) SaveAttachment(ctx context.Context, channel courier.Channel, contentType string, data []byte) (string, error) {
+	extension := ""
+	extensions, err := mime.ExtensionsByType(contentType)
+	if err == nil && len(extensions) > 0 {
+		extension = extensions[0][1:]
+	}
+
+	name, _ := uuid.NewV4()
+	return storeAttachment(b, channel.(*DBChannel).OrgID(), name.String(), extension, contentType, data)
+}
+
 // NewStatusUpdateForID creates a new Status object for the given message id
 func (b *backend) NewMsgStatusForID(channel courier.Channel, id courier.MsgID, status courier.MsgStatusValue) courier.MsgStatus {
 	return newMsgStatus(channel, id, "", status)
@@ -351,6 +460,12 @@ func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus)
 	return nil
 }
 
+// WriteMsgStatuses writes the passed in MsgStatuses to our store in order. Those with msg IDs are committed in
+// batches by our status committer, the rest have to be looked up by external ID and are written one by one.
+func (b *backend) WriteMsgStatuses(ctx context.Context, statuses []courier.MsgStatus) error {
+	return courier.WriteMsgStatusesSequentially(ctx, b, statuses)
+}
+
 // updateContactURN updates contact URN according to the old/new URNs from status
 func (b *backend) updateContactURN(ctx context.Context, status courier.MsgStatus) error {
 	old, new := status.UpdatedURN()
@@ -455,64 +570,98 @@ func (b *backend) WriteExternalIDSeen(msg courier.Msg) {
 
 // Health returns the health of this backend as a string, returning "" if all is well
 func (b *backend) Health() string {
-	// test redis
-	rc := b.redisPool.Get()
-	defer rc.Close()
-	_, redisErr := rc.Do("PING")
+	return courier.FormatHealth(b.HealthDetails())
+}
 
-	// test our db
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	dbErr := b.db.PingContext(ctx)
-	cancel()
+// HealthDetails checks our connections to redis and the database
+func (b *backend) HealthDetails() map[string]courier.HealthStatus {
+	redisHealth := courier.CheckHealthOf("redis", func() error {
+		rc := b.redisPool.Get()
+		defer rc.Close()
+		_, err := rc.Do("PING")
+		return err
+	})
 
-	health := bytes.Buffer{}
-	if redisErr != nil {
-		health.WriteString(fmt.Sprintf("\n% 16s: %v", "redis err", redisErr))
-	}
-	if dbErr != nil {
-		health.WriteString(fmt.Sprintf("\n% 16s: %v", "db err", dbErr))
-	}
+	dbHealth := courier.CheckHealthOf("database", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return b.db.PingContext(ctx)
+	})
 
-	return health.String()
+	return map[string]courier.HealthStatus{
+		"redis":           redisHealth,
+		"database":        dbHealth,
+		"database_writes": b.dbMonitor.Health(),
+	}
 }
 
-// Heartbeat is called every minute, we log our queue depth to librato
-func (b *backend) Heartbeat() error {
+// OutgoingQueueDepth returns the number of msgs waiting to be sent across all our queues
+func (b *backend) OutgoingQueueDepth(ctx context.Context) (int, error) {
 	rc := b.redisPool.Get()
 	defer rc.Close()
 
+	urgentSize, prioritySize, bulkSize, err := outgoingQueueSizes(rc)
+	return urgentSize + prioritySize + bulkSize, err
+}
+
+// MediaDownloads returns the number of attachments of incoming msgs currently being downloaded
+func (b *backend) MediaDownloads() int {
+	return int(atomic.LoadInt64(&b.mediaDownloads))
+}
+
+// returns the number of msgs waiting to be sent in our urgent, priority and bulk queues
+func outgoingQueueSizes(rc redis.Conn) (urgentSize int, prioritySize int, bulkSize int, err error) {
 	active, err := redis.Strings(rc.Do("zrange", fmt.Sprintf("%s:active", msgQueueName), "0", "-1"))
 	if err != nil {
-		return errors.Wrapf(err, "error getting active queues")
+		return 0, 0, 0, errors.Wrapf(err, "error getting active queues")
 	}
 	throttled, err := redis.Strings(rc.Do("zrange", fmt.Sprintf("%s:throttled", msgQueueName), "0", "-1"))
 	if err != nil {
-		return errors.Wrapf(err, "error getting throttled queues")
+		return 0, 0, 0, errors.Wrapf(err, "error getting throttled queues")
 	}
 	queues := append(active, throttled...)
 
-	prioritySize := 0
-	bulkSize := 0
 	for _, queue := range queues {
-		q := fmt.Sprintf("%s/1", queue)
+		q := fmt.Sprintf("%s/2", queue)
 		count, err := redis.Int(rc.Do("zcard", q))
 		if err != nil {
-			return errors.Wrapf(err, "error getting size of priority queue: %s", q)
+			return 0, 0, 0, errors.Wrapf(err, "error getting size of urgent queue: %s", q)
+		}
+		urgentSize += count
+
+		q = fmt.Sprintf("%s/1", queue)
+		count, err = redis.Int(rc.Do("zcard", q))
+		if err != nil {
+			return 0, 0, 0, errors.Wrapf(err, "error getting size of priority queue: %s", q)
 		}
 		prioritySize += count
 
 		q = fmt.Sprintf("%s/0", queue)
 		count, err = redis.Int(rc.Do("zcard", q))
 		if err != nil {
-			return errors.Wrapf(err, "error getting size of bulk queue: %s", q)
+			return 0, 0, 0, errors.Wrapf(err, "error getting size of bulk queue: %s", q)
 		}
 		bulkSize += count
 	}
+	return urgentSize, prioritySize, bulkSize, nil
+}
+
+// Heartbeat is called every minute, we log our queue depth to librato
+func (b *backend) Heartbeat() error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	urgentSize, prioritySize, bulkSize, err := outgoingQueueSizes(rc)
+	if err != nil {
+		return err
+	}
 
 	// log our total
 	librato.Gauge("courier.bulk_queue", float64(bulkSize))
 	librato.Gauge("courier.priority_queue", float64(prioritySize))
-	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).Info("heartbeat queue sizes calculated")
+	librato.Gauge("courier.urgent_queue", float64(urgentSize))
+	librato.Gauge("courier.media_downloads", float64(b.MediaDownloads()))
+	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).WithField("urgent_queue", urgentSize).Info("heartbeat queue sizes calculated")
 
 	return nil
 }
@@ -524,7 +673,7 @@ func (b *backend) Status() string {
 
 	status := bytes.Buffer{}
 	status.WriteString("------------------------------------------------------------------------------------\n")
-	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
+	status.WriteString("   Urgent |      Size | Bulk Size | Workers | TPS | Type | Channel   \n")
 	status.WriteString("------------------------------------------------------------------------------------\n")
 
 	var queue string
@@ -568,6 +717,12 @@ func (b *backend) Status() string {
 			channelType = channel.ChannelType().String()
 		}
 
+		// get # of items in our urgent queue
+		urgentSize, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/2", msgQueueName, queue)))
+		if err != nil {
+			return fmt.Sprintf("error reading urgent queue size: %v", err)
+		}
+
 		// get # of items in our normal queue
 		size, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/1", msgQueueName, queue)))
 		if err != nil {
@@ -580,7 +735,7 @@ func (b *backend) Status() string {
 			return fmt.Sprintf("error reading bulk queue size: %v", err)
 		}
 
-		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, int(workers), tps, channelType, uuid))
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 9d   % 7d   % 3s   % 4s   %s\n", urgentSize, size, bulkSize, int(workers), tps, channelType, uuid))
 	}
 
 	return status.String()
@@ -626,6 +781,18 @@ func (b *backend) Start() error {
 		log.Info("db ok")
 	}
 
+	// watch our writes to the db, if it goes down they are spooled straight away until we've reconnected, and once we
+	// have what was spooled is flushed without waiting for the next flush
+	b.dbMonitor = courier.NewConnectionMonitor("database_writes", b.config.BackendReconnectThreshold,
+		time.Millisecond*time.Duration(b.config.BackendReconnectBackoff), time.Second*time.Duration(b.config.BackendReconnectMaxBackoff),
+		func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			return b.db.PingContext(ctx)
+		},
+		courier.RequestSpoolFlush,
+	)
+
 	// parse and test our redis config
 	redisURL, err := url.Parse(b.config.Redis)
 	if err != nil {
@@ -713,7 +880,7 @@ func (b *backend) Start() error {
 	}
 
 	// create our status committer and start it
-	b.statusCommitter = batch.NewCommitter("status committer", b.db, bulkUpdateMsgStatusSQL, time.Millisecond*500, b.committerWG,
+	b.statusCommitter = batch.NewCommitter("status committer", b.db, b.statusSQL.bulkUpdate, time.Millisecond*500, b.committerWG,
 		func(err error, value batch.Value) {
 			logrus.WithField("comp", "status committer").WithError(err).Error("error writing status")
 			err = courier.WriteToSpool(b.config.SpoolDir, "statuses", value)
@@ -748,6 +915,9 @@ func (b *backend) Stop() error {
 	// close our stop channel
 	close(b.stopChan)
 
+	// stop trying to reconnect to our db if we are
+	b.dbMonitor.Stop()
+
 	// wait for our threads to exit
 	b.waitGroup.Wait()
 	return nil
@@ -781,9 +951,18 @@ func (b *backend) RedisPool() *redis.Pool {
 
 // NewBackend creates a new RapidPro backend
 func newBackend(config *courier.Config) courier.Backend {
+	// our semaphore for media downloads, nil meaning no limit
+	var mediaSlots chan bool
+	if config.MaxConcurrentMediaDownloads > 0 {
+		mediaSlots = make(chan bool, config.MaxConcurrentMediaDownloads)
+	}
+
 	return &backend{
 		config: config,
 
+		mediaSlots: mediaSlots,
+		statusSQL:  newStatusSQL(courier.ParseStatusPrecedence(config.StatusPrecedence)),
+
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
 
@@ -792,19 +971,26 @@ func newBackend(config *courier.Config) courier.Backend {
 }
 
 type backend struct {
+	// number of media downloads currently in flight, first so it is 64 bit aligned for atomic access
+	mediaDownloads int64
+
 	config *courier.Config
 
+	statusSQL       *statusSQL
 	statusCommitter batch.Committer
 	logCommitter    batch.Committer
 	committerWG     *sync.WaitGroup
 
 	db        *sqlx.DB
+	dbMonitor *courier.ConnectionMonitor
 	redisPool *redis.Pool
 	s3Client  s3iface.S3API
 	awsCreds  *credentials.Credentials
 
 	popScript *redis.Script
 
+	mediaSlots chan bool
+
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -59,6 +60,7 @@ SELECT
 	address, 
 	ch.country as country, 
 	ch.config as config, 
+	ch.tps as tps, 
 	org.config as org_config, 
 	org.is_anon as org_is_anon
 FROM 
@@ -184,6 +186,7 @@ SELECT
        address,
        ch.country as country,
        ch.config as config,
+       ch.tps as tps,
        org.config as org_config,
        org.is_anon as org_is_anon
 FROM
@@ -282,6 +285,7 @@ type DBChannel struct {
 	Address_     sql.NullString      `db:"address"`
 	Country_     sql.NullString      `db:"country"`
 	Config_      utils.NullMap       `db:"config"`
+	TPS_         sql.NullInt64       `db:"tps"`
 
 	OrgConfig_ utils.NullMap `db:"org_config"`
 	OrgIsAnon_ bool          `db:"org_is_anon"`
@@ -325,21 +329,33 @@ func (c *DBChannel) ChannelAddress() courier.ChannelAddress {
 // Country returns the country code for this channel if any
 func (c *DBChannel) Country() string { return c.Country_.String }
 
+// the TPS of a channel's queue if it doesn't have one set, the same default RapidPro queues its msgs with
+const defaultChannelTPS = 10
+
+// TPS returns the max number of msgs per second the queue of msgs for this channel is throttled to
+func (c *DBChannel) TPS() int {
+	if c.TPS_.Valid && c.TPS_.Int64 > 0 {
+		return int(c.TPS_.Int64)
+	}
+	return defaultChannelTPS
+}
+
 // IsScheme returns whether this channel serves only the passed in scheme
 func (c *DBChannel) IsScheme(scheme string) bool {
 	return len(c.Schemes_) == 1 && c.Schemes_[0] == scheme
 }
 
-// ConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+// ConfigForKey returns the config value for the passed in key, falling back to the default config of our
+// handler, or defaultValue if neither has it
 func (c *DBChannel) ConfigForKey(key string, defaultValue interface{}) interface{} {
-	// no value, return our default value
+	// no value, return our handler's default value
 	if !c.Config_.Valid {
-		return defaultValue
+		return courier.DefaultConfigForKey(c.ChannelType_, key, defaultValue)
 	}
 
 	value, found := c.Config_.Map[key]
 	if !found {
-		return defaultValue
+		return courier.DefaultConfigForKey(c.ChannelType_, key, defaultValue)
 	}
 	return value
 }
@@ -378,29 +394,42 @@ func (c *DBChannel) StringConfigForKey(key string, defaultValue string) string {
 	return str
 }
 
-// BoolConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+// BoolConfigForKey returns the config value for the passed in key as a bool, parsing it if it was saved as a string, or defaultValue if it isn't found
 func (c *DBChannel) BoolConfigForKey(key string, defaultValue bool) bool {
 	val := c.ConfigForKey(key, defaultValue)
 	b, isBool := val.(bool)
-	if !isBool {
-		return defaultValue
+	if isBool {
+		return b
 	}
-	return b
+
+	// config set through forms may have saved it as a string
+	str, isStr := val.(string)
+	if isStr {
+		b, err := strconv.ParseBool(strings.TrimSpace(str))
+		if err == nil {
+			return b
+		}
+	}
+	return defaultValue
 }
 
-// IntConfigForKey returns the config value for the passed in key
+// IntConfigForKey returns the config value for the passed in key as an int, parsing it if it was saved as a string, or defaultValue if it isn't found
 func (c *DBChannel) IntConfigForKey(key string, defaultValue int) int {
 	val := c.ConfigForKey(key, defaultValue)
 
-	// golang unmarshals number literals in JSON into float64s by default
-	f, isFloat := val.(float64)
-	if isFloat {
-		return int(f)
+	// golang unmarshals number literals in JSON into float64s by default, but handler defaults may be literal ints
+	switch v := val.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case int64:
+		return int(v)
 	}
 
 	str, isStr := val.(string)
 	if isStr {
-		i, err := strconv.Atoi(str)
+		i, err := strconv.Atoi(strings.TrimSpace(str))
 		if err == nil {
 			return i
 		}
@@ -408,6 +437,17 @@ func (c *DBChannel) IntConfigForKey(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// RequireConfig returns an error for the first of the passed in keys which we have no value for
+func (c *DBChannel) RequireConfig(keys ...string) error {
+	for _, key := range keys {
+		val := c.ConfigForKey(key, nil)
+		if val == nil || val == "" {
+			return &courier.MissingConfigError{ChannelType: c.ChannelType_, Key: key}
+		}
+	}
+	return nil
+}
+
 // supportsScheme returns whether the passed in channel supports the passed in scheme
 func (c *DBChannel) supportsScheme(scheme string) bool {
 	for _, s := range c.Schemes_ {
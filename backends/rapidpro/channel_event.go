@@ -73,12 +73,18 @@ func newChannelEvent(channel courier.Channel, eventType courier.ChannelEventType
 func writeChannelEvent(ctx context.Context, b *backend, event courier.ChannelEvent) error {
 	dbEvent := event.(*DBChannelEvent)
 
-	err := writeChannelEventToDB(ctx, b, dbEvent)
+	// try to write it to our db, unless we know it is down
+	err := errDBDown
+	if b.dbMonitor.Up() {
+		err = writeChannelEventToDB(ctx, b, dbEvent)
+		b.dbMonitor.Record(err)
+
+		if err != nil {
+			logrus.WithError(err).WithField("channel_id", dbEvent.ChannelID).WithField("event_type", dbEvent.EventType_).Error("error writing channel event to db")
+		}
+	}
 
 	// failed writing, write to our spool instead
-	if err != nil {
-		logrus.WithError(err).WithField("channel_id", dbEvent.ChannelID).WithField("event_type", dbEvent.EventType_).Error("error writing channel event to db")
-	}
 
 	if err != nil {
 		err = courier.WriteToSpool(b.config.SpoolDir, "events", dbEvent)
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
@@ -12,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/buger/jsonparser"
@@ -58,12 +58,26 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 		return nil
 	}
 
+	// a msg with the same external id (or content and timestamp) was written within our dedupe window, ignore this one
+	prevUUID := checkMsgWritten(b, m)
+	if prevUUID != courier.NilMsgUUID {
+		m.UUID_ = prevUUID
+		m.alreadyWritten = true
+		return nil
+	}
+
 	channel := m.Channel()
 
 	// if we have media, go download it to S3
 	for i, attachment := range m.Attachments_ {
 		if strings.HasPrefix(attachment, "http") {
 			url, err := downloadMediaToS3(ctx, b, channel, m.OrgID_, m.UUID_, attachment)
+
+			// media that is too big is left as is rather than failing the whole msg
+			if err == courier.ErrAttachmentTooLarge {
+				logrus.WithField("msg", m.UUID().String()).WithField("media_url", attachment).Warn("attachment too large, not downloading")
+				continue
+			}
 			if err != nil {
 				return err
 			}
@@ -71,12 +85,16 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 		}
 	}
 
-	// try to write it our db
-	err := writeMsgToDB(ctx, b, m)
+	// try to write it our db, unless we know it is down
+	err := errDBDown
+	if b.dbMonitor.Up() {
+		err = writeMsgToDB(ctx, b, m)
+		b.dbMonitor.Record(err)
 
-	// fail? log
-	if err != nil {
-		logrus.WithError(err).WithField("msg", m.UUID().String()).Error("error writing to db")
+		// fail? log
+		if err != nil {
+			logrus.WithError(err).WithField("msg", m.UUID().String()).Error("error writing to db")
+		}
 	}
 
 	// if we failed write to spool
@@ -85,6 +103,11 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 	}
 	// mark this msg as having been seen
 	writeMsgSeen(b, m)
+
+	// and if it made it to the db or spool, as written
+	if err == nil {
+		writeMsgWritten(b, m)
+	}
 	return err
 }
 
@@ -193,6 +216,120 @@ WHERE
 	id = $1
 `
 
+const selectOutgoingMsgsSQL = `
+SELECT
+	m.id,
+	COALESCE(m.uuid, '') as uuid,
+	m.org_id,
+	m.text,
+	m.attachments,
+	COALESCE(m.high_priority, FALSE) as high_priority,
+	m.status,
+	m.external_id,
+	m.metadata,
+	m.channel_id,
+	m.contact_id,
+	m.contact_urn_id,
+	m.msg_count,
+	m.error_count,
+	m.created_on,
+	c.uuid as channel_uuid,
+	u.identity as urn,
+	u.auth as urn_auth
+FROM
+	msgs_msg m
+	JOIN channels_channel c ON c.id = m.channel_id
+	JOIN contacts_contacturn u ON u.id = m.contact_urn_id
+WHERE
+	m.direction = 'O' AND
+	m.status = ANY($1) AND
+	((cardinality($2::int[]) > 0 AND m.id = ANY($2)) OR
+	 (cardinality($2::int[]) = 0 AND c.uuid = $3 AND m.created_on >= $4 AND m.created_on < $5))
+ORDER BY
+	m.id
+LIMIT 10000
+`
+
+// outgoingMsgRow is what we select for each msg in selectOutgoingMsgsSQL
+type outgoingMsgRow struct {
+	ID           courier.MsgID          `db:"id"`
+	UUID         string                 `db:"uuid"`
+	OrgID        OrgID                  `db:"org_id"`
+	Text         string                 `db:"text"`
+	Attachments  pq.StringArray         `db:"attachments"`
+	HighPriority bool                   `db:"high_priority"`
+	Status       courier.MsgStatusValue `db:"status"`
+	ExternalID   null.String            `db:"external_id"`
+	Metadata     null.String            `db:"metadata"`
+	ChannelID    courier.ChannelID      `db:"channel_id"`
+	ContactID    ContactID              `db:"contact_id"`
+	ContactURNID ContactURNID           `db:"contact_urn_id"`
+	MessageCount int                    `db:"msg_count"`
+	ErrorCount   int                    `db:"error_count"`
+	CreatedOn    time.Time              `db:"created_on"`
+	ChannelUUID  string                 `db:"channel_uuid"`
+	URN          string                 `db:"urn"`
+	URNAuth      null.String            `db:"urn_auth"`
+}
+
+// loadOutgoingMsgsFromDB loads the outgoing msgs matching the passed in query, along with their channels, so that
+// they can be sent again
+func loadOutgoingMsgsFromDB(ctx context.Context, b *backend, query *courier.OutgoingMsgQuery) ([]*DBMsg, error) {
+	statuses := make([]string, len(query.Statuses))
+	for i, status := range query.Statuses {
+		statuses[i] = string(status)
+	}
+	ids := make([]int64, len(query.IDs))
+	for i, id := range query.IDs {
+		ids[i] = int64(id)
+	}
+
+	rows := make([]*outgoingMsgRow, 0)
+	err := b.db.SelectContext(ctx, &rows, selectOutgoingMsgsSQL, pq.Array(statuses), pq.Array(ids), query.ChannelUUID.String(), query.After, query.Before)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*DBMsg, 0, len(rows))
+	for _, row := range rows {
+		channelUUID, err := courier.NewChannelUUID(row.ChannelUUID)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := getChannel(ctx, b.db, courier.AnyChannelType, channelUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		msg := &DBMsg{
+			OrgID_:        row.OrgID,
+			ID_:           row.ID,
+			UUID_:         courier.NewMsgUUIDFromString(row.UUID),
+			Direction_:    MsgOutgoing,
+			Status_:       row.Status,
+			HighPriority_: row.HighPriority,
+			URN_:          urns.URN(row.URN),
+			URNAuth_:      string(row.URNAuth),
+			Text_:         row.Text,
+			Attachments_:  row.Attachments,
+			ExternalID_:   row.ExternalID,
+			ChannelID_:    row.ChannelID,
+			ContactID_:    row.ContactID,
+			ContactURNID_: row.ContactURNID,
+			MessageCount_: row.MessageCount,
+			ErrorCount_:   row.ErrorCount,
+			ChannelUUID_:  channelUUID,
+			CreatedOn_:    row.CreatedOn,
+			channel:       channel,
+		}
+		if row.Metadata != "" {
+			msg.Metadata_ = json.RawMessage(row.Metadata)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
 // for testing only, returned DBMsg object is not fully populated
 func readMsgFromDB(b *backend, id courier.MsgID) (*DBMsg, error) {
 	m := &DBMsg{
@@ -235,12 +372,7 @@ func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel,
 		}
 	}
 
-	resp, err := utils.GetHTTPClient().Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, body, err := b.FetchMedia(ctx, req, b.config.MaxAttachmentSize)
 	if err != nil {
 		return "", err
 	}
@@ -278,23 +410,55 @@ func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel,
 		}
 	}
 
-	// create our filename
-	filename := msgUUID.String()
+	s3URL, err := storeAttachment(b, orgID, msgUUID.String(), extension, mimeType, body)
+	if err != nil {
+		return "", err
+	}
+
+	// return our new media URL, which is prefixed by our content type
+	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
+}
+
+// storeAttachment writes the passed in attachment data to S3 under our org's media prefix, returning its URL
+func storeAttachment(b *backend, orgID OrgID, name string, extension string, mimeType string, data []byte) (string, error) {
+	filename := name
 	if extension != "" {
-		filename = fmt.Sprintf("%s.%s", msgUUID, extension)
+		filename = fmt.Sprintf("%s.%s", name, extension)
 	}
 	path := filepath.Join(b.config.S3MediaPrefix, strconv.FormatInt(int64(orgID), 10), filename[:4], filename[4:8], filename)
 	if !strings.HasPrefix(path, "/") {
 		path = fmt.Sprintf("/%s", path)
 	}
 
-	s3URL, err := utils.PutS3File(b.s3Client, b.config.S3MediaBucket, path, mimeType, body)
+	return utils.PutS3File(b.s3Client, b.config.S3MediaBucket, path, mimeType, data)
+}
+
+// FetchMedia makes the passed in request and reads the body of the response. Downloads are shared across
+// all channels and limited by MaxConcurrentMediaDownloads, additional downloads wait for a free slot.
+func (b *backend) FetchMedia(ctx context.Context, req *http.Request, maxSize int) (*http.Response, []byte, error) {
+	if b.mediaSlots != nil {
+		select {
+		case b.mediaSlots <- true:
+			defer func() { <-b.mediaSlots }()
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	atomic.AddInt64(&b.mediaDownloads, 1)
+	defer atomic.AddInt64(&b.mediaDownloads, -1)
+
+	resp, err := utils.GetHTTPClient().Do(req.WithContext(ctx))
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
 
-	// return our new media URL, which is prefixed by our content type
-	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
+	body, err := courier.ReadAttachment(resp.Body, maxSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
 }
 
 //-----------------------------------------------------------------------------
@@ -461,6 +625,37 @@ func writeExternalIDSeen(b *backend, msg courier.Msg) {
 	luaWriteExternalIDSeen.Do(r, windowKey, urnFingerprint, uuidText)
 }
 
+// checkMsgWritten returns the UUID of the msg written within our dedupe window with the same dedupe key as the
+// passed in msg, or NilMsgUUID if there isn't one
+func checkMsgWritten(b *backend, msg *DBMsg) courier.MsgUUID {
+	dedupeKey := courier.MsgDedupeKey(msg)
+	if b.config.DedupWindow <= 0 || dedupeKey == "" {
+		return courier.NilMsgUUID
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	found, _ := redis.String(rc.Do("get", fmt.Sprintf("written:msgs:%s", dedupeKey)))
+	if found == "" {
+		return courier.NilMsgUUID
+	}
+	return courier.NewMsgUUIDFromString(found)
+}
+
+// writeMsgWritten records that the passed in msg was written, for the duration of our dedupe window
+func writeMsgWritten(b *backend, msg *DBMsg) {
+	dedupeKey := courier.MsgDedupeKey(msg)
+	if b.config.DedupWindow <= 0 || dedupeKey == "" {
+		return
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	rc.Do("set", fmt.Sprintf("written:msgs:%s", dedupeKey), msg.UUID().String(), "ex", b.config.DedupWindow)
+}
+
 //-----------------------------------------------------------------------------
 // Our implementation of Msg interface
 //-----------------------------------------------------------------------------
@@ -474,6 +669,10 @@ type DBMsg struct {
 	Status_               courier.MsgStatusValue `json:"status"          db:"status"`
 	Visibility_           MsgVisibility          `json:"visibility"      db:"visibility"`
 	HighPriority_         bool                   `json:"high_priority"   db:"high_priority"`
+	Priority_             courier.MsgPriority    `json:"priority"`
+	StatusCallback_       string                 `json:"status_callback,omitempty"`
+	SendAt_               *time.Time             `json:"send_at,omitempty"`
+	ExpiresAt_            *time.Time             `json:"expires_at,omitempty"`
 	URN_                  urns.URN               `json:"urn"`
 	URNAuth_              string                 `json:"urn_auth"`
 	Text_                 string                 `json:"text"            db:"text"`
@@ -482,6 +681,8 @@ type DBMsg struct {
 	ResponseToID_         courier.MsgID          `json:"response_to_id"  db:"response_to_id"`
 	ResponseToExternalID_ string                 `json:"response_to_external_id"`
 	Metadata_             json.RawMessage        `json:"metadata"        db:"metadata"`
+	Location_             *courier.LatLon        `json:"location,omitempty"`
+	ContactCards_         []courier.ContactCard  `json:"contact_cards,omitempty"`
 
 	ChannelID_    courier.ChannelID `json:"channel_id"      db:"channel_id"`
 	ContactID_    ContactID         `json:"contact_id"      db:"contact_id"`
@@ -508,6 +709,7 @@ type DBMsg struct {
 	channel        *DBChannel
 	workerToken    queue.WorkerToken
 	alreadyWritten bool
+	idempotencyKey string
 	quickReplies   []string
 }
 
@@ -517,17 +719,25 @@ func (m *DBMsg) UUID() courier.MsgUUID        { return m.UUID_ }
 func (m *DBMsg) Text() string                 { return m.Text_ }
 func (m *DBMsg) Attachments() []string        { return []string(m.Attachments_) }
 func (m *DBMsg) ExternalID() string           { return string(m.ExternalID_) }
+func (m *DBMsg) AlreadyWritten() bool         { return m.alreadyWritten }
 func (m *DBMsg) URN() urns.URN                { return m.URN_ }
 func (m *DBMsg) URNAuth() string              { return m.URNAuth_ }
 func (m *DBMsg) ContactName() string          { return m.ContactName_ }
-func (m *DBMsg) HighPriority() bool           { return m.HighPriority_ }
+func (m *DBMsg) HighPriority() bool           { return m.Priority() >= courier.MsgPriorityNormal }
+func (m *DBMsg) StatusCallback() string       { return m.StatusCallback_ }
 func (m *DBMsg) ReceivedOn() *time.Time       { return &m.SentOn_ }
 func (m *DBMsg) SentOn() *time.Time           { return &m.SentOn_ }
+func (m *DBMsg) SendAt() *time.Time           { return m.SendAt_ }
+func (m *DBMsg) ExpiresAt() *time.Time        { return m.ExpiresAt_ }
+func (m *DBMsg) CreatedOn() time.Time         { return m.CreatedOn_ }
+func (m *DBMsg) Location() *courier.LatLon    { return m.Location_ }
 func (m *DBMsg) ResponseToID() courier.MsgID  { return m.ResponseToID_ }
 func (m *DBMsg) ResponseToExternalID() string { return m.ResponseToExternalID_ }
 
 func (m *DBMsg) Channel() courier.Channel { return m.channel }
 
+func (m *DBMsg) ContactCards() []courier.ContactCard { return m.ContactCards_ }
+
 func (m *DBMsg) QuickReplies() []string {
 	if m.quickReplies != nil {
 		return m.quickReplies
@@ -555,6 +765,11 @@ func (m *DBMsg) Topic() string {
 	return string(topic)
 }
 
+// Templating returns the template this message should be sent as, from its metadata
+func (m *DBMsg) Templating() (*courier.MsgTemplating, error) {
+	return courier.MsgTemplatingFromMetadata(m.Metadata_)
+}
+
 // Metadata returns the metadata for this message
 func (m *DBMsg) Metadata() json.RawMessage {
 	return m.Metadata_
@@ -568,8 +783,8 @@ func (m *DBMsg) urnFingerprint() string {
 // WithContactName can be used to set the contact name on a msg
 func (m *DBMsg) WithContactName(name string) courier.Msg { m.ContactName_ = name; return m }
 
-// WithReceivedOn can be used to set sent_on on a msg in a chained call
-func (m *DBMsg) WithReceivedOn(date time.Time) courier.Msg { m.SentOn_ = date; return m }
+// WithReceivedOn can be used to set sent_on on a msg in a chained call, it is always stored in UTC
+func (m *DBMsg) WithReceivedOn(date time.Time) courier.Msg { m.SentOn_ = date.UTC(); return m }
 
 // WithExternalID can be used to set the external id on a msg in a chained call
 func (m *DBMsg) WithExternalID(id string) courier.Msg { m.ExternalID_ = null.String(id); return m }
@@ -583,6 +798,50 @@ func (m *DBMsg) WithUUID(uuid courier.MsgUUID) courier.Msg { m.UUID_ = uuid; ret
 // WithMetadata can be used to add metadata to a Msg
 func (m *DBMsg) WithMetadata(metadata json.RawMessage) courier.Msg { m.Metadata_ = metadata; return m }
 
+// Priority returns the priority this msg is sent with, msgs flagged as high priority being at least normal priority
+func (m *DBMsg) Priority() courier.MsgPriority {
+	if m.HighPriority_ && m.Priority_ < courier.MsgPriorityNormal {
+		return courier.MsgPriorityNormal
+	}
+	return m.Priority_
+}
+
+// WithPriority can be used to set the priority this msg is sent with
+func (m *DBMsg) WithPriority(priority courier.MsgPriority) courier.Msg {
+	m.Priority_ = priority
+	m.HighPriority_ = priority >= courier.MsgPriorityNormal
+	return m
+}
+
+// WithStatusCallback can be used to set the URL the statuses of this msg are forwarded to
+func (m *DBMsg) WithStatusCallback(url string) courier.Msg { m.StatusCallback_ = url; return m }
+
+// WithSendAt can be used to schedule when a msg is sent in a chained call
+func (m *DBMsg) WithSendAt(date time.Time) courier.Msg { m.SendAt_ = &date; return m }
+
+// WithExpiresAt can be used to set when a msg is no longer worth sending in a chained call
+func (m *DBMsg) WithExpiresAt(date time.Time) courier.Msg { m.ExpiresAt_ = &date; return m }
+
+// WithIdempotencyKey can be used to set the key this msg is deduplicated by
+func (m *DBMsg) WithIdempotencyKey(key string) courier.Msg { m.idempotencyKey = key; return m }
+
+// IdempotencyKey returns the key this msg is deduplicated by, which is its external ID unless set otherwise
+func (m *DBMsg) IdempotencyKey() string {
+	if m.idempotencyKey != "" {
+		return m.idempotencyKey
+	}
+	return string(m.ExternalID_)
+}
+
+// WithLocation sets the location shared in this msg
+func (m *DBMsg) WithLocation(location courier.LatLon) courier.Msg { m.Location_ = &location; return m }
+
+// WithContactCard can be used to append to the contacts shared in this msg
+func (m *DBMsg) WithContactCard(card courier.ContactCard) courier.Msg {
+	m.ContactCards_ = append(m.ContactCards_, card)
+	return m
+}
+
 // WithAttachment can be used to append to the media urls for a message
 func (m *DBMsg) WithAttachment(url string) courier.Msg {
 	m.Attachments_ = append(m.Attachments_, url)
@@ -10,10 +10,12 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
 )
 
 // newMsgStatus creates a new DBMsgStatus for the passed in parameters
@@ -36,10 +38,17 @@ func newMsgStatus(channel courier.Channel, id courier.MsgID, externalID string,
 func writeMsgStatus(ctx context.Context, b *backend, status courier.MsgStatus) error {
 	dbStatus := status.(*DBMsgStatus)
 
-	err := writeMsgStatusToDB(ctx, b, dbStatus)
-
-	if err == courier.ErrMsgNotFound {
-		return err
+	// try to write it to our db, unless we know it is down
+	err := errDBDown
+	if b.dbMonitor.Up() {
+		err = writeMsgStatusToDB(ctx, b, dbStatus)
+
+		// a status for a msg we don't have isn't our db failing
+		if err == courier.ErrMsgNotFound {
+			b.dbMonitor.Record(nil)
+			return err
+		}
+		b.dbMonitor.Record(err)
 	}
 
 	// failed writing, write to our spool instead
@@ -73,7 +82,8 @@ func checkMsgExists(b *backend, status courier.MsgStatus) (err error) {
 	return err
 }
 
-// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
+// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message,
+// the %s is where our status order guard goes, see newStatusSQL
 const updateMsgID = `
 UPDATE msgs_msg SET 
 	status = CASE 
@@ -126,7 +136,8 @@ UPDATE msgs_msg SET
 WHERE 
 	msgs_msg.id = :msg_id AND
 	msgs_msg.channel_id = :channel_id AND 
-	msgs_msg.direction = 'O'
+	msgs_msg.direction = 'O' AND
+	%s
 RETURNING 
 	msgs_msg.id
 `
@@ -173,20 +184,23 @@ UPDATE msgs_msg SET
 		END,
 	modified_on = :modified_on
 WHERE 
-	msgs_msg.id = (SELECT msgs_msg.id FROM msgs_msg WHERE msgs_msg.external_id = :external_id AND msgs_msg.channel_id = :channel_id AND msgs_msg.direction = 'O' LIMIT 1)
+	msgs_msg.id = (SELECT msgs_msg.id FROM msgs_msg WHERE msgs_msg.external_id = :external_id AND msgs_msg.channel_id = :channel_id AND msgs_msg.direction = 'O' LIMIT 1) AND
+	%s
 RETURNING 
 	msgs_msg.id
 `
 
-// writeMsgStatusToDB writes the passed in msg status to our db
+// writeMsgStatusToDB writes the passed in msg status to our db. Our update only applies if the status progresses the
+// msg in our StatusPrecedence, which postgres checks against the row it locks for the update, so concurrent writes of
+// statuses for the same msg are applied in order. A status which doesn't progress its msg isn't an error.
 func writeMsgStatusToDB(ctx context.Context, b *backend, status *DBMsgStatus) error {
 	var rows *sqlx.Rows
 	var err error
 
 	if status.ID() != courier.NilMsgID {
-		rows, err = b.db.NamedQueryContext(ctx, updateMsgID, status)
+		rows, err = b.db.NamedQueryContext(ctx, b.statusSQL.updateMsgID, status)
 	} else if status.ExternalID() != "" {
-		rows, err = b.db.NamedQueryContext(ctx, updateMsgExternalID, status)
+		rows, err = b.db.NamedQueryContext(ctx, b.statusSQL.updateMsgExternalID, status)
 	} else {
 		return fmt.Errorf("attempt to update msg status without id or external id")
 	}
@@ -198,10 +212,15 @@ func writeMsgStatusToDB(ctx context.Context, b *backend, status *DBMsgStatus) er
 	// scan and read the id of the msg that was updated
 	if rows.Next() {
 		rows.Scan(&status.ID_)
-	} else {
-		return courier.ErrMsgNotFound
+		return nil
 	}
 
+	// nothing updated, either we don't have this msg or this status doesn't progress it
+	err = checkMsgExists(b, status)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("msg_id", status.ID_).WithField("external_id", status.ExternalID_).WithField("status", status.Status_).Debug("ignoring status which doesn't progress msg")
 	return nil
 }
 
@@ -286,11 +305,48 @@ AS
 WHERE 
 	msgs_msg.id = s.msg_id::int AND
 	msgs_msg.channel_id = s.channel_id::int AND 
-	msgs_msg.direction = 'O'
+	msgs_msg.direction = 'O' AND
+	%s
 RETURNING 
 	msgs_msg.id
 `
 
+// statusSQL is our status update SQL with the guard for our status order in place
+type statusSQL struct {
+	updateMsgID         string
+	updateMsgExternalID string
+	bulkUpdate          string
+}
+
+// newStatusSQL returns our status update SQL for the passed in status order, updates of a msg to a status in the order
+// only apply if the msg isn't already at it or a later one, e.g. never sent after delivered. Statuses which aren't in
+// the order, such as errored and failed, always apply.
+func newStatusSQL(order courier.StatusPrecedence) *statusSQL {
+	return &statusSQL{
+		updateMsgID:         fmt.Sprintf(updateMsgID, statusOrderGuard(order, ":status")),
+		updateMsgExternalID: fmt.Sprintf(updateMsgExternalID, statusOrderGuard(order, ":status")),
+		bulkUpdate:          fmt.Sprintf(bulkUpdateMsgStatusSQL, statusOrderGuard(order, "s.status")),
+	}
+}
+
+// statusOrderGuard returns the SQL condition for the passed in new status progressing the current status of a msg
+func statusOrderGuard(order courier.StatusPrecedence, newStatus string) string {
+	if len(order) == 0 {
+		return "TRUE"
+	}
+	return fmt.Sprintf("(%s IS NULL OR COALESCE(%s, 0) < %s)", statusRank(order, newStatus), statusRank(order, "msgs_msg.status"), statusRank(order, newStatus))
+}
+
+func statusRank(order courier.StatusPrecedence, status string) string {
+	rank := &strings.Builder{}
+	rank.WriteString("CASE " + status)
+	for i, value := range order {
+		rank.WriteString(fmt.Sprintf(" WHEN '%s' THEN %d", value, i+1))
+	}
+	rank.WriteString(" END")
+	return rank.String()
+}
+
 //-----------------------------------------------------------------------------
 // MsgStatusUpdate implementation
 //-----------------------------------------------------------------------------
@@ -305,6 +361,9 @@ type DBMsgStatus struct {
 	ExternalID_  string                 `json:"external_id,omitempty"    db:"external_id"`
 	Status_      courier.MsgStatusValue `json:"status"                   db:"status"`
 	ModifiedOn_  time.Time              `json:"modified_on"              db:"modified_on"`
+	OccurredOn_  *time.Time             `json:"occurred_on,omitempty"`
+
+	ErrorCategory_ courier.SendErrorCategory `json:"error_category,omitempty"`
 
 	logs []*courier.ChannelLog
 }
@@ -358,3 +417,22 @@ func (s *DBMsgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs,
 
 func (s *DBMsgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
 func (s *DBMsgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
+
+// OccurredOn returns when the provider says this status happened, or when we created it if it didn't say
+func (s *DBMsgStatus) OccurredOn() time.Time {
+	if s.OccurredOn_ != nil {
+		return *s.OccurredOn_
+	}
+	return s.ModifiedOn_
+}
+
+// SetOccurredOn sets when the provider says this status happened, it is always stored in UTC
+func (s *DBMsgStatus) SetOccurredOn(date time.Time) {
+	date = date.UTC()
+	s.OccurredOn_ = &date
+}
+
+func (s *DBMsgStatus) ErrorCategory() courier.SendErrorCategory { return s.ErrorCategory_ }
+func (s *DBMsgStatus) SetErrorCategory(category courier.SendErrorCategory) {
+	s.ErrorCategory_ = category
+}
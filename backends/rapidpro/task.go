@@ -27,6 +27,14 @@ func queueMsgHandling(rc redis.Conn, c *DBContact, m *DBMsg) error {
 		"new_contact":     c.IsNew_,
 	}
 
+	// structured content only where the channel gave us some
+	if m.Location_ != nil {
+		body["location"] = m.Location_
+	}
+	if len(m.ContactCards_) > 0 {
+		body["contact_cards"] = m.ContactCards_
+	}
+
 	return queueMailroomTask(rc, "msg_event", m.OrgID_, m.ContactID_, body)
 }
 
@@ -72,6 +80,17 @@ func queueChannelEvent(rc redis.Conn, c *DBContact, e *DBChannelEvent) error {
 		}
 		return queueMailroomTask(rc, "new_conversation", e.OrgID_, e.ContactID_, body)
 
+	case courier.MsgReaction, courier.MsgEdit:
+		body := map[string]interface{}{
+			"org_id":      e.OrgID_,
+			"contact_id":  e.ContactID_,
+			"urn_id":      e.ContactURNID_,
+			"channel_id":  e.ChannelID_,
+			"extra":       e.Extra(),
+			"new_contact": c.IsNew_,
+		}
+		return queueMailroomTask(rc, string(e.EventType()), e.OrgID_, e.ContactID_, body)
+
 	default:
 		return fmt.Errorf("unknown event type: %s", e.EventType())
 	}
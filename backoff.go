@@ -0,0 +1,19 @@
+package courier
+
+import "time"
+
+// the longest we wait between retries, however many we are configured to make
+const maxRetryBackoff = 5 * time.Minute
+
+// retryBackoff returns how long to wait after the passed in failed attempt before retrying, starting at the passed in
+// number of milliseconds after the first attempt and doubling on each subsequent one, up to maxRetryBackoff
+func retryBackoff(backoffMS int, attempt int) time.Duration {
+	backoff := time.Duration(backoffMS) * time.Millisecond
+	for i := 1; i < attempt && backoff > 0 && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
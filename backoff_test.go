@@ -0,0 +1,20 @@
+package courier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(100, 1))
+	assert.Equal(t, 200*time.Millisecond, retryBackoff(100, 2))
+	assert.Equal(t, 800*time.Millisecond, retryBackoff(100, 4))
+	assert.Equal(t, time.Duration(0), retryBackoff(0, 10))
+
+	// we never wait longer than our max, even after more attempts than we could shift by
+	assert.Equal(t, maxRetryBackoff, retryBackoff(100, 20))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(100, 100))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(1000000, 1))
+}
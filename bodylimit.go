@@ -0,0 +1,41 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ConfigMaxRequestBodySize is the maximum size in bytes of request bodies a channel will accept, overriding our
+// MaxRequestBodySize for channels which legitimately receive large bodies, e.g. media uploads
+const ConfigMaxRequestBodySize = "max_request_body_size"
+
+// errRequestBodyTooLarge is the error http.MaxBytesReader returns once a body goes over its limit
+const errRequestBodyTooLarge = "http: request body too large"
+
+// limitRequestBody limits how much of the body of the passed in request can be read to maxSize bytes, returning an
+// error straight away if the request declares a bigger body than that. A maxSize of 0 means there is no limit.
+func limitRequestBody(w http.ResponseWriter, r *http.Request, body io.ReadCloser, maxSize int) error {
+	if maxSize <= 0 || body == nil {
+		r.Body = body
+		return nil
+	}
+
+	if r.ContentLength > int64(maxSize) {
+		return fmt.Errorf("request body exceeds maximum size of %d bytes", maxSize)
+	}
+	r.Body = http.MaxBytesReader(w, body, int64(maxSize))
+	return nil
+}
+
+// isRequestBodyTooLarge returns whether the passed in error comes from reading past a limit set by limitRequestBody
+func isRequestBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == errRequestBodyTooLarge
+}
+
+// writeBodyTooLarge logs the passed in error and writes a 413 response for it
+func writeBodyTooLarge(ctx context.Context, w http.ResponseWriter, r *http.Request, channel Channel, err error) error {
+	LogRequestError(r, channel, err)
+	return WriteDataResponse(ctx, w, http.StatusRequestEntityTooLarge, "Request Entity Too Large", []interface{}{NewErrorData(err.Error())})
+}
@@ -0,0 +1,73 @@
+package courier
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bodyLimitHandler struct {
+	dummyHandler
+	channel Channel
+}
+
+func (h *bodyLimitHandler) ChannelType() ChannelType { return ChannelType("BL") }
+
+func (h *bodyLimitHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
+	return h.channel, nil
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	config := NewConfig()
+	config.MaxRequestBodySize = 100
+	s := NewServer(config, NewMockBackend()).(*server)
+
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "BL", "2020", "US", map[string]interface{}{})
+	handler := &bodyLimitHandler{channel: channel}
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(body)
+		return nil, nil
+	})
+
+	request := func(size int, declareLength bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/c/bl/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", strings.NewReader(strings.Repeat("x", size)))
+		if !declareLength {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	// bodies up to our limit are fine
+	assert.Equal(t, 200, request(100, true).Code)
+	assert.Equal(t, 200, request(100, false).Code)
+
+	// but one byte over is rejected, whether or not the client tells us the length up front
+	w := request(101, true)
+	assert.Equal(t, 413, w.Code)
+	assert.Contains(t, w.Body.String(), "request body exceeds maximum size of 100 bytes")
+
+	w = request(101, false)
+	assert.Equal(t, 413, w.Code)
+	assert.Contains(t, w.Body.String(), "request body too large")
+
+	// channels can raise our limit
+	channel.SetConfig(ConfigMaxRequestBodySize, 200)
+	assert.Equal(t, 200, request(101, true).Code)
+	assert.Equal(t, 200, request(200, false).Code)
+	assert.Equal(t, 413, request(201, false).Code)
+
+	// or turn it off completely
+	channel.SetConfig(ConfigMaxRequestBodySize, 0)
+	assert.Equal(t, 200, request(1000, true).Code)
+}
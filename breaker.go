@@ -0,0 +1,179 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker around writes to our backend
+type BreakerState string
+
+// Possible values for BreakerState
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// ErrBackendUnavailable is returned instead of writing to our backend while our circuit breaker is open, it is a 503 so
+// that providers retry the request later
+var ErrBackendUnavailable = NewCourierError(http.StatusServiceUnavailable, "backend unavailable, try again later")
+
+// circuitBreaker stops us trying a backend which is failing. After threshold consecutive failures it opens, failing
+// everything immediately for openDuration, after which it is half open and lets a single probe through. If that
+// succeeds it closes again, otherwise it opens for another openDuration.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mutex    sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// how we tell the time, replaced in tests
+	now func() time.Time
+}
+
+func newCircuitBreaker(threshold int, openDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, openDuration: openDuration, state: BreakerClosed, now: time.Now}
+}
+
+// allow returns whether a call should be let through, if so its result must be passed to record
+func (b *circuitBreaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openDuration {
+		b.state = BreakerHalfOpen
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		// only one probe at a time, everything else fails fast until we know whether it succeeded
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+// record records whether a call we let through failed
+func (b *circuitBreaker) record(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	wasProbe := b.state == BreakerHalfOpen && b.probing
+	if wasProbe {
+		b.probing = false
+	}
+
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if wasProbe || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State returns the current state of our breaker, which is half open as soon as it has been open for openDuration
+func (b *circuitBreaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openDuration {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// health returns the health of our backend writes as seen by our breaker
+func (b *circuitBreaker) health() HealthStatus {
+	status := HealthStatus{Name: "backend_writes", State: HealthOK}
+
+	switch b.State() {
+	case BreakerOpen:
+		status.State = HealthDown
+		status.Message = fmt.Sprintf("circuit breaker open after %d consecutive failed writes", b.threshold)
+	case BreakerHalfOpen:
+		status.State = HealthDegraded
+		status.Message = "circuit breaker half open, probing whether backend has recovered"
+	}
+	return status
+}
+
+// breakerBackend wraps a backend, guarding the msgs, statuses and events written through it with a circuit breaker so
+// that a degraded backend fails writes immediately instead of tying up every request until it times out
+type breakerBackend struct {
+	Backend
+	breaker *circuitBreaker
+}
+
+// WriteMsg writes the passed in msg to our wrapped backend unless our breaker is open
+func (b *breakerBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	if !b.breaker.allow() {
+		return ErrBackendUnavailable
+	}
+	err := b.Backend.WriteMsg(ctx, msg)
+	b.breaker.record(isFailedWrite(ctx, err))
+	return err
+}
+
+// WriteMsgStatus writes the passed in status to our wrapped backend unless our breaker is open
+func (b *breakerBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	if !b.breaker.allow() {
+		return ErrBackendUnavailable
+	}
+	err := b.Backend.WriteMsgStatus(ctx, status)
+	b.breaker.record(isFailedWrite(ctx, err))
+	return err
+}
+
+// WriteMsgStatuses writes the passed in statuses to our wrapped backend unless our breaker is open, a batch only counts
+// as failing if every status in it failed
+func (b *breakerBackend) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	if !b.breaker.allow() {
+		return ErrBackendUnavailable
+	}
+	err := b.Backend.WriteMsgStatuses(ctx, statuses)
+
+	failed := isFailedWrite(ctx, err)
+	if statusesErr, isStatusesErr := err.(*MsgStatusesError); isStatusesErr && ctx.Err() == nil {
+		failed = len(statusesErr.Errors) == statusesErr.Total
+	}
+	b.breaker.record(failed)
+	return err
+}
+
+// WriteChannelEvent writes the passed in event to our wrapped backend unless our breaker is open
+func (b *breakerBackend) WriteChannelEvent(ctx context.Context, event ChannelEvent) error {
+	if !b.breaker.allow() {
+		return ErrBackendUnavailable
+	}
+	err := b.Backend.WriteChannelEvent(ctx, event)
+	b.breaker.record(isFailedWrite(ctx, err))
+	return err
+}
+
+// whether a write which returned the passed in error counts as a failure of our backend. A msg not being found isn't
+// one, but a write which took so long that we ran out of time is, even if the backend managed to spool it.
+func isFailedWrite(ctx context.Context, err error) bool {
+	if err == ErrMsgNotFound {
+		return false
+	}
+	return err != nil || ctx.Err() == context.DeadlineExceeded
+}
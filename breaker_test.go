@@ -0,0 +1,118 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	mb := NewMockBackend()
+	config := testConfig()
+	config.BackendBreakerThreshold = 3
+	config.BackendBreakerOpenDuration = 30
+	s := NewServer(config, mb).(*server)
+
+	now := time.Date(2020, 7, 1, 9, 0, 0, 0, time.UTC)
+	s.breaker.now = func() time.Time { return now }
+
+	m := newMetrics()
+	m.registerBreakerState(s.breaker)
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", nil)
+	newMsg := func() Msg { return mb.NewIncomingMsg(channel, "tel:+250788383383", "hello") }
+
+	health := func() HealthStatus {
+		w := httptest.NewRecorder()
+		s.handleHealth(w, httptest.NewRequest("GET", "/health", nil))
+
+		response := &struct {
+			Checks map[string]struct {
+				State   HealthState `json:"state"`
+				Message string      `json:"message"`
+			} `json:"checks"`
+		}{}
+		json.Unmarshal(w.Body.Bytes(), response)
+		check := response.Checks["backend_writes"]
+		return HealthStatus{State: check.State, Message: check.Message}
+	}
+	breakerGauge := func() string {
+		w := httptest.NewRecorder()
+		promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+
+	// closed, failures below our threshold still go to our backend
+	mb.SetErrorOnQueue(true)
+	assert.EqualError(t, s.backend.WriteMsg(ctx, newMsg()), "unable to queue message")
+	assert.EqualError(t, s.backend.WriteMsg(ctx, newMsg()), "unable to queue message")
+	assert.Equal(t, BreakerClosed, s.breaker.State())
+	assert.Equal(t, HealthOK, health().State)
+	assert.Contains(t, breakerGauge(), "courier_backend_breaker_state 0")
+
+	// a success resets our count of consecutive failures
+	mb.SetErrorOnQueue(false)
+	assert.NoError(t, s.backend.WriteMsg(ctx, newMsg()))
+	mb.SetErrorOnQueue(true)
+	assert.Error(t, s.backend.WriteMsg(ctx, newMsg()))
+	assert.Error(t, s.backend.WriteMsg(ctx, newMsg()))
+	assert.Equal(t, BreakerClosed, s.breaker.State())
+
+	// the third in a row trips our breaker open
+	assert.EqualError(t, s.backend.WriteMsg(ctx, newMsg()), "unable to queue message")
+	assert.Equal(t, BreakerOpen, s.breaker.State())
+	assert.Equal(t, HealthStatus{State: HealthDown, Message: "circuit breaker open after 3 consecutive failed writes"}, health())
+	assert.Contains(t, breakerGauge(), "courier_backend_breaker_state 2")
+
+	// once open, writes fail immediately without trying our backend, even if it has recovered
+	mb.SetErrorOnQueue(false)
+	assert.Equal(t, ErrBackendUnavailable, s.backend.WriteMsg(ctx, newMsg()))
+	assert.Equal(t, ErrBackendUnavailable, s.backend.WriteMsgStatus(ctx, mb.NewMsgStatusForID(channel, NewMsgID(10), MsgSent)))
+	assert.Equal(t, ErrBackendUnavailable, s.backend.WriteChannelEvent(ctx, mb.NewChannelEvent(channel, NewConversation, "tel:+250788383383")))
+	assert.Equal(t, 1, mb.LenQueuedMsgs())
+	assert.Equal(t, 0, len(mb.msgStatuses))
+	assert.Equal(t, 0, len(mb.channelEvents))
+
+	// after our open duration we are half open, letting a probe through
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, BreakerHalfOpen, s.breaker.State())
+	assert.Equal(t, HealthDegraded, health().State)
+	assert.Contains(t, breakerGauge(), "courier_backend_breaker_state 1")
+
+	// a failed probe opens us again for another open duration
+	mb.SetErrorOnQueue(true)
+	assert.EqualError(t, s.backend.WriteMsg(ctx, newMsg()), "unable to queue message")
+	assert.Equal(t, BreakerOpen, s.breaker.State())
+	mb.SetErrorOnQueue(false)
+	assert.Equal(t, ErrBackendUnavailable, s.backend.WriteMsg(ctx, newMsg()))
+
+	// only one probe is let through at a time
+	now = now.Add(30 * time.Second)
+	assert.True(t, s.breaker.allow())
+	assert.Equal(t, ErrBackendUnavailable, s.backend.WriteMsg(ctx, newMsg()))
+
+	// and a successful one closes us again
+	s.breaker.record(false)
+	assert.Equal(t, BreakerClosed, s.breaker.State())
+	assert.Equal(t, HealthOK, health().State)
+	assert.Contains(t, breakerGauge(), "courier_backend_breaker_state 0")
+
+	assert.NoError(t, s.backend.WriteMsg(ctx, newMsg()))
+	assert.Equal(t, 2, mb.LenQueuedMsgs())
+
+	// a msg not being found isn't our backend failing
+	assert.False(t, isFailedWrite(ctx, ErrMsgNotFound))
+
+	// but running out of time is, even if our backend didn't error
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	assert.True(t, isFailedWrite(timeoutCtx, nil))
+}
@@ -3,6 +3,9 @@ package courier
 import (
 	"database/sql/driver"
 	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/nyaruka/null"
@@ -26,6 +29,13 @@ const (
 	// ConfigContentType is a constant key for channel configs
 	ConfigContentType = "content_type"
 
+	// ConfigCountry is a constant key for channel configs, when set it overrides the country of the channel as the one
+	// national format numbers received on it are assumed to be from
+	ConfigCountry = "country"
+
+	// ConfigExtraHeaders is a constant key for channel configs, a map of extra headers added to every request to the provider
+	ConfigExtraHeaders = "extra_headers"
+
 	// ConfigMaxLength is the maximum size of a message in characters
 	ConfigMaxLength = "max_length"
 
@@ -47,6 +57,9 @@ const (
 	// ConfigSendURL is a constant key for channel configs
 	ConfigSendURL = "send_url"
 
+	// ConfigSimulateSends is a constant key for channel configs, when set msgs on the channel aren't sent to the provider
+	ConfigSimulateSends = "simulate_sends"
+
 	// ConfigUsername is a constant key for channel configs
 	ConfigUsername = "username"
 
@@ -72,15 +85,44 @@ type ChannelUUID struct {
 // NilChannelUUID is our nil value for channel UUIDs
 var NilChannelUUID = ChannelUUID{uuid.Nil}
 
-// NewChannelUUID creates a new ChannelUUID for the passed in string
+// the canonical form of channel UUIDs, once lowercased
+var channelUUIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// how much of an invalid channel UUID we include in our error, providers can send us anything
+const maxInvalidChannelUUIDLength = 50
+
+// NewChannelUUID creates a new ChannelUUID for the passed in string, ignoring surrounding whitespace and case. If the
+// string isn't a UUID in its canonical form the error is a 400 CourierError which names it.
 func NewChannelUUID(u string) (ChannelUUID, error) {
-	channelUUID, err := uuid.FromString(strings.ToLower(u))
+	normalized := strings.ToLower(strings.TrimSpace(u))
+	if !channelUUIDRegex.MatchString(normalized) {
+		return NilChannelUUID, newInvalidChannelUUIDError(u)
+	}
+
+	channelUUID, err := uuid.FromString(normalized)
 	if err != nil {
-		return NilChannelUUID, err
+		return NilChannelUUID, newInvalidChannelUUIDError(u)
 	}
 	return ChannelUUID{channelUUID}, nil
 }
 
+// returns the error for the passed in invalid channel UUID, truncated if it is long
+func newInvalidChannelUUIDError(u string) error {
+	if len(u) > maxInvalidChannelUUIDLength {
+		u = u[:maxInvalidChannelUUIDLength] + "..."
+	}
+	return NewCourierError(http.StatusBadRequest, fmt.Sprintf("invalid channel UUID %q, must be of the form 01234567-89ab-cdef-0123-456789abcdef", u))
+}
+
+// MustChannelUUID creates a new ChannelUUID for the passed in string, panicking if it isn't valid, for use in tests
+func MustChannelUUID(u string) ChannelUUID {
+	channelUUID, err := NewChannelUUID(u)
+	if err != nil {
+		panic(err)
+	}
+	return channelUUID
+}
+
 // ChannelID is our SQL type for a channel's id
 type ChannelID null.Int
 
@@ -156,4 +198,38 @@ type Channel interface {
 	BoolConfigForKey(key string, defaultValue bool) bool
 	IntConfigForKey(key string, defaultValue int) int
 	OrgConfigForKey(key string, defaultValue interface{}) interface{}
+
+	// RequireConfig returns a MissingConfigError for the first of the passed in keys which this channel has no value for
+	RequireConfig(keys ...string) error
+}
+
+// MissingConfigError is returned when a channel is missing a config value its handler needs
+type MissingConfigError struct {
+	ChannelType ChannelType
+	Key         string
+}
+
+func (e *MissingConfigError) Error() string {
+	return fmt.Sprintf("missing config '%s' for %s channel", e.Key, e.ChannelType)
+}
+
+// ChannelExtraHeaders returns the extra headers the config of the passed in channel says to add to every request to its
+// provider, values which aren't strings are ignored
+func ChannelExtraHeaders(channel Channel) map[string]string {
+	if channel == nil {
+		return nil
+	}
+
+	config, isMap := channel.ConfigForKey(ConfigExtraHeaders, nil).(map[string]interface{})
+	if !isMap {
+		return nil
+	}
+
+	headers := make(map[string]string, len(config))
+	for name, value := range config {
+		if str, isStr := value.(string); isStr {
+			headers[name] = str
+		}
+	}
+	return headers
 }
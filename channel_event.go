@@ -13,8 +13,19 @@ type ChannelEventType string
 const (
 	NewConversation ChannelEventType = "new_conversation"
 	Referral        ChannelEventType = "referral"
+	OptIn           ChannelEventType = "opt_in"
 	StopContact     ChannelEventType = "stop_contact"
 	WelcomeMessage  ChannelEventType = "welcome_message"
+	MsgReaction     ChannelEventType = "msg_reaction"
+	MsgEdit         ChannelEventType = "msg_edit"
+)
+
+// Keys of the extra of MsgReaction and MsgEdit events. Both reference the msg reacted to or edited by the external ID
+// the channel gave it, a reaction has the emoji reacted with (empty if the reaction was removed) and an edit the new text.
+const (
+	EventExtraExternalID = "external_id"
+	EventExtraEmoji      = "emoji"
+	EventExtraText       = "text"
 )
 
 //-----------------------------------------------------------------------------
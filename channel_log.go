@@ -1,8 +1,11 @@
 package courier
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nyaruka/courier/utils"
 )
@@ -76,6 +79,53 @@ func (l *ChannelLog) WithError(description string, err error) *ChannelLog {
 	return l
 }
 
+// the request and response headers which carry credentials
+var secretHeadersRegex = regexp.MustCompile(`(?im)^(authorization|proxy-authorization|x-api-key|x-auth-token):[^\r\n]*`)
+
+// Redact masks credentials in the request and response of this log. That is the values of authorization headers,
+// credentials in the URL and anywhere the passed in secrets appear.
+func (l *ChannelLog) Redact(secrets ...string) *ChannelLog {
+	l.URL = RedactedURL(l.URL, secrets...)
+	l.Request = RedactSecrets(secretHeadersRegex.ReplaceAllString(l.Request, "$1: "+redactMask), secrets...)
+	l.Response = RedactSecrets(secretHeadersRegex.ReplaceAllString(l.Response, "$1: "+redactMask), secrets...)
+	l.Error = RedactSecrets(l.Error, secrets...)
+	return l
+}
+
+// Truncate cuts the request and response of this log down to at most maxSize bytes each, a maxSize of 0 means no limit
+func (l *ChannelLog) Truncate(maxSize int) *ChannelLog {
+	l.Request = truncateLogBody(l.Request, maxSize)
+	l.Response = truncateLogBody(l.Response, maxSize)
+	return l
+}
+
+func truncateLogBody(body string, maxSize int) string {
+	if maxSize <= 0 || len(body) <= maxSize {
+		return body
+	}
+
+	// don't cut a character in two, the result has to be valid UTF-8 to be saved
+	end := maxSize
+	for end > 0 && !utf8.RuneStart(body[end]) {
+		end--
+	}
+	return body[:end] + "..."
+}
+
+// channelLogBackend wraps a backend, redacting and truncating the channel logs written through it
+type channelLogBackend struct {
+	Backend
+	maxBodySize int
+}
+
+// WriteChannelLogs writes the passed in logs to our wrapped backend, once they are redacted and truncated
+func (b *channelLogBackend) WriteChannelLogs(ctx context.Context, logs []*ChannelLog) error {
+	for _, log := range logs {
+		log.Redact(ChannelSecrets(log.Channel)...).Truncate(b.maxBodySize)
+	}
+	return b.Backend.WriteChannelLogs(ctx, logs)
+}
+
 func (l *ChannelLog) String() string {
 	return fmt.Sprintf("%s: %d %s %d\n%s\n%s\n%s", l.Description, l.StatusCode, l.URL, l.Elapsed, l.Error, l.Request, l.Response)
 }
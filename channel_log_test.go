@@ -0,0 +1,125 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelLogRedact(t *testing.T) {
+	log := &ChannelLog{
+		URL:      "https://api.example.com/send?api_key=sesame&to=1234",
+		Request:  "POST /send HTTP/1.1\r\nAuthorization: Basic Ym9iOnNlc2FtZQ==\r\nContent-Type: application/json\r\n\r\n{\"token\":\"0a1b2c\",\"text\":\"hi\"}",
+		Response: "HTTP/1.1 401 Unauthorized\r\n\r\n{\"error\":\"invalid token 0a1b2c\"}",
+		Error:    "received non 200 status: 401, token 0a1b2c",
+	}
+	log.Redact("0a1b2c")
+
+	assert.Equal(t, "https://api.example.com/send?api_key=****&to=1234", log.URL)
+	assert.Equal(t, "POST /send HTTP/1.1\r\nAuthorization: ****\r\nContent-Type: application/json\r\n\r\n{\"token\":\"****\",\"text\":\"hi\"}", log.Request)
+	assert.Equal(t, "HTTP/1.1 401 Unauthorized\r\n\r\n{\"error\":\"invalid token ****\"}", log.Response)
+	assert.Equal(t, "received non 200 status: 401, token ****", log.Error)
+}
+
+func TestChannelLogTruncate(t *testing.T) {
+	log := &ChannelLog{Request: "0123456789", Response: "short"}
+	log.Truncate(0)
+	assert.Equal(t, "0123456789", log.Request)
+
+	log.Truncate(5)
+	assert.Equal(t, "01234...", log.Request)
+	assert.Equal(t, "short", log.Response)
+
+	// we never cut a character in two
+	log = &ChannelLog{Response: "hi 😊 there"}
+	log.Truncate(5)
+	assert.Equal(t, "hi ...", log.Response)
+}
+
+// authHandler is a handler which sends messages with its channel's auth token
+type authHandler struct {
+	dummyHandler
+}
+
+func (h *authHandler) ChannelType() ChannelType { return ChannelType("AU") }
+
+func (h *authHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	status := h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+
+	token := msg.Channel().StringConfigForKey(ConfigAuthToken, "")
+	req, _ := http.NewRequest(http.MethodPost, msg.Channel().StringConfigForKey(ConfigSendURL, "")+"?token="+token, strings.NewReader(msg.Text()+" "+token))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	log := NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr)
+	status.AddLog(log)
+	if err != nil {
+		log.WithError("Message Send Error", err)
+		return status, nil
+	}
+
+	status.SetStatus(MsgWired)
+	return status, nil
+}
+
+func TestSendChannelLogs(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error": "bad token ` + r.URL.Query().Get("token") + `"}`))
+			return
+		}
+		w.Write([]byte(strings.Repeat("ok", 100)))
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.MaxSendRetries = 0
+	config.MaxChannelLogBodySize = 1024
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	sender := NewForeman(s, 1).senders[0]
+
+	handler := &authHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "AU", "2020", "US", map[string]interface{}{ConfigSendURL: server.URL, ConfigAuthToken: "sesame"})
+
+	// a successful send is logged with our token masked
+	sender.sendMessage(&mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383"})
+	log, err := mb.GetLastChannelLog()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMsgID(101), log.MsgID)
+		assert.Equal(t, 200, log.StatusCode)
+		assert.Equal(t, server.URL+"?token=****", log.URL)
+		assert.Contains(t, log.Request, "Authorization: ****")
+		assert.Contains(t, log.Request, "hello ****")
+		assert.Contains(t, log.Response, strings.Repeat("ok", 100))
+		assert.NotContains(t, log.String(), "sesame")
+	}
+
+	// and so is a failed one, with its response cut down to our max size
+	fail = true
+	config.MaxChannelLogBodySize = 20
+	s = NewServer(config, mb)
+	sender = NewForeman(s, 1).senders[0]
+	handler.server = s
+
+	sender.sendMessage(&mockMsg{channel: channel, id: NewMsgID(102), text: "hello", urn: "tel:+250788383383"})
+	log, err = mb.GetLastChannelLog()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMsgID(102), log.MsgID)
+		assert.Equal(t, 400, log.StatusCode)
+		assert.Equal(t, "received non 200 status: 400", log.Error)
+		assert.Equal(t, "HTTP/1.1 400 Bad Req...", log.Response)
+		assert.NotContains(t, log.String(), "sesame")
+	}
+}
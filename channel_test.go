@@ -0,0 +1,106 @@
+package courier
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChannelUUID(t *testing.T) {
+	tcs := []struct {
+		input string
+		uuid  string
+		err   string
+	}{
+		{"e4bb1578-29da-4fa5-a214-9da19dd24230", "e4bb1578-29da-4fa5-a214-9da19dd24230", ""},
+		{"E4BB1578-29DA-4FA5-A214-9DA19DD24230", "e4bb1578-29da-4fa5-a214-9da19dd24230", ""},
+		{"  e4bb1578-29da-4fa5-a214-9da19dd24230\n", "e4bb1578-29da-4fa5-a214-9da19dd24230", ""},
+		{"", "", `invalid channel UUID "", must be of the form 01234567-89ab-cdef-0123-456789abcdef`},
+		{"e4bb1578-29da-4fa5-a214-9da19dd24230?from=1234", "", `invalid channel UUID "e4bb1578-29da-4fa5-a214-9da19dd24230?from=1234", must be of the form 01234567-89ab-cdef-0123-456789abcdef`},
+		{"e4bb157829da4fa5a2149da19dd24230", "", `invalid channel UUID "e4bb157829da4fa5a2149da19dd24230", must be of the form 01234567-89ab-cdef-0123-456789abcdef`},
+		{"{e4bb1578-29da-4fa5-a214-9da19dd24230}", "", `invalid channel UUID "{e4bb1578-29da-4fa5-a214-9da19dd24230}", must be of the form 01234567-89ab-cdef-0123-456789abcdef`},
+		{strings.Repeat("x", 100), "", `invalid channel UUID "` + strings.Repeat("x", 50) + `...", must be of the form 01234567-89ab-cdef-0123-456789abcdef`},
+	}
+
+	for _, tc := range tcs {
+		uuid, err := NewChannelUUID(tc.input)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.input)
+			assert.Equal(t, tc.uuid, uuid.String(), tc.input)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.input)
+			assert.Equal(t, NilChannelUUID, uuid, tc.input)
+		}
+	}
+
+	// invalid UUIDs are written as a 400 naming the bad value
+	_, err := NewChannelUUID("e4bb1578-29da-4fa5-a214-9da19dd2423x")
+	r := httptest.NewRequest("GET", "/c/ex/e4bb1578-29da-4fa5-a214-9da19dd2423x/receive", nil)
+	w := httptest.NewRecorder()
+	WriteError(r.Context(), w, r, err)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `invalid channel UUID \"e4bb1578-29da-4fa5-a214-9da19dd2423x\"`)
+
+	assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", MustChannelUUID("e4bb1578-29da-4fa5-a214-9da19dd24230").String())
+	assert.Panics(t, func() { MustChannelUUID("not a uuid") })
+}
+
+func TestChannelConfig(t *testing.T) {
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "EX", "2020", "US", map[string]interface{}{
+		"api_key":     "sesame",
+		"empty":       "",
+		"max_length":  float64(160),
+		"port":        8080,
+		"retries":     " 3 ",
+		"use_tls":     true,
+		"verify_ssl":  "false",
+		"headers":     map[string]interface{}{"X-Key": "1"},
+		"not_a_bool":  "maybe",
+		"not_an_int":  "lots",
+		"not_a_str":   float64(12),
+		"null_config": nil,
+	})
+
+	// present values of the right type, or saved as strings, are read as that type
+	assert.Equal(t, "sesame", channel.StringConfigForKey("api_key", "default"))
+	assert.Equal(t, "", channel.StringConfigForKey("empty", "default"))
+	assert.Equal(t, 160, channel.IntConfigForKey("max_length", 0))
+	assert.Equal(t, 8080, channel.IntConfigForKey("port", 0))
+	assert.Equal(t, 3, channel.IntConfigForKey("retries", 0))
+	assert.Equal(t, true, channel.BoolConfigForKey("use_tls", false))
+	assert.Equal(t, false, channel.BoolConfigForKey("verify_ssl", true))
+
+	// missing values give us our default
+	assert.Equal(t, "default", channel.StringConfigForKey("missing", "default"))
+	assert.Equal(t, 5, channel.IntConfigForKey("missing", 5))
+	assert.Equal(t, true, channel.BoolConfigForKey("missing", true))
+
+	// as do values of the wrong type, rather than panicking
+	assert.Equal(t, "default", channel.StringConfigForKey("not_a_str", "default"))
+	assert.Equal(t, "default", channel.StringConfigForKey("headers", "default"))
+	assert.Equal(t, "default", channel.StringConfigForKey("null_config", "default"))
+	assert.Equal(t, 5, channel.IntConfigForKey("not_an_int", 5))
+	assert.Equal(t, 5, channel.IntConfigForKey("use_tls", 5))
+	assert.Equal(t, true, channel.BoolConfigForKey("not_a_bool", true))
+	assert.Equal(t, true, channel.BoolConfigForKey("port", true))
+
+	// requiring config that is there is fine
+	assert.NoError(t, channel.RequireConfig())
+	assert.NoError(t, channel.RequireConfig("api_key", "max_length", "use_tls", "headers"))
+
+	// but missing, null or empty config errors naming the first key missing
+	err := channel.RequireConfig("api_key", "secret", "username")
+	assert.EqualError(t, err, "missing config 'secret' for EX channel")
+	assert.Equal(t, &MissingConfigError{ChannelType: "EX", Key: "secret"}, err)
+	assert.EqualError(t, channel.RequireConfig("null_config"), "missing config 'null_config' for EX channel")
+	assert.EqualError(t, channel.RequireConfig("empty"), "missing config 'empty' for EX channel")
+
+	// and is a clear error for a handler to write back
+	w := httptest.NewRecorder()
+	WriteError(context.Background(), w, httptest.NewRequest("POST", "/", nil), channel.RequireConfig("secret"))
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "missing config 'secret' for EX channel")
+}
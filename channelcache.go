@@ -0,0 +1,83 @@
+package courier
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// channels are cached by both their type and UUID, as the same UUID looked up as a different type is an error
+type channelCacheKey struct {
+	channelType ChannelType
+	uuid        ChannelUUID
+}
+
+type cachedChannel struct {
+	channel    Channel
+	expiration time.Time
+}
+
+// channelCacheBackend wraps a backend, caching the channels it returns from GetChannel for a TTL so that every
+// request to a busy channel doesn't have to look it up. Errors are never cached.
+type channelCacheBackend struct {
+	Backend
+	ttl time.Duration
+
+	mutex    sync.RWMutex
+	channels map[channelCacheKey]*cachedChannel
+}
+
+func newChannelCacheBackend(backend Backend, ttl time.Duration) *channelCacheBackend {
+	return &channelCacheBackend{
+		Backend:  backend,
+		ttl:      ttl,
+		channels: make(map[channelCacheKey]*cachedChannel),
+	}
+}
+
+// GetChannel returns the channel with the passed in type and UUID from our cache, or from our wrapped backend if
+// we don't have it or it has expired
+func (b *channelCacheBackend) GetChannel(ctx context.Context, channelType ChannelType, uuid ChannelUUID) (Channel, error) {
+	key := channelCacheKey{channelType, uuid}
+
+	b.mutex.RLock()
+	cached, found := b.channels[key]
+	b.mutex.RUnlock()
+
+	if found && cached.expiration.After(time.Now()) {
+		return cached.channel, nil
+	}
+
+	channel, err := b.Backend.GetChannel(ctx, channelType, uuid)
+	if err != nil {
+		// we don't want to keep serving a channel which was removed
+		b.invalidate(uuid)
+		return channel, err
+	}
+
+	b.mutex.Lock()
+	b.channels[key] = &cachedChannel{channel: channel, expiration: time.Now().Add(b.ttl)}
+	b.mutex.Unlock()
+
+	return channel, nil
+}
+
+// invalidate evicts the channel with the passed in UUID from our cache, whatever type it was looked up as
+func (b *channelCacheBackend) invalidate(uuid ChannelUUID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for key := range b.channels {
+		if key.uuid == uuid {
+			delete(b.channels, key)
+		}
+	}
+}
+
+// InvalidateChannel evicts the channel with the passed in UUID from our channel cache so the next request for it
+// looks it up again, e.g. after its config has changed
+func (s *server) InvalidateChannel(uuid ChannelUUID) {
+	if s.channelCache != nil {
+		s.channelCache.invalidate(uuid)
+	}
+}
@@ -0,0 +1,84 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingBackend is a mock backend which counts how many times channels are looked up
+type countingBackend struct {
+	*MockBackend
+	lookups int
+}
+
+func (b *countingBackend) GetChannel(ctx context.Context, cType ChannelType, uuid ChannelUUID) (Channel, error) {
+	b.lookups++
+	return b.MockBackend.GetChannel(ctx, cType, uuid)
+}
+
+func TestChannelCache(t *testing.T) {
+	ctx := context.Background()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", map[string]interface{}{})
+	backend := &countingBackend{MockBackend: NewMockBackend()}
+	backend.AddChannel(channel)
+
+	cache := newChannelCacheBackend(backend, time.Minute)
+
+	// first lookup goes to our backend
+	c, err := cache.GetChannel(ctx, ChannelType("MCK"), channel.UUID())
+	assert.NoError(t, err)
+	assert.Equal(t, channel, c)
+	assert.Equal(t, 1, backend.lookups)
+
+	// second is served from our cache
+	c, err = cache.GetChannel(ctx, ChannelType("MCK"), channel.UUID())
+	assert.NoError(t, err)
+	assert.Equal(t, channel, c)
+	assert.Equal(t, 1, backend.lookups)
+
+	// once expired, we refresh it
+	cache.channels[channelCacheKey{ChannelType("MCK"), channel.UUID()}].expiration = time.Now().Add(-time.Second)
+	_, err = cache.GetChannel(ctx, ChannelType("MCK"), channel.UUID())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, backend.lookups)
+
+	// errors aren't cached
+	unknown, _ := NewChannelUUID("b3c0179a-fe0d-4e1a-bd16-f3e5b9f25cd0")
+	_, err = cache.GetChannel(ctx, ChannelType("MCK"), unknown)
+	assert.Equal(t, ErrChannelNotFound, err)
+	_, err = cache.GetChannel(ctx, ChannelType("MCK"), unknown)
+	assert.Equal(t, ErrChannelNotFound, err)
+	assert.Equal(t, 4, backend.lookups)
+}
+
+func TestServerChannelCache(t *testing.T) {
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{})
+	backend := &countingBackend{MockBackend: NewMockBackend()}
+	backend.AddChannel(channel)
+
+	config := testConfig()
+	config.ChannelCacheTTL = 60
+
+	s := NewServerWithLogger(config, backend, logrus.New()).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: s.Backend()}}
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, nil
+	})
+
+	// requests after the first don't look up our channel again
+	for i := 0; i < 3; i++ {
+		s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil))
+	}
+	assert.Equal(t, 1, backend.lookups)
+
+	// until it is invalidated
+	s.InvalidateChannel(channel.UUID())
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil))
+	assert.Equal(t, 2, backend.lookups)
+}
@@ -0,0 +1,58 @@
+package courier
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// channelTypeRoute is one of the routes of a channel type in our /channels response
+type channelTypeRoute struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+// channelTypeInfo describes one of our active handlers in our /channels response
+type channelTypeInfo struct {
+	ChannelType ChannelType        `json:"channel_type"`
+	ChannelName string             `json:"channel_name"`
+	Routes      []channelTypeRoute `json:"routes"`
+}
+
+// channelsResponse is our response to /channels, the channel types this instance serves, e.g.
+//
+//	{"channels": [{"channel_type": "TG", "channel_name": "Telegram", "routes": [{"method": "POST", "path": "/c/tg/{uuid}/receive", "action": "receive"}]}]}
+type channelsResponse struct {
+	Channels []*channelTypeInfo `json:"channels"`
+}
+
+// handleChannels lists our active handlers and the routes they registered, ordered by channel type, so that tooling
+// can check we serve a channel type before sending it traffic
+func (s *server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	infos := make(map[ChannelHandler]*channelTypeInfo, len(activeHandlers))
+	response := &channelsResponse{Channels: make([]*channelTypeInfo, 0, len(activeHandlers))}
+
+	for _, handler := range activeHandlers {
+		info := &channelTypeInfo{ChannelType: handler.ChannelType(), ChannelName: handler.ChannelName(), Routes: []channelTypeRoute{}}
+		infos[handler] = info
+		response.Channels = append(response.Channels, info)
+	}
+	sort.Slice(response.Channels, func(i, j int) bool { return response.Channels[i].ChannelType < response.Channels[j].ChannelType })
+
+	s.routesMutex.RLock()
+	for _, route := range s.channelRoutes {
+		info, found := infos[route.handler]
+		if found {
+			info.Routes = append(info.Routes, channelTypeRoute{Method: strings.ToUpper(route.method), Path: s.channelPath(route.path), Action: route.action})
+		}
+	}
+	s.routesMutex.RUnlock()
+
+	err := writeJSONResponse(r.Context(), w, http.StatusOK, response)
+	if err != nil {
+		logrus.WithError(err).Error()
+	}
+}
@@ -0,0 +1,40 @@
+package courier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannels(t *testing.T) {
+	s := NewServer(testConfig(), NewMockBackend()).(*server)
+
+	handler := &dummyHandler{}
+	handler.Initialize(s)
+	s.AddHandlerRoute(handler, http.MethodPost, "status", handler.receiveMsg)
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	// our shared status callback route doesn't belong to any handler
+	s.addStatusCallbackRoute()
+
+	w := httptest.NewRecorder()
+	s.handleChannels(w, httptest.NewRequest("GET", "/channels", nil))
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"channels": [
+			{
+				"channel_type": "DM",
+				"channel_name": "Dummy Handler",
+				"routes": [
+					{"method": "GET", "path": "/c/dm/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/receive", "action": "receive"},
+					{"method": "POST", "path": "/c/dm/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/status", "action": "status"}
+				]
+			}
+		]
+	}`, w.Body.String())
+}
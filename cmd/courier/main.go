@@ -2,8 +2,6 @@ package main
 
 import (
 	"os"
-	"os/signal"
-	"syscall"
 
 	"github.com/evalphobia/logrus_sentry"
 	_ "github.com/lib/pq"
@@ -84,6 +82,9 @@ func main() {
 	}
 	logrus.SetLevel(level)
 
+	// mask sensitive values before anything is logged, or sent to sentry
+	logrus.AddHook(courier.NewRedactHook(config))
+
 	// if we have a DSN entry, try to initialize it
 	if config.SentryDSN != "" {
 		hook, err := logrus_sentry.NewSentryHook(config.SentryDSN, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel})
@@ -109,9 +110,8 @@ func main() {
 		logrus.Fatalf("Error starting server: %s", err)
 	}
 
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	logrus.WithField("comp", "main").WithField("signal", <-ch).Info("stopping")
-
-	server.Stop()
+	err = courier.WaitForShutdown(server)
+	if err != nil {
+		logrus.WithError(err).Error("error stopping server")
+	}
 }
@@ -0,0 +1,152 @@
+package courier
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConnectionMonitor watches the writes a backend makes over one of its connections, e.g. to its database. After
+// threshold consecutive failed writes it marks the connection as down, so that the backend can spool writes straight
+// away rather than have every request fail against it, and tries reconnecting with an exponential backoff. Once that
+// succeeds the connection is up again and onReconnect is called, e.g. to drain what was spooled while it was down.
+type ConnectionMonitor struct {
+	name        string
+	threshold   int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	check       func() error
+	onReconnect func()
+
+	mutex     sync.Mutex
+	failures  int
+	down      bool
+	downSince time.Time
+	lastErr   error
+
+	stopChan  chan bool
+	stopOnce  sync.Once
+	waitGroup sync.WaitGroup
+}
+
+// NewConnectionMonitor creates a new monitor for the named connection, check should try the connection, returning an
+// error if it is still down. A threshold of 0 means the connection is never marked as down.
+func NewConnectionMonitor(name string, threshold int, minBackoff time.Duration, maxBackoff time.Duration, check func() error, onReconnect func()) *ConnectionMonitor {
+	return &ConnectionMonitor{
+		name:        name,
+		threshold:   threshold,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		check:       check,
+		onReconnect: onReconnect,
+		stopChan:    make(chan bool),
+	}
+}
+
+// Up returns whether our connection is up, writes shouldn't be tried over it while it is down. A nil monitor is always up.
+func (m *ConnectionMonitor) Up() bool {
+	if m == nil {
+		return true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.down
+}
+
+// Record records the result of a write over our connection, marking it as down once threshold writes in a row have
+// failed and starting to try reconnecting
+func (m *ConnectionMonitor) Record(err error) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err == nil {
+		m.failures = 0
+		return
+	}
+
+	m.failures++
+	m.lastErr = err
+	if m.down || m.threshold <= 0 || m.failures < m.threshold {
+		return
+	}
+
+	m.down = true
+	m.downSince = time.Now()
+	logrus.WithError(err).WithField("comp", "connection_monitor").WithField("connection", m.name).WithField("failures", m.failures).Error("connection down, reconnecting")
+
+	m.waitGroup.Add(1)
+	go m.reconnect()
+}
+
+// tries our connection until it is back up, waiting twice as long as the last time after each failed attempt
+func (m *ConnectionMonitor) reconnect() {
+	defer m.waitGroup.Done()
+	log := logrus.WithField("comp", "connection_monitor").WithField("connection", m.name)
+
+	backoff := m.minBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-m.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+
+		err := m.check()
+		if err == nil {
+			m.mutex.Lock()
+			m.down = false
+			m.failures = 0
+			downFor := time.Since(m.downSince)
+			m.mutex.Unlock()
+
+			log.WithField("attempts", attempt).WithField("down_for", downFor).Info("connection reconnected")
+			if m.onReconnect != nil {
+				m.onReconnect()
+			}
+			return
+		}
+
+		m.mutex.Lock()
+		m.lastErr = err
+		m.mutex.Unlock()
+
+		backoff *= 2
+		if backoff > m.maxBackoff {
+			backoff = m.maxBackoff
+		}
+		log.WithError(err).WithField("attempt", attempt).WithField("backoff", backoff).Warn("connection still down")
+	}
+}
+
+// Health returns the health of our connection as seen by the writes over it
+func (m *ConnectionMonitor) Health() HealthStatus {
+	if m == nil {
+		return HealthStatus{State: HealthOK}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := HealthStatus{Name: m.name, State: HealthOK}
+	if m.down {
+		status.State = HealthDown
+		status.Message = fmt.Sprintf("down since %s, reconnecting: %s", m.downSince.UTC().Format(time.RFC3339), m.lastErr)
+	}
+	return status
+}
+
+// Stop stops us trying to reconnect, waiting for any attempt in progress to finish
+func (m *ConnectionMonitor) Stop() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() { close(m.stopChan) })
+	m.waitGroup.Wait()
+}
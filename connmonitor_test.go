@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionMonitor(t *testing.T) {
+	// a db which we can disconnect, and which records reconnection attempts
+	var mutex sync.Mutex
+	connected := true
+	attempts := 0
+	check := func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if !connected {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	setConnected := func(c bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		connected = c
+	}
+	reconnects := make(chan bool, 1)
+
+	monitor := NewConnectionMonitor("database_writes", 3, 10*time.Millisecond, 40*time.Millisecond, check, func() { reconnects <- true })
+	defer monitor.Stop()
+
+	// a backend which writes to our db while it is up, otherwise spooling
+	var written, spooled []string
+	write := func(value string) {
+		if monitor.Up() {
+			var err error
+			if !connected {
+				err = errors.New("connection refused")
+			}
+			monitor.Record(err)
+			if err == nil {
+				written = append(written, value)
+				return
+			}
+		}
+		spooled = append(spooled, value)
+	}
+	flush := func() {
+		written = append(written, spooled...)
+		spooled = nil
+	}
+
+	write("a")
+	assert.Equal(t, []string{"a"}, written)
+	assert.Equal(t, HealthOK, monitor.Health().State)
+
+	// the db goes away, failures below our threshold don't mark it as down
+	setConnected(false)
+	write("b")
+	write("c")
+	assert.True(t, monitor.Up())
+
+	// the next does and we stop trying it, spooling instead without waiting to fail
+	write("d")
+	assert.False(t, monitor.Up())
+	health := monitor.Health()
+	assert.Equal(t, HealthDown, health.State)
+	assert.Contains(t, health.Message, "reconnecting: connection refused")
+
+	write("e")
+	assert.Equal(t, []string{"a"}, written)
+	assert.Equal(t, []string{"b", "c", "d", "e"}, spooled)
+
+	// we keep trying to reconnect, backing off each time we fail
+	time.Sleep(150 * time.Millisecond)
+	mutex.Lock()
+	assert.True(t, attempts >= 2 && attempts <= 6, "unexpected number of reconnect attempts: %d", attempts)
+	mutex.Unlock()
+	assert.False(t, monitor.Up())
+
+	// until the db comes back, when we're up again and flush what was spooled
+	setConnected(true)
+	select {
+	case <-reconnects:
+		flush()
+	case <-time.After(time.Second):
+		assert.Fail(t, "didn't reconnect")
+	}
+	assert.True(t, monitor.Up())
+	assert.Equal(t, HealthOK, monitor.Health().State)
+
+	// and writes go to the db again
+	write("f")
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, written)
+	assert.Equal(t, 0, len(spooled))
+
+	// a monitor without a threshold never marks its connection as down
+	monitor = NewConnectionMonitor("database_writes", 0, time.Millisecond, time.Millisecond, check, nil)
+	for i := 0; i < 10; i++ {
+		monitor.Record(errors.New("connection refused"))
+	}
+	assert.True(t, monitor.Up())
+}
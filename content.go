@@ -0,0 +1,136 @@
+package courier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ConfigContentDenylist is a list of regular expressions, outgoing messages matching any of them will not be sent
+	ConfigContentDenylist = "content_denylist"
+
+	// ConfigMaxURLs is the maximum number of URLs an outgoing message may contain
+	ConfigMaxURLs = "max_urls"
+
+	// ConfigMaxMsgLength is the maximum number of characters the text of an outgoing message may have in total, unlike
+	// ConfigMaxLength which is the length handlers split longer messages into parts of
+	ConfigMaxMsgLength = "max_msg_length"
+
+	// ConfigRequireTemplates is whether the channel can only send msgs which aren't responses to the contact, i.e. those
+	// which start a conversation, as pre-approved templates, e.g. WhatsApp outside of its 24 hour customer care window
+	ConfigRequireTemplates = "require_templates"
+)
+
+// ErrContentInvalid is the type of error returned when a message doesn't pass its channel's content rules
+type ErrContentInvalid struct {
+	Reason string
+}
+
+func (e *ErrContentInvalid) Error() string {
+	return fmt.Sprintf("message content invalid: %s", e.Reason)
+}
+
+// ErrMsgInvalid is the type of error returned when an outgoing message can't be sent on its channel at all
+type ErrMsgInvalid struct {
+	Reason string
+}
+
+func (e *ErrMsgInvalid) Error() string {
+	return fmt.Sprintf("message invalid: %s", e.Reason)
+}
+
+// ValidateMsg checks the passed in outgoing message can be sent on its channel, i.e. that it has something to send,
+// its URN is one the channel supports, its text isn't longer than the channel allows and it has a template if the
+// channel requires one, and then that it passes the content rules of its channel, see ValidateMsgContent
+func ValidateMsg(msg Msg) error {
+	channel := msg.Channel()
+
+	if strings.TrimSpace(msg.Text()) == "" && len(msg.Attachments()) == 0 {
+		return &ErrMsgInvalid{"has no text or attachments"}
+	}
+
+	// channels which don't tell us their schemes support any
+	scheme := msg.URN().Scheme()
+	if len(channel.Schemes()) > 0 && !utils.StringArrayContains(channel.Schemes(), scheme) {
+		return &ErrMsgInvalid{fmt.Sprintf("URN scheme '%s' isn't supported by channel, which supports %s", scheme, strings.Join(channel.Schemes(), ", "))}
+	}
+
+	maxLength := channel.IntConfigForKey(ConfigMaxMsgLength, 0)
+	if maxLength > 0 {
+		length := utf8.RuneCountInString(msg.Text())
+		if length > maxLength {
+			return &ErrMsgInvalid{fmt.Sprintf("text is %d characters, channel allows at most %d", length, maxLength)}
+		}
+	}
+
+	// msgs which start a conversation on channels which require templates for them need one, the provider would reject them otherwise
+	if channel.BoolConfigForKey(ConfigRequireTemplates, false) && msg.ResponseToID() == NilMsgID && msg.ResponseToExternalID() == "" {
+		templating, err := msg.Templating()
+		if err != nil {
+			return &ErrMsgInvalid{fmt.Sprintf("unable to decode template: %s", err)}
+		}
+		if templating == nil {
+			return &ErrMsgInvalid{"channel requires a template for msgs which aren't responses to the contact, and msg has none"}
+		}
+	}
+
+	return ValidateMsgContent(msg)
+}
+
+var urlRegex = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+// ValidateMsgContent checks the passed in outgoing message against the content rules configured on its
+// channel, returning an ErrContentInvalid describing the first rule it violates
+func ValidateMsgContent(msg Msg) error {
+	channel := msg.Channel()
+	text := msg.Text()
+
+	maxURLs := channel.IntConfigForKey(ConfigMaxURLs, -1)
+	if maxURLs >= 0 {
+		count := len(urlRegex.FindAllString(text, -1))
+		if count > maxURLs {
+			return &ErrContentInvalid{fmt.Sprintf("contains %d URLs, channel allows at most %d", count, maxURLs)}
+		}
+	}
+
+	for _, pattern := range configStringList(channel, ConfigContentDenylist) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logrus.WithField("channel_uuid", channel.UUID()).WithField("pattern", pattern).WithError(err).Error("invalid content denylist pattern, ignoring")
+			continue
+		}
+		if re.MatchString(text) {
+			return &ErrContentInvalid{fmt.Sprintf("matches denied pattern '%s'", pattern)}
+		}
+	}
+
+	return nil
+}
+
+// configStringList returns the config value for the passed in key as a list of strings, JSON decoded
+// configs will give us []interface{} while test channels may use []string
+func configStringList(channel Channel, key string) []string {
+	switch v := channel.ConfigForKey(key, nil).(type) {
+	case []string:
+		return v
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, s := range v {
+			str, isStr := s.(string)
+			if isStr {
+				strs = append(strs, str)
+			}
+		}
+		return strs
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	}
+	return nil
+}
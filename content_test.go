@@ -0,0 +1,103 @@
+package courier
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMsgContent(t *testing.T) {
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	msg := &mockMsg{channel: channel, text: "see http://foo.com and www.bar.com"}
+
+	// no rules configured, everything goes
+	assert.NoError(t, ValidateMsgContent(msg))
+
+	// URL count rule
+	channel.SetConfig(ConfigMaxURLs, 2)
+	assert.NoError(t, ValidateMsgContent(msg))
+
+	channel.SetConfig(ConfigMaxURLs, float64(1))
+	err := ValidateMsgContent(msg)
+	assert.EqualError(t, err, "message content invalid: contains 2 URLs, channel allows at most 1")
+	assert.IsType(t, &ErrContentInvalid{}, err)
+
+	channel.SetConfig(ConfigMaxURLs, 0)
+	assert.NoError(t, ValidateMsgContent(&mockMsg{channel: channel, text: "no links here"}))
+	assert.Error(t, ValidateMsgContent(&mockMsg{channel: channel, text: "go to HTTPS://foo.com/path?x=1"}))
+
+	// keyword rule
+	channel = NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{
+		ConfigContentDenylist: []interface{}{`(?i)\bloan\b`, `[invalid`, `casino`},
+	})
+	assert.NoError(t, ValidateMsgContent(&mockMsg{channel: channel, text: "your balance is low"}))
+	assert.EqualError(t, ValidateMsgContent(&mockMsg{channel: channel, text: "Get a LOAN today"}), `message content invalid: matches denied pattern '(?i)\bloan\b'`)
+	assert.EqualError(t, ValidateMsgContent(&mockMsg{channel: channel, text: "visit our casino"}), `message content invalid: matches denied pattern 'casino'`)
+
+	// a single string pattern works too
+	channel.SetConfig(ConfigContentDenylist, "prize")
+	assert.Error(t, ValidateMsgContent(&mockMsg{channel: channel, text: "you won a prize"}))
+}
+
+func TestMsgTemplatingFromMetadata(t *testing.T) {
+	templating, err := MsgTemplatingFromMetadata(json.RawMessage(`{"topic": "event", "templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "variables": ["Chef", "tomorrow"]}}`))
+	assert.NoError(t, err)
+	if assert.NotNil(t, templating) {
+		assert.Equal(t, "revive_issue", templating.Template.Name)
+		assert.Equal(t, "171f8a4d-f725-46d7-85a6-11aceff0bfe3", templating.Template.UUID)
+		assert.Equal(t, "eng", templating.Language)
+		assert.Equal(t, []string{"Chef", "tomorrow"}, templating.Variables)
+	}
+
+	templating, err = MsgTemplatingFromMetadata(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, templating)
+
+	templating, err = MsgTemplatingFromMetadata(json.RawMessage(`{"topic": "event"}`))
+	assert.NoError(t, err)
+	assert.Nil(t, templating)
+
+	// malformed metadata is an error rather than no templating
+	templating, err = MsgTemplatingFromMetadata(json.RawMessage(`{"templating": `))
+	assert.EqualError(t, err, "unexpected end of JSON input")
+	assert.Nil(t, templating)
+
+	templating, err = MsgTemplatingFromMetadata(json.RawMessage(`{"templating": {"template": "revive_issue"}}`))
+	assert.Error(t, err)
+	assert.Nil(t, templating)
+}
+
+func TestValidateMsg(t *testing.T) {
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello"}))
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", attachments: []string{"image/jpeg:https://foo.bar/image.jpg"}}))
+
+	// msgs need something to send
+	err := ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "  "})
+	assert.EqualError(t, err, "message invalid: has no text or attachments")
+	assert.IsType(t, &ErrMsgInvalid{}, err)
+
+	// to a URN their channel supports
+	assert.EqualError(t, ValidateMsg(&mockMsg{channel: channel, urn: "telegram:12345", text: "hello"}), "message invalid: URN scheme 'telegram' isn't supported by channel, which supports tel")
+
+	// with text no longer than their channel allows
+	channel.SetConfig(ConfigMaxMsgLength, 5)
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "héllo"}))
+	assert.EqualError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello!"}), "message invalid: text is 6 characters, channel allows at most 5")
+
+	// with a template if they start a conversation on a channel which requires them
+	channel.SetConfig(ConfigRequireTemplates, true)
+	templating := json.RawMessage(`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "variables": ["Chef", "tomorrow"]}}`)
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello", metadata: templating}))
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello", responseToID: NewMsgID(101)}))
+	assert.NoError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello", responseToExternalID: "ext1"}))
+	assert.EqualError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello", metadata: json.RawMessage(`{"topic": "event"}`)}), "message invalid: channel requires a template for msgs which aren't responses to the contact, and msg has none")
+	assert.EqualError(t, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello", metadata: json.RawMessage(`{"templating": `)}), "message invalid: unable to decode template: unexpected end of JSON input")
+	channel.SetConfig(ConfigRequireTemplates, false)
+
+	// and passing its content rules
+	channel.SetConfig(ConfigContentDenylist, "hello")
+	assert.IsType(t, &ErrContentInvalid{}, ValidateMsg(&mockMsg{channel: channel, urn: "tel:+250788383383", text: "hello"}))
+}
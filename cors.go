@@ -0,0 +1,84 @@
+package courier
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// how long browsers can cache our answers to CORS preflight requests for, in seconds
+const corsMaxAge = 600
+
+// parseOrigins parses the passed in comma separated list of origins
+func parseOrigins(origins string) []string {
+	parsed := make([]string, 0)
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			parsed = append(parsed, origin)
+		}
+	}
+	return parsed
+}
+
+// corsAllowed returns which of our allowed origins the passed in origin matches, which is either the origin itself
+// or the wildcard *, or empty if it isn't allowed at all
+func corsAllowed(allowedOrigins []string, origin string) string {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" {
+			return allowed
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// newCORSMiddleware returns middleware which answers CORS preflight requests and sets the Access-Control-Allow-*
+// headers on responses to requests from the passed in origins. Credentials are only allowed for origins listed
+// explicitly, never for the wildcard *.
+func newCORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowedMethods := strings.Join(supportedRouteMethods, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			allowed := corsAllowed(allowedOrigins, origin)
+
+			w.Header().Add("Vary", "Origin")
+
+			if allowed == "" {
+				if preflight {
+					WriteDataResponse(r.Context(), w, http.StatusForbidden, "Forbidden", []interface{}{NewErrorData(fmt.Sprintf("origin not allowed: %s", origin))})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if allowed != "*" {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", fmt.Sprint(corsMaxAge))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
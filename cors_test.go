@@ -0,0 +1,89 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	newServer := func(origins string) *server {
+		config := NewConfig()
+		config.CORSAllowedOrigins = origins
+		s := NewServer(config, NewMockBackend()).(*server)
+
+		channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "AL", "2020", "US", map[string]interface{}{})
+		s.AddHandlerRoute(&allowlistHandler{channel: channel}, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+			w.Write([]byte("ok"))
+			return nil, nil
+		})
+		return s
+	}
+
+	request := func(s *server, method string, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/c/al/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	// no origins configured, no CORS
+	s := newServer("")
+	w := request(s, http.MethodOptions, "https://widget.example.com")
+	assert.Equal(t, 405, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = request(s, http.MethodPost, "https://widget.example.com")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	s = newServer("https://widget.example.com, https://other.example.com/")
+
+	// preflight from an allowed origin
+	w = request(s, http.MethodOptions, "https://widget.example.com")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "https://widget.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "POST")
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	// and the actual request
+	w = request(s, http.MethodPost, "https://other.example.com")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Equal(t, "https://other.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// preflight from a disallowed origin is rejected
+	w = request(s, http.MethodOptions, "https://evil.example.com")
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), "origin not allowed: https://evil.example.com")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// and other requests from it don't get any CORS headers so browsers won't let the page read the response
+	w = request(s, http.MethodPost, "https://evil.example.com")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// requests which aren't cross origin are untouched
+	w = request(s, http.MethodPost, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Vary"))
+
+	// the wildcard allows any origin, but never with credentials
+	s = newServer("*")
+	w = request(s, http.MethodOptions, "https://evil.example.com")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+}
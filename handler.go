@@ -18,6 +18,13 @@ type Event interface {
 // execution or in courier itself should be passed back.
 type ChannelHandleFunc func(context.Context, Channel, http.ResponseWriter, *http.Request) ([]Event, error)
 
+// ChannelResolver looks up the channel for the raw identifier in the path of a route added with
+// AddHandlerRouteWithResolver, returning an error if there isn't one
+type ChannelResolver func(ctx context.Context, id string) (Channel, error)
+
+// getChannelFunc looks up the channel an incoming request is for
+type getChannelFunc func(context.Context, *http.Request) (Channel, error)
+
 // ChannelHandler is the interface all handlers must satisfy
 type ChannelHandler interface {
 	Initialize(Server) error
@@ -38,6 +45,38 @@ type MediaDownloadRequestBuilder interface {
 	BuildDownloadMediaRequest(context.Context, Backend, Channel, string) (*http.Request, error)
 }
 
+// ChannelAcker is the interface handlers whose providers expect a specific response to their callbacks should satisfy,
+// e.g. a plain "OK" or an XML ack, as anything else can have them retry the callback again and again. Requests to these
+// handlers which are handled without writing a response get the success ack.
+type ChannelAcker interface {
+	// SuccessAck returns the response for a request whose msgs, statuses or events were written, or which was ignored
+	SuccessAck(Channel) *ChannelResponse
+
+	// ErrorAck returns the response for a request which failed with the passed in error, nil to write it as usual
+	ErrorAck(Channel, error) *ChannelResponse
+}
+
+// ChannelConfigDefaulter is the interface handlers which provide default config values for all channels of their type should satisfy.
+// Individual channels can override any of these values in their own config.
+type ChannelConfigDefaulter interface {
+	DefaultChannelConfig() map[string]interface{}
+}
+
+// DefaultConfigForKey returns the handler level default config value for the passed in channel type and key,
+// or defaultValue if the handler doesn't provide one
+func DefaultConfigForKey(ct ChannelType, key string, defaultValue interface{}) interface{} {
+	defaulter, isDefaulter := registeredHandlers[ct].(ChannelConfigDefaulter)
+	if !isDefaulter {
+		return defaultValue
+	}
+
+	value, found := defaulter.DefaultChannelConfig()[key]
+	if !found {
+		return defaultValue
+	}
+	return value
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
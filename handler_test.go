@@ -1,13 +1,20 @@
 package courier
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +37,14 @@ func (h *dummyHandler) ChannelName() string       { return "Dummy Handler" }
 func (h *dummyHandler) ChannelType() ChannelType  { return ChannelType("DM") }
 func (h *dummyHandler) UseChannelRouteUUID() bool { return true }
 
+var dummyDefaultConfig = map[string]interface{}{
+	ConfigBaseURL:      "https://api.dummy.com",
+	ConfigMaxLength:    float64(160),
+	ConfigMaxMsgLength: 640,
+}
+
+func (h *dummyHandler) DefaultChannelConfig() map[string]interface{} { return dummyDefaultConfig }
+
 func (h *dummyHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
 	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
 	return dmChannel, nil
@@ -58,9 +73,24 @@ func (h *dummyHandler) receiveMsg(ctx context.Context, channel Channel, w http.R
 	}
 
 	msg := h.backend.NewIncomingMsg(channel, urns.URN("tel:"+from), text)
-	w.WriteHeader(200)
-	w.Write([]byte("ok"))
+	if r.Form.Get("id") != "" {
+		msg.WithExternalID(r.Form.Get("id"))
+	}
+	if r.Form.Get("date") != "" {
+		date, _ := time.Parse(time.RFC3339, r.Form.Get("date"))
+		msg.WithReceivedOn(date)
+	}
+	if r.Form.Get("key") != "" {
+		msg.WithIdempotencyKey(r.Form.Get("key"))
+	}
+
 	h.backend.WriteMsg(ctx, msg)
+	w.WriteHeader(200)
+	if msg.AlreadyWritten() {
+		w.Write([]byte("duplicate"))
+	} else {
+		w.Write([]byte("ok"))
+	}
 	return []Event{msg}, nil
 }
 
@@ -102,13 +132,14 @@ func TestHandling(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// message should have errored because we have registered handlers
-	assert.Equal(1, len(mb.msgStatuses))
-	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
-	assert.Equal(MsgErrored, mb.msgStatuses[0].Status())
-	assert.Equal(1, len(mb.msgStatuses[0].Logs()))
+	statuses := mb.WrittenMsgStatuses()
+	assert.Equal(1, len(statuses))
+	assert.Equal(msg.ID(), statuses[0].ID())
+	assert.Equal(MsgErrored, statuses[0].Status())
+	assert.Equal(1, len(statuses[0].Logs()))
 
 	// clear our statuses
-	mb.msgStatuses = nil
+	mb.ClearMsgStatuses()
 
 	// change our channel to our dummy channel
 	msg = &mockMsg{
@@ -124,21 +155,23 @@ func TestHandling(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// message should be marked as wired
-	assert.Equal(1, len(mb.msgStatuses))
-	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
-	assert.Equal(MsgSent, mb.msgStatuses[0].Status())
+	statuses = mb.WrittenMsgStatuses()
+	assert.Equal(1, len(statuses))
+	assert.Equal(msg.ID(), statuses[0].ID())
+	assert.Equal(MsgSent, statuses[0].Status())
 
 	// clear our statuses
-	mb.msgStatuses = nil
+	mb.ClearMsgStatuses()
 
 	// send the message again, should be skipped but again marked as wired
 	mb.PushOutgoingMsg(msg)
 	time.Sleep(time.Second)
 
 	// message should be marked as wired
-	assert.Equal(1, len(mb.msgStatuses))
-	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
-	assert.Equal(MsgWired, mb.msgStatuses[0].Status())
+	statuses = mb.WrittenMsgStatuses()
+	assert.Equal(1, len(statuses))
+	assert.Equal(msg.ID(), statuses[0].ID())
+	assert.Equal(MsgWired, statuses[0].Status())
 
 	// try to receive a message instead
 	resp, err := http.Get("http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive")
@@ -161,3 +194,585 @@ func TestHandling(t *testing.T) {
 	log, _ := mb.GetLastChannelLog()
 	assert.NotContains(log.Request, "secret")
 }
+
+func TestDefaultChannelConfig(t *testing.T) {
+	// channels inherit the defaults of their handler
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, "https://api.dummy.com", channel.StringConfigForKey(ConfigBaseURL, ""))
+	assert.Equal(t, 160, channel.IntConfigForKey(ConfigMaxLength, 640))
+	assert.Equal(t, 640, channel.IntConfigForKey(ConfigMaxMsgLength, 0))
+	assert.Equal(t, "default", channel.StringConfigForKey(ConfigSendMethod, "default"))
+
+	// but can override them
+	channel.SetConfig(ConfigBaseURL, "https://eu.dummy.com")
+	channel.SetConfig(ConfigMaxLength, 320)
+	assert.Equal(t, "https://eu.dummy.com", channel.StringConfigForKey(ConfigBaseURL, ""))
+	assert.Equal(t, 320, channel.IntConfigForKey(ConfigMaxLength, 640))
+
+	// channel types without defaults just use the passed in default
+	channel = NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "XX", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, "", channel.StringConfigForKey(ConfigBaseURL, ""))
+	assert.Equal(t, 640, channel.IntConfigForKey(ConfigMaxLength, 640))
+	assert.Equal(t, "fallback", DefaultConfigForKey(ChannelType("XX"), ConfigBaseURL, "fallback"))
+}
+
+func TestHandlerRouteMethods(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb)
+
+	// add a PUT route to our dummy handler
+	handler := GetHandler(ChannelType("DM"))
+	s.AddHandlerRoute(handler, http.MethodPut, "update", func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		w.Write([]byte("updated"))
+		return nil, nil
+	})
+
+	// methods we don't support are rejected
+	assert.PanicsWithValue(t, "unsupported method: CONNECT", func() {
+		s.AddHandlerRoute(handler, "CONNECT", "connect", nil)
+	})
+
+	s.Start()
+	defer s.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// a PUT reaches our handler
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/update", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "updated", string(body))
+
+	// but a GET to the same path isn't allowed
+	resp, err = http.Get("http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/update")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 405, resp.StatusCode)
+}
+
+func TestDrain(t *testing.T) {
+	// draining before we've started is fine
+	NewServer(testConfig(), NewMockBackend()).Drain()
+
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+	s.Start()
+	defer s.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(dmChannel)
+
+	s.Drain()
+
+	// new channel requests are rejected so they are retried
+	resp, err := http.Get("http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+	assert.Equal(t, 0, len(mb.queueMsgs))
+
+	// and we no longer report being ready
+	resp, err = http.Get("http://localhost:8080/ready")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 503, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "draining")
+
+	// and msgs are left on the queue
+	mb.PushOutgoingMsg(&mockMsg{channel: dmChannel, id: NewMsgID(103), uuid: NilMsgUUID, text: "not sent", urn: "tel:+250788383383"})
+	time.Sleep(time.Second)
+	assert.Equal(t, 0, len(mb.msgStatuses))
+	assert.Equal(t, 1, len(mb.outgoingMsgs))
+}
+
+func TestDuplicateMsgs(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+	s.Start()
+	defer s.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	receive := func(query string) string {
+		resp, err := http.Get("http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?" + query)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// the same payload with an external id twice is only written once
+	assert.Equal(t, "ok", receive("from=2065551212&text=hello&id=ext1"))
+	assert.Equal(t, "duplicate", receive("from=2065551212&text=hello&id=ext1"))
+	assert.Equal(t, 1, mb.LenQueuedMsgs())
+
+	// a different external id is a new msg
+	assert.Equal(t, "ok", receive("from=2065551212&text=hello&id=ext2"))
+	assert.Equal(t, 2, mb.LenQueuedMsgs())
+
+	// without an external id we fall back to the content and timestamp
+	assert.Equal(t, "ok", receive("from=2065551212&text=hi&date=2020-06-01T10:00:00Z"))
+	assert.Equal(t, "duplicate", receive("from=2065551212&text=hi&date=2020-06-01T10:00:00Z"))
+	assert.Equal(t, "ok", receive("from=2065551212&text=hi&date=2020-06-01T10:00:05Z"))
+	assert.Equal(t, 4, mb.LenQueuedMsgs())
+
+	// and without either, we can't tell so always write
+	assert.Equal(t, "ok", receive("from=2065551212&text=yes"))
+	assert.Equal(t, "ok", receive("from=2065551212&text=yes"))
+	assert.Equal(t, 6, mb.LenQueuedMsgs())
+
+	// unless the handler gives us an idempotency key, which takes precedence over everything else
+	assert.Equal(t, "ok", receive("from=2065551212&text=yes&key=cb1"))
+	assert.Equal(t, "duplicate", receive("from=2065551212&text=yes&key=cb1&id=ext3"))
+	assert.Equal(t, "ok", receive("from=2065551212&text=yes&key=cb2&id=ext1"))
+	assert.Equal(t, 8, mb.LenQueuedMsgs())
+}
+
+type resolverHandler struct {
+	dummyHandler
+}
+
+func (h *resolverHandler) ChannelType() ChannelType { return ChannelType("RS") }
+
+func (h *resolverHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		return nil, err
+	}
+	return h.backend.GetChannel(ctx, h.ChannelType(), uuid)
+}
+
+func TestHandlerRouteWithResolver(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "1234567890", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+
+	writeChannel := func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		w.Write([]byte(channel.UUID().String()))
+		return nil, nil
+	}
+
+	// one route looked up by our UUID, another by the provider's page ID
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", writeChannel)
+	s.AddHandlerRouteWithResolver(handler, http.MethodPost, "page", func(ctx context.Context, id string) (Channel, error) {
+		return mb.GetChannelByAddress(ctx, handler.ChannelType(), ChannelAddress(id))
+	}, writeChannel)
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+
+	w := request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", w.Body.String())
+
+	w = request("/c/rs/1234567890/page")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", w.Body.String())
+
+	// page IDs don't match our UUID route, and unknown ones are errors from our resolver
+	assert.Equal(t, 404, request("/c/rs/1234567890/receive").Code)
+	assert.Equal(t, 400, request("/c/rs/999/page").Code)
+
+	assert.Contains(t, s.routes, fmt.Sprintf("%-20s - %s %s", "/c/rs/{id}/page", handler.ChannelName(), "page"))
+}
+
+func TestRemoveHandlerRoutes(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "1234567890", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	removed := &resolverHandler{dummyHandler{server: s, backend: mb}}
+	kept := &allowlistHandler{channel: NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "AL", "2020", "US", nil)}
+
+	writeOK := func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		w.Write([]byte("ok"))
+		return nil, nil
+	}
+
+	s.AddHandlerRoute(removed, http.MethodPost, "receive", writeOK)
+	s.AddHandlerRoute(removed, http.MethodPost, "status", writeOK)
+	s.AddHandlerRoute(kept, http.MethodPost, "receive", writeOK)
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, 200, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+	assert.Equal(t, 200, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/status"))
+	assert.Equal(t, 200, request("/c/al/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+
+	s.RemoveHandlerRoutes(removed)
+
+	// only the routes of the removed handler are gone, both from our router and our route help
+	assert.Equal(t, 404, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+	assert.Equal(t, 404, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/status"))
+	assert.Equal(t, 200, request("/c/al/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+
+	assert.Equal(t, 1, len(s.routes))
+	assert.Contains(t, s.routes[0], "/c/al/")
+
+	// removing a handler again is a no-op, and handlers can still add routes afterwards
+	s.RemoveHandlerRoutes(removed)
+	s.AddHandlerRoute(removed, http.MethodPost, "receive", writeOK)
+	assert.Equal(t, 200, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+}
+
+func TestHandlerChannelEvent(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "1234567890", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+
+	// a provider telling us a contact started a conversation, like a "get started" button being pressed
+	s.AddHandlerRoute(handler, http.MethodPost, "start", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		event := mb.NewChannelEvent(c, NewConversation, urns.URN("tel:+12065551212")).WithContactName("Bob")
+		err := mb.WriteChannelEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{event}, WriteChannelEventSuccess(ctx, w, r, event)
+	})
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/start", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Event Accepted")
+
+	event, err := mb.GetLastChannelEvent()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewConversation, event.EventType())
+		assert.Equal(t, urns.URN("tel:+12065551212"), event.URN())
+		assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", event.ChannelUUID().String())
+	}
+	assert.Equal(t, "Bob", mb.GetLastContactName())
+
+	// and the channel log of handling it is written like any other request
+	assert.Equal(t, 1, len(mb.channelLogs))
+}
+
+func TestChannelBasePath(t *testing.T) {
+	assert.NoError(t, checkChannelBasePath("/c/"))
+	assert.NoError(t, checkChannelBasePath("/hooks/courier/"))
+	assert.EqualError(t, checkChannelBasePath("hooks/"), "invalid channel base path 'hooks/', must begin and end with /")
+	assert.Error(t, checkChannelBasePath("/hooks"))
+	assert.Error(t, checkChannelBasePath("/"))
+	assert.Error(t, checkChannelBasePath(""))
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "1234567890", "US", map[string]interface{}{}))
+
+	config := testConfig()
+	config.ChannelBasePath = "/hooks/"
+	s := NewServer(config, mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		w.Write([]byte("ok"))
+		return nil, nil
+	})
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w.Code
+	}
+
+	// callbacks reach our handler under our custom base path, and still under our default one
+	assert.Equal(t, 200, request("/hooks/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+	assert.Equal(t, 200, request("/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+	assert.Equal(t, 404, request("/other/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive"))
+
+	// and our index lists routes under our custom base path
+	if assert.Equal(t, 1, len(s.routes)) {
+		assert.True(t, strings.HasPrefix(s.routes[0], "/hooks/rs/{uuid:"), "unexpected route %s", s.routes[0])
+	}
+
+	// an invalid base path is an error when we start
+	config.ChannelBasePath = "hooks"
+	assert.EqualError(t, NewServer(config, mb).Start(), "invalid channel base path 'hooks', must begin and end with /")
+}
+
+func TestMockBackendErrors(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "1234567890", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	s := NewServer(testConfig(), mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		msg := mb.NewIncomingMsg(c, urns.URN("tel:+12065551212"), "hello")
+		err := mb.WriteMsg(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{msg}, WriteMsgSuccess(ctx, w, r, []Msg{msg})
+	})
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil))
+		return w
+	}
+
+	// a msg which is written can be asserted on
+	assert.Equal(t, 200, request().Code)
+	msg, err := mb.GetLastQueueMsg()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", msg.Text())
+
+	// failing to write it is an error
+	mb.SetErrorOnQueue(true)
+	w := request()
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "unable to queue message")
+	mb.SetErrorOnQueue(false)
+
+	// as is failing to look up the channel
+	mb.SetErrorOnGetChannel(errors.New("database is down"))
+	w = request()
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "database is down")
+
+	_, err = mb.GetChannelByAddress(context.Background(), ChannelType("RS"), ChannelAddress("1234567890"))
+	assert.EqualError(t, err, "database is down")
+
+	mb.SetErrorOnGetChannel(nil)
+	assert.Equal(t, 200, request().Code)
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{}))
+
+	config := testConfig()
+	config.HandlerTimeout = 1
+	s := NewServer(config, mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+
+	// a handler which is stuck waiting on something which never comes
+	s.AddHandlerRoute(handler, http.MethodPost, "slow", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	s.AddHandlerRoute(handler, http.MethodPost, "fast", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "nothing to do")
+	})
+
+	request := func(action string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/"+action, nil))
+		return w
+	}
+
+	start := time.Now()
+	w := request("slow")
+	assert.Equal(t, 504, w.Code)
+	assert.Contains(t, w.Body.String(), "timed out handling request")
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	// the timeout is still recorded in our channel log
+	log, err := mb.GetLastChannelLog()
+	assert.NoError(t, err)
+	assert.Equal(t, 504, log.StatusCode)
+	assert.Equal(t, "timed out handling request", log.Error)
+
+	assert.Equal(t, 200, request("fast").Code)
+}
+
+func TestHandlerPanic(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+
+	// a handler with a bug in it
+	s.AddHandlerRoute(handler, http.MethodPost, "buggy", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		var payload map[string]string
+		payload["text"] = "hello"
+		return nil, nil
+	})
+	s.AddHandlerRoute(handler, http.MethodPost, "fine", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "nothing to do")
+	})
+
+	request := func(action string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/"+action, strings.NewReader("text=hello")))
+		return w
+	}
+
+	w := request("buggy")
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "panic handling request")
+
+	// the panic is recorded in our channel log
+	log, err := mb.GetLastChannelLog()
+	assert.NoError(t, err)
+	assert.Equal(t, "Channel Error", log.Description)
+	assert.Equal(t, 500, log.StatusCode)
+	assert.Equal(t, "panic handling request: assignment to entry in nil map", log.Error)
+
+	// and we carry on handling requests
+	assert.Equal(t, 200, request("fine").Code)
+}
+
+func TestHandlerMultipartCleanup(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	handler := &resolverHandler{dummyHandler{server: s, backend: mb}}
+
+	// a handler which parses a multipart body without buffering any of it in memory, remembering where its file went
+	var tmpFile string
+	s.AddHandlerRoute(handler, http.MethodPost, "upload", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		if err := r.ParseMultipartForm(0); err != nil {
+			return nil, err
+		}
+		file, err := r.MultipartForm.File["media"][0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		tmpFile = file.(*os.File).Name()
+		return nil, WriteIgnored(ctx, w, r, "nothing to do")
+	})
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("media", "photo.jpg")
+	fileWriter.Write([]byte("not really a jpeg"))
+	writer.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/c/rs/e4bb1578-29da-4fa5-a214-9da19dd24230/upload", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	s.router.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+
+	// the temporary file for the upload is removed once the request has been handled
+	assert.NotEqual(t, "", tmpFile)
+	_, err := os.Stat(tmpFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// xmlAckHandler is a handler whose provider expects an XML ack for its callbacks, including those which fail
+type xmlAckHandler struct {
+	resolverHandler
+}
+
+func (h *xmlAckHandler) ChannelType() ChannelType { return ChannelType("XA") }
+
+func (h *xmlAckHandler) SuccessAck(c Channel) *ChannelResponse {
+	return NewChannelResponse("text/xml", []byte(`<ack><status>OK</status></ack>`))
+}
+
+func (h *xmlAckHandler) ErrorAck(c Channel, err error) *ChannelResponse {
+	return &ChannelResponse{StatusCode: http.StatusBadRequest, ContentType: "text/xml", Body: []byte(fmt.Sprintf(`<ack><status>ERROR</status><reason>%s</reason></ack>`, err))}
+}
+
+// zeroAckHandler is a handler whose provider expects a plain 0 for its callbacks, but our usual errors
+type zeroAckHandler struct {
+	resolverHandler
+}
+
+func (h *zeroAckHandler) ChannelType() ChannelType { return ChannelType("ZA") }
+
+func (h *zeroAckHandler) SuccessAck(c Channel) *ChannelResponse {
+	return NewChannelResponse("", []byte("0"))
+}
+
+func (h *zeroAckHandler) ErrorAck(c Channel, err error) *ChannelResponse { return nil }
+
+func TestChannelAcks(t *testing.T) {
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "XA", "2020", "US", map[string]interface{}{}))
+	mb.AddChannel(NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "ZA", "2020", "US", map[string]interface{}{}))
+
+	s := NewServer(testConfig(), mb).(*server)
+	xmlHandler := &xmlAckHandler{resolverHandler{dummyHandler{server: s, backend: mb}}}
+	zeroHandler := &zeroAckHandler{resolverHandler{dummyHandler{server: s, backend: mb}}}
+
+	for _, handler := range []ChannelHandler{xmlHandler, zeroHandler} {
+		s.AddHandlerRoute(handler, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+			msg := mb.NewIncomingMsg(c, "tel:+250788383383", r.FormValue("text"))
+			return []Event{msg}, s.Backend().WriteMsg(ctx, msg)
+		})
+		s.AddHandlerRoute(handler, http.MethodPost, "status", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+			status := mb.NewMsgStatusForExternalID(c, r.FormValue("id"), MsgDelivered)
+			return []Event{status}, s.Backend().WriteMsgStatus(ctx, status)
+		})
+		s.AddHandlerRoute(handler, http.MethodPost, "custom", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+			w.WriteHeader(http.StatusAccepted)
+			_, err := w.Write([]byte("handled it myself"))
+			return nil, err
+		})
+	}
+
+	request := func(path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	// msgs and statuses written successfully are acked as each provider expects
+	w := request("/c/xa/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", "text=hello")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/xml", w.Header().Get("Content-Type"))
+	assert.Equal(t, `<ack><status>OK</status></ack>`, w.Body.String())
+	assert.Equal(t, 1, mb.LenQueuedMsgs())
+
+	w = request("/c/xa/e4bb1578-29da-4fa5-a214-9da19dd24230/status", "id=ext1")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `<ack><status>OK</status></ack>`, w.Body.String())
+
+	w = request("/c/za/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive", "text=hello")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "0", w.Body.String())
+
+	w = request("/c/za/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status", "id=ext1")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0", w.Body.String())
+
+	// and the ack is what is logged as our response
+	log, err := mb.GetLastChannelLog()
+	assert.NoError(t, err)
+	assert.Equal(t, "Status Updated", log.Description)
+	assert.Equal(t, "0", log.Response)
+
+	// handlers can still write their own response
+	w = request("/c/xa/e4bb1578-29da-4fa5-a214-9da19dd24230/custom", "")
+	assert.Equal(t, 202, w.Code)
+	assert.Equal(t, "handled it myself", w.Body.String())
+
+	// failed writes get the error ack if the provider has one, or our usual error if not
+	mb.SetErrorOnQueue(true)
+	w = request("/c/xa/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", "text=hello")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, `<ack><status>ERROR</status><reason>unable to queue message</reason></ack>`, w.Body.String())
+
+	w = request("/c/za/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive", "text=hello")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "unable to queue message")
+}
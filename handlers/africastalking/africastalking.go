@@ -6,12 +6,10 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 )
 
 const configIsShared = "is_shared"
@@ -59,17 +57,13 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	// create our date from the timestamp
 	// 2017-05-03T06:04:45Z
-	date, err := time.Parse("2006-01-02T15:04:05Z", form.Date)
+	date, err := handlers.ParseProviderTime(form.Date, "2006-01-02T15:04:05Z", "2006-01-02 15:04:05")
 	if err != nil {
-		date, err = time.Parse("2006-01-02 15:04:05", form.Date)
-		if err != nil {
-			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", form.Date))
-		}
-		date = date.UTC()
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format: %s", form.Date))
 	}
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(form.From, channel.Country())
+	urn, err := handlers.StrictTelForChannel(form.From, channel)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -132,7 +126,7 @@ func (h *handler) SendMsg(_ context.Context, msg courier.Msg) (courier.MsgStatus
 	form := url.Values{
 		"username": []string{username},
 		"to":       []string{msg.URN().Path()},
-		"message":  []string{handlers.GetTextAndAttachments(msg)},
+		"message":  []string{handlers.GetTextAttachmentsAndQuickReplies(msg)},
 	}
 
 	// if this isn't shared, include our from
@@ -144,7 +138,7 @@ func (h *handler) SendMsg(_ context.Context, msg courier.Msg) (courier.MsgStatus
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("apikey", apiKey)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,6 +39,18 @@ func TestDecodePossibleBase64(t *testing.T) {
 	assert.Contains(DecodePossibleBase64(test6), "I received your letter today")
 }
 
+func TestGetTextAttachmentsAndQuickReplies(t *testing.T) {
+	mb := courier.NewMockBackend()
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AC", "2020", "US", nil)
+
+	msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Are you happy?", false, nil, "", 0, "")
+	assert.Equal(t, "Are you happy?", GetTextAttachmentsAndQuickReplies(msg))
+
+	msg = mb.NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Are you happy?", false, []string{"Yes", "No"}, "", 0, "")
+	msg.WithAttachment("image/jpeg:https://foo.bar/image.jpg")
+	assert.Equal(t, "Are you happy?\n\n1. Yes\n2. No\nhttps://foo.bar/image.jpg", GetTextAttachmentsAndQuickReplies(msg))
+}
+
 func TestSplitMsg(t *testing.T) {
 	assert := assert.New(t)
 	assert.Equal([]string{""}, SplitMsg("", 160))
@@ -45,6 +60,44 @@ func TestSplitMsg(t *testing.T) {
 	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMsg("This is a message   longer than 10", 20))
 }
 
+func TestSplitMessage(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{""}, SplitMessage("", 160))
+	assert.Equal([]string{"Simple message"}, SplitMessage("Simple message", 160))
+	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMessage("This is a message longer than 10", 20))
+	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMessage("This is a message   longer than 10", 20))
+
+	// words longer than our max length have to be split
+	assert.Equal([]string{"Supercalifragilistic", "expialidocious"}, SplitMessage("Supercalifragilisticexpialidocious", 20))
+
+	// long ASCII bodies are split into 160 char segments on word boundaries
+	long := strings.Repeat("hello world ", 30)
+	parts := SplitMessage(long, 160)
+	assert.Equal(3, len(parts))
+	for _, part := range parts {
+		assert.True(len(part) <= 160)
+	}
+	assert.Equal(strings.TrimSpace(long), strings.Join(parts, " "))
+
+	// extended GSM7 characters take two characters each
+	assert.Equal([]string{"{{{{{", "{{{{{"}, SplitMessage("{{{{{{{{{{", 10))
+
+	// an emoji forces UCS-2, so 160 GSM7 characters becomes 70
+	emoji := "😀 " + strings.Repeat("ab ", 30)
+	parts = SplitMessage(emoji, 160)
+	assert.Equal(2, len(parts))
+	assert.True(strings.HasPrefix(parts[0], "😀 ab"))
+	assert.Equal(strings.TrimSpace(emoji), strings.Join(parts, " "))
+
+	// emoji are two UCS-2 code units so only 35 fit in a segment
+	parts = SplitMessage(strings.Repeat("😀", 40), 160)
+	assert.Equal([]string{strings.Repeat("😀", 35), strings.Repeat("😀", 5)}, parts)
+
+	// accented characters are only GSM7 if they're in its table
+	assert.Equal([]string{"é è ù"}, SplitMessage("é è ù", 5))
+	assert.Equal(2, len(SplitMessage(strings.Repeat("ą", 71), 160)))
+}
+
 func TestSplitMsgByChannel(t *testing.T) {
 	assert := assert.New(t)
 	var channelWithMaxLength = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AC", "2020", "US",
@@ -72,3 +125,123 @@ func TestSplitMsgByChannel(t *testing.T) {
 	assert.Equal([]string{" "}, SplitMsgByChannel(channelWithMaxLength, " ", 20))
 	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMsgByChannel(channelWithMaxLength, "This is a message   longer than 10", 20))
 }
+
+func TestStrictTelForCountry(t *testing.T) {
+	tcs := []struct {
+		number  string
+		country string
+		urn     urns.URN
+		err     string
+	}{
+		{"+250788383383", "RW", "tel:+250788383383", ""},
+		{"250788383383", "RW", "tel:+250788383383", ""},
+		{"0788383383", "RW", "tel:+250788383383", ""},
+		{" 078 838 3383 ", "RW", "tel:+250788383383", ""},
+		{"(206) 555-1212", "US", "tel:+12065551212", ""},
+		{"+1 206.555.1212", "EC", "tel:+12065551212", ""},
+		{"2020", "US", "tel:2020", ""},
+		{"MTN", "RW", "", "phone number supplied is not a number"},
+		{"", "RW", "", "scheme or path cannot be empty"},
+
+		// without a country, only E164 numbers and shortcodes can be normalized
+		{"+250788383383", "", "tel:+250788383383", ""},
+		{"250788383383", "", "tel:+250788383383", ""},
+		{"2020", "", "tel:2020", ""},
+		{"0788383383", "", "", "unable to normalize national number 0788383383 without a country"},
+		{"(206) 555-1212", "", "", "unable to normalize national number 2065551212 without a country"},
+	}
+
+	for _, tc := range tcs {
+		urn, err := StrictTelForCountry(tc.number, tc.country)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "expected error for %s", tc.number)
+		} else {
+			assert.NoError(t, err, "unexpected error for %s", tc.number)
+			assert.Equal(t, tc.urn, urn, "urn mismatch for %s", tc.number)
+		}
+	}
+}
+
+func TestParseProviderTime(t *testing.T) {
+	tcs := []struct {
+		raw     string
+		layouts []string
+		time    time.Time
+		err     string
+	}{
+		// RFC 3339, in any zone
+		{"2017-05-03T06:04:45Z", nil, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{"2017-05-03T06:04:45.345-03:00", nil, time.Date(2017, 5, 3, 9, 4, 45, 345000000, time.UTC), ""},
+		{" 2017-05-03T08:04:45+02:00 ", nil, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+
+		// unix epochs in seconds and milliseconds
+		{"1493791485", nil, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{"1493791485345", nil, time.Date(2017, 5, 3, 6, 4, 45, 345000000, time.UTC), ""},
+		{"0", nil, time.Unix(0, 0).UTC(), ""},
+
+		// provider layouts, those without a zone being UTC
+		{"2017-05-03 06:04:45", []string{"2006-01-02 15:04:05"}, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{"2017-05-03T06:04:45.345-0300", []string{"2006-01-02T15:04:05.999999999-0700"}, time.Date(2017, 5, 3, 9, 4, 45, 345000000, time.UTC), ""},
+		{"2017-05-03T06:04:45Z", []string{"2006-01-02 15:04:05"}, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+		{"20170503060445", []string{"20060102150405"}, time.Date(2017, 5, 3, 6, 4, 45, 0, time.UTC), ""},
+
+		// errors are those from the provider's layout if it has one
+		{"", nil, time.Time{}, `parsing time "" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "" as "2006"`},
+		{"-1493791485", nil, time.Time{}, `parsing time "-1493791485" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "-1493791485" as "2006"`},
+		{"May 3rd", []string{"2006-01-02 15:04:05"}, time.Time{}, `parsing time "May 3rd" as "2006-01-02 15:04:05": cannot parse "May 3rd" as "2006"`},
+	}
+
+	for _, tc := range tcs {
+		parsed, err := ParseProviderTime(tc.raw, tc.layouts...)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "expected error for %s", tc.raw)
+		} else {
+			assert.NoError(t, err, "unexpected error for %s", tc.raw)
+			assert.Equal(t, tc.time, parsed, "time mismatch for %s", tc.raw)
+			assert.Equal(t, time.UTC, parsed.Location(), "time not in UTC for %s", tc.raw)
+		}
+	}
+}
+
+func TestStrictTelForChannel(t *testing.T) {
+	rwanda := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AC", "2020", "RW", nil)
+	kenya := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "AC", "2020", "KE", nil)
+	noCountry := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ad", "AC", "2020", "", nil)
+	configured := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ae", "AC", "2020", "", map[string]interface{}{courier.ConfigCountry: "ec"})
+	overridden := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56af", "AC", "2020", "US", map[string]interface{}{courier.ConfigCountry: "RW"})
+
+	tcs := []struct {
+		number  string
+		channel courier.Channel
+		urn     urns.URN
+		err     string
+	}{
+		// the same national number is a different subscriber depending on the country of the channel
+		{"0788383383", rwanda, "tel:+250788383383", ""},
+		{"0712345678", kenya, "tel:+254712345678", ""},
+		{"0987654321", configured, "tel:+593987654321", ""},
+		{"0788383383", overridden, "tel:+250788383383", ""},
+
+		// and whichever way a subscriber's number is formatted they get the same URN
+		{"+250788383383", rwanda, "tel:+250788383383", ""},
+		{"250788383383", rwanda, "tel:+250788383383", ""},
+		{"+250788383383", kenya, "tel:+250788383383", ""},
+
+		// without a country we don't guess at national numbers
+		{"+250788383383", noCountry, "tel:+250788383383", ""},
+		{"0788383383", noCountry, "", "unable to normalize national number 0788383383 without a country"},
+	}
+
+	for _, tc := range tcs {
+		urn, err := StrictTelForChannel(tc.number, tc.channel)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "expected error for %s on %s", tc.number, tc.channel.Country())
+		} else {
+			assert.NoError(t, err, "unexpected error for %s on %s", tc.number, tc.channel.Country())
+			assert.Equal(t, tc.urn, urn, "urn mismatch for %s on %s", tc.number, tc.channel.Country())
+		}
+	}
+
+	assert.Equal(t, "EC", ChannelCountry(configured))
+	assert.Equal(t, "KE", ChannelCountry(kenya))
+}
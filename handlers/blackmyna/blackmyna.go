@@ -10,7 +10,6 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 	"github.com/pkg/errors"
 )
 
@@ -52,7 +51,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(form.From, channel.Country())
+	urn, err := handlers.StrictTelForChannel(form.From, channel)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -116,13 +115,13 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	form := url.Values{
 		"address":       []string{msg.URN().Path()},
 		"senderaddress": []string{msg.Channel().Address()},
-		"message":       []string{handlers.GetTextAndAttachments(msg)},
+		"message":       []string{handlers.GetTextAttachmentsAndQuickReplies(msg)},
 	}
 
 	req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth(username, password)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
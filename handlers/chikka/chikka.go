@@ -12,7 +12,6 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 )
 
 var (
@@ -58,7 +57,7 @@ var statusMapping = map[string]courier.MsgStatusValue{
 
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
-	err := r.ParseForm()
+	err := handlers.ParseForm(r)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -90,7 +89,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		date := time.Unix(0, int64(form.Timestamp*1000000000)).UTC()
 
 		// create our URN
-		urn, err := handlers.StrictTelForCountry(form.MobileNumber, channel.Country())
+		urn, err := handlers.StrictTelForChannel(form.MobileNumber, channel)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 		}
@@ -117,7 +116,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	}
 
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
-	parts := handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxMsgLength)
+	parts := handlers.SplitMessageByChannel(msg.Channel(), handlers.GetTextAttachmentsAndQuickReplies(msg), maxMsgLength)
 	for _, part := range parts {
 		// build our request
 		form := url.Values{
@@ -137,7 +136,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 		if rr.StatusCode == 400 {
 			message, _ := jsonparser.GetString([]byte(rr.Body), "message")
@@ -149,7 +148,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 				req, _ = http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-				rr, err = utils.MakeHTTPRequest(req)
+				rr, err = handlers.MakeHTTPRequest(msg.Channel(), req)
 
 			}
 
@@ -69,6 +69,12 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		URLParams:    map[string]string{"message": "I need to keep adding more things to make it work", "sendto": "250788383383", "original": "2020", "userid": "Username", "password": "Password", "dcs": "0", "udhl": "0", "messageid": "10.2"},
 		ResponseBody: "000", ResponseStatus: 200,
 		SendPrep: setSendURL},
+	{Label: "Send Quick Replies",
+		Text: "Are you happy?", URN: "tel:+250788383383", QuickReplies: []string{"Yes", "No"},
+		Status:       "W",
+		URLParams:    map[string]string{"message": "Are you happy?\n\n1. Yes\n2. No", "sendto": "250788383383", "original": "2020", "userid": "Username", "password": "Password", "dcs": "0", "udhl": "0", "messageid": "10"},
+		ResponseBody: "000", ResponseStatus: 200,
+		SendPrep: setSendURL},
 	{Label: "Send Attachment",
 		Text: "My pic!", URN: "tel:+250788383383", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		Status:       "W",
@@ -18,7 +18,6 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/gsm7"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/pkg/errors"
 )
@@ -104,7 +103,7 @@ func (h *handler) receiveStopContact(ctx context.Context, channel courier.Channe
 	// create our URN
 	urn := urns.NilURN
 	if channel.Schemes()[0] == urns.TelScheme {
-		urn, err = handlers.StrictTelForCountry(form.From, channel.Country())
+		urn, err = handlers.StrictTelForChannel(form.From, channel)
 	} else {
 		urn, err = urns.NewURNFromParts(channel.Schemes()[0], form.From, "", "")
 	}
@@ -173,7 +172,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		text = textNode.InnerText()
 	} else {
 		// parse our form
-		err := r.ParseForm()
+		err := handlers.ParseForm(r)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.Wrapf(err, "invalid request"))
 		}
@@ -191,7 +190,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	// if we have a date, parse it
 	date := time.Now()
 	if dateString != "" {
-		date, err = time.Parse(time.RFC3339Nano, dateString)
+		date, err = handlers.ParseProviderTime(dateString)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid date format, must be RFC 3339"))
 		}
@@ -200,14 +199,14 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	// create our URN
 	urn := urns.NilURN
 	if channel.Schemes()[0] == urns.TelScheme {
-		urn, err = handlers.StrictTelForCountry(from, channel.Country())
+		urn, err = handlers.StrictTelForChannel(from, channel)
 	} else {
 		urn, err = urns.NewURNFromParts(channel.Schemes()[0], from, "", "")
 	}
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
-	urn = urn.Normalize(channel.Country())
+	urn = urn.Normalize(handlers.ChannelCountry(channel))
 
 	// build our msg
 	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date)
@@ -350,7 +349,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			req.Header.Set("Authorization", authorization)
 		}
 
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
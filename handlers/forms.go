@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 
 	"github.com/gorilla/schema"
@@ -23,6 +24,42 @@ func init() {
 	decoder.SetAliasTag("name")
 }
 
+// MaxFormMemory is how many bytes of the file parts of a multipart body are buffered in memory, anything beyond that is
+// written to temporary files which are removed once the request has been handled
+const MaxFormMemory = 1024 * 1024
+
+// ParseForm parses the URL query parameters and any form encoded or multipart body of the passed in request into
+// r.Form, with the file parts of a multipart body available via FormFile. Handlers should use this rather than
+// calling r.ParseForm or r.ParseMultipartForm themselves so that every form body is parsed the same way.
+func ParseForm(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return r.ParseMultipartForm(MaxFormMemory)
+	}
+	return r.ParseForm()
+}
+
+// FormFile returns the contents and content type of the first file part with the passed in name in the multipart body
+// of the passed in request, which must have been parsed with ParseForm. If there is none, http.ErrMissingFile is returned.
+func FormFile(r *http.Request, name string) ([]byte, string, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[name]) == 0 {
+		return nil, "", http.ErrMissingFile
+	}
+
+	header := r.MultipartForm.File[name][0]
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, header.Header.Get("Content-Type"), nil
+}
+
 // Validate validates the passe din struct using our shared validator instance
 func Validate(form interface{}) error {
 	return validate.Struct(form)
@@ -31,7 +68,7 @@ func Validate(form interface{}) error {
 // DecodeAndValidateForm takes the passed in form and attempts to parse and validate it from the
 // URL query parameters as well as any POST parameters of the passed in request
 func DecodeAndValidateForm(form interface{}, r *http.Request) error {
-	err := r.ParseForm()
+	err := ParseForm(r)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForm(t *testing.T) {
+	// a form encoded body, along with query parameters
+	r := httptest.NewRequest(http.MethodPost, "/receive?to=2020", strings.NewReader("from=%2B250788383383&text=hello+world"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	assert.NoError(t, ParseForm(r))
+	assert.Equal(t, "+250788383383", r.Form.Get("from"))
+	assert.Equal(t, "hello world", r.Form.Get("text"))
+	assert.Equal(t, "2020", r.Form.Get("to"))
+
+	_, _, err := FormFile(r, "media")
+	assert.Equal(t, http.ErrMissingFile, err)
+
+	// reading the body stops at the request body size limit
+	r = httptest.NewRequest(http.MethodPost, "/receive", strings.NewReader("text="+strings.Repeat("a", 200)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 100)
+	assert.EqualError(t, ParseForm(r), "http: request body too large")
+
+	// a multipart body with a small image and a video too big to buffer in memory
+	image := []byte("\xff\xd8\xff\xe0 not really a jpeg")
+	video := bytes.Repeat([]byte{0x42}, MaxFormMemory+1)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("from", "+250788383383")
+	writer.WriteField("text", "look at this")
+	for _, part := range []struct {
+		name        string
+		filename    string
+		contentType string
+		data        []byte
+	}{
+		{"image", "photo.jpg", "image/jpeg", image},
+		{"video", "clip.mp4", "video/mp4", video},
+	} {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="`+part.name+`"; filename="`+part.filename+`"`)
+		header.Set("Content-Type", part.contentType)
+		fileWriter, _ := writer.CreatePart(header)
+		fileWriter.Write(part.data)
+	}
+	writer.Close()
+	payload := body.Bytes()
+
+	r = httptest.NewRequest(http.MethodPost, "/receive?to=2020", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+
+	assert.NoError(t, ParseForm(r))
+	defer r.MultipartForm.RemoveAll()
+
+	assert.Equal(t, "+250788383383", r.Form.Get("from"))
+	assert.Equal(t, "look at this", r.Form.Get("text"))
+	assert.Equal(t, "2020", r.Form.Get("to"))
+
+	data, contentType, err := FormFile(r, "image")
+	assert.NoError(t, err)
+	assert.Equal(t, image, data)
+	assert.Equal(t, "image/jpeg", contentType)
+
+	data, contentType, err = FormFile(r, "video")
+	assert.NoError(t, err)
+	assert.Equal(t, video, data)
+	assert.Equal(t, "video/mp4", contentType)
+
+	// the video was spilled to a temporary file rather than kept in memory
+	file, err := r.MultipartForm.File["video"][0].Open()
+	assert.NoError(t, err)
+	_, onDisk := file.(*os.File)
+	assert.True(t, onDisk)
+	file.Close()
+
+	_, _, err = FormFile(r, "audio")
+	assert.Equal(t, http.ErrMissingFile, err)
+
+	// multipart bodies are limited too
+	r = httptest.NewRequest(http.MethodPost, "/receive", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 1000)
+	assert.EqualError(t, ParseForm(r), "http: request body too large")
+}
@@ -21,7 +21,6 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -104,15 +103,12 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
 
-	agentID := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
-	if agentID == "" {
-		return nil, fmt.Errorf("missing config 'username' for FC channel")
+	err := msg.Channel().RequireConfig(courier.ConfigUsername, courier.ConfigAuthToken)
+	if err != nil {
+		return nil, err
 	}
-
+	agentID := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
 	authToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
-	if authToken == "" {
-		return nil, fmt.Errorf("missing config 'auth_token' for FC channel")
-	}
 
 	user := strings.Split(msg.URN().Path(), "/")
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
@@ -168,7 +164,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	var bearer = "Bearer " + authToken
 	req.Header.Set("Authorization", bearer)
 
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// record our status and log
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -185,10 +181,11 @@ func (h *handler) validateSignature(c courier.Channel, r *http.Request) error {
 	if !h.validateSignatures {
 		return nil
 	}
-	key := c.StringConfigForKey(courier.ConfigSecret, "")
-	if key == "" {
-		return fmt.Errorf("missing config 'secret' for FC channel")
+	err := c.RequireConfig(courier.ConfigSecret)
+	if err != nil {
+		return err
 	}
+	key := c.StringConfigForKey(courier.ConfigSecret, "")
 	//x509 parser needs newlines for valid key- RP stores config strings without them.
 	// this puts them back in
 	key = strings.Replace(key, "- ", "-\n", 1)
@@ -12,7 +12,7 @@ import (
 // NewTelReceiveHandler creates a new receive handler given the passed in text and from fields
 func NewTelReceiveHandler(h *BaseHandler, fromField string, bodyField string) courier.ChannelHandleFunc {
 	return func(ctx context.Context, c courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
-		err := r.ParseForm()
+		err := ParseForm(r)
 		if err != nil {
 			return nil, WriteAndLogRequestError(ctx, h, c, w, r, err)
 		}
@@ -23,7 +23,7 @@ func NewTelReceiveHandler(h *BaseHandler, fromField string, bodyField string) co
 			return nil, WriteAndLogRequestError(ctx, h, c, w, r, fmt.Errorf("missing required field '%s'", fromField))
 		}
 		// create our URN
-		urn, err := StrictTelForCountry(from, c.Country())
+		urn, err := StrictTelForChannel(from, c)
 		if err != nil {
 			return nil, WriteAndLogRequestError(ctx, h, c, w, r, err)
 		}
@@ -36,7 +36,7 @@ func NewTelReceiveHandler(h *BaseHandler, fromField string, bodyField string) co
 // NewExternalIDStatusHandler creates a new status handler given the passed in status map and fields
 func NewExternalIDStatusHandler(h *BaseHandler, statuses map[string]courier.MsgStatusValue, externalIDField string, statusField string) courier.ChannelHandleFunc {
 	return func(ctx context.Context, c courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
-		err := r.ParseForm()
+		err := ParseForm(r)
 		if err != nil {
 			return nil, WriteAndLogRequestError(ctx, h, c, w, r, err)
 		}
@@ -12,7 +12,6 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 	"github.com/pkg/errors"
 )
 
@@ -150,14 +149,14 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 		date := time.Now()
 		if dateString != "" {
-			date, err = time.Parse("2006-01-02T15:04:05.999999999-0700", dateString)
+			date, err = handlers.ParseProviderTime(dateString, "2006-01-02T15:04:05.999999999-0700")
 			if err != nil {
 				return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 			}
 		}
 
 		// create our URN
-		urn, err := handlers.StrictTelForCountry(infobipMessage.From, channel.Country())
+		urn, err := handlers.StrictTelForChannel(infobipMessage.From, channel)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 		}
@@ -202,7 +201,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 						MessageID: msg.ID().String(),
 					},
 				},
-				Text:               handlers.GetTextAndAttachments(msg),
+				Text:               handlers.GetTextAttachmentsAndQuickReplies(msg),
 				NotifyContentType:  "application/json",
 				IntermediateReport: true,
 				NotifyURL:          statusURL,
@@ -222,7 +221,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.SetBasicAuth(username, password)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
@@ -14,7 +14,6 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 )
 
 var idRegex = regexp.MustCompile(`Success \"(.*)\"`)
@@ -85,7 +84,7 @@ func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.
 	}
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(form.From, c.Country())
+	urn, err := handlers.StrictTelForChannel(form.From, c)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, c, w, r, err)
 	}
@@ -152,14 +151,14 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		"dlr-level":  []string{"2"},
 		"dlr-method": []string{http.MethodPost},
 		"coding":     []string{"0"},
-		"content":    []string{string(gsm7.Encode(gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))))},
+		"content":    []string{string(gsm7.Encode(gsm7.ReplaceSubstitutions(handlers.GetTextAttachmentsAndQuickReplies(msg))))},
 	}
 
 	fullURL, _ := url.Parse(sendURL)
 	fullURL.RawQuery = form.Encode()
 
 	req, _ := http.NewRequest(http.MethodGet, fullURL.String(), nil)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
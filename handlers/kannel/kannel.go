@@ -70,7 +70,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	date := time.Unix(form.TS, 0).UTC()
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(form.Sender, channel.Country())
+	urn, err := handlers.StrictTelForChannel(form.Sender, channel)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -147,7 +147,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		"username": []string{username},
 		"password": []string{password},
 		"from":     []string{msg.Channel().Address()},
-		"text":     []string{handlers.GetTextAndAttachments(msg)},
+		"text":     []string{handlers.GetTextAttachmentsAndQuickReplies(msg)},
 		"to":       []string{msg.URN().Path()},
 		"dlr-url":  []string{dlrURL},
 		"dlr-mask": []string{dlrMask},
@@ -171,7 +171,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 	// if we are smart, first try to convert to GSM7 chars
 	if encoding == encodingSmart {
-		replaced := gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))
+		replaced := gsm7.ReplaceSubstitutions(handlers.GetTextAttachmentsAndQuickReplies(msg))
 		if gsm7.IsValid(replaced) {
 			form["text"] = []string{replaced}
 		} else {
@@ -201,9 +201,9 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	var rr *utils.RequestResponse
 
 	if verifySSL {
-		rr, err = utils.MakeHTTPRequest(req)
+		rr, err = handlers.MakeHTTPRequest(msg.Channel(), req)
 	} else {
-		rr, err = utils.MakeInsecureHTTPRequest(req)
+		rr, err = handlers.MakeInsecureHTTPRequest(msg.Channel(), req)
 	}
 
 	// record our status and log
@@ -14,7 +14,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/nyaruka/courier/utils"
 
 	"github.com/nyaruka/gocommon/urns"
 
@@ -135,8 +134,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 }
 
 func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
-	actual := r.Header.Get(signatureHeader)
-	if actual == "" {
+	if r.Header.Get(signatureHeader) == "" {
 		return fmt.Errorf("missing request signature")
 	}
 
@@ -146,17 +144,7 @@ func (h *handler) validateSignature(channel courier.Channel, r *http.Request) er
 		return fmt.Errorf("invalid or missing auth token in config")
 	}
 
-	expected, err := calculateSignature(secret, r)
-	if err != nil {
-		return err
-	}
-
-	// compare signatures in way that isn't sensitive to a timing attack
-	if !hmac.Equal(expected, []byte(actual)) {
-		return fmt.Errorf("invalid request signature")
-	}
-
-	return nil
+	return courier.ValidateSignature(secret, r, signatureHeader, courier.HashSHA256)
 }
 
 // see https://developers.line.me/en/docs/messaging-api/reference/#signature-validation
@@ -243,7 +231,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			if err != nil {
 				return status, err
 			}
-			rr, err := utils.MakeHTTPRequest(req)
+			rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 			log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 			status.AddLog(log)
 
@@ -259,7 +247,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				if err != nil {
 					return status, err
 				}
-				rr, err = utils.MakeHTTPRequest(req)
+				rr, err = handlers.MakeHTTPRequest(msg.Channel(), req)
 				log = courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 				status.AddLog(log)
 				if err != nil {
@@ -12,7 +12,6 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/gsm7"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 )
 
 var (
@@ -41,7 +40,7 @@ func (h *handler) Initialize(s courier.Server) error {
 
 // receiveMessage takes care of handling incoming messages
 func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
-	err := r.ParseForm()
+	err := handlers.ParseForm(r)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, c, w, r, err)
 	}
@@ -53,7 +52,7 @@ func (h *handler) receiveMessage(ctx context.Context, c courier.Channel, w http.
 	}
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(from, c.Country())
+	urn, err := handlers.StrictTelForChannel(from, c)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, c, w, r, err)
 	}
@@ -83,7 +82,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	}
 
 	// figure out if we need to send as unicode (encoding 7)
-	text := gsm7.ReplaceSubstitutions(handlers.GetTextAndAttachments(msg))
+	text := gsm7.ReplaceSubstitutions(handlers.GetTextAttachmentsAndQuickReplies(msg))
 	encoding := "0"
 	if !gsm7.IsValid(text) {
 		encoding = "7"
@@ -91,7 +90,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 	// send our message
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
-	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), text, maxMsgLength) {
+	for _, part := range handlers.SplitMessageByChannel(msg.Channel(), text, maxMsgLength) {
 		// build our request
 		params := url.Values{
 			"AuthKey":     []string{"m3-Tech"},
@@ -111,7 +110,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		msgURL.RawQuery = params.Encode()
 		req, _ := http.NewRequest(http.MethodGet, msgURL.String(), nil)
 
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 		status.AddLog(courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err))
 		if err != nil {
 			break
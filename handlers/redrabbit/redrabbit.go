@@ -10,7 +10,6 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/gsm7"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 )
 
 var (
@@ -44,7 +43,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		return nil, fmt.Errorf("Missing username or password for RR channel")
 	}
 
-	text := handlers.GetTextAndAttachments(msg)
+	text := handlers.GetTextAttachmentsAndQuickReplies(msg)
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
 	form := url.Values{
 		"LoginName":         []string{username},
@@ -70,7 +69,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	msgURL.RawQuery = form.Encode()
 	req, _ := http.NewRequest(http.MethodGet, msgURL.String(), nil)
 
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 	status.AddLog(courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err))
 	if err != nil {
 		return status, nil
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+)
+
+// MakeHTTPRequest makes the passed in request to the provider of the passed in channel, adding the extra headers in its
+// config, see utils.MakeHTTPRequest
+func MakeHTTPRequest(channel courier.Channel, req *http.Request) (*utils.RequestResponse, error) {
+	addExtraHeaders(channel, req)
+	return utils.MakeHTTPRequest(req)
+}
+
+// MakeInsecureHTTPRequest makes the passed in request to the provider of the passed in channel without validating its
+// certificate, adding the extra headers in its config, see utils.MakeInsecureHTTPRequest
+func MakeInsecureHTTPRequest(channel courier.Channel, req *http.Request) (*utils.RequestResponse, error) {
+	addExtraHeaders(channel, req)
+	return utils.MakeInsecureHTTPRequest(req)
+}
+
+// MakeHTTPRequestWithClient makes the passed in request to the provider of the passed in channel with the passed in
+// client, adding the extra headers in its config, see utils.MakeHTTPRequestWithClient
+func MakeHTTPRequestWithClient(channel courier.Channel, req *http.Request, client *http.Client) (*utils.RequestResponse, error) {
+	addExtraHeaders(channel, req)
+	return utils.MakeHTTPRequestWithClient(req, client)
+}
+
+// adds the extra headers in the config of the passed in channel to the passed in request, replacing any the handler set
+func addExtraHeaders(channel courier.Channel, req *http.Request) {
+	for name, value := range courier.ChannelExtraHeaders(channel) {
+		req.Header.Set(name, value)
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtraHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "KN", "2020", "US", map[string]interface{}{
+		courier.ConfigExtraHeaders: map[string]interface{}{
+			"X-Api-Version":  "2020-07-01",
+			"X-Account-Key":  "sesame",
+			"Content-Type":   "application/vnd.provider+json",
+			"X-Ignored-Flag": true,
+		},
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/send", nil)
+	req.Header.Set("Content-Type", "application/json")
+	rr, err := MakeHTTPRequest(channel, req)
+	assert.NoError(t, err)
+
+	// our configured headers are added to the request, replacing those the handler set
+	assert.Equal(t, "2020-07-01", received.Get("X-Api-Version"))
+	assert.Equal(t, "sesame", received.Get("X-Account-Key"))
+	assert.Equal(t, "application/vnd.provider+json", received.Get("Content-Type"))
+	assert.Equal(t, "", received.Get("X-Ignored-Flag"))
+
+	// and those which look like credentials are masked in our channel log, the rest are left as is
+	log := courier.NewChannelLogFromRR("Message Sent", channel, courier.NewMsgID(10), rr).Redact(courier.ChannelSecrets(channel)...)
+	assert.Contains(t, log.Request, "X-Account-Key: ****")
+	assert.Contains(t, log.Request, "X-Api-Version: 2020-07-01")
+	assert.NotContains(t, log.Request, "sesame")
+
+	// channels without extra headers send what the handler set
+	plain := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "KN", "2020", "US", map[string]interface{}{})
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/send", nil)
+	req.Header.Set("Content-Type", "application/json")
+	_, err = MakeHTTPRequest(plain, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", received.Get("Content-Type"))
+	assert.Equal(t, "", received.Get("X-Api-Version"))
+}
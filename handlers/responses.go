@@ -27,6 +27,10 @@ func WriteMsgsAndResponse(ctx context.Context, h ResponseWriter, msgs []courier.
 		events[i] = m
 	}
 
+	// handlers whose providers expect an ack leave writing it to the server
+	if _, isAcker := h.(courier.ChannelAcker); isAcker {
+		return events, nil
+	}
 	return events, h.WriteMsgSuccessResponse(ctx, w, r, msgs)
 }
 
@@ -41,17 +45,28 @@ func WriteMsgStatusAndResponse(ctx context.Context, h ResponseWriter, channel co
 		return nil, err
 	}
 
+	if _, isAcker := h.(courier.ChannelAcker); isAcker {
+		return []courier.Event{status}, nil
+	}
 	return []courier.Event{status}, h.WriteStatusSuccessResponse(ctx, w, r, []courier.MsgStatus{status})
 }
 
 // WriteAndLogRequestError logs the passed in error and writes the response to the response writer
 func WriteAndLogRequestError(ctx context.Context, h ResponseWriter, channel courier.Channel, w http.ResponseWriter, r *http.Request, err error) error {
 	courier.LogRequestError(r, channel, err)
+	if acker, isAcker := h.(courier.ChannelAcker); isAcker {
+		if ack := acker.ErrorAck(channel, err); ack != nil {
+			return courier.WriteChannelResponse(w, ack)
+		}
+	}
 	return h.WriteRequestError(ctx, w, r, err)
 }
 
 // WriteAndLogRequestIgnored logs that the passed in request was ignored and writes the response to the response writer
 func WriteAndLogRequestIgnored(ctx context.Context, h ResponseWriter, channel courier.Channel, w http.ResponseWriter, r *http.Request, details string) error {
 	courier.LogRequestIgnored(r, channel, details)
+	if acker, isAcker := h.(courier.ChannelAcker); isAcker {
+		return courier.WriteChannelResponse(w, acker.SuccessAck(channel))
+	}
 	return h.WriteRequestIgnored(ctx, w, r, details)
 }
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+// a handler whose provider expects an XML ack
+type ackHandler struct {
+	BaseHandler
+}
+
+func (h *ackHandler) SuccessAck(c courier.Channel) *courier.ChannelResponse {
+	return courier.NewChannelResponse("text/xml", []byte(`<ack>OK</ack>`))
+}
+
+func (h *ackHandler) ErrorAck(c courier.Channel, err error) *courier.ChannelResponse {
+	return &courier.ChannelResponse{StatusCode: http.StatusBadRequest, ContentType: "text/xml", Body: []byte(`<ack>ERROR</ack>`)}
+}
+
+func TestAckResponses(t *testing.T) {
+	ctx := context.Background()
+	mb := courier.NewMockBackend()
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "AC", "2020", "US", nil)
+	r := httptest.NewRequest(http.MethodPost, "/c/ac/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive", nil)
+
+	acker := &ackHandler{NewBaseHandler("AC", "Acker")}
+	acker.backend = mb
+	plain := &BaseHandler{backend: mb}
+
+	// writing msgs and statuses leaves acking them to our server
+	w := httptest.NewRecorder()
+	events, err := WriteMsgsAndResponse(ctx, acker, []courier.Msg{mb.NewIncomingMsg(channel, "tel:+250788383383", "hello")}, w, r)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "", w.Body.String())
+
+	w = httptest.NewRecorder()
+	_, err = WriteMsgStatusAndResponse(ctx, acker, channel, mb.NewMsgStatusForExternalID(channel, "ext1", courier.MsgDelivered), w, r)
+	assert.NoError(t, err)
+	assert.Equal(t, "", w.Body.String())
+
+	// handlers without an ack write our usual response
+	w = httptest.NewRecorder()
+	_, err = WriteMsgsAndResponse(ctx, plain, []courier.Msg{mb.NewIncomingMsg(channel, "tel:+250788383383", "hello")}, w, r)
+	assert.NoError(t, err)
+	assert.Contains(t, w.Body.String(), "Message Accepted")
+
+	// ignored requests are acked as successes, as the provider needn't retry them
+	w = httptest.NewRecorder()
+	assert.NoError(t, WriteAndLogRequestIgnored(ctx, acker, channel, w, r, "nothing to do"))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `<ack>OK</ack>`, w.Body.String())
+
+	// and errors with the error ack
+	w = httptest.NewRecorder()
+	assert.NoError(t, WriteAndLogRequestError(ctx, acker, channel, w, r, errors.New("missing text")))
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "text/xml", w.Header().Get("Content-Type"))
+	assert.Equal(t, `<ack>ERROR</ack>`, w.Body.String())
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
-	"github.com/nyaruka/courier/utils"
 	"github.com/pkg/errors"
 )
 
@@ -61,14 +60,14 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	date := time.Now()
 	if dateString != "" {
-		date, err = time.Parse(time.RFC3339Nano, dateString)
+		date, err = handlers.ParseProviderTime(dateString)
 		if err != nil {
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid date format, must be RFC 3339"))
 		}
 	}
 
 	// create our URN
-	urn, err := handlers.StrictTelForCountry(form.From, channel.Country())
+	urn, err := handlers.StrictTelForChannel(form.From, channel)
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
@@ -95,7 +94,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	// build our request
 	form := url.Values{
 		"from":     []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
-		"msg":      []string{handlers.GetTextAndAttachments(msg)},
+		"msg":      []string{handlers.GetTextAttachmentsAndQuickReplies(msg)},
 		"to":       []string{strings.TrimPrefix(msg.URN().Path(), "+")},
 		"username": []string{username},
 		"password": []string{password},
@@ -106,7 +105,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 	req, _ := http.NewRequest(http.MethodGet, sendURL, nil)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr, err := utils.MakeInsecureHTTPRequest(req)
+	rr, err := handlers.MakeInsecureHTTPRequest(msg.Channel(), req)
 
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
 	status.AddLog(courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err))
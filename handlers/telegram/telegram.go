@@ -47,6 +47,31 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
+	// the contact edited or reacted to a message, let whoever handles events know
+	if payload.EditedMessage != nil {
+		edit := payload.EditedMessage
+		text := edit.Text
+		if text == "" {
+			text = edit.Caption
+		}
+		extra := map[string]interface{}{courier.EventExtraExternalID: fmt.Sprintf("%d", edit.MessageID), courier.EventExtraText: text}
+		return h.receiveEvent(ctx, channel, w, r, courier.MsgEdit, edit.From, edit.EditDate, extra)
+	}
+	if payload.MessageReaction != nil {
+		reaction := payload.MessageReaction
+
+		// a reaction with no emoji is the contact taking their reaction back
+		emoji := ""
+		for _, nr := range reaction.NewReaction {
+			if nr.Type == "emoji" {
+				emoji = nr.Emoji
+				break
+			}
+		}
+		extra := map[string]interface{}{courier.EventExtraExternalID: fmt.Sprintf("%d", reaction.MessageID), courier.EventExtraEmoji: emoji}
+		return h.receiveEvent(ctx, channel, w, r, courier.MsgReaction, reaction.User, reaction.Date, extra)
+	}
+
 	// no message? ignore this
 	if payload.Message.MessageID == 0 {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, no message")
@@ -131,6 +156,22 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 }
 
+// receiveEvent writes a channel event of the passed in type from the passed in user
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, eventType courier.ChannelEventType, from moUser, date int64, extra map[string]interface{}) ([]courier.Event, error) {
+	urn, err := urns.NewTelegramURN(from.ContactID, strings.ToLower(from.Username))
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+	name := handlers.NameFromFirstLastUsername(from.FirstName, from.LastName, from.Username)
+
+	event := h.Backend().NewChannelEvent(channel, eventType, urn).WithContactName(name).WithOccurredOn(time.Unix(date, 0).UTC()).WithExtra(extra)
+	err = h.Backend().WriteChannelEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	return []courier.Event{event}, courier.WriteChannelEventSuccess(ctx, w, r, event)
+}
+
 func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form url.Values, replies string) (string, *courier.ChannelLog, error) {
 	// either include or remove our keyboard depending on whether we have quick replies
 	if replies == "" {
@@ -143,7 +184,7 @@ func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form u
 	req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	// build our channel log
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -298,7 +339,7 @@ func (h *handler) resolveFileID(ctx context.Context, channel courier.Channel, fi
 	req, _ := http.NewRequest(http.MethodPost, fileURL, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(channel, req)
 	if err != nil {
 		log := courier.NewChannelLogFromRR("File Resolving", channel, courier.NilMsgID, rr).WithError("File Resolving Error", err)
 		h.Backend().WriteChannelLogs(ctx, []*courier.ChannelLog{log})
@@ -345,6 +386,13 @@ type moLocation struct {
 	Longitude float64 `json:"longitude"`
 }
 
+type moUser struct {
+	ContactID int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
 // {
 // 	"update_id": 174114370,
 // 	"message": {
@@ -368,17 +416,12 @@ type moLocation struct {
 type moPayload struct {
 	UpdateID int64 `json:"update_id" validate:"required"`
 	Message  struct {
-		MessageID int64 `json:"message_id"`
-		From      struct {
-			ContactID int64  `json:"id"`
-			FirstName string `json:"first_name"`
-			LastName  string `json:"last_name"`
-			Username  string `json:"username"`
-		} `json:"from"`
-		Date    int64  `json:"date"`
-		Text    string `json:"text"`
-		Caption string `json:"caption"`
-		Sticker *struct {
+		MessageID int64  `json:"message_id"`
+		From      moUser `json:"from"`
+		Date      int64  `json:"date"`
+		Text      string `json:"text"`
+		Caption   string `json:"caption"`
+		Sticker   *struct {
 			Thumb moFile `json:"thumb"`
 		} `json:"sticker"`
 		Photo    []moFile    `json:"photo"`
@@ -397,4 +440,20 @@ type moPayload struct {
 			LastName    string `json:"last_name"`
 		}
 	} `json:"message"`
+	EditedMessage *struct {
+		MessageID int64  `json:"message_id"`
+		From      moUser `json:"from"`
+		EditDate  int64  `json:"edit_date"`
+		Text      string `json:"text"`
+		Caption   string `json:"caption"`
+	} `json:"edited_message"`
+	MessageReaction *struct {
+		MessageID   int64  `json:"message_id"`
+		User        moUser `json:"user"`
+		Date        int64  `json:"date"`
+		NewReaction []struct {
+			Type  string `json:"type"`
+			Emoji string `json:"emoji"`
+		} `json:"new_reaction"`
+	} `json:"message_reaction"`
 }
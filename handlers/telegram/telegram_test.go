@@ -58,6 +58,72 @@ var startMsg = `{
     }
   }`
 
+var editedMsg = `{
+    "update_id": 174114371,
+    "edited_message": {
+      "message_id": 41,
+      "from": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "username": "nicpottier"
+      },
+      "chat": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "type": "private"
+      },
+      "date": 1454119029,
+      "edit_date": 1454119089,
+      "text": "Hello World!"
+    }
+  }`
+
+var reactionMsg = `{
+    "update_id": 174114372,
+    "message_reaction": {
+      "chat": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "type": "private"
+      },
+      "message_id": 42,
+      "user": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "username": "nicpottier"
+      },
+      "date": 1454119129,
+      "old_reaction": [],
+      "new_reaction": [{"type": "emoji", "emoji": "👍"}]
+    }
+  }`
+
+var reactionRemovedMsg = `{
+    "update_id": 174114373,
+    "message_reaction": {
+      "chat": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "type": "private"
+      },
+      "message_id": 42,
+      "user": {
+          "id": 3527065,
+          "first_name": "Nic",
+          "last_name": "Pottier",
+          "username": "nicpottier"
+      },
+      "date": 1454119189,
+      "old_reaction": [{"type": "emoji", "emoji": "👍"}],
+      "new_reaction": []
+    }
+  }`
+
 var emptyMsg = `{
  	"update_id": 174114370
 }`
@@ -457,6 +523,18 @@ var testCases = []ChannelHandleTestCase{
 	{Label: "Receive Start Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: startMsg, Status: 200, Response: "Accepted",
 		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.NewConversation)), URN: Sp("telegram:3527065#nicpottier"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
 
+	{Label: "Receive Edited Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: editedMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.MsgEdit)), ChannelEventExtra: map[string]interface{}{"external_id": "41", "text": "Hello World!"},
+		URN: Sp("telegram:3527065#nicpottier"), Date: Tp(time.Date(2016, 1, 30, 1, 58, 9, 0, time.UTC))},
+
+	{Label: "Receive Reaction", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: reactionMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.MsgReaction)), ChannelEventExtra: map[string]interface{}{"external_id": "42", "emoji": "👍"},
+		URN: Sp("telegram:3527065#nicpottier"), Date: Tp(time.Date(2016, 1, 30, 1, 58, 49, 0, time.UTC))},
+
+	{Label: "Receive Reaction Removed", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: reactionRemovedMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.MsgReaction)), ChannelEventExtra: map[string]interface{}{"external_id": "42", "emoji": ""},
+		URN: Sp("telegram:3527065#nicpottier"), Date: Tp(time.Date(2016, 1, 30, 1, 59, 49, 0, time.UTC))},
+
 	{Label: "Receive No Params", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: emptyMsg, Status: 200, Response: "Ignoring"},
 
 	{Label: "Receive Invalid JSON", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: "foo", Status: 400, Response: "unable to parse"},
@@ -41,6 +41,9 @@ type ChannelHandleTestCase struct {
 	Attachments []string
 	Date        *time.Time
 
+	Location     *courier.LatLon
+	ContactCards []courier.ContactCard
+
 	MsgStatus *string
 
 	ChannelEvent      *string
@@ -413,9 +416,15 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 				if len(testCase.Attachments) > 0 {
 					require.Equal(testCase.Attachments, msg.Attachments())
 				}
+				if testCase.Location != nil {
+					require.Equal(testCase.Location, msg.Location())
+				}
+				if len(testCase.ContactCards) > 0 {
+					require.Equal(testCase.ContactCards, msg.ContactCards())
+				}
 				if testCase.Date != nil {
 					if msg != nil {
-						require.Equal((*testCase.Date).Local(), (*msg.ReceivedOn()).Local())
+						require.Equal((*testCase.Date).UTC(), *msg.ReceivedOn())
 					} else if event != nil {
 						require.Equal(*testCase.Date, event.OccurredOn())
 					} else {
@@ -249,7 +249,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req.SetBasicAuth(accountSID, accountToken)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -317,7 +317,7 @@ func (h *handler) validateSignature(c courier.Channel, r *http.Request) error {
 		return fmt.Errorf("missing request signature")
 	}
 
-	if err := r.ParseForm(); err != nil {
+	if err := handlers.ParseForm(r); err != nil {
 		return err
 	}
 
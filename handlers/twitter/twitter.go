@@ -345,7 +345,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req, _ := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequestWithClient(req, client)
+		rr, err := handlers.MakeHTTPRequestWithClient(msg.Channel(), req, client)
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -387,7 +387,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 
 	// retrieve the media to be sent from S3
 	req, _ := http.NewRequest(http.MethodGet, attachmentURL, nil)
-	s3rr, err := utils.MakeHTTPRequest(req)
+	s3rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 	log := courier.NewChannelLogFromRR("Media Fetch", msg.Channel(), msg.ID(), s3rr)
 	if err != nil {
 		log.WithError("Media Fetch Error", err)
@@ -420,7 +420,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err := utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err := handlers.MakeHTTPRequestWithClient(msg.Channel(), twReq, client)
 	log = courier.NewChannelLogFromRR("Media Upload INIT", msg.Channel(), msg.ID(), twrr)
 	if err != nil {
 		log.WithError("Media Upload INIT Error", err)
@@ -474,7 +474,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", contentType)
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err = handlers.MakeHTTPRequestWithClient(msg.Channel(), twReq, client)
 	log = courier.NewChannelLogFromRR("Media Upload APPEND request", msg.Channel(), msg.ID(), twrr)
 	if err != nil {
 		log = log.WithError("Media Upload APPEND request Error", err)
@@ -491,7 +491,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err = handlers.MakeHTTPRequestWithClient(msg.Channel(), twReq, client)
 
 	log = courier.NewChannelLogFromRR("Media Upload FINALIZE", msg.Channel(), msg.ID(), twrr)
 
@@ -528,7 +528,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 		twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		twReq.Header.Set("Accept", "application/json")
 		twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-		twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+		twrr, err = handlers.MakeHTTPRequestWithClient(msg.Channel(), twReq, client)
 		log = courier.NewChannelLogFromRR("Media Upload STATUS", msg.Channel(), msg.ID(), twrr)
 		if err != nil {
 			log.WithError("Media Upload STATUS Error", err)
@@ -7,8 +7,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -24,6 +27,24 @@ func GetTextAndAttachments(m courier.Msg) string {
 	return buf.String()
 }
 
+// GetTextAttachmentsAndQuickReplies returns the text of our message followed by its quick replies as a numbered list
+// and then any attachments, for channels which have no native way of sending quick replies
+func GetTextAttachmentsAndQuickReplies(m courier.Msg) string {
+	buf := bytes.NewBuffer([]byte(m.Text()))
+	if len(m.QuickReplies()) > 0 {
+		buf.WriteString("\n")
+		for i, reply := range m.QuickReplies() {
+			buf.WriteString(fmt.Sprintf("\n%d. %s", i+1, reply))
+		}
+	}
+	for _, a := range m.Attachments() {
+		_, url := SplitAttachment(a)
+		buf.WriteString("\n")
+		buf.WriteString(url)
+	}
+	return buf.String()
+}
+
 // SplitAttachment takes an attachment string and returns the media type and URL for the attachment
 func SplitAttachment(attachment string) (string, string) {
 	parts := strings.SplitN(attachment, ":", 2)
@@ -118,9 +139,146 @@ func SplitMsg(text string, max int) []string {
 	return parts
 }
 
+// SplitMessageByChannel splits the passed in text into SMS segments that are at most channel config max length or type
+// max length, see SplitMessage
+func SplitMessageByChannel(channel courier.Channel, text string, maxLength int) []string {
+	max := channel.IntConfigForKey(courier.ConfigMaxLength, maxLength)
+
+	return SplitMessage(text, max)
+}
+
+// SplitMessage splits the passed in text into SMS segments of at most maxLen GSM7 characters. Text which can't be
+// encoded as GSM7 is sent as UCS-2, which only fits 70 characters in the space of 160 GSM7 ones, so our segments are
+// shortened to match. We split on whitespace where possible.
+func SplitMessage(text string, maxLen int) []string {
+	isUCS2 := !gsm7.IsValid(text)
+	if isUCS2 {
+		maxLen = maxLen * 70 / 160
+	}
+	if maxLen < 1 {
+		maxLen = 1
+	}
+
+	// the number of GSM7 characters or UCS-2 code units needed to encode each rune
+	runeLength := func(r rune) int {
+		if isUCS2 {
+			if r > 0xFFFF {
+				return 2
+			}
+			return 1
+		}
+		return len(gsm7.Encode(string(r)))
+	}
+
+	runes := []rune(text)
+	total := 0
+	for _, r := range runes {
+		total += runeLength(r)
+	}
+	if total <= maxLen {
+		return []string{text}
+	}
+
+	parts := make([]string, 0, 2)
+	start := 0
+	for start < len(runes) {
+		length, end, lastSpace := 0, start, -1
+		for end < len(runes) && length+runeLength(runes[end]) <= maxLen {
+			if unicode.IsSpace(runes[end]) {
+				// close enough to our max length, break here
+				if length > maxLen-6 {
+					break
+				}
+				lastSpace = end
+			}
+			length += runeLength(runes[end])
+			end++
+		}
+
+		// we always take at least one rune, even if it doesn't fit
+		if end == start {
+			end++
+		}
+
+		// if we are splitting a word, break at the last whitespace instead
+		if end < len(runes) && !unicode.IsSpace(runes[end]) && lastSpace > start {
+			end = lastSpace
+		}
+
+		part := strings.TrimSpace(string(runes[start:end]))
+		if part != "" {
+			parts = append(parts, part)
+		}
+
+		// skip over any whitespace we split on
+		start = end
+		for start < len(runes) && unicode.IsSpace(runes[start]) {
+			start++
+		}
+	}
+
+	return parts
+}
+
+// epochs at least this large are in milliseconds, as in seconds they would be thousands of years from now
+const minMillisEpoch = 100000000000
+
+// ParseProviderTime parses a timestamp sent to us by a provider, trying each of the passed in layouts, then RFC 3339
+// and then unix epochs in seconds or milliseconds. Times without a zone are taken to be UTC, and the returned time is
+// always in UTC so that times from providers in different zones order correctly. If nothing matches, the error is that
+// from the first layout, as that is what the provider is expected to be sending.
+func ParseProviderTime(raw string, layouts ...string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+
+	var firstErr error
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, raw)
+		if err == nil {
+			return t.UTC(), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	if firstErr == nil {
+		firstErr = err
+	}
+
+	epoch, err := strconv.ParseInt(raw, 10, 64)
+	if err == nil && epoch >= 0 {
+		if epoch >= minMillisEpoch {
+			return time.Unix(0, epoch*int64(time.Millisecond)).UTC(), nil
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+
+	return time.Time{}, firstErr
+}
+
+// maxShortcodeLength is the longest number we accept without a country, anything longer which isn't E164 is a national
+// number which we can't normalize
+const maxShortcodeLength = 6
+
+// ChannelCountry returns the country national format numbers received on the passed in channel are assumed to be from,
+// that in its config if set, otherwise its own country
+func ChannelCountry(channel courier.Channel) string {
+	return strings.ToUpper(channel.StringConfigForKey(courier.ConfigCountry, channel.Country()))
+}
+
+// StrictTelForChannel is StrictTelForCountry for the passed in number using the country of the passed in channel
+func StrictTelForChannel(number string, channel courier.Channel) (urns.URN, error) {
+	return StrictTelForCountry(number, ChannelCountry(channel))
+}
+
 // StrictTelForCountry wraps urns.NewURNTelForCountry but is stricter in
 // what it accepts. Incoming tels must be numeric or we will return an
-// error. (IE, alphanumeric shortcodes are not ok)
+// error. (IE, alphanumeric shortcodes are not ok) National format numbers
+// need a country, rather than us guessing at what they might be.
 func StrictTelForCountry(number string, country string) (urns.URN, error) {
 	// first figure out if we are valid non-strictly
 	urn, err := urns.NewTelURNForCountry(number, country)
@@ -134,6 +292,11 @@ func StrictTelForCountry(number string, country string) (urns.URN, error) {
 		return urns.NilURN, fmt.Errorf("phone number supplied is not a number")
 	}
 
+	// without a country, anything longer than a shortcode must already be E164
+	if country == "" && !strings.HasPrefix(urn.Path(), "+") && len(urn.Path()) > maxShortcodeLength {
+		return urns.NilURN, fmt.Errorf("unable to normalize national number %s without a country", urn.Path())
+	}
+
 	// finally if our original number started with a plus and is the same as our new number, use that
 	// as our URN. This deals with the case where a carrier is handing us an E164 number that
 	// the phonenumbers library doesn't know about yet
@@ -280,7 +280,7 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	params.Set(paramUserIds, urnPath)
 
 	req.URL.RawQuery = params.Encode()
-	res, err := utils.MakeHTTPRequest(req)
+	res, err := handlers.MakeHTTPRequest(channel, req)
 
 	if err != nil {
 		return nil, err
@@ -397,7 +397,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	params.Set(paramAttachments, attachments)
 
 	req.URL.RawQuery = params.Encode()
-	res, err := utils.MakeHTTPRequest(req)
+	res, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), res).WithError("Message Send Error", err)
 	status.AddLog(log)
@@ -469,7 +469,7 @@ func handleMediaUploadAndGetAttachment(channel courier.Channel, mediaType, media
 		if err != nil {
 			return "", err
 		}
-		uploadResponse, err := uploadMedia(URLPhotoUploadServer, uploadKey, mediaExt, download)
+		uploadResponse, err := uploadMedia(channel, URLPhotoUploadServer, uploadKey, mediaExt, download)
 
 		if err != nil {
 			return "", err
@@ -503,7 +503,7 @@ func getUploadServerURL(channel courier.Channel, sendURL string) (string, error)
 	}
 	params := buildApiBaseParams(channel)
 	req.URL.RawQuery = params.Encode()
-	res, err := utils.MakeHTTPRequest(req)
+	res, err := handlers.MakeHTTPRequest(channel, req)
 
 	if err != nil {
 		return "", err
@@ -531,7 +531,7 @@ func downloadMedia(mediaURL string) (io.Reader, error) {
 }
 
 // uploadMedia multiform request that passes file key as uploadKey and file value as media to upload server
-func uploadMedia(serverURL, uploadKey, mediaExt string, media io.Reader) ([]byte, error) {
+func uploadMedia(channel courier.Channel, serverURL, uploadKey, mediaExt string, media io.Reader) ([]byte, error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
@@ -558,7 +558,7 @@ func uploadMedia(serverURL, uploadKey, mediaExt string, media io.Reader) ([]byte
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	if res, err := utils.MakeHTTPRequest(req); err != nil {
+	if res, err := handlers.MakeHTTPRequest(channel, req); err != nil {
 		return nil, err
 	} else {
 		return res.Body, nil
@@ -577,7 +577,7 @@ func saveUploadedMediaInfo(channel courier.Channel, sendURL, serverId, hash, med
 		return nil, err
 	}
 	req.URL.RawQuery = params.Encode()
-	res, err := utils.MakeHTTPRequest(req)
+	res, err := handlers.MakeHTTPRequest(channel, req)
 
 	if err != nil {
 		return nil, err
@@ -79,6 +79,17 @@ type eventPayload struct {
 		Text      struct {
 			Body string `json:"body"`
 		} `json:"text"`
+		Contacts []struct {
+			Name struct {
+				FormattedName string `json:"formatted_name"`
+			} `json:"name"`
+			Phones []struct {
+				Phone string `json:"phone"`
+			} `json:"phones"`
+			Emails []struct {
+				Email string `json:"email"`
+			} `json:"emails"`
+		} `json:"contacts"`
 		Audio *struct {
 			File     string `json:"file"      validate:"required"`
 			ID       string `json:"id"        validate:"required"`
@@ -104,8 +115,8 @@ type eventPayload struct {
 		} `json:"image"`
 		Location *struct {
 			Address   string  `json:"address"   validate:"required"`
-			Latitude  float32 `json:"latitude"  validate:"required"`
-			Longitude float32 `json:"longitude" validate:"required"`
+			Latitude  float64 `json:"latitude"  validate:"required"`
+			Longitude float64 `json:"longitude" validate:"required"`
 			Name      string  `json:"name"      validate:"required"`
 			URL       string  `json:"url"       validate:"required"`
 		} `json:"location"`
@@ -168,6 +179,8 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 
 		text := ""
 		mediaURL := ""
+		var location *courier.LatLon
+		var cards []courier.ContactCard
 
 		if msg.Type == "text" {
 			text = msg.Text.Body
@@ -181,6 +194,18 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 			mediaURL, err = resolveMediaURL(channel, msg.Image.ID)
 		} else if msg.Type == "location" && msg.Location != nil {
 			mediaURL = fmt.Sprintf("geo:%f,%f", msg.Location.Latitude, msg.Location.Longitude)
+			location = &courier.LatLon{Latitude: msg.Location.Latitude, Longitude: msg.Location.Longitude, Name: msg.Location.Name, Address: msg.Location.Address}
+		} else if msg.Type == "contacts" && len(msg.Contacts) > 0 {
+			for _, contact := range msg.Contacts {
+				card := courier.ContactCard{Name: contact.Name.FormattedName}
+				for _, phone := range contact.Phones {
+					card.Phones = append(card.Phones, phone.Phone)
+				}
+				for _, email := range contact.Emails {
+					card.Emails = append(card.Emails, email.Email)
+				}
+				cards = append(cards, card)
+			}
 		} else if msg.Type == "video" && msg.Video != nil {
 			mediaURL, err = resolveMediaURL(channel, msg.Video.ID)
 		} else if msg.Type == "voice" && msg.Voice != nil {
@@ -202,6 +227,12 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		if mediaURL != "" {
 			event.WithAttachment(mediaURL)
 		}
+		if location != nil {
+			event.WithLocation(*location)
+		}
+		for _, card := range cards {
+			event.WithContactCard(card)
+		}
 
 		err = h.Backend().WriteMsg(ctx, event)
 		if err != nil {
@@ -494,7 +525,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 	} else {
 		// do we have a template?
-		var templating *MsgTemplating
+		var templating *courier.MsgTemplating
 		templating, err = h.getTemplate(msg)
 		if err != nil {
 			return nil, errors.Wrapf(err, "unable to decode template: %s for channel: %s", string(msg.Metadata()), msg.Channel().UUID())
@@ -605,7 +636,7 @@ func sendWhatsAppMsg(msg courier.Msg, sendPath *url.URL, token string, payload i
 	}
 	req, _ := http.NewRequest(http.MethodPost, sendPath.String(), bytes.NewReader(jsonBody))
 	req.Header = buildWhatsAppRequestHeader(token)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(msg.Channel(), req)
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 	errPayload := &mtErrorPayload{}
 	err = json.Unmarshal(rr.Body, errPayload)
@@ -618,7 +649,7 @@ func sendWhatsAppMsg(msg courier.Msg, sendPath *url.URL, token string, payload i
 		}
 		// check contact
 		baseURL := fmt.Sprintf("%s://%s", sendPath.Scheme, sendPath.Host)
-		rrCheck, err := checkWhatsAppContact(baseURL, token, msg.URN())
+		rrCheck, err := checkWhatsAppContact(msg.Channel(), baseURL, token, msg.URN())
 
 		if rrCheck == nil {
 			elapsed := time.Now().Sub(start)
@@ -679,7 +710,7 @@ func sendWhatsAppMsg(msg courier.Msg, sendPath *url.URL, token string, payload i
 		if retryParam != "" {
 			reqRetry.URL.RawQuery = fmt.Sprintf("%s=1", retryParam)
 		}
-		rrRetry, err := utils.MakeHTTPRequest(reqRetry)
+		rrRetry, err := handlers.MakeHTTPRequest(msg.Channel(), reqRetry)
 		retryLog := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rrRetry).WithError("Message Send Error", err)
 
 		if err != nil {
@@ -725,7 +756,7 @@ type mtContactCheckPayload struct {
 	ForceCheck bool     `json:"force_check"`
 }
 
-func checkWhatsAppContact(baseURL string, token string, urn urns.URN) (*utils.RequestResponse, error) {
+func checkWhatsAppContact(channel courier.Channel, baseURL string, token string, urn urns.URN) (*utils.RequestResponse, error) {
 	payload := mtContactCheckPayload{
 		Blocking:   "wait",
 		Contacts:   []string{fmt.Sprintf("+%s", urn.Path())},
@@ -739,7 +770,7 @@ func checkWhatsAppContact(baseURL string, token string, urn urns.URN) (*utils.Re
 	sendURL := fmt.Sprintf("%s/v1/contacts", baseURL)
 	req, _ := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(reqBody))
 	req.Header = buildWhatsAppRequestHeader(token)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := handlers.MakeHTTPRequest(channel, req)
 
 	if err != nil {
 		return rr, err
@@ -756,17 +787,11 @@ func checkWhatsAppContact(baseURL string, token string, urn urns.URN) (*utils.Re
 	}
 }
 
-func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
-	mdJSON := msg.Metadata()
-	if len(mdJSON) == 0 {
-		return nil, nil
-	}
-	metadata := &TemplateMetadata{}
-	err := json.Unmarshal(mdJSON, metadata)
+func (h *handler) getTemplate(msg courier.Msg) (*courier.MsgTemplating, error) {
+	templating, err := msg.Templating()
 	if err != nil {
 		return nil, err
 	}
-	templating := metadata.Templating
 	if templating == nil {
 		return nil, nil
 	}
@@ -782,22 +807,10 @@ func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
 	if !found {
 		return nil, fmt.Errorf("unable to find mapping for language: %s", templating.Language)
 	}
-	templating.Language = language
-
-	return templating, err
-}
 
-type TemplateMetadata struct {
-	Templating *MsgTemplating `json:"templating"`
-}
-
-type MsgTemplating struct {
-	Template struct {
-		Name string `json:"name" validate:"required"`
-		UUID string `json:"uuid" validate:"required"`
-	} `json:"template" validate:"required,dive"`
-	Language  string   `json:"language" validate:"required"`
-	Variables []string `json:"variables"`
+	mapped := *templating
+	mapped.Language = language
+	return &mapped, nil
 }
 
 // mapping from iso639-3 to WA language code
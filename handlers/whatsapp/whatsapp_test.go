@@ -128,6 +128,29 @@ var locationMsg = `{
 	}]
 }`
 
+var contactsMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "contacts",
+		"contacts": [{
+			"name": {
+				"first_name": "John",
+				"formatted_name": "John Smith",
+				"last_name": "Smith"
+			},
+			"phones": [{"phone": "+1 (650) 555-1234", "type": "WORK", "wa_id": "16505551234"}],
+			"emails": [{"email": "john@example.org", "type": "WORK"}]
+		}, {
+			"name": {
+				"formatted_name": "Jane"
+			},
+			"phones": [{"phone": "+250788383383", "type": "CELL"}]
+		}]
+	}]
+}`
+
 var videoMsg = `{
 	"messages": [{
 		"from": "250788123123",
@@ -229,7 +252,14 @@ var testCases = []ChannelHandleTestCase{
 	{Label: "Receive Valid Image Message", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: imageMsg, Status: 200, Response: `"type":"msg"`,
 		Text: Sp("the caption"), Attachment: Sp("https://foo.bar/v1/media/41"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
 	{Label: "Receive Valid Location Message", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: locationMsg, Status: 200, Response: `"type":"msg"`,
-		Text: Sp(""), Attachment: Sp("geo:0.000000,1.000000"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
+		Text: Sp(""), Attachment: Sp("geo:0.000000,1.000000"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Location: &courier.LatLon{Latitude: 0, Longitude: 1, Name: "some name", Address: "some address"}},
+	{Label: "Receive Valid Contacts Message", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: contactsMsg, Status: 200, Response: `"type":"msg"`,
+		Text: Sp(""), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		ContactCards: []courier.ContactCard{
+			{Name: "John Smith", Phones: []string{"+1 (650) 555-1234"}, Emails: []string{"john@example.org"}},
+			{Name: "Jane", Phones: []string{"+250788383383"}},
+		}},
 	{Label: "Receive Valid Video Message", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: videoMsg, Status: 200, Response: `"type":"msg"`,
 		Text: Sp(""), Attachment: Sp("https://foo.bar/v1/media/41"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
 	{Label: "Receive Valid Voice Message", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: voiceMsg, Status: 200, Response: `"type":"msg"`,
@@ -381,6 +411,16 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Error:    `unable to decode template: {"templating": { "template": { "name": "revive_issue", "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "bnt", "variables": ["Chef", "tomorrow"]}} for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: unable to find mapping for language: bnt`,
 		Metadata: json.RawMessage(`{"templating": { "template": { "name": "revive_issue", "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "bnt", "variables": ["Chef", "tomorrow"]}}`),
 	},
+	{Label: "Template Malformed",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Error:    `unable to decode template: {"templating": { "template": { "name": "revive_issue" for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: unexpected end of JSON input`,
+		Metadata: json.RawMessage(`{"templating": { "template": { "name": "revive_issue"`),
+	},
+	{Label: "Template Missing Name",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Error:    `unable to decode template: {"templating": { "template": { "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "eng", "variables": ["Chef", "tomorrow"]}} for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: invalid templating definition: Key: 'MsgTemplating.Template.Name' Error:Field validation for 'Name' failed on the 'required' tag`,
+		Metadata: json.RawMessage(`{"templating": { "template": { "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "eng", "variables": ["Chef", "tomorrow"]}}`),
+	},
 	{Label: "WhatsApp Contact Error",
 		Text: "contact status error", URN: "whatsapp:250788123123",
 		Status: "E",
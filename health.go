@@ -0,0 +1,90 @@
+package courier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// HealthState is the state of one of the services we depend on
+type HealthState string
+
+// Possible values for HealthState
+const (
+	HealthOK       HealthState = "ok"
+	HealthDegraded HealthState = "degraded"
+	HealthDown     HealthState = "down"
+)
+
+// HealthDegradedLatency is how long a service can take to respond to a health check before we consider it degraded
+var HealthDegradedLatency = 250 * time.Millisecond
+
+// HealthStatus is the result of checking the health of one of the services we depend on
+type HealthStatus struct {
+	Name    string
+	State   HealthState
+	Latency time.Duration
+	Message string
+}
+
+// MarshalJSON marshals our status to JSON, with our latency in milliseconds
+func (s HealthStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Name      string      `json:"name"`
+		State     HealthState `json:"state"`
+		LatencyMS float64     `json:"latency_ms"`
+		Message   string      `json:"message,omitempty"`
+	}{s.Name, s.State, float64(s.Latency) / float64(time.Millisecond), s.Message})
+}
+
+// CheckHealthOf runs the passed in check, timing it, and returns the health status of the named service. The service is
+// down if the check returns an error and degraded if it takes longer than HealthDegradedLatency.
+func CheckHealthOf(name string, check func() error) HealthStatus {
+	start := time.Now()
+	err := check()
+	status := HealthStatus{Name: name, State: HealthOK, Latency: time.Since(start)}
+
+	if err != nil {
+		status.State = HealthDown
+		status.Message = err.Error()
+	} else if status.Latency > HealthDegradedLatency {
+		status.State = HealthDegraded
+		status.Message = fmt.Sprintf("slow to respond, took %s", status.Latency)
+	}
+	return status
+}
+
+// OverallHealth returns the worst state of the passed in statuses
+func OverallHealth(statuses map[string]HealthStatus) HealthState {
+	overall := HealthOK
+	for _, status := range statuses {
+		if status.State == HealthDown {
+			return HealthDown
+		}
+		if status.State == HealthDegraded {
+			overall = HealthDegraded
+		}
+	}
+	return overall
+}
+
+// FormatHealth formats the passed in statuses as text for our index page, only services which aren't ok are
+// included so this is empty if all is well
+func FormatHealth(statuses map[string]HealthStatus) string {
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	health := bytes.Buffer{}
+	for _, name := range names {
+		status := statuses[name]
+		if status.State != HealthOK {
+			health.WriteString(fmt.Sprintf("\n% 16s: %s %s", name, status.State, status.Message))
+		}
+	}
+	return health.String()
+}
@@ -0,0 +1,38 @@
+package courier
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	defer func() { HealthDegradedLatency = 250 * time.Millisecond }()
+	HealthDegradedLatency = 50 * time.Millisecond
+
+	ok := CheckHealthOf("redis", func() error { return nil })
+	assert.Equal(t, HealthOK, ok.State)
+	assert.Equal(t, "", ok.Message)
+
+	down := CheckHealthOf("database", func() error { return errors.New("connection refused") })
+	assert.Equal(t, HealthDown, down.State)
+	assert.Equal(t, "connection refused", down.Message)
+
+	slow := CheckHealthOf("spool", func() error { time.Sleep(60 * time.Millisecond); return nil })
+	assert.Equal(t, HealthDegraded, slow.State)
+	assert.True(t, slow.Latency >= 60*time.Millisecond)
+
+	assert.Equal(t, HealthOK, OverallHealth(map[string]HealthStatus{"redis": ok}))
+	assert.Equal(t, HealthDegraded, OverallHealth(map[string]HealthStatus{"redis": ok, "spool": slow}))
+	assert.Equal(t, HealthDown, OverallHealth(map[string]HealthStatus{"redis": ok, "spool": slow, "database": down}))
+
+	assert.Equal(t, "", FormatHealth(map[string]HealthStatus{"redis": ok}))
+	assert.Equal(t, "\n        database: down connection refused", FormatHealth(map[string]HealthStatus{"redis": ok, "database": down}))
+
+	marshalled, err := json.Marshal(HealthStatus{Name: "redis", State: HealthOK, Latency: 1500 * time.Microsecond})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "redis", "state": "ok", "latency_ms": 1.5}`, string(marshalled))
+}
@@ -0,0 +1,136 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// the spool directory inbound msgs we fail to forward are written to, to be forwarded once our webhook is back
+const inboundWebhookSpoolDir = "webhooks"
+
+// inboundWebhookPayload is what we POST to our InboundWebhookURL for each incoming msg
+type inboundWebhookPayload struct {
+	UUID         MsgUUID       `json:"uuid"`
+	ChannelUUID  ChannelUUID   `json:"channel_uuid"`
+	ChannelType  ChannelType   `json:"channel_type"`
+	URN          string        `json:"urn"`
+	ContactName  string        `json:"contact_name,omitempty"`
+	Text         string        `json:"text"`
+	Attachments  []string      `json:"attachments,omitempty"`
+	Location     *LatLon       `json:"location,omitempty"`
+	ContactCards []ContactCard `json:"contact_cards,omitempty"`
+	ExternalID   string        `json:"external_id,omitempty"`
+	ReceivedOn   *time.Time    `json:"received_on,omitempty"`
+}
+
+func newInboundWebhookPayload(msg Msg) *inboundWebhookPayload {
+	return &inboundWebhookPayload{
+		UUID:         msg.UUID(),
+		ChannelUUID:  msg.Channel().UUID(),
+		ChannelType:  msg.Channel().ChannelType(),
+		URN:          msg.URN().String(),
+		ContactName:  msg.ContactName(),
+		Text:         msg.Text(),
+		Attachments:  msg.Attachments(),
+		Location:     msg.Location(),
+		ContactCards: msg.ContactCards(),
+		ExternalID:   msg.ExternalID(),
+		ReceivedOn:   msg.ReceivedOn(),
+	}
+}
+
+// inboundWebhookBackend wraps a backend, forwarding the incoming msgs written through it to our InboundWebhookURL.
+// Forwarding happens in the background so it never holds up writing msgs, and a webhook which is down never fails them.
+type inboundWebhookBackend struct {
+	Backend
+	server *server
+}
+
+// WriteMsg writes the passed in msg to our wrapped backend, then forwards it to our webhook unless it is a duplicate
+func (b *inboundWebhookBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	err := b.Backend.WriteMsg(ctx, msg)
+	if err != nil || msg.AlreadyWritten() {
+		return err
+	}
+
+	log := logrus.WithField("comp", "inbound_webhook").WithField("channel_uuid", msg.Channel().UUID()).WithField("msg_uuid", msg.UUID().String())
+
+	body, err := json.Marshal(newInboundWebhookPayload(msg))
+	if err != nil {
+		log.WithError(err).Error("error marshalling msg for inbound webhook")
+		return nil
+	}
+
+	done := b.server.TrackComponent("inbound_webhook")
+	go func() {
+		defer done()
+		b.server.sendInboundWebhook(log, body)
+	}()
+	return nil
+}
+
+// sendInboundWebhook POSTs the passed in msg JSON to our InboundWebhookURL, retrying with an exponential backoff if
+// that fails. If we give up after InboundWebhookRetries retries or are stopped, it is spooled to be forwarded later.
+func (s *server) sendInboundWebhook(log *logrus.Entry, body []byte) {
+	for attempt := 1; ; attempt++ {
+		err := s.postInboundWebhook(body)
+		if err == nil {
+			return
+		}
+
+		if attempt > s.config.InboundWebhookRetries {
+			log.WithError(err).WithField("attempts", attempt).Error("error forwarding msg to inbound webhook, spooling")
+			s.spoolInboundWebhook(log, body)
+			return
+		}
+
+		backoff := retryBackoff(s.config.InboundWebhookBackoff, attempt)
+		log.WithError(err).WithField("attempt", attempt).WithField("backoff", backoff).Warn("error forwarding msg to inbound webhook, retrying")
+
+		select {
+		case <-s.stopChan:
+			log.WithError(err).Error("stopped before msg could be forwarded to inbound webhook, spooling")
+			s.spoolInboundWebhook(log, body)
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// POSTs the passed in msg JSON to our InboundWebhookURL once
+func (s *server) postInboundWebhook(body []byte) error {
+	req, _ := http.NewRequest(http.MethodPost, s.config.InboundWebhookURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	_, err := utils.MakeHTTPRequest(req)
+	return err
+}
+
+// writes the passed in msg JSON to our spool, to be forwarded when our spool is next flushed
+func (s *server) spoolInboundWebhook(log *logrus.Entry, body []byte) {
+	err := WriteToSpool(s.config.SpoolDir, inboundWebhookSpoolDir, json.RawMessage(body))
+	if err != nil {
+		log.WithError(err).Error("error spooling msg for inbound webhook, msg will not be forwarded")
+	}
+}
+
+// flushInboundWebhookFile is our flusher for spooled inbound webhook msgs, it tries to forward them once
+func (s *server) flushInboundWebhookFile(filename string, contents []byte) error {
+	return s.postInboundWebhook(contents)
+}
+
+// registers our flusher for spooled inbound webhook msgs, making sure their spool directory exists
+func (s *server) registerInboundWebhookFlusher() error {
+	err := EnsureSpoolDirPresent(s.config.SpoolDir, inboundWebhookSpoolDir)
+	if err != nil {
+		return err
+	}
+	RegisterFlusher(path.Join(s.config.SpoolDir, inboundWebhookSpoolDir), s.flushInboundWebhookFile)
+	return nil
+}
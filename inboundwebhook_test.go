@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundWebhook(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	// a receiver which is up until we say it is down
+	var mutex sync.Mutex
+	down := false
+	received := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		payload := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received <- payload
+	}))
+	defer receiver.Close()
+	setDown := func(isDown bool) { mutex.Lock(); down = isDown; mutex.Unlock() }
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := testConfig()
+	config.SpoolDir = spoolDir
+	config.InboundWebhookURL = receiver.URL
+	config.InboundWebhookRetries = 1
+	config.InboundWebhookBackoff = 1
+
+	mb := NewMockBackend()
+	s := NewServerWithLogger(config, mb, logrus.New()).(*server)
+	assert.NoError(t, s.registerInboundWebhookFlusher())
+
+	ctx := context.Background()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+
+	// incoming msgs are forwarded as JSON once they are written
+	msg := mb.NewIncomingMsg(channel, urns.URN("tel:+250788383383"), "hello").WithExternalID("ext1").WithContactName("Bob")
+	assert.NoError(t, s.Backend().WriteMsg(ctx, msg))
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, msg.UUID().String(), payload["uuid"])
+		assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", payload["channel_uuid"])
+		assert.Equal(t, "DM", payload["channel_type"])
+		assert.Equal(t, "tel:+250788383383", payload["urn"])
+		assert.Equal(t, "Bob", payload["contact_name"])
+		assert.Equal(t, "hello", payload["text"])
+		assert.Equal(t, "ext1", payload["external_id"])
+	case <-time.After(time.Second):
+		assert.Fail(t, "msg wasn't forwarded to inbound webhook")
+	}
+
+	// if our receiver is down, the msg is still written and is spooled once we give up retrying
+	setDown(true)
+	msg = mb.NewIncomingMsg(channel, urns.URN("tel:+250788383383"), "are you there?")
+	assert.NoError(t, s.Backend().WriteMsg(ctx, msg))
+	s.waitGroup.Wait()
+
+	webhookDir := path.Join(spoolDir, inboundWebhookSpoolDir)
+	assert.Equal(t, 1, countSpoolFiles(webhookDir))
+
+	// and forwarded once our receiver is back
+	setDown(false)
+	assert.NoError(t, drainSpool(webhookDir, s.flushInboundWebhookFile, 1, s.Stopped))
+	assert.Equal(t, 0, countSpoolFiles(webhookDir))
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, msg.UUID().String(), payload["uuid"])
+		assert.Equal(t, "are you there?", payload["text"])
+	case <-time.After(time.Second):
+		assert.Fail(t, "spooled msg wasn't forwarded to inbound webhook")
+	}
+
+	// duplicates of msgs already written aren't forwarded again
+	dupe := mb.NewIncomingMsg(channel, urns.URN("tel:+250788383383"), "hello").(*mockMsg)
+	dupe.alreadyWritten = true
+	assert.NoError(t, s.Backend().WriteMsg(ctx, dupe))
+	s.waitGroup.Wait()
+	assert.Equal(t, 0, len(received))
+}
@@ -0,0 +1,245 @@
+package courier
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// metrics holds the prometheus registry and collectors exposed on /metrics when metrics are enabled. All
+// methods are safe to call on a nil metrics, in which case they are no-ops.
+type metrics struct {
+	registry *prometheus.Registry
+
+	msgWrites       *prometheus.CounterVec
+	statusWrites    *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	spoolDepth      *prometheus.GaugeVec
+	sendErrors      *prometheus.CounterVec
+}
+
+// newMetrics creates a new registry with all our collectors registered
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		msgWrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "courier_msg_writes_total",
+			Help: "The number of incoming messages written to the backend",
+		}, []string{"channel_type", "result"}),
+
+		statusWrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "courier_msg_status_writes_total",
+			Help: "The number of message status updates written to the backend",
+		}, []string{"channel_type", "result"}),
+
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "courier_handler_duration_seconds",
+			Help:    "The time taken by channel handlers to handle incoming requests",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"channel_type", "action"}),
+
+		spoolDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "courier_spool_depth",
+			Help: "The number of files waiting to be flushed from the spool",
+		}, []string{"directory"}),
+
+		sendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "courier_send_errors_total",
+			Help: "The number of outgoing messages providers failed to send, by why they failed",
+		}, []string{"channel_type", "category"}),
+	}
+
+	m.registry.MustRegister(m.msgWrites, m.statusWrites, m.handlerDuration, m.spoolDepth, m.sendErrors)
+	m.registry.MustRegister(prometheus.NewGoCollector())
+	return m
+}
+
+func (m *metrics) recordMsgWrite(channelType ChannelType, err error) {
+	if m != nil {
+		m.msgWrites.WithLabelValues(string(channelType), resultLabel(err)).Inc()
+	}
+}
+
+func (m *metrics) recordStatusWrite(channelType ChannelType, err error) {
+	if m != nil {
+		m.statusWrites.WithLabelValues(string(channelType), resultLabel(err)).Inc()
+	}
+}
+
+func (m *metrics) recordHandlerDuration(channelType ChannelType, action string, duration time.Duration) {
+	if m != nil {
+		m.handlerDuration.WithLabelValues(string(channelType), action).Observe(duration.Seconds())
+	}
+}
+
+func (m *metrics) recordSendError(channelType ChannelType, category SendErrorCategory) {
+	if m != nil && category != NilSendErrorCategory {
+		m.sendErrors.WithLabelValues(string(channelType), string(category)).Inc()
+	}
+}
+
+func (m *metrics) setSpoolDepth(directory string, depth int) {
+	if m != nil {
+		m.spoolDepth.WithLabelValues(filepath.Base(directory)).Set(float64(depth))
+	}
+}
+
+// the values of our breaker state gauge for each state
+var breakerStateValues = map[BreakerState]float64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}
+
+// registerBreakerState registers a gauge of the state of the passed in breaker, read whenever we are scraped
+func (m *metrics) registerBreakerState(breaker *circuitBreaker) {
+	if m != nil && breaker != nil {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "courier_backend_breaker_state",
+			Help: "The state of the circuit breaker around backend writes, 0 when closed, 1 when half open and 2 when open",
+		}, func() float64 { return breakerStateValues[breaker.State()] }))
+	}
+}
+
+// registerSenderGauges registers gauges of the size of the passed in foreman's pool of senders, how many of them are
+// busy sending and how many msgs are waiting for them in our backend's queue, read whenever we are scraped
+func (m *metrics) registerSenderGauges(foreman *Foreman, backend Backend) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "courier_sender_workers",
+		Help: "The number of senders in our pool, i.e. how many msgs can be sent at once",
+	}, func() float64 { return float64(len(foreman.senders)) }))
+
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "courier_sender_workers_active",
+		Help: "The number of senders currently sending a msg",
+	}, func() float64 { return float64(foreman.ActiveSenders()) }))
+
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "courier_outgoing_queue_depth",
+		Help: "The number of outgoing msgs waiting to be sent",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		depth, err := backend.OutgoingQueueDepth(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("error getting outgoing queue depth")
+			return math.NaN()
+		}
+		return float64(depth)
+	}))
+}
+
+// registerMediaDownloads registers a gauge of how many attachments the passed in backend is downloading, read whenever
+// we are scraped
+func (m *metrics) registerMediaDownloads(backend Backend) {
+	if m != nil {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "courier_media_downloads",
+			Help: "The number of attachments of incoming msgs currently being downloaded",
+		}, func() float64 { return float64(backend.MediaDownloads()) }))
+	}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// countSpoolFiles returns the number of files waiting to be flushed in the passed in spool directory
+func countSpoolFiles(directory string) int {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, f := range files {
+		if !f.IsDir() && isSpoolFile(f.Name()) {
+			count++
+		}
+	}
+	return count
+}
+
+// metricsBackend wraps a backend, recording metrics for the messages and statuses written through it
+type metricsBackend struct {
+	Backend
+	metrics *metrics
+
+	// statuses only know their channel's UUID, so we remember the type of each channel we've looked up
+	mutex        sync.RWMutex
+	channelTypes map[ChannelUUID]ChannelType
+}
+
+// newMetricsBackend creates a new backend wrapping the passed in backend which records to the passed in metrics
+func newMetricsBackend(backend Backend, metrics *metrics) *metricsBackend {
+	return &metricsBackend{Backend: backend, metrics: metrics, channelTypes: make(map[ChannelUUID]ChannelType)}
+}
+
+// WriteMsg writes the passed in message to our wrapped backend, recording the result
+func (b *metricsBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	err := b.Backend.WriteMsg(ctx, msg)
+	b.metrics.recordMsgWrite(msg.Channel().ChannelType(), err)
+	return err
+}
+
+// WriteMsgStatus writes the passed in status to our wrapped backend, recording the result
+func (b *metricsBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	err := b.Backend.WriteMsgStatus(ctx, status)
+
+	channelType := b.channelType(ctx, status.ChannelUUID())
+	b.metrics.recordStatusWrite(channelType, err)
+	b.metrics.recordSendError(channelType, status.ErrorCategory())
+	return err
+}
+
+// WriteMsgStatuses writes the passed in statuses to our wrapped backend, recording the result of each
+func (b *metricsBackend) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	err := b.Backend.WriteMsgStatuses(ctx, statuses)
+
+	// if we didn't get per status errors, every status shares the same result
+	statusesErr, _ := err.(*MsgStatusesError)
+
+	for i, status := range statuses {
+		statusErr := err
+		if statusesErr != nil {
+			statusErr = statusesErr.Errors[i]
+		}
+
+		channelType := b.channelType(ctx, status.ChannelUUID())
+		b.metrics.recordStatusWrite(channelType, statusErr)
+		b.metrics.recordSendError(channelType, status.ErrorCategory())
+	}
+	return err
+}
+
+// channelType returns the type of the channel with the passed in UUID, only looking it up the first time we see it.
+// Channels we can't look up are labelled unknown until we can.
+func (b *metricsBackend) channelType(ctx context.Context, channelUUID ChannelUUID) ChannelType {
+	b.mutex.RLock()
+	channelType, found := b.channelTypes[channelUUID]
+	b.mutex.RUnlock()
+	if found {
+		return channelType
+	}
+
+	channel, err := b.Backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		return ChannelType("unknown")
+	}
+
+	b.mutex.Lock()
+	b.channelTypes[channelUUID] = channel.ChannelType()
+	b.mutex.Unlock()
+	return channel.ChannelType()
+}
@@ -0,0 +1,43 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// channelCountingBackend counts the channels looked up through it
+type channelCountingBackend struct {
+	*MockBackend
+	lookups int
+}
+
+func (b *channelCountingBackend) GetChannel(ctx context.Context, cType ChannelType, uuid ChannelUUID) (Channel, error) {
+	b.lookups++
+	return b.MockBackend.GetChannel(ctx, cType, uuid)
+}
+
+func TestMetricsBackendChannelTypes(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	backend := &channelCountingBackend{MockBackend: mb}
+	b := newMetricsBackend(backend, newMetrics())
+
+	// channels we can't look up are unknown, and we try again next time
+	mb.SetErrorOnGetChannel(errors.New("boom"))
+	assert.Equal(t, ChannelType("unknown"), b.channelType(context.Background(), channel.UUID()))
+	mb.SetErrorOnGetChannel(nil)
+
+	// but once we know a channel's type we don't look it up again, however many statuses are written for it
+	b.WriteMsgStatus(context.Background(), mb.NewMsgStatusForID(channel, NewMsgID(10), MsgSent))
+	b.WriteMsgStatuses(context.Background(), []MsgStatus{
+		mb.NewMsgStatusForID(channel, NewMsgID(11), MsgSent),
+		mb.NewMsgStatusForID(channel, NewMsgID(12), MsgSent),
+	})
+	assert.Equal(t, ChannelType("DM"), b.channelType(context.Background(), channel.UUID()))
+	assert.Equal(t, 2, backend.lookups)
+}
@@ -1,10 +1,14 @@
 package courier
 
 import (
+	"crypto/sha1"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nyaruka/null"
@@ -55,6 +59,37 @@ func (i *MsgID) Scan(value interface{}) error {
 	return null.ScanInt(value, (*null.Int)(i))
 }
 
+// MsgPriority is the priority an outgoing msg is sent with, all queued msgs of a higher priority are sent before
+// any of a lower one, and msgs of the same priority are sent in the order they were queued
+type MsgPriority int
+
+const (
+	// MsgPriorityBulk is for msgs sent in batches, such as broadcasts
+	MsgPriorityBulk = MsgPriority(0)
+
+	// MsgPriorityNormal is for replies, this is what msgs flagged as high priority are sent with
+	MsgPriorityNormal = MsgPriority(1)
+
+	// MsgPriorityHigh is for time sensitive msgs, such as OTP codes or alerts
+	MsgPriorityHigh = MsgPriority(2)
+)
+
+// MsgPriorities are all our msg priorities, highest first
+var MsgPriorities = []MsgPriority{MsgPriorityHigh, MsgPriorityNormal, MsgPriorityBulk}
+
+// String satisfies the Stringer interface
+func (p MsgPriority) String() string {
+	switch p {
+	case MsgPriorityHigh:
+		return "high"
+	case MsgPriorityNormal:
+		return "normal"
+	case MsgPriorityBulk:
+		return "bulk"
+	}
+	return strconv.Itoa(int(p))
+}
+
 // NilMsgID is our nil value for MsgID
 var NilMsgID = MsgID(0)
 
@@ -78,6 +113,72 @@ func NewMsgUUIDFromString(uuidString string) MsgUUID {
 	return MsgUUID{uuid}
 }
 
+// LatLon is a location pin shared in an incoming msg
+type LatLon struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// ContactCard is a contact shared in an incoming msg, e.g. from the contact's address book
+type ContactCard struct {
+	Name   string   `json:"name"`
+	Phones []string `json:"phones,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+}
+
+// MsgTemplating is the pre-approved template an outgoing msg should be sent as on channels which require them, such as
+// WhatsApp for msgs which start a conversation. Variables are the values of the template's parameters, in order.
+type MsgTemplating struct {
+	Template struct {
+		Name string `json:"name" validate:"required"`
+		UUID string `json:"uuid" validate:"required"`
+	} `json:"template" validate:"required,dive"`
+	Language  string   `json:"language" validate:"required"`
+	Variables []string `json:"variables"`
+}
+
+// MsgTemplatingFromMetadata returns the templating in the passed in msg metadata, nil if it has none, or an error if
+// the metadata can't be parsed
+func MsgTemplatingFromMetadata(metadata json.RawMessage) (*MsgTemplating, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	envelope := &struct {
+		Templating *MsgTemplating `json:"templating"`
+	}{}
+	if err := json.Unmarshal(metadata, envelope); err != nil {
+		return nil, err
+	}
+	return envelope.Templating, nil
+}
+
+// MsgExpiresAt returns when the passed in outgoing msg expires, which is its own expiry if it has one, otherwise the
+// one configured for its priority from when it was created. Nil means it never expires.
+func MsgExpiresAt(config *Config, msg Msg) *time.Time {
+	if msg.ExpiresAt() != nil {
+		return msg.ExpiresAt()
+	}
+
+	var seconds int
+	switch msg.Priority() {
+	case MsgPriorityHigh:
+		seconds = config.MsgExpiryHigh
+	case MsgPriorityNormal:
+		seconds = config.MsgExpiryNormal
+	default:
+		seconds = config.MsgExpiryBulk
+	}
+	if seconds <= 0 || msg.CreatedOn().IsZero() {
+		return nil
+	}
+
+	expiresAt := msg.CreatedOn().Add(time.Duration(seconds) * time.Second)
+	return &expiresAt
+}
+
 //-----------------------------------------------------------------------------
 // Msg interface
 //-----------------------------------------------------------------------------
@@ -92,19 +193,60 @@ type Msg interface {
 	URN() urns.URN
 	URNAuth() string
 	ContactName() string
+
+	// QuickReplies returns the replies the contact can choose from, handlers for channels which support them should
+	// render them as native buttons, others can use handlers.GetTextAttachmentsAndQuickReplies to list them in the text
 	QuickReplies() []string
+
 	Topic() string
+
+	// Templating returns the template this outgoing msg should be sent as on channels which support them, nil if none,
+	// or an error if its metadata can't be parsed
+	Templating() (*MsgTemplating, error)
+
 	Metadata() json.RawMessage
 	ResponseToID() MsgID
 	ResponseToExternalID() string
 
 	Channel() Channel
 
+	// ReceivedOn returns when this incoming msg was received, in UTC. That is the time the provider says it was sent
+	// to us if it does, otherwise when we received it.
 	ReceivedOn() *time.Time
 	SentOn() *time.Time
 
 	HighPriority() bool
 
+	// Priority returns the priority this msg is sent with, msgs flagged as high priority being at least normal priority
+	Priority() MsgPriority
+
+	// StatusCallback returns the URL the statuses of this outgoing msg should be forwarded to, if any
+	StatusCallback() string
+
+	// Location returns the location shared in this incoming msg, nil if it isn't one or the channel doesn't support
+	// receiving them as such
+	Location() *LatLon
+
+	// ContactCards returns the contacts shared in this incoming msg, if any
+	ContactCards() []ContactCard
+
+	// SendAt returns when this outgoing msg is scheduled to be sent, nil if it should be sent as soon as possible
+	SendAt() *time.Time
+
+	// ExpiresAt returns when this outgoing msg is no longer worth sending, e.g. an OTP code, nil if it doesn't expire
+	ExpiresAt() *time.Time
+
+	// CreatedOn returns when this msg was created
+	CreatedOn() time.Time
+
+	// AlreadyWritten returns whether this msg was found to be a duplicate of one already written, in which case
+	// writing it is a no-op and it has the UUID of the original
+	AlreadyWritten() bool
+
+	// IdempotencyKey returns the key which identifies this msg across retries by the provider, defaulting to its
+	// external ID. Writing a msg with a key already written within our DedupWindow is a no-op.
+	IdempotencyKey() string
+
 	WithContactName(name string) Msg
 	WithReceivedOn(date time.Time) Msg
 	WithExternalID(id string) Msg
@@ -113,6 +255,35 @@ type Msg interface {
 	WithAttachment(url string) Msg
 	WithURNAuth(auth string) Msg
 	WithMetadata(metadata json.RawMessage) Msg
+	WithIdempotencyKey(key string) Msg
+	WithPriority(priority MsgPriority) Msg
+	WithStatusCallback(url string) Msg
+	WithSendAt(date time.Time) Msg
+	WithExpiresAt(date time.Time) Msg
+	WithLocation(location LatLon) Msg
+	WithContactCard(card ContactCard) Msg
 
 	EventID() int64
 }
+
+// MsgDedupeKey returns the key incoming msgs are deduplicated by, which is the channel and idempotency key of the msg
+// if it has one (this is its external ID unless the handler set another). Otherwise it is a hash of the msg's content
+// and the time it was received, as providers which don't send IDs usually send that, or empty if the msg has no
+// received on so can't be told apart from a legitimate repeat.
+func MsgDedupeKey(msg Msg) string {
+	key := msg.IdempotencyKey()
+	if key != "" && key == msg.ExternalID() {
+		return fmt.Sprintf("%s|ext:%s", msg.Channel().UUID(), key)
+	}
+	if key != "" {
+		return fmt.Sprintf("%s|key:%s", msg.Channel().UUID(), key)
+	}
+
+	if msg.ReceivedOn() == nil {
+		return ""
+	}
+
+	content := fmt.Sprintf("%s|%s|%s|%s", msg.URN().Identity(), msg.Text(), strings.Join(msg.Attachments(), "|"), msg.ReceivedOn().UTC().Format(time.RFC3339Nano))
+	hash := sha1.Sum([]byte(content))
+	return fmt.Sprintf("%s|hash:%s", msg.Channel().UUID(), hex.EncodeToString(hash[:]))
+}
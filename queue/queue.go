@@ -16,6 +16,10 @@ type Priority int64
 type WorkerToken string
 
 const (
+	// UrgentPriority is typically used for time sensitive messages such as OTP codes. These are processed before
+	// any messages of other priorities.
+	UrgentPriority = 2
+
 	// HighPriority is typically used for replies to ensure they sent as soon as possible.
 	HighPriority = 1
 
@@ -37,7 +41,7 @@ var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS,
 	-- our queue name is built from the type, name and tps, usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps"
 	local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. KEYS[4]
 
-	-- our priority queue name also includes the priority of the message (we have one queue for default and one for bulk)
+	-- our priority queue name also includes the priority of the message (we have one queue each for urgent, default and bulk)
 	local priorityQueueKey = queueKey .. "/" .. KEYS[5]
 	redis.call("zadd", priorityQueueKey, KEYS[1], KEYS[6])
 
@@ -63,7 +67,13 @@ var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS,
 // specified transactions per second are popped off at a time. A tps value of 0 means there is no
 // limit to the rate that messages can be consumed
 func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return PushOntoQueueWithDelay(conn, qType, queue, tps, value, priority, 0)
+}
+
+// PushOntoQueueWithDelay pushes the passed in value to the passed in queue in the same way as PushOntoQueue,
+// but the value will not be popped until the passed in delay has passed
+func PushOntoQueueWithDelay(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, delay time.Duration) error {
+	epochMS := strconv.FormatFloat(float64(time.Now().Add(delay).UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
 	_, err := redis.Int(luaPush.Do(conn, epochMS, qType, queue, tps, priority, value))
 	return err
 }
@@ -100,30 +110,29 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
   	    end
 	end
 
-	-- pop our next value out, first from our default queue
-	local resultQueue = queue .. "/1"
-	local result = redis.call("zrangebyscore", resultQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
-	
-	-- keep track as to whether this result is in the future (and therefore ineligible)
-	local isFutureResult = result[1] and tonumber(result[2]) > tonumber(KEYS[1])
+	-- pop our next value out, first from our urgent queue, then our default queue and finally our bulk queue
+	local result = {}
+	local resultQueue = ""
+
+	-- keep track as to whether we only found results in the future (and therefore ineligible)
+	local isFutureResult = false
 
-	-- if we didn't find one, try again from our bulk queue
-	if not result[1] or isFutureResult then
-		local bulkQueue = queue .. "/0"
-		local bulkResult = redis.call("zrangebyscore", bulkQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+	for _, priority in ipairs({"2", "1", "0"}) do
+		local priorityQueue = queue .. "/" .. priority
+		local priorityResult = redis.call("zrangebyscore", priorityQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
 
 		-- if we got a result
-		if bulkResult[1] then
-			-- if it is in the future, set ourselves as in the future
-			if tonumber(bulkResult[2]) > tonumber(KEYS[1]) then
+		if priorityResult[1] then
+			-- if it is in the future, set ourselves as in the future and try the next queue
+			if tonumber(priorityResult[2]) > tonumber(KEYS[1]) then
 				isFutureResult = true
-			
+
 			-- otherwise, this is a valid result
-			else 
-				redis.call("echo", "found result")
+			else
 				isFutureResult = false
-				result = bulkResult
-				resultQueue = bulkQueue
+				result = priorityResult
+				resultQueue = priorityQueue
+				break
 			end
 		end
 	end
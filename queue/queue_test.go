@@ -168,6 +168,38 @@ func TestLua(t *testing.T) {
 	assert.Empty(value)
 }
 
+func TestPriorities(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// urgent msgs queued last are still popped first, and each priority is popped in the order it was pushed
+	pushes := []struct {
+		id       int
+		priority Priority
+	}{{1, LowPriority}, {2, HighPriority}, {3, LowPriority}, {4, UrgentPriority}, {5, HighPriority}, {6, UrgentPriority}}
+	for _, p := range pushes {
+		err := PushOntoQueue(conn, "msgs", "chan1", 0, fmt.Sprintf(`[{"id":%d}]`, p.id), p.priority)
+		assert.NoError(err)
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, id := range []int{4, 6, 2, 5, 1, 3} {
+		queue, value, err := PopFromQueue(conn, "msgs")
+		assert.NoError(err)
+		assert.Equal(WorkerToken("msgs:chan1|0"), queue)
+		assert.Equal(fmt.Sprintf(`{"id":%d}`, id), value)
+	}
+
+	// nothing should be left
+	queue := Retry
+	for queue == Retry {
+		queue, _, _ = PopFromQueue(conn, "msgs")
+	}
+	assert.Equal(EmptyQueue, queue)
+}
+
 func nTestThrottle(t *testing.T) {
 	assert := assert.New(t)
 	pool := getPool()
@@ -248,3 +280,38 @@ func BenchmarkQueue(b *testing.B) {
 		assert.NoError(err)
 	}
 }
+
+func TestDelayedPush(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+	quitter := make(chan bool)
+	wg := &sync.WaitGroup{}
+	StartDethrottler(pool, quitter, wg, "msgs")
+
+	err := PushOntoQueueWithDelay(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Second)
+	assert.NoError(err)
+
+	// our value isn't available yet, the caller should retry but then find the queue empty
+	queue, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, queue)
+	assert.Equal("", value)
+
+	queue, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, queue)
+
+	// once our delay has passed and our dethrottler has run, we can pop it
+	time.Sleep(time.Second * 2)
+
+	queue, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), queue)
+	assert.Equal(`{"id":1}`, value)
+
+	close(quitter)
+	wg.Wait()
+}
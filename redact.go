@@ -0,0 +1,171 @@
+package courier
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+)
+
+// redactMask is what redacted values are replaced with
+const redactMask = "****"
+
+// the channel config keys whose values are secrets that should never be logged
+var channelSecretKeys = []string{ConfigAuthToken, ConfigAPIKey, ConfigPassword, ConfigSecret, ConfigSendAuthorization}
+
+// the names of headers which usually carry credentials, whose values are secrets when configured as extra headers
+var secretHeaderNamesRegex = regexp.MustCompile(`(?i)auth|token|key|secret|password|signature|credential`)
+
+// the names of query parameters which usually carry credentials
+var secretParams = map[string]bool{
+	"access_token": true,
+	"api_key":      true,
+	"apikey":       true,
+	"auth_token":   true,
+	"key":          true,
+	"password":     true,
+	"secret":       true,
+	"token":        true,
+}
+
+// the log fields which contain URNs, whose phone numbers we partially mask
+var urnLogFields = []string{"msg_urn", "event_urn", "urn"}
+
+// RedactPhone masks all but the last 4 digits of the passed in phone number
+func RedactPhone(number string) string {
+	digits := 0
+	for _, c := range number {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	masked := []rune(number)
+	for i, c := range masked {
+		if digits <= 4 {
+			break
+		}
+		if c >= '0' && c <= '9' {
+			masked[i] = '*'
+			digits--
+		}
+	}
+	return string(masked)
+}
+
+// RedactURN partially masks the passed in URN if it is a phone number, others are returned as is
+func RedactURN(urn string) string {
+	scheme, path, _, _ := urns.URN(urn).ToParts()
+	if scheme == urns.TelScheme || scheme == urns.WhatsAppScheme {
+		return scheme + ":" + RedactPhone(path)
+	}
+	return urn
+}
+
+// RedactedURL returns the passed in URL with any credentials masked, that is the password of the user info, the values
+// of query parameters like token or api_key, and anywhere the passed in secrets appear
+func RedactedURL(rawURL string, secrets ...string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RedactSecrets(rawURL, secrets...)
+	}
+
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redactMask)
+	}
+
+	query := u.Query()
+	masked := false
+	for param := range query {
+		if secretParams[strings.ToLower(param)] {
+			query.Set(param, redactMask)
+			masked = true
+		}
+	}
+	if masked {
+		u.RawQuery = query.Encode()
+	}
+
+	// url encoding escapes our mask, put it back so it reads the same everywhere
+	redacted := strings.Replace(u.String(), url.QueryEscape(redactMask), redactMask, -1)
+	return RedactSecrets(redacted, secrets...)
+}
+
+// RedactSecrets replaces every occurrence of the passed in secrets in s
+func RedactSecrets(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, redactMask, -1)
+		}
+	}
+	return s
+}
+
+// ChannelSecrets returns the secrets in the config of the passed in channel, e.g. its auth token
+func ChannelSecrets(channel Channel) []string {
+	if channel == nil {
+		return nil
+	}
+
+	secrets := make([]string, 0, len(channelSecretKeys))
+	for _, key := range channelSecretKeys {
+		secret := channel.StringConfigForKey(key, "")
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	for name, value := range ChannelExtraHeaders(channel) {
+		if value != "" && secretHeaderNamesRegex.MatchString(name) {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+// RedactHook is a logrus hook which masks sensitive values in log fields before they are logged
+type RedactHook struct {
+	fields map[string]bool
+}
+
+// NewRedactHook creates a new hook which fully masks the log fields named in the RedactLogFields of the passed in
+// config, partially masks phone numbers and masks credentials in URLs
+func NewRedactHook(config *Config) *RedactHook {
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(config.RedactLogFields, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields[field] = true
+		}
+	}
+	return &RedactHook{fields: fields}
+}
+
+// Levels returns the levels we redact, which is all of them
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the fields of the passed in entry
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	for field := range h.fields {
+		if _, found := entry.Data[field]; found {
+			entry.Data[field] = redactMask
+		}
+	}
+
+	for _, field := range urnLogFields {
+		if urn, isString := entry.Data[field].(string); isString && !h.fields[field] {
+			entry.Data[field] = RedactURN(urn)
+		}
+		if urn, isURN := entry.Data[field].(urns.URN); isURN && !h.fields[field] {
+			entry.Data[field] = RedactURN(string(urn))
+		}
+	}
+
+	if rawURL, isString := entry.Data["url"].(string); isString && !h.fields["url"] {
+		entry.Data["url"] = RedactedURL(rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutgoingMsgQuery describes the outgoing msgs to look up with Backend.GetOutgoingMsgs, either those with the passed
+// in IDs or those on the passed in channel created within [After, Before), in both cases only those currently with
+// one of the passed in statuses
+type OutgoingMsgQuery struct {
+	IDs         []MsgID
+	ChannelUUID ChannelUUID
+	After       time.Time
+	Before      time.Time
+	Statuses    []MsgStatusValue
+}
+
+// the statuses of the msgs we replay if the request doesn't say, i.e. those which never made it to the provider
+var defaultReplayStatuses = []MsgStatusValue{MsgErrored, MsgFailed}
+
+// replayRequest is the payload posted to /replay, e.g.
+//
+//	{"ids": [12345, 12346]}
+//	{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "after": "2020-07-01T09:00:00Z", "before": "2020-07-01T10:00:00Z"}
+type replayRequest struct {
+	IDs         []MsgID          `json:"ids"`
+	ChannelUUID string           `json:"channel_uuid"`
+	After       time.Time        `json:"after"`
+	Before      time.Time        `json:"before"`
+	Statuses    []MsgStatusValue `json:"statuses"`
+}
+
+// replayResponse is our response to /replay, how many of the msgs asked for were requeued and how many were skipped
+// because they don't have one of the statuses replayed, e.g. they were already sent, Skipped is only known when
+// replaying msgs by ID
+type replayResponse struct {
+	Requeued int `json:"requeued"`
+	Skipped  int `json:"skipped"`
+}
+
+// handleReplay requeues the outgoing msgs described by the request which errored, so that they are sent again
+func (s *server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	payload := &replayRequest{}
+	err := json.NewDecoder(r.Body).Decode(payload)
+	if err != nil {
+		WriteError(r.Context(), w, r, fmt.Errorf("unable to parse request JSON: %s", err))
+		return
+	}
+
+	query := &OutgoingMsgQuery{IDs: payload.IDs, After: payload.After, Before: payload.Before, Statuses: payload.Statuses}
+	if len(query.Statuses) == 0 {
+		query.Statuses = defaultReplayStatuses
+	}
+
+	if len(query.IDs) == 0 {
+		query.ChannelUUID, err = NewChannelUUID(payload.ChannelUUID)
+		if err != nil || query.After.IsZero() || query.Before.IsZero() {
+			WriteError(r.Context(), w, r, fmt.Errorf("one of ids or channel_uuid, after and before is required"))
+			return
+		}
+	}
+
+	msgs, err := s.backend.GetOutgoingMsgs(r.Context(), query)
+	if err != nil {
+		logrus.WithError(err).Error("error looking up msgs to replay")
+		WriteDataResponse(r.Context(), w, http.StatusInternalServerError, "Internal Server Error", []interface{}{NewErrorData(err.Error())})
+		return
+	}
+
+	response := &replayResponse{}
+	for _, msg := range msgs {
+		err = s.backend.QueueOutgoingMsg(r.Context(), msg)
+		if err != nil {
+			logrus.WithError(err).WithField("msg_id", msg.ID().String()).Error("error requeuing msg to replay")
+			continue
+		}
+		response.Requeued++
+	}
+	if len(query.IDs) > 0 {
+		response.Skipped = len(query.IDs) - len(msgs)
+	}
+
+	logrus.WithField("requeued", response.Requeued).WithField("skipped", response.Skipped).Info("replayed msgs")
+
+	err = writeJSONResponse(r.Context(), w, http.StatusOK, response)
+	if err != nil {
+		logrus.WithError(err).Error()
+	}
+}
@@ -0,0 +1,99 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// outageHandler is a handler which errors sending msgs while its provider is down
+type outageHandler struct {
+	dummyHandler
+	down bool
+}
+
+func (h *outageHandler) ChannelType() ChannelType { return ChannelType("OU") }
+
+func (h *outageHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	if h.down {
+		return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored), nil
+	}
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), nil
+}
+
+func TestReplay(t *testing.T) {
+	mb := NewMockBackend()
+	config := testConfig()
+	config.EnableReplay = true
+	s := NewServer(config, mb).(*server)
+	foreman := NewForeman(s, 1)
+
+	handler := &outageHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OU", "2020", "US", nil)
+	msg1 := &mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383"}
+	msg2 := &mockMsg{channel: channel, id: NewMsgID(102), text: "hello", urn: "tel:+250788383383"}
+
+	replay := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		s.handleReplay(w, httptest.NewRequest("POST", "/replay", strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	// one msg is sent, the other errors because our provider is down
+	start := time.Now()
+	foreman.senders[0].sendMessage(msg2)
+	handler.down = true
+	foreman.senders[0].sendMessage(msg1)
+	handler.down = false
+
+	// replaying both only requeues the one which errored
+	code, body := replay(`{"ids": [101, 102]}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "{\"requeued\":1,\"skipped\":1}\n", body)
+	assert.Equal(t, []Msg{msg1}, mb.outgoingMsgs)
+
+	// as does replaying everything on the channel since our outage started
+	mb.outgoingMsgs = nil
+	code, body = replay(fmt.Sprintf(`{"channel_uuid": "53e5aafa-8155-449d-9009-fcb30d54bd26", "after": "%s", "before": "%s"}`, start.Format(time.RFC3339Nano), time.Now().Format(time.RFC3339Nano)))
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "{\"requeued\":1,\"skipped\":0}\n", body)
+	assert.Equal(t, []Msg{msg1}, mb.outgoingMsgs)
+
+	// once it is sent, replaying it again is skipped
+	msg, _ := mb.PopNextOutgoingMsg(context.Background())
+	foreman.senders[0].sendMessage(msg)
+
+	code, body = replay(`{"ids": [101]}`)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "{\"requeued\":0,\"skipped\":1}\n", body)
+	assert.Equal(t, 0, len(mb.outgoingMsgs))
+
+	// we need to know which msgs to replay
+	code, body = replay(`{"channel_uuid": "53e5aafa-8155-449d-9009-fcb30d54bd26"}`)
+	assert.Equal(t, 400, code)
+	assert.Contains(t, body, "one of ids or channel_uuid, after and before is required")
+
+	code, body = replay(`not json`)
+	assert.Equal(t, 400, code)
+	assert.Contains(t, body, "unable to parse request JSON")
+
+	// and replaying is protected by our status credentials
+	config.StatusUsername = "admin"
+	config.StatusPassword = "sesame"
+	code, _ = replay(`{"ids": [101]}`)
+	assert.Equal(t, 401, code)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/replay", strings.NewReader(`{"ids": [101]}`))
+	r.SetBasicAuth("admin", "sesame")
+	s.handleReplay(w, r)
+	assert.Equal(t, 200, w.Code)
+}
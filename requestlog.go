@@ -0,0 +1,94 @@
+package courier
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// requestLogger is our middleware which logs the requests we handle. Successful requests are sampled, only one in
+// every sampling being logged, but requests which aren't successful are always logged. Requests to our excluded paths,
+// such as health checks, are never logged.
+type requestLogger struct {
+	// the number of successful requests we've seen, used to sample them, first so it is 64 bit aligned for atomic access
+	successes uint64
+
+	logger        *logrus.Logger
+	sampling      int
+	excludedPaths map[string]bool
+}
+
+// checkRequestLogFormat checks that the passed in request log format is one we support
+func checkRequestLogFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid request log format '%s', must be text or json", format)
+	}
+	return nil
+}
+
+// newRequestLogger creates a new request logger from our config, which logs to the passed in logger in the configured
+// format (an invalid format is an error when we start, until then we use text)
+func newRequestLogger(config *Config, logger *logrus.Logger) *requestLogger {
+	if config.RequestLogFormat == "json" {
+		logger.Formatter = &logrus.JSONFormatter{}
+	}
+
+	excludedPaths := make(map[string]bool)
+	for _, path := range strings.Split(config.RequestLogExcludedPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			excludedPaths[path] = true
+		}
+	}
+
+	return &requestLogger{logger: logger, sampling: config.RequestLogSampling, excludedPaths: excludedPaths}
+}
+
+// Handler returns the middleware which logs requests to the passed in handler
+func (l *requestLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.excludedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if !l.shouldLog(status) {
+			return
+		}
+
+		// we only log the path as query strings often contain phone numbers or credentials
+		l.logger.WithFields(logrus.Fields{
+			"request_id":  middleware.GetReqID(r.Context()),
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      status,
+			"bytes":       ww.BytesWritten(),
+			"remote_addr": r.RemoteAddr,
+			"elapsed_ms":  float64(time.Since(start)) / float64(time.Millisecond),
+		}).Info("request handled")
+	})
+}
+
+// whether a request with the passed in response status should be logged
+func (l *requestLogger) shouldLog(status int) bool {
+	if status < 200 || status >= 300 {
+		return true
+	}
+	if l.sampling <= 0 {
+		return false
+	}
+	return (atomic.AddUint64(&l.successes, 1)-1)%uint64(l.sampling) == 0
+}
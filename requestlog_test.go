@@ -0,0 +1,71 @@
+package courier
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogging(t *testing.T) {
+	newTestServer := func(config *Config) (*server, *bytes.Buffer) {
+		logs := &bytes.Buffer{}
+		logger := logrus.New()
+		logger.Out = logs
+
+		s := NewServerWithLogger(config, NewMockBackend(), logger).(*server)
+		s.router.Get("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+		s.router.Get("/boom", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) })
+		return s, logs
+	}
+	request := func(s *server, path string) {
+		s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	countLines := func(logs *bytes.Buffer) int { return strings.Count(logs.String(), "\n") }
+
+	// by default every request is logged, other than health checks and metrics
+	s, logs := newTestServer(testConfig())
+	request(s, "/ok")
+	request(s, "/health")
+	request(s, "/metrics")
+	assert.Equal(t, 1, countLines(logs))
+	assert.Contains(t, logs.String(), "path=/ok")
+	assert.Contains(t, logs.String(), "status=200")
+
+	// sampled, only 1 in every 5 successful requests are logged but errors always are
+	config := testConfig()
+	config.RequestLogSampling = 5
+	s, logs = newTestServer(config)
+	for i := 0; i < 10; i++ {
+		request(s, "/ok")
+	}
+	assert.Equal(t, 2, countLines(logs))
+
+	for i := 0; i < 3; i++ {
+		request(s, "/boom")
+	}
+	assert.Equal(t, 5, countLines(logs))
+	assert.Equal(t, 3, strings.Count(logs.String(), "status=500"))
+
+	// and they can be logged as JSON
+	config = testConfig()
+	config.RequestLogFormat = "json"
+	s, logs = newTestServer(config)
+	request(s, "/boom?urn=tel:+250788383383")
+
+	entry := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/boom", entry["path"])
+	assert.Equal(t, float64(500), entry["status"])
+
+	// other formats are an error when we start
+	config = testConfig()
+	config.RequestLogFormat = "xml"
+	assert.EqualError(t, NewServer(config, NewMockBackend()).Start(), "invalid request log format 'xml', must be text or json")
+}
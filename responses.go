@@ -4,24 +4,55 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/nyaruka/gocommon/urns"
 	validator "gopkg.in/go-playground/validator.v9"
 )
 
 const statusMsgNotFoundDetail = "message not found, ignored"
 
-// writeAndLogRequestError writes a JSON response for the passed in message and logs an info messages
-func writeAndLogRequestError(ctx context.Context, w http.ResponseWriter, r *http.Request, c Channel, err error) error {
+// CourierError is an error which carries the HTTP status code that should be returned for it
+type CourierError struct {
+	StatusCode int
+	Message    string
+}
+
+// NewCourierError creates a new error which will be written with the passed in HTTP status code
+func NewCourierError(statusCode int, message string) *CourierError {
+	return &CourierError{StatusCode: statusCode, Message: message}
+}
+
+func (e *CourierError) Error() string { return e.Message }
+
+// WriteAndLogError logs the passed in error and writes an error response for it
+func WriteAndLogError(ctx context.Context, w http.ResponseWriter, r *http.Request, c Channel, err error) error {
 	LogRequestError(r, c, err)
 	return WriteError(ctx, w, r, err)
 }
 
-// WriteError writes a JSON response for the passed in error
+// WriteError writes a JSON response for the passed in error. The status code is taken from the error if it is a
+// CourierError, otherwise it is a 400. Clients which prefer JSON in their Accept header get a simple error object
+// instead of our usual data response.
 func WriteError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) error {
+	statusCode := http.StatusBadRequest
+	if cErr, isCourier := err.(*CourierError); isCourier {
+		statusCode = cErr.StatusCode
+	}
+
+	if prefersJSON(r) {
+		return writeJSONResponse(ctx, w, statusCode, &errorResponse{
+			Error:     err.Error(),
+			Code:      statusCode,
+			RequestID: middleware.GetReqID(ctx),
+		})
+	}
+
 	errors := []interface{}{NewErrorData(err.Error())}
 
 	vErrs, isValidation := err.(validator.ValidationErrors)
@@ -30,7 +61,37 @@ func WriteError(ctx context.Context, w http.ResponseWriter, r *http.Request, err
 			errors = append(errors, NewErrorData(fmt.Sprintf("field '%s' %s", strings.ToLower(vErrs[i].Field()), vErrs[i].Tag())))
 		}
 	}
-	return WriteDataResponse(ctx, w, http.StatusBadRequest, "Error", errors)
+	return WriteDataResponse(ctx, w, statusCode, "Error", errors)
+}
+
+// prefersJSON returns whether the Accept header of the passed in request ranks application/json above any other
+// media type, wildcards like */* don't count as a preference
+func prefersJSON(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+
+	bestType, bestQ := "", 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qParam, found := params["q"]; found {
+			q, err = strconv.ParseFloat(qParam, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		// more specific types beat wildcards of the same quality
+		if q > bestQ || (q == bestQ && strings.Contains(bestType, "*") && !strings.Contains(mediaType, "*")) {
+			bestType, bestQ = mediaType, q
+		}
+	}
+	return bestType == "application/json"
 }
 
 // WriteIgnored writes a JSON response indicating that we ignored the request
@@ -74,6 +135,54 @@ func WriteDataResponse(ctx context.Context, w http.ResponseWriter, statusCode in
 	return writeJSONResponse(ctx, w, statusCode, &dataResponse{message, data})
 }
 
+// ChannelResponse is the HTTP response to an incoming request, for channels which expect the body of that response
+// to be our reply rather than us sending it separately
+type ChannelResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// NewChannelResponse creates a new 200 response with the passed in content type and body
+func NewChannelResponse(contentType string, body []byte) *ChannelResponse {
+	return &ChannelResponse{StatusCode: http.StatusOK, ContentType: contentType, Body: body}
+}
+
+// ChannelRespondFunc is like a ChannelHandleFunc but returns the response to write instead of writing it
+type ChannelRespondFunc func(context.Context, Channel, *http.Request) ([]Event, *ChannelResponse, error)
+
+// RespondWith adapts the passed in ChannelRespondFunc so it can be added as a route. Errors are written as usual and
+// a nil response is written as an empty 200.
+func RespondWith(respondFunc ChannelRespondFunc) ChannelHandleFunc {
+	return func(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		events, resp, err := respondFunc(ctx, channel, r)
+		if err != nil {
+			return events, err
+		}
+		if resp == nil {
+			resp = &ChannelResponse{}
+		}
+		return events, WriteChannelResponse(w, resp)
+	}
+}
+
+// WriteChannelResponse writes the passed in response, a 200 and plain text unless it says otherwise
+func WriteChannelResponse(w http.ResponseWriter, resp *ChannelResponse) error {
+	statusCode := resp.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	contentType := resp.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	_, err := w.Write(resp.Body)
+	return err
+}
+
 // MsgReceiveData is our response payload for a received message
 type MsgReceiveData struct {
 	Type        string      `json:"type"`
@@ -164,6 +273,12 @@ func NewInfoData(info string) InfoData {
 	return InfoData{"info", info}
 }
 
+type errorResponse struct {
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
 type dataResponse struct {
 	Message string        `json:"message"`
 	Data    []interface{} `json:"data"`
@@ -0,0 +1,100 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "host/abc-000001")
+
+	tcs := []struct {
+		accept     string
+		err        error
+		statusCode int
+		body       string
+	}{
+		{"", errors.New("missing text"), 400, `{"message":"Error","data":[{"type":"error","error":"missing text"}]}`},
+		{"*/*", NewCourierError(403, "invalid from"), 403, `{"message":"Error","data":[{"type":"error","error":"invalid from"}]}`},
+		{"text/html, application/json;q=0.9", errors.New("missing text"), 400, `{"message":"Error","data":[{"type":"error","error":"missing text"}]}`},
+		{"application/json", errors.New("missing text"), 400, `{"error":"missing text","code":400,"request_id":"host/abc-000001"}`},
+		{"text/html;q=0.5, application/json", NewCourierError(422, "invalid from"), 422, `{"error":"invalid from","code":422,"request_id":"host/abc-000001"}`},
+		{"*/*, application/json", NewCourierError(500, "unable to save"), 500, `{"error":"unable to save","code":500,"request_id":"host/abc-000001"}`},
+	}
+
+	for _, tc := range tcs {
+		r, _ := http.NewRequest(http.MethodPost, "/c/dm/receive", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+
+		err := WriteError(ctx, w, r, tc.err)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.statusCode, w.Code, "status mismatch for accept '%s'", tc.accept)
+		assert.JSONEq(t, tc.body, w.Body.String(), "body mismatch for accept '%s'", tc.accept)
+	}
+}
+
+type replyHandler struct {
+	dummyHandler
+	channel Channel
+}
+
+func (h *replyHandler) ChannelType() ChannelType { return ChannelType("RP") }
+
+func (h *replyHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
+	return h.channel, nil
+}
+
+func TestRespondWith(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(NewConfig(), mb).(*server)
+
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RP", "2020", "US", map[string]interface{}{})
+	handler := &replyHandler{channel: channel}
+
+	// a handler which replies to each msg in the body of its response
+	s.AddHandlerRoute(handler, http.MethodPost, "receive", RespondWith(func(ctx context.Context, c Channel, r *http.Request) ([]Event, *ChannelResponse, error) {
+		text := r.URL.Query().Get("text")
+		if text == "" {
+			return nil, nil, errors.New("missing text")
+		}
+		if text == "bye" {
+			return nil, nil, nil
+		}
+		return nil, NewChannelResponse("application/xml", []byte("<reply>you said "+text+"</reply>")), nil
+	}))
+
+	request := func(text string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/c/rp/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?text="+text, nil))
+		return w
+	}
+
+	w := request("hello")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/xml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<reply>you said hello</reply>", w.Body.String())
+
+	// our reply ends up in the channel log too
+	log, err := mb.GetLastChannelLog()
+	assert.NoError(t, err)
+	assert.Contains(t, log.Response, "<reply>you said hello</reply>")
+
+	// no response is an empty 200
+	w = request("bye")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Body.String())
+
+	// and errors are written as usual
+	w = request("")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "missing text")
+}
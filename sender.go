@@ -1,29 +1,70 @@
 package courier
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nyaruka/librato"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Foreman takes care of managing our set of sending workers and assigns msgs for each to send
 type Foreman struct {
+	// the number of our senders currently sending a msg, first so it is 32 bit aligned for atomic access
+	active int32
+
+	// whether we are draining, set from our signal handler so accessed atomically
+	draining int32
+
 	server           Server
 	senders          []*Sender
 	availableSenders chan *Sender
+	limiter          *sendLimiter
+	semaphore        *sendSemaphore
+	tracer           trace.Tracer
 	quit             chan bool
+
+	// the number of times msgs have been requeued because their provider throttled us
+	throttledMutex sync.Mutex
+	throttled      map[MsgID]*throttledMsg
+}
+
+// throttledMsg is how many times a msg has been requeued because its provider throttled us, until when we remember it
+type throttledMsg struct {
+	count     int
+	expiresOn time.Time
 }
 
+// how long after a throttled msg was due to be retried we forget how many times it was throttled
+const throttledExpiry = time.Hour
+
 // NewForeman creates a new Foreman for the passed in server with the number of max senders
 func NewForeman(server Server, maxSenders int) *Foreman {
 	foreman := &Foreman{
 		server:           server,
 		senders:          make([]*Sender, maxSenders),
 		availableSenders: make(chan *Sender, maxSenders),
+		limiter:          newSendLimiter(server.Config().MaxSendRatePerChannel),
+		semaphore:        newSendSemaphore(server.Config().MaxConcurrentSendsPerChannel),
 		quit:             make(chan bool),
+		throttled:        make(map[MsgID]*throttledMsg),
+		tracer:           trace.NewNoopTracerProvider().Tracer(tracerName),
+	}
+
+	// if our server is tracing, our sends are traced too
+	if tracing, isTracing := server.(interface{ spanTracer() trace.Tracer }); isTracing {
+		foreman.tracer = tracing.spanTracer()
 	}
 
 	for i := 0; i < maxSenders; i++ {
@@ -50,11 +91,25 @@ func (f *Foreman) Stop() {
 	logrus.WithField("comp", "foreman").WithField("state", "stopping").Info("foreman stopping")
 }
 
+// ActiveSenders returns the number of our senders which are currently sending a msg
+func (f *Foreman) ActiveSenders() int {
+	return int(atomic.LoadInt32(&f.active))
+}
+
+// Drain stops the foreman assigning any new msgs to its senders, those already being sent are allowed to finish
+func (f *Foreman) Drain() {
+	atomic.StoreInt32(&f.draining, 1)
+	logrus.WithField("comp", "foreman").WithField("state", "draining").Info("foreman draining")
+}
+
+func (f *Foreman) isDraining() bool {
+	return atomic.LoadInt32(&f.draining) == 1
+}
+
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing messages from our
 // backend and assigning them to workers
 func (f *Foreman) Assign() {
-	f.server.WaitGroup().Add(1)
-	defer f.server.WaitGroup().Done()
+	defer f.server.TrackComponent("foreman")()
 	log := logrus.WithField("comp", "foreman")
 
 	log.WithFields(logrus.Fields{
@@ -74,6 +129,21 @@ func (f *Foreman) Assign() {
 
 		// otherwise, grab the next msg and assign it to a sender
 		case sender := <-f.availableSenders:
+			// both can be ready at once, but once stopped we shouldn't keep assigning
+			select {
+			case <-f.quit:
+				f.availableSenders <- sender
+				continue
+			default:
+			}
+
+			// we are draining, don't take any new msgs off the queue
+			if f.isDraining() {
+				f.availableSenders <- sender
+				f.sleep(250 * time.Millisecond)
+				continue
+			}
+
 			// see if we have a message to work on
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 			msg, err := backend.PopNextOutgoingMsg(ctx)
@@ -95,12 +165,20 @@ func (f *Foreman) Assign() {
 					lastSleep = true
 				}
 				f.availableSenders <- sender
-				time.Sleep(250 * time.Millisecond)
+				f.sleep(250 * time.Millisecond)
 			}
 		}
 	}
 }
 
+// sleeps for the passed in duration, or until we are stopped so that we don't hold up stopping
+func (f *Foreman) sleep(duration time.Duration) {
+	select {
+	case <-f.quit:
+	case <-time.After(duration):
+	}
+}
+
 // Sender is our type for a single goroutine that is sending messages
 type Sender struct {
 	id      int
@@ -121,9 +199,9 @@ func NewSender(foreman *Foreman, id int) *Sender {
 
 // Start starts our Sender's goroutine and has it start waiting for tasks from the foreman
 func (w *Sender) Start() {
+	done := w.foreman.server.TrackComponent("sender")
 	go func() {
-		w.foreman.server.WaitGroup().Add(1)
-		defer w.foreman.server.WaitGroup().Done()
+		defer done()
 
 		log := logrus.WithField("comp", "sender").WithField("sender_id", w.id)
 		log.Debug("started")
@@ -141,7 +219,9 @@ func (w *Sender) Start() {
 				return
 			}
 
+			atomic.AddInt32(&w.foreman.active, 1)
 			w.sendMessage(msg)
+			atomic.AddInt32(&w.foreman.active, -1)
 		}
 	}()
 }
@@ -162,6 +242,13 @@ func (w *Sender) sendMessage(msg Msg) {
 	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
 	defer cancel()
 
+	// if we are tracing, each send is a trace of its own
+	sendCTX, span := w.foreman.tracer.Start(sendCTX, "send msg", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("channel_uuid", msg.Channel().UUID().String()),
+		attribute.String("msg_id", msg.ID().String()),
+	))
+	defer span.End()
+
 	log = log.WithField("msg_id", msg.ID().String()).WithField("msg_text", msg.Text()).WithField("msg_urn", msg.URN().Identity())
 	if len(msg.Attachments()) > 0 {
 		log = log.WithField("attachments", msg.Attachments())
@@ -170,6 +257,52 @@ func (w *Sender) sendMessage(msg Msg) {
 		log = log.WithField("quick_replies", msg.QuickReplies())
 	}
 
+	// has this msg expired while it was queued? if so it won't be sent, so there's no point scheduling or throttling it
+	expiresAt := MsgExpiresAt(server.Config(), msg)
+	expired := expiresAt != nil && !expiresAt.After(time.Now())
+
+	// is this msg scheduled to be sent later? if so park it in our backend until it is due rather than hold a sender
+	if !expired && msg.SendAt() != nil && msg.SendAt().After(time.Now()) {
+		delay := time.Until(*msg.SendAt())
+		err := backend.RequeueOutgoingMsg(sendCTX, msg, delay)
+		if err == nil {
+			log.WithField("send_at", msg.SendAt()).Debug("msg scheduled for later, requeued msg")
+			return
+		}
+
+		// if we can't requeue it, better to send it now than never
+		log.WithError(err).Error("error requeuing scheduled msg, sending anyways")
+	}
+
+	if !expired {
+		// does this channel already have as many sends in flight as it is allowed? if so put this msg back to be sent later
+		if w.foreman.semaphore.acquire(msg.Channel().UUID()) {
+			defer w.foreman.semaphore.release(msg.Channel().UUID())
+		} else {
+			err := backend.RequeueOutgoingMsg(sendCTX, msg, sendConcurrencyRequeueDelay)
+			if err == nil {
+				log.WithField("delay", sendConcurrencyRequeueDelay).Debug("channel at max concurrent sends, requeued msg")
+				return
+			}
+
+			// if we can't requeue it, better to send it now than never
+			log.WithError(err).Error("error requeuing msg, sending anyways")
+		}
+
+		// is this channel sending faster than it is allowed to? if so put this msg back to be sent later
+		allowed, wait := w.foreman.limiter.allow(msg.Channel().UUID())
+		if !allowed {
+			err := backend.RequeueOutgoingMsg(sendCTX, msg, wait)
+			if err == nil {
+				log.WithField("delay", wait).Debug("channel over send rate, requeued msg")
+				return
+			}
+
+			// if we can't requeue it, better to send it now than never
+			log.WithError(err).Error("error requeuing msg, sending anyways")
+		}
+	}
+
 	start := time.Now()
 
 	// was this msg already sent? (from a double queue?)
@@ -188,19 +321,73 @@ func (w *Sender) sendMessage(msg Msg) {
 		log.WithError(err).Error("error looking up msg loop")
 	}
 
+	// has this contact stopped receiving msgs on this channel?
+	stopped, err := backend.IsURNStopped(sendCTX, msg.Channel().UUID(), msg.URN())
+
+	// failing on stopped lookup isn't permanent, but log
+	if err != nil {
+		log.WithError(err).Error("error looking up whether urn is stopped")
+	}
+
+	// can this msg be sent on its channel and does it pass the content rules of the channel?
+	invalidErr := ValidateMsg(msg)
+
 	if sent {
 		// if this message was already sent, create a wired status for it
 		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
 		log.Warning("duplicate send, marking as wired")
+	} else if expired {
+		// this msg is no longer worth sending, e.g. an OTP code which has expired, fail it without sending
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
+		status.AddLog(NewChannelLogFromError("Message Expired", msg.Channel(), msg.ID(), 0, fmt.Errorf("message expired at %s before it could be sent, failing message without send", expiresAt.UTC().Format(time.RFC3339))))
+		status.SetErrorCategory(SendErrorExpired)
+		log.WithField("expires_at", expiresAt).Warning("message expired, failing message")
 	} else if loop {
 		// if this contact is in a loop, fail the message immediately without sending
 		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
 		status.AddLog(NewChannelLogFromError("Message Loop", msg.Channel(), msg.ID(), 0, fmt.Errorf("message loop detected, failing message without send")))
 		log.Error("message loop detected, failing message")
+	} else if stopped {
+		// this contact asked not to receive msgs on this channel, fail the message without sending
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
+		status.AddLog(NewChannelLogFromError("Contact Stopped", msg.Channel(), msg.ID(), 0, fmt.Errorf("contact has stopped receiving messages on this channel, failing message without send")))
+		log.Warning("contact stopped, failing message")
+	} else if invalidErr != nil {
+		// the provider would reject this anyways, fail it without sending
+		description := "Message Invalid"
+		if _, isContentErr := invalidErr.(*ErrContentInvalid); isContentErr {
+			description = "Message Content Invalid"
+		}
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
+		status.AddLog(NewChannelLogFromError(description, msg.Channel(), msg.ID(), 0, invalidErr))
+		log.WithError(invalidErr).Warning("message invalid, failing message")
+	} else if server.Config().SimulateSends || msg.Channel().BoolConfigForKey(ConfigSimulateSends, false) {
+		// we are simulating sends, log what we would have sent and mark it as delivered without calling the provider
+		status = simulateSend(backend, msg)
+		log.Info("simulated send, marking as delivered")
 	} else {
-		// send our message
-		status, err = server.SendMsg(sendCTX, msg)
+		// send our message, retrying on transient failures
+		var retryAfter time.Duration
+		providerCTX, providerSpan := StartSpan(sendCTX, "send to provider")
+		status, retryAfter, err = w.sendWithRetries(providerCTX, msg)
+		setSpanError(providerSpan, err)
+		providerSpan.End()
 		duration := time.Now().Sub(start)
+
+		// our provider asked us to wait before trying again, put this msg back to be sent after that
+		if retryAfter > 0 {
+			requeueErr := backend.RequeueOutgoingMsg(sendCTX, msg, retryAfter)
+			if requeueErr == nil {
+				log.WithField("delay", retryAfter).Info("throttled by provider, requeued msg")
+
+				err = backend.WriteChannelLogs(sendCTX, status.Logs())
+				if err != nil {
+					log.WithError(err).Info("error writing msg logs")
+				}
+				return
+			}
+			log.WithError(requeueErr).Error("error requeuing throttled msg")
+		}
 		secondDuration := float64(duration) / float64(time.Second)
 
 		if err != nil {
@@ -208,12 +395,18 @@ func (w *Sender) sendMessage(msg Msg) {
 			if status == nil {
 				status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
 				status.AddLog(NewChannelLogFromError("Sending Error", msg.Channel(), msg.ID(), duration, err))
+				status.SetErrorCategory(SendErrorUnknown)
 			}
 		}
 
 		// report to librato and log locally
 		if status.Status() == MsgErrored || status.Status() == MsgFailed {
-			log.WithField("elapsed", duration).Warning("msg errored")
+			// record why our provider failed it, unless our handler already knows better
+			if status.ErrorCategory() == NilSendErrorCategory {
+				status.SetErrorCategory(classifySendStatus(status))
+			}
+
+			log.WithField("elapsed", duration).WithField("error_category", status.ErrorCategory()).Warning("msg errored")
 			librato.Gauge(fmt.Sprintf("courier.msg_send_error_%s", msg.Channel().ChannelType()), secondDuration)
 		} else {
 			log.WithField("elapsed", duration).Info("msg sent")
@@ -222,9 +415,18 @@ func (w *Sender) sendMessage(msg Msg) {
 	}
 
 	// we allot 10 seconds to write our status to the db
-	writeCTX, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	writeCTX, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), span), time.Second*10)
 	defer cancel()
 
+	// our own statuses say what we just did, so are written even if they don't progress the msg
+	writeCTX = context.WithValue(writeCTX, contextSenderStatus, true)
+
+	// if this msg wants to know about its statuses, remember where to send them before we write any
+	err = registerStatusCallback(backend, msg, status)
+	if err != nil {
+		log.WithError(err).Error("error registering status callback")
+	}
+
 	err = backend.WriteMsgStatus(writeCTX, status)
 	if err != nil {
 		log.WithError(err).Info("error writing msg status")
@@ -236,6 +438,191 @@ func (w *Sender) sendMessage(msg Msg) {
 		log.WithError(err).Info("error writing msg logs")
 	}
 
-	// mark our send task as complete
+	// mark our send task as complete, it won't be coming back to us throttled
 	backend.MarkOutgoingMsgComplete(writeCTX, msg, status)
+	w.foreman.clearThrottled(msg.ID())
+}
+
+// sendWithRetries sends the passed in message, retrying with an exponential backoff (plus some jitter) if the send
+// fails in a way which looks transient, such as a connection error or a 5xx from the provider. If the provider throttles
+// us and tells us how long to wait with a Retry-After header, that is returned so the msg can be requeued for then.
+func (w *Sender) sendWithRetries(ctx context.Context, msg Msg) (MsgStatus, time.Duration, error) {
+	config := w.foreman.server.Config()
+	var prevLogs []*ChannelLog
+
+	for attempt := 1; ; attempt++ {
+		status, err := w.foreman.server.SendMsg(ctx, msg)
+		transient := err == nil && isTransientFailure(status)
+
+		// the logs of just this attempt, before we add those of our previous ones
+		var logs []*ChannelLog
+		if status != nil {
+			logs = append(logs, status.Logs()...)
+			prependLogs(status, prevLogs)
+		}
+
+		if !transient {
+			return status, 0, err
+		}
+
+		// times this msg has been requeued because of throttling count towards our retries
+		throttled := w.foreman.throttledCount(msg.ID())
+
+		if attempt+throttled > config.MaxSendRetries {
+			if config.MaxSendRetries > 0 {
+				status.AddLog(NewChannelLogFromError("Send Retries Exhausted", msg.Channel(), msg.ID(), 0, fmt.Errorf("giving up after %d attempts", attempt+throttled)))
+			}
+			return status, 0, err
+		}
+
+		// if our provider told us how long to wait this time, do exactly that
+		retryAfter := parseRetryAfter(logs[len(logs)-1], time.Now())
+		if retryAfter > 0 {
+			w.foreman.incrementThrottled(msg.ID(), retryAfter)
+			return status, retryAfter, err
+		}
+
+		// otherwise wait before our next attempt, doubling each time
+		backoff := retryBackoff(config.SendRetryBackoff, attempt)
+		backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+
+		logrus.WithField("comp", "sender").WithField("msg_id", msg.ID().String()).WithField("attempt", attempt).WithField("backoff", backoff).Info("transient send failure, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return status, 0, err
+		}
+
+		prevLogs = status.Logs()
+	}
+}
+
+// prependLogs puts the passed in logs of our earlier attempts ahead of the logs already on the passed in status, so that
+// they stay in the order the requests were made. Logs returns a status's own logs, so they can be reordered in place.
+func prependLogs(status MsgStatus, earlier []*ChannelLog) {
+	if len(earlier) == 0 {
+		return
+	}
+
+	current := append([]*ChannelLog(nil), status.Logs()...)
+	for _, log := range earlier {
+		status.AddLog(log)
+	}
+
+	all := status.Logs()
+	copy(all, earlier)
+	copy(all[len(earlier):], current)
+}
+
+// parseRetryAfter returns how long the provider asked us to wait in the Retry-After header of the 429 response in the
+// passed in log, which can be either a number of seconds or an HTTP date. Zero is returned if there isn't one.
+func parseRetryAfter(log *ChannelLog, now time.Time) time.Duration {
+	if log.StatusCode != http.StatusTooManyRequests || log.Response == "" {
+		return 0
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(log.Response)), nil)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+
+	retryAfter := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if retryAfter == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(retryAfter)
+	if err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(retryAfter)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+func (f *Foreman) throttledCount(id MsgID) int {
+	f.throttledMutex.Lock()
+	defer f.throttledMutex.Unlock()
+
+	throttled, found := f.throttled[id]
+	if !found || time.Now().After(throttled.expiresOn) {
+		return 0
+	}
+	return throttled.count
+}
+
+// incrementThrottled records that the passed in msg has been requeued for retryAfter because its provider throttled
+// us. It will usually come back to us and be cleared once sent, but if it is picked up by another instance it won't, so
+// our count expires a while after it was due to be retried and any counts which have expired are pruned.
+func (f *Foreman) incrementThrottled(id MsgID, retryAfter time.Duration) {
+	f.throttledMutex.Lock()
+	defer f.throttledMutex.Unlock()
+
+	now := time.Now()
+	for msgID, throttled := range f.throttled {
+		if now.After(throttled.expiresOn) {
+			delete(f.throttled, msgID)
+		}
+	}
+
+	throttled, found := f.throttled[id]
+	if !found {
+		throttled = &throttledMsg{}
+		f.throttled[id] = throttled
+	}
+	throttled.count++
+	throttled.expiresOn = now.Add(retryAfter + throttledExpiry)
+}
+
+func (f *Foreman) clearThrottled(id MsgID) {
+	f.throttledMutex.Lock()
+	defer f.throttledMutex.Unlock()
+	delete(f.throttled, id)
+}
+
+// isTransientFailure returns whether the passed in status is an error worth retrying. We only retry when the last request
+// failed because our provider is down or rate limiting us, and no earlier request succeeded (so we never resend parts of a msg)
+func isTransientFailure(status MsgStatus) bool {
+	if status == nil || status.Status() != MsgErrored || len(status.Logs()) == 0 {
+		return false
+	}
+
+	logs := status.Logs()
+	for _, log := range logs[:len(logs)-1] {
+		if log.StatusCode/100 == 2 {
+			return false
+		}
+	}
+
+	return ClassifySendLog(logs[len(logs)-1]).Retryable()
+}
+
+// simulatedSend is what is logged for a msg when we simulate sending it
+type simulatedSend struct {
+	URN          string   `json:"urn"`
+	Text         string   `json:"text"`
+	Attachments  []string `json:"attachments,omitempty"`
+	QuickReplies []string `json:"quick_replies,omitempty"`
+}
+
+// simulateSend returns a delivered status for the passed in msg, with a log of what would have been sent
+func simulateSend(backend Backend, msg Msg) MsgStatus {
+	payload, _ := json.MarshalIndent(&simulatedSend{
+		URN:          msg.URN().String(),
+		Text:         msg.Text(),
+		Attachments:  msg.Attachments(),
+		QuickReplies: msg.QuickReplies(),
+	}, "", "  ")
+
+	status := backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgDelivered)
+	status.AddLog(NewChannelLog("Simulated Send", msg.Channel(), msg.ID(), "", "", 0, string(payload), "", 0, nil))
+	return status
 }
@@ -0,0 +1,648 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+// retryHandler is a handler which sends messages by making a single request to its channel's send URL
+type retryHandler struct {
+	dummyHandler
+}
+
+func (h *retryHandler) ChannelType() ChannelType { return ChannelType("RT") }
+
+func (h *retryHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	status := h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+
+	req, _ := http.NewRequest(http.MethodPost, msg.Channel().StringConfigForKey(ConfigSendURL, ""), strings.NewReader(msg.Text()))
+	rr, err := utils.MakeHTTPRequest(req)
+
+	log := NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr)
+	status.AddLog(log)
+	if err != nil {
+		log.WithError("Message Send Error", err)
+		return status, nil
+	}
+
+	status.SetStatus(MsgWired)
+	return status, nil
+}
+
+func TestSendRetries(t *testing.T) {
+	failures, failStatus, requests := 0, 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(failStatus)
+			w.Write([]byte("error"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.MaxSendRetries = 2
+	config.SendRetryBackoff = 1
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &retryHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "RT", "2020", "US", map[string]interface{}{ConfigSendURL: server.URL})
+	msg := &mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383"}
+
+	tcs := []struct {
+		label      string
+		failures   int
+		failStatus int
+		requests   int
+		status     MsgStatusValue
+		logs       int
+		lastError  string
+	}{
+		{"success", 0, 0, 1, MsgWired, 1, ""},
+		{"transient then success", 2, 503, 3, MsgWired, 3, ""},
+		{"rate limited then success", 1, 429, 2, MsgWired, 2, ""},
+		{"transient until exhausted", 5, 500, 3, MsgErrored, 4, "giving up after 3 attempts"},
+		{"permanent", 5, 400, 1, MsgErrored, 1, "received non 200 status: 400"},
+	}
+
+	for _, tc := range tcs {
+		failures, failStatus, requests = tc.failures, tc.failStatus, 0
+
+		status, retryAfter, err := foreman.senders[0].sendWithRetries(context.Background(), msg)
+		assert.NoError(t, err, tc.label)
+		assert.Equal(t, time.Duration(0), retryAfter, tc.label)
+		assert.Equal(t, tc.requests, requests, "request count mismatch for %s", tc.label)
+		assert.Equal(t, tc.status, status.Status(), "status mismatch for %s", tc.label)
+		assert.Equal(t, tc.logs, len(status.Logs()), "log count mismatch for %s", tc.label)
+
+		if tc.lastError != "" {
+			logs := status.Logs()
+			assert.Equal(t, tc.lastError, logs[len(logs)-1].Error, "error mismatch for %s", tc.label)
+		}
+	}
+
+	// retries can be disabled entirely
+	config.MaxSendRetries = 0
+	failures, failStatus, requests = 5, 503, 0
+	status, _, _ := foreman.senders[0].sendWithRetries(context.Background(), msg)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, MsgErrored, status.Status())
+	assert.Equal(t, 1, len(status.Logs()))
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	retryAfter, unavailable := "", 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unavailable > 0 {
+			unavailable--
+			w.WriteHeader(503)
+			return
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(429)
+		w.Write([]byte("slow down"))
+	}))
+	defer server.Close()
+
+	config := testConfig()
+	config.MaxSendRetries = 2
+	config.SendRetryBackoff = 1
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 1)
+	sender := foreman.senders[0]
+
+	handler := &retryHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "RT", "2020", "US", map[string]interface{}{ConfigSendURL: server.URL})
+	msg := &mockMsg{channel: channel, id: NewMsgID(102), text: "hello", urn: "tel:+250788383383"}
+
+	// a Retry-After in seconds means we requeue for exactly that long
+	retryAfter = "30"
+	sender.sendMessage(msg)
+	assert.Equal(t, 1, len(mb.requeuedMsgs))
+	assert.Equal(t, 0, len(mb.msgStatuses))
+	assert.Equal(t, 1, foreman.throttledCount(msg.ID()))
+
+	// and an HTTP date for the time until then
+	retryAfter = time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	status, wait, err := sender.sendWithRetries(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MsgErrored, status.Status())
+	assert.True(t, wait > 55*time.Second && wait <= time.Minute, "unexpected wait %s", wait)
+
+	// once we've been throttled as many times as we retry, the msg errors
+	sender.sendMessage(msg)
+	assert.Equal(t, 1, len(mb.requeuedMsgs))
+	if assert.Equal(t, 1, len(mb.msgStatuses)) {
+		assert.Equal(t, MsgErrored, mb.msgStatuses[0].Status())
+		logs := mb.msgStatuses[0].Logs()
+		assert.Equal(t, "giving up after 3 attempts", logs[len(logs)-1].Error)
+	}
+	assert.Equal(t, 0, foreman.throttledCount(msg.ID()))
+
+	// without a Retry-After we just use our backoff
+	retryAfter = ""
+	status, wait, _ = sender.sendWithRetries(context.Background(), msg)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.Equal(t, 4, len(status.Logs()))
+
+	// a Retry-After on a later attempt is honored too, and our logs stay in the order our requests were made
+	foreman.clearThrottled(msg.ID())
+	retryAfter, unavailable = "30", 1
+	status, wait, _ = sender.sendWithRetries(context.Background(), msg)
+	assert.Equal(t, 30*time.Second, wait)
+	if assert.Equal(t, 2, len(status.Logs())) {
+		assert.Equal(t, 503, status.Logs()[0].StatusCode)
+		assert.Equal(t, 429, status.Logs()[1].StatusCode)
+	}
+
+	// throttled counts of msgs which never come back to us are forgotten
+	foreman.incrementThrottled(NewMsgID(103), 0)
+	foreman.throttled[NewMsgID(103)].expiresOn = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, foreman.throttledCount(NewMsgID(103)))
+	foreman.incrementThrottled(msg.ID(), time.Minute)
+	assert.Equal(t, 2, foreman.throttledCount(msg.ID()))
+	assert.Equal(t, 1, len(foreman.throttled))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	response := func(code int, header string) *ChannelLog {
+		resp := fmt.Sprintf("HTTP/1.1 %d Too Many Requests\r\nContent-Length: 0\r\n", code)
+		if header != "" {
+			resp += "Retry-After: " + header + "\r\n"
+		}
+		return &ChannelLog{StatusCode: code, Response: resp + "\r\n"}
+	}
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter(response(429, "120"), now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(response(429, "Mon, 01 Jun 2020 10:01:30 GMT"), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(response(429, "Mon, 01 Jun 2020 09:59:00 GMT"), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(response(429, ""), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(response(429, "soon"), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(response(503, "120"), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(&ChannelLog{StatusCode: 429}, now))
+}
+
+// orderHandler is a handler which records the order it is asked to send messages in
+type orderHandler struct {
+	dummyHandler
+
+	mutex sync.Mutex
+	sent  []MsgID
+}
+
+func (h *orderHandler) ChannelType() ChannelType { return ChannelType("OR") }
+
+func (h *orderHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	h.mutex.Lock()
+	h.sent = append(h.sent, msg.ID())
+	h.mutex.Unlock()
+
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), nil
+}
+
+func (h *orderHandler) sentIDs() []MsgID {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]MsgID(nil), h.sent...)
+}
+
+// panicHandler is a handler with a bug which panics whenever it sends a message
+type panicHandler struct {
+	dummyHandler
+}
+
+func (h *panicHandler) ChannelType() ChannelType { return ChannelType("PN") }
+
+func (h *panicHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	var parts []string
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), fmt.Errorf("unreachable: %s", parts[1])
+}
+
+func TestSendPanic(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &panicHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "PN", "2020", "US", nil)
+
+	for _, id := range []int64{101, 102} {
+		msg := &mockMsg{channel: channel, id: NewMsgID(id), text: "hello", urn: "tel:+250788383383"}
+		foreman.senders[0].sendMessage(msg)
+
+		// our sender survives the panic and errors the msg rather than dropping it
+		status, err := mb.GetLastMsgStatus()
+		if assert.NoError(t, err) {
+			assert.Equal(t, msg.ID(), status.ID())
+			assert.Equal(t, MsgErrored, status.Status())
+			if assert.Equal(t, 1, len(status.Logs())) {
+				assert.Equal(t, "Sending Error", status.Logs()[0].Description)
+				assert.Equal(t, "panic sending msg: runtime error: index out of range [1] with length 0", status.Logs()[0].Error)
+			}
+		}
+	}
+	assert.Equal(t, 2, len(mb.msgStatuses))
+}
+
+func TestSendPriority(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+
+	handler := &orderHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OR", "2020", "US", nil)
+	queueMsg := func(id int64, priority MsgPriority) {
+		msg := &mockMsg{channel: channel, id: NewMsgID(id), text: "hello", urn: "tel:+250788383383"}
+		mb.PushOutgoingMsg(msg.WithPriority(priority))
+	}
+
+	// a broadcast is queued, then a reply and an OTP code arrive while it's waiting
+	queueMsg(1, MsgPriorityBulk)
+	queueMsg(2, MsgPriorityBulk)
+	queueMsg(3, MsgPriorityNormal)
+	queueMsg(4, MsgPriorityBulk)
+	queueMsg(5, MsgPriorityHigh)
+	queueMsg(6, MsgPriorityNormal)
+	queueMsg(7, MsgPriorityHigh)
+
+	assert.Equal(t, "high   queue: 2\nnormal queue: 2\nbulk   queue: 3\n", mb.Status())
+
+	foreman := NewForeman(s, 1)
+	foreman.Start()
+	defer foreman.Stop()
+
+	for i := 0; i < 100 && len(handler.sentIDs()) < 7; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// higher priorities go first, and each priority is sent in the order it was queued
+	assert.Equal(t, []MsgID{5, 7, 3, 6, 1, 2, 4}, handler.sentIDs())
+	assert.Equal(t, "", mb.Status())
+}
+
+func TestSendScheduled(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+
+	handler := &orderHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OR", "2020", "US", nil)
+	mb.PushOutgoingMsg((&mockMsg{channel: channel, id: NewMsgID(1), text: "later", urn: "tel:+250788383383"}).WithSendAt(time.Now().Add(500 * time.Millisecond)))
+	mb.PushOutgoingMsg(&mockMsg{channel: channel, id: NewMsgID(2), text: "now", urn: "tel:+250788383383"})
+	mb.PushOutgoingMsg((&mockMsg{channel: channel, id: NewMsgID(3), text: "past", urn: "tel:+250788383383"}).WithSendAt(time.Now().Add(-time.Minute)))
+
+	foreman := NewForeman(s, 1)
+	foreman.Start()
+	defer foreman.Stop()
+
+	// msgs without a schedule or scheduled in the past are sent straight away, our scheduled msg is parked
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []MsgID{2, 3}, handler.sentIDs())
+	assert.Equal(t, "high   queue: 0\nnormal queue: 0\nbulk   queue: 1\n", mb.Status())
+	assert.Equal(t, 1, len(mb.requeuedMsgs))
+
+	// until it is due
+	for i := 0; i < 100 && len(handler.sentIDs()) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []MsgID{2, 3, 1}, handler.sentIDs())
+	assert.Equal(t, 1, len(mb.requeuedMsgs))
+}
+
+func TestSendExpired(t *testing.T) {
+	mb := NewMockBackend()
+	config := testConfig()
+	config.MsgExpiryHigh = 60
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &orderHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OR", "2020", "US", nil)
+	newMsg := func(id int64, priority MsgPriority, createdOn time.Time) Msg {
+		return &mockMsg{channel: channel, id: NewMsgID(id), text: "your code is 1234", urn: "tel:+250788383383", priority: priority, createdOn: createdOn}
+	}
+	twoMinutesAgo := time.Now().Add(-2 * time.Minute)
+
+	tcs := []struct {
+		label   string
+		msg     Msg
+		expired bool
+	}{
+		{"own expiry passed", newMsg(1, MsgPriorityNormal, time.Now()).WithExpiresAt(time.Now().Add(-time.Second)), true},
+		{"own expiry to come", newMsg(2, MsgPriorityHigh, twoMinutesAgo).WithExpiresAt(time.Now().Add(time.Minute)), false},
+		{"no expiry", newMsg(3, MsgPriorityNormal, twoMinutesAgo), false},
+		{"priority expiry passed", newMsg(4, MsgPriorityHigh, twoMinutesAgo), true},
+		{"priority expiry to come", newMsg(5, MsgPriorityHigh, time.Now()), false},
+		{"no priority expiry", newMsg(6, MsgPriorityBulk, twoMinutesAgo), false},
+	}
+
+	for _, tc := range tcs {
+		sentBefore := len(handler.sentIDs())
+		foreman.senders[0].sendMessage(tc.msg)
+
+		status, err := mb.GetLastMsgStatus()
+		if !assert.NoError(t, err, tc.label) {
+			continue
+		}
+		assert.Equal(t, tc.msg.ID(), status.ID(), tc.label)
+
+		if tc.expired {
+			// expired msgs are failed rather than errored so that they aren't retried
+			assert.Equal(t, sentBefore, len(handler.sentIDs()), tc.label)
+			assert.Equal(t, MsgFailed, status.Status(), tc.label)
+			assert.Equal(t, SendErrorExpired, status.ErrorCategory(), tc.label)
+			if assert.Equal(t, 1, len(status.Logs()), tc.label) {
+				assert.Equal(t, "Message Expired", status.Logs()[0].Description, tc.label)
+			}
+		} else {
+			assert.Equal(t, sentBefore+1, len(handler.sentIDs()), tc.label)
+			assert.Equal(t, MsgWired, status.Status(), tc.label)
+		}
+	}
+
+	// expired msgs aren't parked until they are scheduled either
+	foreman.senders[0].sendMessage(newMsg(7, MsgPriorityHigh, twoMinutesAgo).WithSendAt(time.Now().Add(time.Hour)))
+	assert.Equal(t, 0, len(mb.requeuedMsgs))
+	status, err := mb.GetLastMsgStatus()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMsgID(7), status.ID())
+		assert.Equal(t, MsgFailed, status.Status())
+	}
+}
+
+func TestSendInvalidMsg(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &orderHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OR", "2020", "US", map[string]interface{}{ConfigMaxMsgLength: 10, ConfigMaxURLs: 0})
+
+	tcs := []struct {
+		label       string
+		msg         Msg
+		description string
+		err         string
+	}{
+		{"empty", &mockMsg{channel: channel, id: NewMsgID(101), urn: "tel:+250788383383"}, "Message Invalid", "message invalid: has no text or attachments"},
+		{"wrong scheme", &mockMsg{channel: channel, id: NewMsgID(102), text: "hello", urn: "facebook:12345"}, "Message Invalid", "message invalid: URN scheme 'facebook' isn't supported by channel, which supports tel"},
+		{"too long", &mockMsg{channel: channel, id: NewMsgID(103), text: "hello there!", urn: "tel:+250788383383"}, "Message Invalid", "message invalid: text is 12 characters, channel allows at most 10"},
+		{"content", &mockMsg{channel: channel, id: NewMsgID(104), text: "www.x.com", urn: "tel:+250788383383"}, "Message Content Invalid", "message content invalid: contains 1 URLs, channel allows at most 0"},
+	}
+
+	for _, tc := range tcs {
+		foreman.senders[0].sendMessage(tc.msg)
+
+		// invalid msgs are failed without being sent
+		status, err := mb.GetLastMsgStatus()
+		if assert.NoError(t, err, tc.label) {
+			assert.Equal(t, tc.msg.ID(), status.ID(), tc.label)
+			assert.Equal(t, MsgFailed, status.Status(), tc.label)
+			if assert.Equal(t, 1, len(status.Logs()), tc.label) {
+				assert.Equal(t, tc.description, status.Logs()[0].Description, tc.label)
+				assert.Equal(t, tc.err, status.Logs()[0].Error, tc.label)
+			}
+		}
+	}
+	assert.Equal(t, 0, len(handler.sentIDs()))
+}
+
+func TestSimulateSends(t *testing.T) {
+	mb := NewMockBackend()
+	config := testConfig()
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &orderHandler{dummyHandler: dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "OR", "2020", "US", nil)
+	simulated := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "OR", "2021", "US", map[string]interface{}{ConfigSimulateSends: true})
+
+	// when simulating sends, msgs are marked as delivered without our provider being called
+	config.SimulateSends = true
+	foreman.senders[0].sendMessage(&mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383", quickReplies: []string{"Yes", "No"}})
+
+	status, err := mb.GetLastMsgStatus()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMsgID(101), status.ID())
+		assert.Equal(t, MsgDelivered, status.Status())
+		if assert.Equal(t, 1, len(status.Logs())) {
+			assert.Equal(t, "Simulated Send", status.Logs()[0].Description)
+			assert.Contains(t, status.Logs()[0].Request, `"text": "hello"`)
+			assert.Contains(t, status.Logs()[0].Request, `"quick_replies": [`)
+		}
+	}
+	assert.Equal(t, 0, len(handler.sentIDs()))
+
+	// channels can simulate sends on their own
+	config.SimulateSends = false
+	foreman.senders[0].sendMessage(&mockMsg{channel: simulated, id: NewMsgID(102), text: "hello", urn: "tel:+250788383383"})
+
+	status, err = mb.GetLastMsgStatus()
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMsgID(102), status.ID())
+		assert.Equal(t, MsgDelivered, status.Status())
+	}
+	assert.Equal(t, 0, len(handler.sentIDs()))
+
+	// otherwise we send for real
+	foreman.senders[0].sendMessage(&mockMsg{channel: channel, id: NewMsgID(103), text: "hello", urn: "tel:+250788383383"})
+	assert.Equal(t, []MsgID{NewMsgID(103)}, handler.sentIDs())
+}
+
+func TestSendErrorCategory(t *testing.T) {
+	failStatus, failBody, requests := 0, "", 0
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(failStatus)
+		w.Write([]byte(failBody))
+	}))
+	defer provider.Close()
+
+	config := testConfig()
+	config.MaxSendRetries = 1
+	config.SendRetryBackoff = 1
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 1)
+
+	handler := &retryHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "RT", "2020", "US", map[string]interface{}{ConfigSendURL: provider.URL})
+
+	tcs := []struct {
+		label    string
+		status   int
+		body     string
+		requests int
+		category SendErrorCategory
+	}{
+		{"auth", 401, "bad token", 1, SendErrorAuth},
+		{"invalid recipient", 400, `{"error": "invalid phone number"}`, 1, SendErrorInvalidRecipient},
+		{"rate limited in body", 400, `{"error": "rate limit exceeded"}`, 2, SendErrorRateLimited},
+		{"outage", 503, "down for maintenance", 2, SendErrorOutage},
+		{"unknown", 400, "bad request", 1, SendErrorUnknown},
+	}
+
+	for i, tc := range tcs {
+		failStatus, failBody, requests = tc.status, tc.body, 0
+		foreman.senders[0].sendMessage(&mockMsg{channel: channel, id: NewMsgID(int64(101 + i)), text: "hello", urn: "tel:+250788383383"})
+
+		// failed sends are classified, and only retried if they might succeed next time
+		status, err := mb.GetLastMsgStatus()
+		if assert.NoError(t, err, tc.label) {
+			assert.Equal(t, MsgErrored, status.Status(), tc.label)
+			assert.Equal(t, tc.category, status.ErrorCategory(), tc.label)
+		}
+		assert.Equal(t, tc.requests, requests, tc.label)
+	}
+}
+
+// poolHandler is a handler whose sends block until they are released, recording how many are in flight at once
+type poolHandler struct {
+	dummyHandler
+
+	release chan bool
+
+	mutex       sync.Mutex
+	inFlight    int
+	maxInFlight int
+	sent        int
+}
+
+func (h *poolHandler) ChannelType() ChannelType { return ChannelType("PL") }
+
+func (h *poolHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	h.mutex.Lock()
+	h.inFlight++
+	if h.inFlight > h.maxInFlight {
+		h.maxInFlight = h.inFlight
+	}
+	h.mutex.Unlock()
+
+	<-h.release
+
+	h.mutex.Lock()
+	h.inFlight--
+	h.sent++
+	h.mutex.Unlock()
+
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), nil
+}
+
+func (h *poolHandler) counts() (inFlight int, maxInFlight int, sent int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.inFlight, h.maxInFlight, h.sent
+}
+
+func TestSenderPool(t *testing.T) {
+	mb := NewMockBackend()
+	s := NewServer(testConfig(), mb)
+
+	handler := &poolHandler{dummyHandler: dummyHandler{server: s, backend: mb}, release: make(chan bool)}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "PL", "2020", "US", nil)
+	for i := 1; i <= 6; i++ {
+		mb.PushOutgoingMsg(&mockMsg{channel: channel, id: NewMsgID(int64(i)), text: "hello", urn: "tel:+250788383383"})
+	}
+
+	foreman := NewForeman(s, 2)
+	m := newMetrics()
+	m.registerSenderGauges(foreman, mb)
+	foreman.Start()
+
+	gauges := func() string {
+		w := httptest.NewRecorder()
+		promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+
+	// our two senders each take a msg, the rest wait in our queue
+	for i := 0; i < 100 && foreman.ActiveSenders() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, foreman.ActiveSenders())
+	assert.Contains(t, gauges(), "courier_sender_workers 2")
+	assert.Contains(t, gauges(), "courier_sender_workers_active 2")
+	assert.Contains(t, gauges(), "courier_outgoing_queue_depth 4")
+
+	// release our sends one at a time, never more than two are in flight
+	for i := 0; i < 6; i++ {
+		handler.release <- true
+	}
+	for i := 0; i < 100; i++ {
+		if _, _, sent := handler.counts(); sent == 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	inFlight, maxInFlight, sent := handler.counts()
+	assert.Equal(t, 0, inFlight)
+	assert.Equal(t, 2, maxInFlight)
+	assert.Equal(t, 6, sent)
+	assert.Contains(t, gauges(), "courier_outgoing_queue_depth 0")
+
+	// and our senders all exit when stopped
+	foreman.Stop()
+	done := make(chan bool)
+	go func() {
+		s.WaitGroup().Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "senders didn't stop")
+	}
+}
@@ -0,0 +1,96 @@
+package courier
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// SendErrorCategory is why a provider failed to send a msg
+type SendErrorCategory string
+
+// Possible values for SendErrorCategory
+const (
+	SendErrorAuth             SendErrorCategory = "auth"
+	SendErrorInvalidRecipient SendErrorCategory = "invalid_recipient"
+	SendErrorRateLimited      SendErrorCategory = "rate_limited"
+	SendErrorOutage           SendErrorCategory = "outage"
+	SendErrorExpired          SendErrorCategory = "expired"
+	SendErrorUnknown          SendErrorCategory = "unknown"
+	NilSendErrorCategory      SendErrorCategory = ""
+)
+
+// Retryable returns whether sends which failed for this reason are worth retrying, i.e. the provider is down or
+// throttling us rather than rejecting the msg
+func (c SendErrorCategory) Retryable() bool {
+	return c == SendErrorOutage || c == SendErrorRateLimited
+}
+
+// phrases in response bodies which tell us why a provider failed a send, checked in order as providers often put
+// these in the bodies of responses whose status codes aren't that specific
+var sendErrorPhrases = []struct {
+	category SendErrorCategory
+	phrases  []string
+}{
+	{SendErrorRateLimited, []string{"rate limit", "ratelimit", "too many requests", "throttl"}},
+	{SendErrorInvalidRecipient, []string{"invalid number", "invalid phone", "invalid recipient", "invalid destination", "invalid msisdn", "not a valid phone", "unknown subscriber", "recipient not found", "unsubscribed", "blacklist"}},
+	{SendErrorAuth, []string{"invalid credentials", "invalid api key", "invalid token", "invalid auth", "unauthorized", "authentication failed", "access denied"}},
+}
+
+// ClassifySendError returns the category of the error a provider responded to a send with, from the status code of
+// its response and the phrases in its body. A status code of 0 means we weren't able to connect at all.
+func ClassifySendError(statusCode int, body string) SendErrorCategory {
+	switch {
+	case statusCode == 0:
+		return SendErrorOutage
+	case statusCode == http.StatusTooManyRequests:
+		return SendErrorRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return SendErrorAuth
+	}
+
+	body = strings.ToLower(body)
+	for _, c := range sendErrorPhrases {
+		for _, phrase := range c.phrases {
+			if strings.Contains(body, phrase) {
+				return c.category
+			}
+		}
+	}
+
+	if statusCode/100 == 5 {
+		return SendErrorOutage
+	}
+	return SendErrorUnknown
+}
+
+// ClassifySendLog returns the category of the error in the passed in channel log of a send request
+func ClassifySendLog(log *ChannelLog) SendErrorCategory {
+	return ClassifySendError(log.StatusCode, responseBody(log.Response))
+}
+
+// classifySendStatus returns the category of the error the passed in failed send status ended with, which is that of
+// its last request to the provider (logs of our own errors, such as giving up on retries, have no URL)
+func classifySendStatus(status MsgStatus) SendErrorCategory {
+	logs := status.Logs()
+	for i := len(logs) - 1; i >= 0; i-- {
+		if logs[i].URL != "" {
+			return ClassifySendLog(logs[i])
+		}
+	}
+	return SendErrorUnknown
+}
+
+// returns the body of the passed in HTTP response dump, as saved in our channel logs, so that headers such as
+// X-RateLimit-Remaining aren't mistaken for errors. Anything which isn't a response dump is returned as is.
+func responseBody(response string) string {
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(response)), nil)
+	if err != nil {
+		return response
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
@@ -0,0 +1,44 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifySendError(t *testing.T) {
+	tcs := []struct {
+		label    string
+		code     int
+		response string
+		category SendErrorCategory
+	}{
+		{"connection error", 0, "", SendErrorOutage},
+		{"unauthorized", 401, "HTTP/1.1 401 Unauthorized\r\nContent-Length: 0\r\n\r\n", SendErrorAuth},
+		{"forbidden", 403, "HTTP/1.1 403 Forbidden\r\n\r\n", SendErrorAuth},
+		{"auth in body", 400, "HTTP/1.1 400 Bad Request\r\n\r\n{\"error\": \"Invalid API key\"}", SendErrorAuth},
+		{"too many requests", 429, "HTTP/1.1 429 Too Many Requests\r\nRetry-After: 5\r\n\r\n", SendErrorRateLimited},
+		{"rate limited in body", 400, "HTTP/1.1 400 Bad Request\r\n\r\n{\"code\": 20429, \"message\": \"Rate limit exceeded\"}", SendErrorRateLimited},
+		{"throttled in body", 200, "HTTP/1.1 200 OK\r\n\r\n<status>Throttled</status>", SendErrorRateLimited},
+		{"invalid number", 400, "HTTP/1.1 400 Bad Request\r\n\r\n{\"code\": 21211, \"message\": \"The 'To' number +1234 is not a valid phone number.\"}", SendErrorInvalidRecipient},
+		{"invalid recipient on 500", 500, "HTTP/1.1 500 Internal Server Error\r\n\r\nERROR: invalid msisdn", SendErrorInvalidRecipient},
+		{"unsubscribed", 200, "HTTP/1.1 200 OK\r\n\r\n{\"status\": \"error\", \"reason\": \"Unsubscribed recipient\"}", SendErrorInvalidRecipient},
+		{"server error", 500, "HTTP/1.1 500 Internal Server Error\r\n\r\nerror", SendErrorOutage},
+		{"bad gateway", 502, "HTTP/1.1 502 Bad Gateway\r\n\r\n<html>bad gateway</html>", SendErrorOutage},
+		{"rate limit headers aren't errors", 503, "HTTP/1.1 503 Service Unavailable\r\nX-RateLimit-Remaining: 100\r\n\r\nmaintenance", SendErrorOutage},
+		{"bad request", 400, "HTTP/1.1 400 Bad Request\r\n\r\n{\"error\": \"missing field\"}", SendErrorUnknown},
+		{"not a response", 400, "unable to parse", SendErrorUnknown},
+	}
+
+	for _, tc := range tcs {
+		category := ClassifySendLog(&ChannelLog{StatusCode: tc.code, Response: tc.response})
+		assert.Equal(t, tc.category, category, tc.label)
+	}
+
+	// only sends which failed because the provider is down or throttling us are worth retrying
+	assert.True(t, SendErrorOutage.Retryable())
+	assert.True(t, SendErrorRateLimited.Retryable())
+	assert.False(t, SendErrorAuth.Retryable())
+	assert.False(t, SendErrorInvalidRecipient.Retryable())
+	assert.False(t, SendErrorUnknown.Retryable())
+}
@@ -0,0 +1,142 @@
+package courier
+
+import (
+	"sync"
+	"time"
+)
+
+// how long a channel can go without sending before we forget its bucket
+const sendLimiterIdleTTL = 5 * time.Minute
+
+// how long msgs are requeued for when their channel already has as many sends in flight as we allow
+const sendConcurrencyRequeueDelay = time.Second
+
+// sendLimiter is a token bucket rate limiter keyed by channel UUID, used to limit how fast we send on each channel
+type sendLimiter struct {
+	rate    float64
+	idleTTL time.Duration
+	now     func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[ChannelUUID]*sendBucket
+	lastSweep time.Time
+}
+
+type sendBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// newSendLimiter creates a new limiter allowing rate msgs per second on each channel, returning nil if rate is 0
+func newSendLimiter(rate int) *sendLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &sendLimiter{
+		rate:      float64(rate),
+		idleTTL:   sendLimiterIdleTTL,
+		now:       time.Now,
+		buckets:   make(map[ChannelUUID]*sendBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token for the passed in channel if one is available. If not, it returns false and how long the
+// caller should wait before trying again. A nil limiter allows everything.
+func (l *sendLimiter) allow(uuid ChannelUUID) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, found := l.buckets[uuid]
+	if !found {
+		// new buckets start full, so channels can burst up to their rate
+		bucket = &sendBucket{tokens: l.rate, lastSeen: now}
+		l.buckets[uuid] = bucket
+	}
+
+	// refill our bucket for the time that has passed, never holding more than a second's worth
+	bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * l.rate
+	if bucket.tokens > l.rate {
+		bucket.tokens = l.rate
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep removes the buckets of channels which have been idle longer than our TTL, at most once per TTL
+func (l *sendLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+
+	for uuid, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) >= l.idleTTL {
+			delete(l.buckets, uuid)
+		}
+	}
+	l.lastSweep = now
+}
+
+// sendSemaphore limits how many msgs can be sent at once on each channel, keyed by channel UUID
+type sendSemaphore struct {
+	max int
+
+	mutex    sync.Mutex
+	inFlight map[ChannelUUID]int
+}
+
+// newSendSemaphore creates a new semaphore allowing max concurrent sends on each channel, returning nil if max is 0
+func newSendSemaphore(max int) *sendSemaphore {
+	if max <= 0 {
+		return nil
+	}
+	return &sendSemaphore{
+		max:      max,
+		inFlight: make(map[ChannelUUID]int),
+	}
+}
+
+// acquire takes a slot for a send on the passed in channel, returning false if all its slots are taken. Callers
+// which acquire a slot must release it when their send is complete. A nil semaphore allows everything.
+func (s *sendSemaphore) acquire(uuid ChannelUUID) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.inFlight[uuid] >= s.max {
+		return false
+	}
+	s.inFlight[uuid]++
+	return true
+}
+
+// release gives back a slot acquired for the passed in channel, forgetting channels which no longer have any sends in flight
+func (s *sendSemaphore) release(uuid ChannelUUID) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.inFlight[uuid]--
+	if s.inFlight[uuid] <= 0 {
+		delete(s.inFlight, uuid)
+	}
+}
@@ -0,0 +1,165 @@
+package courier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendLimiter(t *testing.T) {
+	// no rate, no limiter
+	assert.Nil(t, newSendLimiter(0))
+	allowed, _ := (*sendLimiter)(nil).allow(NilChannelUUID)
+	assert.True(t, allowed)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newSendLimiter(2)
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+
+	channel1 := MustChannelUUID("e4bb1578-29da-4fa5-a214-9da19dd24230")
+	channel2 := MustChannelUUID("53e5aafa-8155-449d-9009-fcb30d54bd26")
+
+	// we can burst up to our rate
+	allowed, _ = limiter.allow(channel1)
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow(channel1)
+	assert.True(t, allowed)
+
+	// but then need to wait for a token
+	allowed, wait := limiter.allow(channel1)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// other channels have their own buckets
+	allowed, _ = limiter.allow(channel2)
+	assert.True(t, allowed)
+
+	// after half a second we have a new token
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.allow(channel1)
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow(channel1)
+	assert.False(t, allowed)
+
+	// channels that go idle are forgotten
+	now = now.Add(2 * time.Minute)
+	limiter.allow(channel2)
+	now = now.Add(4 * time.Minute)
+	assert.Len(t, limiter.buckets, 2)
+	limiter.allow(channel2)
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, channel2)
+}
+
+func TestSendRateLimited(t *testing.T) {
+	config := testConfig()
+	config.MaxSendRatePerChannel = 1
+
+	mb := NewMockBackend()
+	foreman := NewForeman(NewServer(config, mb), 1)
+	sender := foreman.senders[0]
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	msg1 := &mockMsg{channel: channel, id: NewMsgID(101), text: "first", urn: "tel:+250788383383"}
+	msg2 := &mockMsg{channel: channel, id: NewMsgID(102), text: "second", urn: "tel:+250788383383"}
+
+	// our first message goes through, but our second exceeds our rate and is requeued without a status
+	sender.sendMessage(msg1)
+	sender.sendMessage(msg2)
+
+	assert.Equal(t, 1, len(mb.msgStatuses))
+	assert.Equal(t, NewMsgID(101), mb.msgStatuses[0].ID())
+	assert.Equal(t, []Msg{msg2}, mb.requeuedMsgs)
+	assert.Equal(t, []Msg{msg2}, mb.outgoingMsgs)
+}
+
+func TestSendSemaphore(t *testing.T) {
+	// no max, no semaphore
+	assert.Nil(t, newSendSemaphore(0))
+	assert.True(t, (*sendSemaphore)(nil).acquire(NilChannelUUID))
+	(*sendSemaphore)(nil).release(NilChannelUUID)
+
+	semaphore := newSendSemaphore(2)
+	channel1, _ := NewChannelUUID("e4bb1578-29da-4fa5-a214-9da19dd24230")
+	channel2, _ := NewChannelUUID("53e5aafa-8155-449d-9009-fcb30d54bd26")
+
+	assert.True(t, semaphore.acquire(channel1))
+	assert.True(t, semaphore.acquire(channel1))
+	assert.False(t, semaphore.acquire(channel1))
+
+	// other channels have their own slots
+	assert.True(t, semaphore.acquire(channel2))
+
+	// releasing a slot lets the next send through
+	semaphore.release(channel1)
+	assert.True(t, semaphore.acquire(channel1))
+
+	// channels without sends in flight are forgotten
+	semaphore.release(channel2)
+	assert.Len(t, semaphore.inFlight, 1)
+	semaphore.release(channel1)
+	semaphore.release(channel1)
+	assert.Len(t, semaphore.inFlight, 0)
+}
+
+// blockingHandler is a handler whose sends of "slow" msgs don't complete until they are unblocked
+type blockingHandler struct {
+	dummyHandler
+	started chan MsgID
+	unblock chan bool
+}
+
+func (h *blockingHandler) ChannelType() ChannelType { return ChannelType("BK") }
+
+func (h *blockingHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	h.started <- msg.ID()
+	if msg.Text() == "slow" {
+		<-h.unblock
+	}
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), nil
+}
+
+func TestSendConcurrencyLimited(t *testing.T) {
+	config := testConfig()
+	config.MaxConcurrentSendsPerChannel = 1
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	foreman := NewForeman(s, 2)
+
+	handler := &blockingHandler{dummyHandler{server: s, backend: mb}, make(chan MsgID, 3), make(chan bool)}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel1 := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "BK", "2020", "US", map[string]interface{}{})
+	channel2 := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "BK", "2021", "US", map[string]interface{}{})
+	msg1 := &mockMsg{channel: channel1, id: NewMsgID(101), text: "slow", urn: "tel:+250788383383"}
+	msg2 := &mockMsg{channel: channel1, id: NewMsgID(102), text: "fast", urn: "tel:+250788383383"}
+	msg3 := &mockMsg{channel: channel2, id: NewMsgID(103), text: "fast", urn: "tel:+250788383383"}
+
+	// start a send on our first channel which won't complete until we say so
+	done := make(chan bool)
+	go func() {
+		foreman.senders[0].sendMessage(msg1)
+		close(done)
+	}()
+	assert.Equal(t, NewMsgID(101), <-handler.started)
+
+	// another send on that channel is over our limit so is requeued, but our other channel sends fine
+	foreman.senders[1].sendMessage(msg2)
+	foreman.senders[1].sendMessage(msg3)
+	assert.Equal(t, NewMsgID(103), <-handler.started)
+	assert.Equal(t, []Msg{msg2}, mb.requeuedMsgs)
+
+	// once our slow send completes, our first channel can send again
+	close(handler.unblock)
+	<-done
+
+	foreman.senders[1].sendMessage(msg2)
+	assert.Equal(t, NewMsgID(102), <-handler.started)
+	assert.Equal(t, []Msg{msg2}, mb.requeuedMsgs)
+	assert.Len(t, foreman.semaphore.inFlight, 0)
+}
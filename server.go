@@ -2,12 +2,16 @@ package courier
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/http/pprof"
 	"os"
 	"runtime/debug"
 	"sort"
@@ -15,12 +19,19 @@ import (
 	"time"
 
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/librato"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Server is the main interface ChannelHandlers use to interact with backends. It provides an
@@ -29,18 +40,34 @@ type Server interface {
 	Config() *Config
 
 	AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc)
+	AddHandlerRouteWithResolver(handler ChannelHandler, method string, action string, resolver ChannelResolver, handlerFunc ChannelHandleFunc)
+	RemoveHandlerRoutes(handler ChannelHandler)
+
+	// InvalidateChannel evicts the channel with the passed in UUID from our channel cache, if we have one
+	InvalidateChannel(uuid ChannelUUID)
 
 	SendMsg(context.Context, Msg) (MsgStatus, error)
 
+	WriteMsgStatuses(context.Context, []MsgStatus) error
+
 	Backend() Backend
 
+	// HTTPClient returns the HTTP client outgoing requests to providers should be made with, so connections are reused
+	HTTPClient() *http.Client
+
 	WaitGroup() *sync.WaitGroup
+
+	// TrackComponent adds a goroutine running the named component to our wait group, the goroutine must call the returned
+	// func when it stops. Components still running when we are stopped are named in our logs.
+	TrackComponent(name string) func()
+
 	StopChan() chan bool
 	Stopped() bool
 
 	Router() chi.Router
 
 	Start() error
+	Drain()
 	Stop() error
 }
 
@@ -52,39 +79,178 @@ func NewServer(config *Config, backend Backend) Server {
 	return NewServerWithLogger(config, backend, logger)
 }
 
+// the content types of responses we compress when EnableCompression is set, anything else (e.g. media) is either
+// already compressed or not worth it
+var compressedContentTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/xml",
+	"application/json",
+	"application/xml",
+}
+
 // NewServerWithLogger creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
 func NewServerWithLogger(config *Config, backend Backend, logger *logrus.Logger) Server {
 	router := chi.NewRouter()
-	router.Use(middleware.DefaultCompress)
+	if config.EnableCompression {
+		router.Use(middleware.Compress(flate.DefaultCompression, compressedContentTypes...))
+	}
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RequestID)
+	router.Use(savePeerAddr)
 	router.Use(middleware.RealIP)
+	router.Use(newRequestLogger(config, logger).Handler)
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(30 * time.Second))
 
-	chanRouter := chi.NewRouter()
-	router.Mount("/c/", chanRouter)
+	trustedProxies, err := parseCIDRs(strings.Split(config.TrustedProxies, ","))
+	if err != nil {
+		logrus.WithError(err).Error("invalid trusted proxies, not trusting any")
+	}
+
+	s := &server{
+		config: config,
 
-	return &server{
-		config:  config,
-		backend: backend,
+		// channel logs are redacted and truncated before they are saved, however they are written
+		backend: &channelLogBackend{Backend: backend, maxBodySize: config.MaxChannelLogBodySize},
 
-		router:     router,
-		chanRouter: chanRouter,
+		trustedProxies: trustedProxies,
+		corsOrigins:    parseOrigins(config.CORSAllowedOrigins),
 
-		stopChan:  make(chan bool),
-		waitGroup: &sync.WaitGroup{},
-		stopped:   false,
+		router: router,
+
+		stopChan:   make(chan bool),
+		waitGroup:  &sync.WaitGroup{},
+		components: newComponentTracker(),
 	}
+
+	// if tracing, every request gets a root span and writes to our backend are spans within it
+	if config.EnableTracing {
+		s.tracerProvider = newTracerProvider()
+		router.Use(s.traceRequests)
+		s.backend = &tracingBackend{Backend: s.backend}
+	}
+
+	// statuses of msgs with callback URLs are forwarded there
+	s.backend = &statusNotifyBackend{Backend: s.backend, server: s}
+
+	// stop events stop msgs being sent to their URNs until they re-engage with the channel
+	s.backend = &stoppedURNBackend{Backend: s.backend}
+
+	// incoming msgs without contact names get them from their provider if configured
+	if config.DescribeIncomingURNs {
+		s.backend = newURNDescriberBackend(s.backend)
+	}
+
+	// incoming msgs are forwarded to our inbound webhook if configured
+	if config.InboundWebhookURL != "" {
+		s.backend = &inboundWebhookBackend{Backend: s.backend, server: s}
+	}
+
+	// writes to a backend which keeps failing fail fast if configured
+	if config.BackendBreakerThreshold > 0 {
+		s.breaker = newCircuitBreaker(config.BackendBreakerThreshold, time.Duration(config.BackendBreakerOpenDuration)*time.Second)
+		s.backend = &breakerBackend{Backend: s.backend, breaker: s.breaker}
+	}
+
+	// channels are cached in front of our backend if configured
+	if config.ChannelCacheTTL > 0 {
+		s.channelCache = newChannelCacheBackend(s.backend, time.Duration(config.ChannelCacheTTL)*time.Second)
+		s.backend = s.channelCache
+	}
+
+	// status updates which don't progress their msgs are dropped, after our channel cache as we look up their channels
+	if config.StatusPrecedence != "" && config.StatusDedupeWindow > 0 {
+		s.backend = newStatusPrecedenceBackend(s.backend, ParseStatusPrecedence(config.StatusPrecedence), time.Duration(config.StatusDedupeWindow)*time.Second)
+	}
+
+	// an invalid base path is an error when we start, until then use our default
+	s.channelBasePath = config.ChannelBasePath
+	if checkChannelBasePath(s.channelBasePath) != nil {
+		s.channelBasePath = defaultChannelBasePath
+	}
+
+	// our channel routes can be rebuilt as handlers are removed, so route to whichever is current
+	s.chanRouter = s.newChanRouter()
+	chanHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.routesMutex.RLock()
+		chanRouter := s.chanRouter
+		s.routesMutex.RUnlock()
+
+		chanRouter.ServeHTTP(w, r)
+	})
+	router.Mount(s.channelBasePath, chanHandler)
+
+	// handlers give providers callback URLs under our default base path, so that always works too
+	if s.channelBasePath != defaultChannelBasePath {
+		router.Mount(defaultChannelBasePath, chanHandler)
+	}
+
+	return s
+}
+
+// the path our channel routes are served under unless configured otherwise
+const defaultChannelBasePath = "/c/"
+
+// checkChannelBasePath checks that the passed in path can have our channel routes mounted on it
+func checkChannelBasePath(path string) error {
+	if len(path) < 3 || !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") {
+		return fmt.Errorf("invalid channel base path '%s', must begin and end with /", path)
+	}
+	return nil
+}
+
+// newChanRouter creates a new empty router for our channel routes
+func (s *server) newChanRouter() *chi.Mux {
+	chanRouter := chi.NewRouter()
+
+	// answer CORS requests from browser based channels if we allow any origins
+	if len(s.corsOrigins) > 0 {
+		chanRouter.Use(newCORSMiddleware(s.corsOrigins))
+	}
+	return chanRouter
 }
 
 // Start starts the Server listening for incoming requests and sending messages. It will return an error
 // if it encounters any unrecoverable (or ignorable) error, though its bias is to move forward despite
 // connection errors
 func (s *server) Start() error {
-	// set our user agent, needs to happen before we do anything so we don't change have threading issues
+	// load our certificate if we are terminating TLS ourselves
+	tlsConfig, err := newTLSConfig(s.config)
+	if err != nil {
+		return err
+	}
+
+	err = checkChannelBasePath(s.config.ChannelBasePath)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestLogFormat(s.config.RequestLogFormat)
+	if err != nil {
+		return err
+	}
+
+	if s.config.EnableTracing && s.config.TracingEndpoint == "" {
+		return errors.New("tracing is enabled but no tracing endpoint is set")
+	}
+
+	// make sure we can spool anything we fail to write to our backend
+	err = checkSpoolDir(s.config.SpoolDir)
+	if err != nil {
+		return fmt.Errorf("spool directory '%s' is not writable: %s", s.config.SpoolDir, err)
+	}
+
+	err = ConfigureSpoolEncryption(s.config.SpoolEncryptionKey)
+	if err != nil {
+		return err
+	}
+	ConfigureSpoolLimit(s.config.MaxSpoolFiles)
+
+	// set our user agent and configure the HTTP clients shared by our handlers, needs to happen before we do anything
+	// so we don't change have threading issues
 	utils.HTTPUserAgent = fmt.Sprintf("Courier/%s", s.config.Version)
+	utils.ConfigureHTTPClients(s.config.HTTPClientMaxIdleConns, s.config.HTTPClientMaxIdleConnsPerHost, timeoutOrDefault(s.config.HTTPClientTimeout, 60*time.Second))
 
 	// configure librato if we have configuration options for it
 	host, _ := os.Hostname()
@@ -93,37 +259,121 @@ func (s *server) Start() error {
 		librato.Start()
 	}
 
+	// record metrics for everything written to our backend if enabled
+	if s.config.EnableMetrics {
+		s.metrics = newMetrics()
+		s.metrics.registerBreakerState(s.breaker)
+		s.metrics.registerMediaDownloads(s.backend)
+		s.backend = newMetricsBackend(s.backend, s.metrics)
+	}
+
 	// start our backend
-	err := s.backend.Start()
+	err = s.startBackend()
 	if err != nil {
 		return err
 	}
 
+	// msgs we couldn't forward to our inbound webhook are spooled too
+	if s.config.InboundWebhookURL != "" {
+		err = s.registerInboundWebhookFlusher()
+		if err != nil {
+			return err
+		}
+	}
+
 	// start our spool flushers
 	startSpoolFlushers(s)
 
+	// and exporting our spans if we are tracing
+	if s.tracerProvider != nil {
+		err = s.startTracing(s.TrackComponent("tracer"))
+		if err != nil {
+			return err
+		}
+	}
+
 	// wire up our main pages
 	s.router.NotFound(s.handle404)
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
-	s.router.Get("/status", s.handleStatus)
 
-	// initialize our handlers
+	// and our admin pages, on their own port if we have one so they can be kept off the public internet
+	adminRouter := s.router
+	if s.config.AdminPort > 0 {
+		adminRouter = newAdminRouter()
+		adminRouter.NotFound(s.handle404)
+		adminRouter.MethodNotAllowed(s.handle405)
+	}
+	// which need our admin credentials if we have them, wherever they are served
+	admin := adminRouter.With(s.requireAdminAuth)
+	admin.Get("/status", s.handleStatus)
+	admin.Get("/health", s.handleHealth)
+	admin.Get("/ready", s.handleReady)
+	admin.Get("/channels", s.handleChannels)
+	admin.Get("/spool", s.handleSpool)
+	admin.Post("/spool/flush", s.handleSpoolFlush)
+	admin.Delete("/spool", s.handlePurgeSpool)
+	if s.metrics != nil {
+		admin.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	}
+	if s.config.EnableReplay {
+		admin.Post("/replay", s.handleReplay)
+	}
+
+	// initialize our handlers, and the status callback they can share
+	s.addStatusCallbackRoute()
 	s.initializeChannelHandlers()
 
+	// expose profiling if enabled, preferably on its own port, otherwise alongside our admin pages
+	var handler http.Handler = s.router
+	var adminHandler http.Handler = adminRouter
+	if s.config.EnablePprof {
+		if s.config.PprofPort > 0 {
+			s.startPprofServer()
+		} else if s.config.AdminPort > 0 {
+			adminHandler = withPprof(adminRouter, s.requireAdminAuth)
+		} else {
+			handler = withPprof(s.router, s.requireAdminAuth)
+		}
+	}
+
+	if s.config.AdminPort > 0 {
+		s.startAdminServer(adminHandler)
+	}
+
 	// configure timeouts on our server
 	s.httpServer = &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", s.config.Address, s.config.Port),
-		Handler:      s.router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Handler:      handler,
+		ReadTimeout:  timeoutOrDefault(s.config.HTTPReadTimeout, defaultHTTPTimeout),
+		WriteTimeout: timeoutOrDefault(s.config.HTTPWriteTimeout, defaultHTTPTimeout),
+		IdleTimeout:  timeoutOrDefault(s.config.HTTPIdleTimeout, defaultHTTPTimeout),
+		TLSConfig:    tlsConfig,
+	}
+
+	// clients which support it get HTTP/2 over HTTPS, and over plain HTTP too if we are behind a proxy which speaks it,
+	// everyone else still gets HTTP/1.1
+	http2Server := &http2.Server{IdleTimeout: s.httpServer.IdleTimeout}
+	if tlsConfig != nil {
+		err = http2.ConfigureServer(s.httpServer, http2Server)
+		if err != nil {
+			return err
+		}
+	} else if s.config.EnableH2C {
+		s.httpServer.Handler = h2c.NewHandler(handler, http2Server)
 	}
 
-	// and start serving HTTP
+	// and start serving HTTP, or HTTPS if we have a certificate
+	httpDone := s.TrackComponent("http_server")
 	go func() {
-		s.waitGroup.Add(1)
-		defer s.waitGroup.Done()
-		err := s.httpServer.ListenAndServe()
+		defer httpDone()
+
+		var err error
+		if tlsConfig != nil {
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			logrus.WithFields(logrus.Fields{
 				"comp":  "server",
@@ -134,11 +384,11 @@ func (s *server) Start() error {
 	}()
 
 	// start our heartbeat
+	heartbeatDone := s.TrackComponent("heartbeat")
 	go func() {
-		s.waitGroup.Add(1)
-		defer s.waitGroup.Done()
+		defer heartbeatDone()
 
-		for !s.stopped {
+		for !s.Stopped() {
 			select {
 			case <-s.stopChan:
 				return
@@ -152,36 +402,108 @@ func (s *server) Start() error {
 	}()
 
 	logrus.WithFields(logrus.Fields{
-		"comp":    "server",
-		"port":    s.config.Port,
-		"state":   "started",
-		"version": s.config.Version,
+		"comp":       "server",
+		"port":       s.config.Port,
+		"admin_port": s.config.AdminPort,
+		"state":      "started",
+		"version":    s.config.Version,
 	}).Info("server listening on ", s.config.Port)
 
 	// start our foreman for outgoing messages
 	s.foreman = NewForeman(s, s.config.MaxWorkers)
+	s.metrics.registerSenderGauges(s.foreman, s.backend)
 	s.foreman.Start()
 
+	// we are now ready to take traffic
+	atomic.StoreInt32(&s.ready, 1)
+
 	return nil
 }
 
+// how many seconds we ask providers to wait before retrying requests we reject while draining
+const drainRetryAfter = 30
+
+// Drain stops the server taking new work without tearing anything down. Channel requests are rejected with a 503
+// so providers retry them elsewhere, and no new msgs are taken off the send queue, but any already in flight are
+// allowed to finish. Stop should still be called to shut everything down.
+func (s *server) Drain() {
+	logrus.WithField("comp", "server").WithField("state", "draining").Info("draining server")
+
+	atomic.StoreInt32(&s.draining, 1)
+
+	// we may be asked to drain before we have started sending
+	if s.foreman != nil {
+		s.foreman.Drain()
+	}
+}
+
+func (s *server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// isReady returns whether we have started and are ready to take traffic
+func (s *server) isReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// startBackend starts our backend, retrying with an exponential backoff if it fails as the services it depends on may
+// still be starting themselves. If it still fails after all our retries the last error is returned.
+func (s *server) startBackend() error {
+	for attempt := 1; ; attempt++ {
+		err := s.backend.Start()
+		if err == nil || attempt > s.config.BackendStartRetries {
+			return err
+		}
+
+		backoff := retryBackoff(s.config.BackendStartBackoff, attempt)
+		logrus.WithError(err).WithField("comp", "server").WithField("attempt", attempt).WithField("backoff", backoff).Error("error starting backend, retrying")
+		time.Sleep(backoff)
+	}
+}
+
 // Stop stops the server, returning only after all threads have stopped
 func (s *server) Stop() error {
 	log := logrus.WithField("comp", "server")
 	log.WithField("state", "stopping").Info("stopping server")
 
+	// mark ourselves as stopped so we don't report being ready while we drain
+	atomic.StoreInt32(&s.stopped, 1)
+
 	// stop our foreman
 	s.foreman.Stop()
 
-	// shut down our HTTP server
-	if err := s.httpServer.Shutdown(context.Background()); err != nil {
-		log.WithField("state", "stopping").WithError(err).Error("error shutting down server")
+	// everything below shares one deadline, so that stopping takes at most our ShutdownTimeout in total
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(s.config.ShutdownTimeout))
+	defer cancel()
+
+	// shut down our HTTP server, giving any in flight requests a chance to complete
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		if err == context.DeadlineExceeded {
+			log.WithField("state", "stopping").WithField("timeout", s.config.ShutdownTimeout).Error("timed out waiting for in flight requests to complete")
+		} else {
+			log.WithField("state", "stopping").WithError(err).Error("error shutting down server")
+		}
+	}
+
+	// and our admin and profiling servers if we have them
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(shutdownCtx); err != nil {
+			log.WithField("state", "stopping").WithError(err).Error("error shutting down admin server")
+		}
+	}
+	if s.pprofServer != nil {
+		if err := s.pprofServer.Shutdown(shutdownCtx); err != nil {
+			log.WithField("state", "stopping").WithError(err).Error("error shutting down pprof server")
+		}
 	}
 
 	// stop everything
-	s.stopped = true
 	close(s.stopChan)
 
+	// try to get anything left in our spool to our backend before it stops
+	flushSpoolOnStop(shutdownCtx, s)
+
 	// stop our backend
 	err := s.backend.Stop()
 	if err != nil {
@@ -191,51 +513,237 @@ func (s *server) Stop() error {
 	// stop our librato sender
 	librato.Stop()
 
-	// wait for everything to stop
-	s.waitGroup.Wait()
+	// wait for everything to stop, but not forever as a component which is stuck would stop us ever exiting
+	stoppedCleanly := waitWithContext(shutdownCtx, s.waitGroup)
+	if !stoppedCleanly {
+		log.WithField("state", "stopping").WithField("timeout", s.config.ShutdownTimeout).WithField("running", s.components.stillRunning()).Error("timed out waiting for components to stop")
+	}
 
 	// clean things up, tearing down any connections
 	s.backend.Cleanup()
 
+	if !stoppedCleanly {
+		return ErrUncleanShutdown
+	}
+
 	log.WithField("state", "stopped").Info("server stopped")
 	return nil
 }
 
-func (s *server) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+func (s *server) SendMsg(ctx context.Context, msg Msg) (status MsgStatus, err error) {
 	// find the handler for this message type
 	handler, found := activeHandlers[msg.Channel().ChannelType()]
 	if !found {
 		return nil, fmt.Errorf("unable to find handler for channel type: %s", msg.Channel().ChannelType())
 	}
 
+	// a handler which panics shouldn't take down our sender, instead the send errors and the msg isn't sent
+	defer func() {
+		panicLog := recover()
+		if panicLog != nil {
+			logrus.WithField("channel_uuid", msg.Channel().UUID()).WithField("channel_type", msg.Channel().ChannelType()).WithField("msg_id", msg.ID().String()).WithField("trace", panicLog).WithField("stack", string(debug.Stack())).Error("panic sending msg")
+			status, err = nil, fmt.Errorf("panic sending msg: %v", panicLog)
+		}
+	}()
+
 	// have the handler send it
 	return handler.SendMsg(ctx, msg)
 }
 
+// WriteMsgStatuses writes the passed in status updates using our backend, see Backend.WriteMsgStatuses
+func (s *server) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	return s.backend.WriteMsgStatuses(ctx, statuses)
+}
+
+// the read, write and idle timeout used by our HTTP server when one isn't configured
+const defaultHTTPTimeout = 30 * time.Second
+
+// timeoutOrDefault returns the passed in number of seconds as a duration, or the default if it isn't set
+func timeoutOrDefault(seconds int, defaultTimeout time.Duration) time.Duration {
+	if seconds <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// newTLSConfig returns the TLS config to serve HTTPS with if the passed in config has a certificate and key,
+// or nil if we should serve plain HTTP
+func newTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return nil, nil
+	}
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, errors.New("TLSCertFile and TLSKeyFile must both be set to serve HTTPS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificate: %s", err)
+	}
+
+	return &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+	}, nil
+}
+
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
 func (s *server) Config() *Config            { return s.config }
-func (s *server) Stopped() bool              { return s.stopped }
+func (s *server) HTTPClient() *http.Client   { return utils.GetHTTPClient() }
+func (s *server) Stopped() bool              { return atomic.LoadInt32(&s.stopped) == 1 }
 
 func (s *server) Backend() Backend   { return s.backend }
 func (s *server) Router() chi.Router { return s.router }
 
+// TrackComponent adds a goroutine running the named component to our wait group, see Server.TrackComponent
+func (s *server) TrackComponent(name string) func() {
+	s.waitGroup.Add(1)
+	s.components.start(name)
+	return func() {
+		s.components.done(name)
+		s.waitGroup.Done()
+	}
+}
+
 type server struct {
-	backend Backend
+	backend      Backend
+	channelCache *channelCacheBackend
+	breaker      *circuitBreaker
+
+	httpServer  *http.Server
+	adminServer *http.Server
+	pprofServer *http.Server
+	router      *chi.Mux
 
-	httpServer *http.Server
-	router     *chi.Mux
-	chanRouter *chi.Mux
+	// our channel routes, guarded by routesMutex as they can be removed while we are running
+	routesMutex   sync.RWMutex
+	chanRouter    *chi.Mux
+	channelRoutes []*channelRoute
+	routes        []string
+	corsOrigins   []string
 
-	foreman *Foreman
+	channelBasePath string
+
+	foreman        *Foreman
+	metrics        *metrics
+	tracerProvider *sdktrace.TracerProvider
 
 	config *Config
 
-	waitGroup *sync.WaitGroup
-	stopChan  chan bool
-	stopped   bool
+	waitGroup  *sync.WaitGroup
+	components *componentTracker
+	stopChan   chan bool
+
+	// these are read from request and component goroutines so are accessed atomically
+	stopped  int32
+	draining int32
+	ready    int32
+
+	trustedProxies []*net.IPNet
+}
+
+// channelRoute is a route added to our channel router
+type channelRoute struct {
+	handler     ChannelHandler
+	method      string
+	path        string
+	action      string
+	handlerFunc http.HandlerFunc
+	help        string
+}
+
+// newPprofRouter returns a router which serves the standard net/http/pprof handlers under /debug/pprof/, behind the
+// passed in auth middleware
+func newPprofRouter(auth func(http.Handler) http.Handler) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(auth)
+	router.HandleFunc("/debug/pprof/", pprof.Index)
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.HandleFunc("/debug/pprof/{profile}", pprof.Index)
+	return router
+}
 
-	routes []string
+// withPprof serves profiling requests ahead of the passed in handler, so that our middleware (which strips the
+// trailing slash pprof relies on) doesn't get in the way
+func withPprof(next http.Handler, auth func(http.Handler) http.Handler) http.Handler {
+	pprofRouter := newPprofRouter(auth)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+			pprofRouter.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAdminRouter returns a router for the admin pages we serve on our AdminPort
+func newAdminRouter() *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(middleware.StripSlashes)
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Recoverer)
+	return router
+}
+
+// startAdminServer serves the passed in admin handler on our AdminPort, keeping it apart from our public endpoints
+func (s *server) startAdminServer(handler http.Handler) {
+	s.adminServer = &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", s.config.Address, s.config.AdminPort),
+		Handler:      handler,
+		ReadTimeout:  timeoutOrDefault(s.config.HTTPReadTimeout, defaultHTTPTimeout),
+		WriteTimeout: timeoutOrDefault(s.config.HTTPWriteTimeout, defaultHTTPTimeout),
+		IdleTimeout:  timeoutOrDefault(s.config.HTTPIdleTimeout, defaultHTTPTimeout),
+	}
+
+	done := s.TrackComponent("admin_server")
+	go func() {
+		defer done()
+
+		err := s.adminServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{
+				"comp":  "admin",
+				"state": "stopping",
+				"err":   err,
+			}).Error()
+		}
+	}()
+}
+
+// startPprofServer serves profiling on our PprofPort, keeping it apart from our public endpoints
+func (s *server) startPprofServer() {
+	s.pprofServer = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", s.config.Address, s.config.PprofPort),
+		Handler: newPprofRouter(s.requireAdminAuth),
+	}
+
+	done := s.TrackComponent("pprof_server")
+	go func() {
+		defer done()
+
+		err := s.pprofServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{
+				"comp":  "pprof",
+				"state": "stopping",
+				"err":   err,
+			}).Error()
+		}
+	}()
 }
 
 func (s *server) initializeChannelHandlers() {
@@ -257,27 +765,109 @@ func (s *server) initializeChannelHandlers() {
 	}
 
 	// sort our route help
+	s.routesMutex.Lock()
 	sort.Strings(s.routes)
+	s.routesMutex.Unlock()
+}
+
+// errHandlerTimeout is the error for requests which a handler didn't finish handling within our HandlerTimeout
+var errHandlerTimeout = errors.New("timed out handling request")
+
+// errHandlerPanic is the error for requests which a handler panicked handling, it is a 500 as the provider did nothing wrong
+var errHandlerPanic = NewCourierError(http.StatusInternalServerError, "panic handling request")
+
+// writes and logs the passed in error, as the ack the provider of the passed in handler expects if it has one
+func writeHandlerError(ctx context.Context, w http.ResponseWriter, r *http.Request, handler ChannelHandler, channel Channel, err error) error {
+	if ack := errorAck(handler, channel, err); ack != nil {
+		LogRequestError(r, channel, err)
+		return WriteChannelResponse(w, ack)
+	}
+	return WriteAndLogError(ctx, w, r, channel, err)
+}
+
+// returns the error ack for the passed in error if the passed in handler has one
+func errorAck(handler ChannelHandler, channel Channel, err error) *ChannelResponse {
+	acker, isAcker := handler.(ChannelAcker)
+	if !isAcker {
+		return nil
+	}
+	return acker.ErrorAck(channel, err)
 }
 
-func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc ChannelHandleFunc) http.HandlerFunc {
+func (s *server) channelHandleWrapper(handler ChannelHandler, action string, getChannel getChannelFunc, handlerFunc ChannelHandleFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// if we are draining, have the provider try again later
+		if s.isDraining() {
+			w.Header().Set("Retry-After", fmt.Sprint(drainRetryAfter))
+			WriteDataResponse(r.Context(), w, http.StatusServiceUnavailable, "Service Unavailable", []interface{}{NewErrorData("server is draining, try again later")})
+			return
+		}
+
 		start := time.Now()
 
 		// stuff a few things in our context that help with logging
 		baseCtx := context.WithValue(r.Context(), contextRequestURL, r.URL.String())
 		baseCtx = context.WithValue(baseCtx, contextRequestStart, time.Now())
 
-		// add a 30 second timeout
-		ctx, cancel := context.WithTimeout(baseCtx, time.Second*30)
+		// bound how long handling this request can take
+		ctx, cancel := context.WithTimeout(baseCtx, timeoutOrDefault(s.config.HandlerTimeout, defaultHTTPTimeout))
 		defer cancel()
 
-		channel, err := handler.GetChannel(ctx, r)
+		// limit how much of the body can be read while looking up our channel, as some handlers need to for that
+		body := r.Body
+		if body != nil && s.config.MaxRequestBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, body, int64(s.config.MaxRequestBodySize))
+		}
+		limitedBody := r.Body
+
+		channel, err := getChannel(ctx, r)
 		if err != nil {
+			if isRequestBodyTooLarge(err) {
+				writeBodyTooLarge(ctx, w, r, nil, err)
+				return
+			}
 			WriteError(ctx, w, r, err)
 			return
 		}
 
+		// if this channel only accepts requests from certain addresses, check this is one of them
+		err = s.checkAllowedIP(channel, r)
+		if err != nil {
+			LogRequestError(r, channel, err)
+			WriteDataResponse(ctx, w, http.StatusForbidden, "Forbidden", []interface{}{NewErrorData(err.Error())})
+			return
+		}
+
+		// then limit it to what this channel accepts, channels can raise our limit if they need to
+		maxBodySize := s.config.MaxRequestBodySize
+		if channel != nil {
+			maxBodySize = channel.IntConfigForKey(ConfigMaxRequestBodySize, maxBodySize)
+		}
+
+		// handlers which read the body to find the channel replace it with what they read, which is already limited
+		if r.Body != limitedBody {
+			body = r.Body
+		}
+		err = limitRequestBody(w, r, body, maxBodySize)
+		if err != nil {
+			writeBodyTooLarge(ctx, w, r, channel, err)
+			return
+		}
+
+		// handling this request is a span within the request's span if we are tracing
+		span := trace.SpanFromContext(ctx)
+		if s.tracerProvider != nil {
+			spanName := fmt.Sprintf("handle %s", action)
+			if handler != nil {
+				spanName = fmt.Sprintf("handle %s %s", handler.ChannelType(), action)
+			}
+			ctx, span = StartSpan(ctx, spanName)
+			if channel != nil {
+				span.SetAttributes(attribute.String("channel_uuid", channel.UUID().String()))
+			}
+			defer span.End()
+		}
+
 		r = r.WithContext(ctx)
 
 		// read the bytes from our body so we can create a channel log for this request
@@ -287,10 +877,15 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		r.Header.Del("Cookie")
 		request, err := httputil.DumpRequest(r, true)
 		if err != nil {
-			writeAndLogRequestError(ctx, w, r, channel, err)
+			if isRequestBodyTooLarge(err) {
+				writeBodyTooLarge(ctx, w, r, channel, err)
+				return
+			}
+			WriteAndLogError(ctx, w, r, channel, err)
 			return
 		}
 		url := fmt.Sprintf("https://%s%s", r.Host, r.URL.RequestURI())
+		secrets := ChannelSecrets(channel)
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 		ww.Tee(response)
@@ -301,20 +896,55 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			// catch any panics and recover
 			panicLog := recover()
 			if panicLog != nil {
-				debug.PrintStack()
-				logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", url).WithField("request", string(request)).WithField("trace", panicLog).Error("panic handling request")
-				writeAndLogRequestError(ctx, ww, r, channel, errors.New("panic handling msg"))
+				logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("action", action).WithField("url", RedactedURL(url, secrets...)).WithField("request", RedactSecrets(string(request), secrets...)).WithField("trace", panicLog).WithField("stack", string(debug.Stack())).Error("panic handling request")
+				setSpanError(span, errHandlerPanic)
+
+				// our handler may have already started writing its response, in which case we can't change it
+				if ww.Status() == 0 {
+					writeHandlerError(ctx, ww, r, handler, channel, errHandlerPanic)
+				}
+
+				// write a channel log of the request so the panic is visible on the channel
+				panicErr := fmt.Errorf("%s: %v", errHandlerPanic.Error(), panicLog)
+				duration := time.Now().Sub(start)
+				panicLogs := []*ChannelLog{NewChannelLog("Channel Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, panicErr)}
+				if err := s.backend.WriteChannelLogs(baseCtx, panicLogs); err != nil {
+					logrus.WithError(err).Error("error writing channel log")
+				}
+			}
+		}()
+
+		// handlers parse multipart bodies on our copy of the request, so the http server doesn't know to remove any
+		// temporary files written for their file parts, we do that once they are done with them
+		defer func() {
+			if r.MultipartForm != nil {
+				r.MultipartForm.RemoveAll()
 			}
 		}()
 
 		events, err := handlerFunc(ctx, channel, ww, r)
+		setSpanError(span, err)
 		duration := time.Now().Sub(start)
 		secondDuration := float64(duration) / float64(time.Second)
-
-		// if we received an error, write it out and report it
-		if err != nil {
-			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", url).WithField("request", string(request)).Error("error handling request")
-			writeAndLogRequestError(ctx, ww, r, channel, err)
+		s.metrics.recordHandlerDuration(channel.ChannelType(), action, duration)
+
+		// if we ran out of time, tell the provider that rather than whatever error that caused
+		timedOut := ctx.Err() == context.DeadlineExceeded && (err != nil || ww.Status() == 0)
+		if timedOut {
+			err = errHandlerTimeout
+			logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("action", action).WithField("url", RedactedURL(url, secrets...)).Error("timed out handling request")
+			if ack := errorAck(handler, channel, err); ack != nil {
+				WriteChannelResponse(ww, ack)
+			} else {
+				WriteDataResponse(ctx, ww, http.StatusGatewayTimeout, "Gateway Timeout", []interface{}{NewErrorData(err.Error())})
+			}
+		} else if err != nil {
+			// if we received an error, write it out and report it
+			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", RedactedURL(url, secrets...)).WithField("request", RedactSecrets(string(request), secrets...)).Error("error handling request")
+			writeHandlerError(ctx, ww, r, handler, channel, err)
+		} else if acker, isAcker := handler.(ChannelAcker); isAcker && ww.Status() == 0 {
+			// our handler left acking the request to us
+			WriteChannelResponse(ww, acker.SuccessAck(channel))
 		}
 
 		// if no events were created we still want to log this to the channel, do so
@@ -346,8 +976,12 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			}
 		}
 
-		// and write these out
-		err = s.backend.WriteChannelLogs(ctx, logs)
+		// and write these out, without our handler's deadline if it has passed
+		logsCtx := ctx
+		if timedOut {
+			logsCtx = baseCtx
+		}
+		err = s.backend.WriteChannelLogs(logsCtx, logs)
 
 		// log any error writing our channel log but don't break the request
 		if err != nil {
@@ -356,8 +990,17 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 	}
 }
 
+// the HTTP methods handlers can register routes for
+var supportedRouteMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodPatch,
+	http.MethodHead,
+}
+
 func (s *server) AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) {
-	method = strings.ToLower(method)
 	channelType := strings.ToLower(string(handler.ChannelType()))
 
 	path := fmt.Sprintf("/%s/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}", channelType)
@@ -365,11 +1008,79 @@ func (s *server) AddHandlerRoute(handler ChannelHandler, method string, action s
 		path = fmt.Sprintf("/%s", channelType)
 	}
 
+	s.addHandlerRoute(handler, method, action, path, handler.GetChannel, handlerFunc)
+}
+
+// AddHandlerRouteWithResolver adds a route for the passed in handler whose path contains an identifier which isn't
+// one of our channel UUIDs, e.g. a provider page ID. The raw identifier is passed to resolver to look up the channel.
+func (s *server) AddHandlerRouteWithResolver(handler ChannelHandler, method string, action string, resolver ChannelResolver, handlerFunc ChannelHandleFunc) {
+	path := fmt.Sprintf("/%s/{id}", strings.ToLower(string(handler.ChannelType())))
+
+	getChannel := func(ctx context.Context, r *http.Request) (Channel, error) {
+		return resolver(ctx, chi.URLParam(r, "id"))
+	}
+
+	s.addHandlerRoute(handler, method, action, path, getChannel, handlerFunc)
+}
+
+func (s *server) addHandlerRoute(handler ChannelHandler, method string, action string, path string, getChannel getChannelFunc, handlerFunc ChannelHandleFunc) {
+	// routes are added when handlers initialize, so an unsupported method is a programming error
+	if !utils.StringArrayContains(supportedRouteMethods, strings.ToUpper(method)) {
+		panic(fmt.Sprintf("unsupported method: %s", method))
+	}
+
 	if action != "" {
 		path = fmt.Sprintf("%s/%s", path, action)
 	}
-	s.chanRouter.Method(method, path, s.channelHandleWrapper(handler, handlerFunc))
-	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), action))
+
+	s.addChannelRoute(&channelRoute{
+		handler:     handler,
+		method:      strings.ToLower(method),
+		path:        path,
+		action:      action,
+		handlerFunc: s.channelHandleWrapper(handler, action, getChannel, handlerFunc),
+		help:        fmt.Sprintf("%-20s - %s %s", s.channelPath(path), handler.ChannelName(), action),
+	})
+}
+
+// channelPath returns the full path of the passed in channel route, that is with our base path
+func (s *server) channelPath(path string) string {
+	return strings.TrimSuffix(s.channelBasePath, "/") + path
+}
+
+// addChannelRoute adds the passed in route to our channel router and route help
+func (s *server) addChannelRoute(route *channelRoute) {
+	s.routesMutex.Lock()
+	defer s.routesMutex.Unlock()
+
+	s.chanRouter.Method(route.method, route.path, route.handlerFunc)
+	s.channelRoutes = append(s.channelRoutes, route)
+	s.routes = append(s.routes, route.help)
+}
+
+// RemoveHandlerRoutes removes all the routes added for the passed in handler. As routers can't have routes removed,
+// this rebuilds our channel router from the routes of every other handler.
+func (s *server) RemoveHandlerRoutes(handler ChannelHandler) {
+	s.routesMutex.Lock()
+	defer s.routesMutex.Unlock()
+
+	chanRouter := s.newChanRouter()
+	channelRoutes := make([]*channelRoute, 0, len(s.channelRoutes))
+	routes := make([]string, 0, len(s.channelRoutes))
+
+	for _, route := range s.channelRoutes {
+		if route.handler != nil && route.handler == handler {
+			continue
+		}
+		chanRouter.Method(route.method, route.path, route.handlerFunc)
+		channelRoutes = append(channelRoutes, route)
+		routes = append(routes, route.help)
+	}
+	sort.Strings(routes)
+
+	s.chanRouter = chanRouter
+	s.channelRoutes = channelRoutes
+	s.routes = routes
 }
 
 func prependHeaders(body string, statusCode int, resp http.ResponseWriter) string {
@@ -391,8 +1102,11 @@ func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString(s.backend.Health())
 
 	buf.WriteString("\n\n")
+	s.routesMutex.RLock()
 	buf.WriteString(strings.Join(s.routes, "\n"))
+	s.routesMutex.RUnlock()
 	buf.WriteString("</pre></body>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(buf.Bytes())
 }
 
@@ -414,15 +1128,18 @@ func (s *server) handle405(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkStatusAuth checks the request has our admin credentials if we have them, writing a 401 and returning false if not
+func (s *server) checkStatusAuth(w http.ResponseWriter, r *http.Request) bool {
+	if (s.config.AdminToken != "" || s.config.StatusUsername != "") && !s.hasAdminCredentials(r) {
+		s.writeAdminUnauthorized(w)
+		return false
+	}
+	return true
+}
+
 func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	if s.config.StatusUsername != "" {
-		user, pass, ok := r.BasicAuth()
-		if !ok || user != s.config.StatusUsername || pass != s.config.StatusPassword {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Authenticate"`)
-			w.WriteHeader(401)
-			w.Write([]byte("Unauthorised.\n"))
-			return
-		}
+	if !s.checkStatusAuth(w, r) {
+		return
 	}
 
 	var buf bytes.Buffer
@@ -434,15 +1151,56 @@ func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString(s.backend.Status())
 	buf.WriteString("\n\n")
 	buf.WriteString("</pre></body>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(buf.Bytes())
 }
 
+// healthResponse is the JSON response for our /health endpoint, containing the status of each of our dependencies
+type healthResponse struct {
+	Status HealthState             `json:"status"`
+	Checks map[string]HealthStatus `json:"checks"`
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	checks := s.backend.HealthDetails()
+	checks["spool"] = CheckHealthOf("spool", func() error { return checkSpoolDir(s.config.SpoolDir) })
+	if s.breaker != nil {
+		checks["backend_writes"] = s.breaker.health()
+	}
+
+	health := &healthResponse{Status: OverallHealth(checks), Checks: checks}
+
+	err := writeJSONResponse(r.Context(), w, http.StatusOK, health)
+	if err != nil {
+		logrus.WithError(err).Error()
+	}
+}
+
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	status, message := http.StatusOK, "ready"
+	if s.Stopped() {
+		status, message = http.StatusServiceUnavailable, "stopping"
+	} else if s.isDraining() {
+		status, message = http.StatusServiceUnavailable, "draining"
+	} else if !s.isReady() {
+		status, message = http.StatusServiceUnavailable, "starting"
+	}
+
+	err := WriteDataResponse(r.Context(), w, status, message, []interface{}{})
+	if err != nil {
+		logrus.WithError(err).Error()
+	}
+}
+
 // for use in request.Context
 type contextKey int
 
 const (
 	contextRequestURL contextKey = iota
 	contextRequestStart
+	contextPeerAddr
+	contextSpan
+	contextSenderStatus
 )
 
 var splash = `
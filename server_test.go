@@ -1,13 +1,32 @@
 package courier
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/nyaruka/courier/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
 )
 
 func TestServer(t *testing.T) {
@@ -16,6 +35,10 @@ func TestServer(t *testing.T) {
 	config.StatusUsername = "admin"
 	config.StatusPassword = "password123"
 
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+	config.SpoolDir = spoolDir
+
 	server := NewServerWithLogger(config, NewMockBackend(), logger)
 	server.Start()
 	defer server.Stop()
@@ -53,4 +76,425 @@ func TestServer(t *testing.T) {
 	rr, err = utils.MakeHTTPRequest(req)
 	assert.Error(t, err)
 	assert.Contains(t, string(rr.Body), "method not allowed")
+
+	// health check with everything working
+	req, _ = http.NewRequest("GET", "http://localhost:8080/health", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	health := &struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Name      string  `json:"name"`
+			State     string  `json:"state"`
+			LatencyMS float64 `json:"latency_ms"`
+			Message   string  `json:"message"`
+		} `json:"checks"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rr.Body, health))
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, "redis", health.Checks["redis"].Name)
+	assert.Equal(t, "ok", health.Checks["redis"].State)
+	assert.Equal(t, "ok", health.Checks["spool"].State)
+
+	// and with a spool directory we can't write to
+	config.SpoolDir = path.Join(spoolDir, "missing")
+	req, _ = http.NewRequest("GET", "http://localhost:8080/health", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(rr.Body, health))
+	assert.Equal(t, "down", health.Status)
+	assert.Equal(t, "ok", health.Checks["redis"].State)
+	assert.Equal(t, "down", health.Checks["spool"].State)
+	assert.Contains(t, health.Checks["spool"].Message, "no such file or directory")
+
+	// we are started, so we should be ready
+	req, _ = http.NewRequest("GET", "http://localhost:8080/ready", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "ready")
+}
+
+func TestServerReady(t *testing.T) {
+	s := NewServerWithLogger(NewConfig(), NewMockBackend(), logrus.New()).(*server)
+
+	// not started yet, not ready
+	rr := httptest.NewRecorder()
+	s.handleReady(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, 503, rr.Code)
+	assert.Contains(t, rr.Body.String(), "starting")
+
+	atomic.StoreInt32(&s.ready, 1)
+	rr = httptest.NewRecorder()
+	s.handleReady(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, 200, rr.Code)
+
+	// once stopped we are no longer ready, so traffic can drain
+	atomic.StoreInt32(&s.stopped, 1)
+	rr = httptest.NewRecorder()
+	s.handleReady(rr, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, 503, rr.Code)
+	assert.Contains(t, rr.Body.String(), "stopping")
+}
+
+// writeSelfSignedCert writes a self signed certificate for localhost and its key to the passed in directory
+func writeSelfSignedCert(t *testing.T, dir string) (string, []byte, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"Courier Test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	certFile := path.Join(dir, "cert.pem")
+	keyFile := path.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, certPEM, keyFile
+}
+
+func TestServerTLS(t *testing.T) {
+	tlsDir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(tlsDir)
+	certFile, certPEM, keyFile := writeSelfSignedCert(t, tlsDir)
+
+	// only setting one of cert and key is a configuration error
+	config := NewConfig()
+	config.TLSCertFile = certFile
+	err := NewServerWithLogger(config, NewMockBackend(), logrus.New()).Start()
+	assert.EqualError(t, err, "TLSCertFile and TLSKeyFile must both be set to serve HTTPS")
+
+	config = NewConfig()
+	config.TLSCertFile = certFile
+	config.TLSKeyFile = keyFile
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Get("https://localhost:8080/")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.True(t, resp.TLS.HandshakeComplete)
+		assert.True(t, resp.TLS.Version >= tls.VersionTLS12)
+		assert.Equal(t, 1, resp.ProtoMajor)
+	}
+
+	// clients which support HTTP/2 negotiate it
+	h2Client := &http.Client{Transport: &http2.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err = h2Client.Get("https://localhost:8080/")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, 2, resp.ProtoMajor)
+	}
+
+	// plain HTTP is no longer served
+	resp, err = http.Get("http://localhost:8080/")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, 400, resp.StatusCode)
+	}
+}
+
+func TestServerH2C(t *testing.T) {
+	config := NewConfig()
+	config.EnableH2C = true
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	// clients can speak HTTP/2 over plain HTTP, as proxies in front of us would
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS:   func(network, addr string, cfg *tls.Config) (net.Conn, error) { return net.Dial(network, addr) },
+	}}
+
+	resp, err := h2cClient.Get("http://localhost:8080/")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, 2, resp.ProtoMajor)
+	}
+
+	// and HTTP/1.1 still works
+	resp, err = http.Get("http://localhost:8080/")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, 1, resp.ProtoMajor)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	config := NewConfig()
+	config.HTTPReadTimeout = 120
+	config.HTTPWriteTimeout = 0
+	config.HTTPIdleTimeout = 5
+
+	s := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	s.Start()
+	defer s.Stop()
+
+	httpServer := s.(*server).httpServer
+	assert.Equal(t, 120*time.Second, httpServer.ReadTimeout)
+	assert.Equal(t, 30*time.Second, httpServer.WriteTimeout)
+	assert.Equal(t, 5*time.Second, httpServer.IdleTimeout)
+}
+
+func TestServerShutdown(t *testing.T) {
+	server := NewServerWithLogger(NewConfig(), NewMockBackend(), logrus.New())
+
+	// add a route which takes a while to respond
+	server.Router().Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("finally"))
+	})
+
+	server.Start()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	var rr *utils.RequestResponse
+	var err error
+	done := make(chan bool)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/slow", nil)
+		rr, err = utils.MakeHTTPRequest(req)
+		close(done)
+	}()
+
+	// stop our server while our request is still in flight
+	time.Sleep(100 * time.Millisecond)
+	server.Stop()
+	<-done
+
+	// our request should have been allowed to finish
+	assert.NoError(t, err)
+	assert.Equal(t, 200, rr.StatusCode)
+	assert.Equal(t, "finally", string(rr.Body))
+}
+
+func TestServerMetrics(t *testing.T) {
+	config := NewConfig()
+	config.EnableMetrics = true
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	server := NewServerWithLogger(config, mb, logrus.New())
+	server.Start()
+	defer server.Stop()
+
+	// wait for server to come up
+	time.Sleep(100 * time.Millisecond)
+
+	// receive a message through our dummy handler
+	req, _ := http.NewRequest("GET", "http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil)
+	_, err := utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+
+	// and write a status for it
+	status := mb.NewMsgStatusForID(channel, NewMsgID(10), MsgSent)
+	server.Backend().WriteMsgStatus(context.Background(), status)
+
+	// and one for a msg our provider failed to send
+	status = mb.NewMsgStatusForID(channel, NewMsgID(11), MsgErrored)
+	status.SetErrorCategory(SendErrorAuth)
+	server.Backend().WriteMsgStatus(context.Background(), status)
+
+	req, _ = http.NewRequest("GET", "http://localhost:8080/metrics", nil)
+	rr, err := utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+
+	body := string(rr.Body)
+	assert.Contains(t, body, `courier_msg_writes_total{channel_type="DM",result="success"} 1`)
+	assert.Contains(t, body, `courier_msg_status_writes_total{channel_type="DM",result="success"} 2`)
+	assert.Contains(t, body, `courier_send_errors_total{category="auth",channel_type="DM"} 1`)
+	assert.Contains(t, body, `courier_handler_duration_seconds_count{action="receive",channel_type="DM"} 1`)
+	assert.Contains(t, body, "courier_media_downloads 0")
+}
+
+func TestServerPprof(t *testing.T) {
+	getPprof := func(url string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr, _ := utils.MakeHTTPRequest(req)
+		if rr == nil {
+			return 0
+		}
+		return rr.StatusCode
+	}
+
+	// not exposed by default
+	server := NewServerWithLogger(NewConfig(), NewMockBackend(), logrus.New())
+	server.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 404, getPprof("http://localhost:8080/debug/pprof/"))
+	server.Stop()
+
+	// exposed on our main port if enabled without a port of its own
+	config := NewConfig()
+	config.EnablePprof = true
+	server = NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	server.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 200, getPprof("http://localhost:8080/debug/pprof/"))
+	assert.Equal(t, 200, getPprof("http://localhost:8080/debug/pprof/goroutine?debug=1"))
+	server.Stop()
+
+	// and only on its internal port if it has one
+	config.PprofPort = 8081
+	server = NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	server.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 404, getPprof("http://localhost:8080/debug/pprof/"))
+	assert.Equal(t, 200, getPprof("http://localhost:8081/debug/pprof/"))
+	assert.Equal(t, 200, getPprof("http://localhost:8081/debug/pprof/heap?debug=1"))
+	server.Stop()
+}
+
+func TestServerAdminPort(t *testing.T) {
+	get := func(url string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr, _ := utils.MakeHTTPRequest(req)
+		if rr == nil {
+			return 0
+		}
+		return rr.StatusCode
+	}
+
+	config := NewConfig()
+	config.EnableMetrics = true
+	config.EnablePprof = true
+	config.AdminPort = 8081
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// our admin pages are only on our admin port
+	for _, p := range []string{"/metrics", "/health", "/ready", "/debug/pprof/"} {
+		assert.Equal(t, 404, get("http://localhost:8080"+p), "%s should not be on our main port", p)
+		assert.Equal(t, 200, get("http://localhost:8081"+p), "%s should be on our admin port", p)
+	}
+
+	// and our index and channel endpoints are only on our main port
+	assert.Equal(t, 200, get("http://localhost:8080/"))
+	assert.Equal(t, 404, get("http://localhost:8081/"))
+	assert.Equal(t, 404, get("http://localhost:8081/c/status/xx/53e5aafa-8155-449d-9009-fcb30d54bd26"))
+}
+
+func TestServerCompression(t *testing.T) {
+	get := func(acceptEncoding string) *http.Response {
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	server := NewServerWithLogger(NewConfig(), NewMockBackend(), logrus.New())
+	server.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	// clients which accept gzip get it
+	resp := get("gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	reader, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(reader)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "courier")
+
+	// others get plain text
+	resp = get("")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "courier")
+	server.Stop()
+
+	// and nobody does if compression is disabled
+	config := NewConfig()
+	config.EnableCompression = false
+	server = NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	resp = get("gzip")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "courier")
+}
+
+// flakyBackend is a mock backend which fails to start until it has been started a number of times
+type flakyBackend struct {
+	*MockBackend
+	failures int
+	starts   int
+}
+
+func (b *flakyBackend) Start() error {
+	b.starts++
+	if b.starts <= b.failures {
+		return errors.New("redis not reachable")
+	}
+	return nil
+}
+
+func TestServerBackendStartRetries(t *testing.T) {
+	config := NewConfig()
+	config.BackendStartRetries = 2
+	config.BackendStartBackoff = 1
+
+	// failing twice is within our retries
+	backend := &flakyBackend{MockBackend: NewMockBackend(), failures: 2}
+	s := NewServerWithLogger(config, backend, logrus.New()).(*server)
+	assert.NoError(t, s.startBackend())
+	assert.Equal(t, 3, backend.starts)
+
+	// but once we run out of retries we give up with the last error
+	backend = &flakyBackend{MockBackend: NewMockBackend(), failures: 3}
+	s = NewServerWithLogger(config, backend, logrus.New()).(*server)
+	assert.EqualError(t, s.startBackend(), "redis not reachable")
+	assert.Equal(t, 3, backend.starts)
+
+	// and without retries we only try once
+	config.BackendStartRetries = 0
+	backend = &flakyBackend{MockBackend: NewMockBackend(), failures: 1}
+	s = NewServerWithLogger(config, backend, logrus.New()).(*server)
+	assert.Error(t, s.startBackend())
+	assert.Equal(t, 1, backend.starts)
 }
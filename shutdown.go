@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WaitForShutdown blocks until we receive a SIGTERM or SIGINT, then drains the passed in server for our DrainPeriod
+// before stopping it. A second signal while this is happening exits immediately.
+func WaitForShutdown(s Server) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	return waitForShutdown(s, signals, func() { os.Exit(1) })
+}
+
+func waitForShutdown(s Server, signals <-chan os.Signal, forceExit func()) error {
+	log := logrus.WithField("comp", "main")
+	log.WithField("signal", <-signals).Info("stopping")
+
+	// if we get signalled again, give up on stopping cleanly
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.WithField("signal", sig).Warn("signalled again, exiting immediately")
+			forceExit()
+		case <-done:
+		}
+	}()
+
+	// stop taking on new work and give what's in flight a chance to finish
+	s.Drain()
+	time.Sleep(time.Second * time.Duration(s.Config().DrainPeriod))
+
+	return s.Stop()
+}
+
+// ErrUncleanShutdown is returned by Stop when some of our components were still running after our ShutdownTimeout, so
+// were abandoned rather than stopped
+var ErrUncleanShutdown = errors.New("unclean shutdown, components still running after shutdown timeout")
+
+// componentTracker counts the goroutines running each of our components, so that if they don't all stop when we are
+// stopped we can say which are still running
+type componentTracker struct {
+	mutex   sync.Mutex
+	running map[string]int
+}
+
+func newComponentTracker() *componentTracker {
+	return &componentTracker{running: make(map[string]int)}
+}
+
+func (t *componentTracker) start(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.running[name]++
+}
+
+func (t *componentTracker) done(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.running[name]--
+	if t.running[name] <= 0 {
+		delete(t.running, name)
+	}
+}
+
+// stillRunning returns the names of the components which are still running, in order
+func (t *componentTracker) stillRunning() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	names := make([]string, 0, len(t.running))
+	for name := range t.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// waitWithContext waits for the passed in wait group until the passed in context is done, returning whether it finished
+func waitWithContext(ctx context.Context, waitGroup *sync.WaitGroup) bool {
+	finished := make(chan bool)
+	go func() {
+		waitGroup.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,165 @@
+package courier
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shutdownServer records the calls made to it while shutting down
+type shutdownServer struct {
+	Server
+	config  *Config
+	stopped chan bool
+
+	mutex sync.Mutex
+	calls []string
+}
+
+func (s *shutdownServer) Config() *Config { return s.config }
+func (s *shutdownServer) Drain()          { s.record("drain") }
+func (s *shutdownServer) Stop() error {
+	s.record("stop")
+	<-s.stopped
+	return nil
+}
+
+func (s *shutdownServer) record(call string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *shutdownServer) recorded() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+func TestWaitForShutdown(t *testing.T) {
+	config := NewConfig()
+	config.DrainPeriod = 0
+
+	s := &shutdownServer{config: config, stopped: make(chan bool)}
+	signals := make(chan os.Signal, 2)
+	forced := make(chan bool, 1)
+	done := make(chan error)
+
+	go func() {
+		done <- waitForShutdown(s, signals, func() { forced <- true })
+	}()
+
+	// nothing happens until we're signalled
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(s.recorded()))
+
+	// then we drain before stopping
+	signals <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"drain", "stop"}, s.recorded())
+
+	// a second signal while we're still stopping forces us to exit
+	signals <- syscall.SIGINT
+	select {
+	case <-forced:
+	case <-time.After(time.Second):
+		assert.Fail(t, "second signal didn't force an exit")
+	}
+
+	close(s.stopped)
+	assert.NoError(t, <-done)
+}
+
+func TestWaitForShutdownDrainPeriod(t *testing.T) {
+	config := NewConfig()
+	config.DrainPeriod = 1
+
+	s := &shutdownServer{config: config, stopped: make(chan bool)}
+	close(s.stopped)
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+
+	start := time.Now()
+	err := waitForShutdown(s, signals, func() { assert.Fail(t, "shouldn't force an exit") })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drain", "stop"}, s.recorded())
+	assert.True(t, time.Since(start) >= time.Second)
+}
+
+func TestStopTimeout(t *testing.T) {
+	config := testConfig()
+	config.ShutdownTimeout = 1
+
+	// with nothing stuck we stop cleanly
+	s := NewServer(config, NewMockBackend())
+	assert.NoError(t, s.Start())
+	assert.NoError(t, s.Stop())
+
+	s = NewServer(config, NewMockBackend())
+	assert.NoError(t, s.Start())
+
+	// a component which never stops
+	stuck := make(chan bool)
+	defer close(stuck)
+	done := s.TrackComponent("stuck")
+	go func() {
+		<-stuck
+		done()
+	}()
+
+	// doesn't stop us stopping, but we say it wasn't clean and what was left running
+	start := time.Now()
+	assert.Equal(t, ErrUncleanShutdown, s.Stop())
+	assert.True(t, time.Since(start) < 3*time.Second)
+	assert.Equal(t, []string{"stuck"}, s.(*server).components.stillRunning())
+}
+
+func TestStopSharesTimeout(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := testConfig()
+	config.ShutdownTimeout = 1
+	config.SpoolDir = spoolDir
+	config.SpoolFlushInterval = 60
+
+	// a spool which takes a long time to flush
+	RegisterFlusher(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error {
+		time.Sleep(400 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+
+	s := NewServer(config, NewMockBackend())
+	assert.NoError(t, s.Start())
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]int{"id": i}))
+	}
+
+	// and a component which never stops
+	stuck := make(chan bool)
+	defer close(stuck)
+	done := s.TrackComponent("stuck")
+	go func() {
+		<-stuck
+		done()
+	}()
+
+	// only get our one timeout between them
+	start := time.Now()
+	assert.Equal(t, ErrUncleanShutdown, s.Stop())
+	assert.True(t, time.Since(start) < 1500*time.Millisecond, "took %s to stop", time.Since(start))
+
+	files, _ := ioutil.ReadDir(path.Join(spoolDir, "msgs"))
+	assert.True(t, len(files) > 0)
+}
@@ -0,0 +1,85 @@
+package courier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// HashAlgo is a hash algorithm used by a channel to sign its requests
+type HashAlgo string
+
+const (
+	// HashSHA1 is HMAC-SHA1 signing
+	HashSHA1 = HashAlgo("sha1")
+
+	// HashSHA256 is HMAC-SHA256 signing
+	HashSHA256 = HashAlgo("sha256")
+)
+
+// ErrMissingSignature is returned when a request doesn't include the header containing its signature
+var ErrMissingSignature = errors.New("missing request signature")
+
+// ErrInvalidSignature is returned when the signature of a request doesn't match the one we calculate
+var ErrInvalidSignature = errors.New("invalid request signature")
+
+// ValidateSignature checks that the signature in the passed in header matches the HMAC of the request body
+// calculated using the passed in secret and algorithm. Signatures can be hex or base64 encoded and may be
+// prefixed with the algorithm name, e.g. "sha1=...". The request body is restored so it can still be read
+// by the caller afterwards.
+func ValidateSignature(secret string, r *http.Request, headerName string, algo HashAlgo) error {
+	var hashFunc func() hash.Hash
+	switch algo {
+	case HashSHA1:
+		hashFunc = sha1.New
+	case HashSHA256:
+		hashFunc = sha256.New
+	default:
+		return fmt.Errorf("unsupported signature algorithm: %s", algo)
+	}
+
+	actual := strings.TrimPrefix(r.Header.Get(headerName), string(algo)+"=")
+	if actual == "" {
+		return ErrMissingSignature
+	}
+
+	body := []byte{}
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("unable to read request body: %s", err)
+		}
+	}
+
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	// providers vary in how they encode their signatures, try hex first then base64
+	decoded, err := hex.DecodeString(actual)
+	if err != nil || len(decoded) != len(expected) {
+		decoded, err = base64.StdEncoding.DecodeString(actual)
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	}
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal(expected, decoded) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
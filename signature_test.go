@@ -0,0 +1,50 @@
+package courier
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSignature(t *testing.T) {
+	tcs := []struct {
+		label     string
+		body      string
+		signature string
+		algo      HashAlgo
+		err       error
+	}{
+		{"valid sha1 hex", "hello world", "03376ee7ad7bbfceee98660439a4d8b125122a5a", HashSHA1, nil},
+		{"valid sha1 hex with prefix", "hello world", "sha1=03376ee7ad7bbfceee98660439a4d8b125122a5a", HashSHA1, nil},
+		{"valid sha256 hex", "hello world", "734cc62f32841568f45715aeb9f4d7891324e6d948e4c6c60c0621cdac48623a", HashSHA256, nil},
+		{"valid sha256 base64", "hello world", "c0zGLzKEFWj0VxWuufTXiRMk5tlI5MbGDAYhzaxIYjo=", HashSHA256, nil},
+		{"valid sha256 empty body", "", "f9e66e179b6747ae54108f82f8ade8b3c25d76fd30afde6c395822c530196169", HashSHA256, nil},
+		{"wrong signature", "hello world", "734cc62f32841568f45715aeb9f4d7891324e6d948e4c6c60c0621cdac48623b", HashSHA256, ErrInvalidSignature},
+		{"wrong algorithm", "hello world", "03376ee7ad7bbfceee98660439a4d8b125122a5a", HashSHA256, ErrInvalidSignature},
+		{"tampered body", "hello world!", "734cc62f32841568f45715aeb9f4d7891324e6d948e4c6c60c0621cdac48623a", HashSHA256, ErrInvalidSignature},
+		{"not encoded", "hello world", "not a signature", HashSHA256, ErrInvalidSignature},
+		{"missing signature", "hello world", "", HashSHA256, ErrMissingSignature},
+	}
+
+	for _, tc := range tcs {
+		r := httptest.NewRequest(http.MethodPost, "/receive", bytes.NewBufferString(tc.body))
+		if tc.signature != "" {
+			r.Header.Set("X-Signature", tc.signature)
+		}
+
+		err := ValidateSignature("secret", r, "X-Signature", tc.algo)
+		assert.Equal(t, tc.err, err, "unexpected error for %s", tc.label)
+
+		// body should still be readable
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, tc.body, string(body), "body not preserved for %s", tc.label)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/receive", nil)
+	r.Header.Set("X-Signature", "abc")
+	assert.EqualError(t, ValidateSignature("secret", r, "X-Signature", HashAlgo("md5")), "unsupported signature algorithm: md5")
+}
@@ -1,19 +1,32 @@
 package courier
 
 import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// ErrSpoolFull is returned when writing to a spool directory which already holds MaxSpoolFiles files
+var ErrSpoolFull = errors.New("spool is full")
+
 // FlusherFunc defines our interface for flushers, they are handed a filename and byte blob and are expected
 // to try to flush that to the db, returning an error if the db is still down
 type FlusherFunc func(filename string, contents []byte) error
@@ -23,33 +36,202 @@ func RegisterFlusher(directory string, flusherFunc FlusherFunc) {
 	registeredFlushers = append(registeredFlushers, &flusherRegistration{directory, flusherFunc})
 }
 
-// WriteToSpool writes the passed in object to the passed in subdir
+// RequestSpoolFlush asks for our spool to be flushed now rather than at the next SpoolFlushInterval, e.g. because a
+// backend which was down is back up
+func RequestSpoolFlush() {
+	select {
+	case spoolFlushRequests <- true:
+	default:
+	}
+}
+
+// WriteToSpool writes the passed in object to the passed in subdir, encrypted if we have a spool encryption key. If
+// the subdir already holds as many files as our spool limit allows, ErrSpoolFull is returned instead.
 func WriteToSpool(spoolDir string, subdir string, contents interface{}) error {
 	contentBytes, err := json.MarshalIndent(contents, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	filename := path.Join(spoolDir, subdir, fmt.Sprintf("%d.json", time.Now().UnixNano()))
+	directory := path.Join(spoolDir, subdir)
+	if !reserveSpoolFile(directory) {
+		return ErrSpoolFull
+	}
+
+	err = writeSpoolFile(directory, contentBytes)
+	if err != nil {
+		releaseSpoolFile(directory)
+	}
+	return err
+}
+
+// writes the passed in contents to a new file in the passed in spool directory
+func writeSpoolFile(directory string, contentBytes []byte) error {
+	var err error
+	filename := path.Join(directory, fmt.Sprintf("%d.json", time.Now().UnixNano()))
+
+	spoolCipherMutex.RLock()
+	gcm := spoolCipher
+	spoolCipherMutex.RUnlock()
+
+	if gcm != nil {
+		contentBytes, err = encryptSpoolContents(gcm, contentBytes)
+		if err != nil {
+			return err
+		}
+		filename += encryptedSpoolSuffix
+	}
+
 	return ioutil.WriteFile(filename, contentBytes, 0640)
 }
 
-// starts our spool flusher, which every 30 seconds tries to write our pending msgs and statuses
-func startSpoolFlushers(s Server) {
+// ConfigureSpoolLimit sets the number of files each spool directory can hold, 0 meaning there is no limit
+func ConfigureSpoolLimit(maxFiles int) {
+	spoolDepthMutex.Lock()
+	defer spoolDepthMutex.Unlock()
+
+	spoolLimit = maxFiles
+	spoolDepths = make(map[string]int)
+}
+
+// reserves room for a new file in the passed in spool directory, returning false if it is full
+func reserveSpoolFile(directory string) bool {
+	spoolDepthMutex.Lock()
+	defer spoolDepthMutex.Unlock()
+
+	if spoolLimit <= 0 {
+		return true
+	}
+
+	// the first time we write to a directory we need to count what is already there
+	depth, found := spoolDepths[directory]
+	if !found {
+		depth = countSpoolFiles(directory)
+	}
+	if depth >= spoolLimit {
+		spoolDepths[directory] = depth
+		return false
+	}
+
+	spoolDepths[directory] = depth + 1
+	return true
+}
+
+// releases room reserved for a file in the passed in spool directory which couldn't be written
+func releaseSpoolFile(directory string) {
+	spoolDepthMutex.Lock()
+	defer spoolDepthMutex.Unlock()
+
+	if depth, found := spoolDepths[directory]; found && depth > 0 {
+		spoolDepths[directory] = depth - 1
+	}
+}
+
+// records the number of files in the passed in spool directory, as counted after flushing it
+func recordSpoolDepth(directory string, depth int) {
+	spoolDepthMutex.Lock()
+	defer spoolDepthMutex.Unlock()
+
+	if spoolLimit > 0 {
+		spoolDepths[directory] = depth
+	}
+}
+
+// encrypted spool files have their own suffix so that we can still flush any plaintext files spooled before a key was set
+const encryptedSpoolSuffix = ".enc"
+
+// ConfigureSpoolEncryption sets the base64 encoded AES-256 key spool files are encrypted with, an empty key means they
+// are written as plaintext
+func ConfigureSpoolEncryption(key string) error {
+	var gcm cipher.AEAD
+
+	if key != "" {
+		keyBytes, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("spool encryption key must be base64 encoded: %s", err)
+		}
+		if len(keyBytes) != 32 {
+			return fmt.Errorf("spool encryption key must be 32 bytes for AES-256, got %d", len(keyBytes))
+		}
+
+		block, err := aes.NewCipher(keyBytes)
+		if err != nil {
+			return err
+		}
+		gcm, err = cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	spoolCipherMutex.Lock()
+	spoolCipher = gcm
+	spoolCipherMutex.Unlock()
+	return nil
+}
+
+// encrypts the passed in contents, prefixing them with the random nonce they were sealed with
+func encryptSpoolContents(gcm cipher.AEAD, contents []byte) ([]byte, error) {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, contents, nil), nil
+}
+
+// decrypts the passed in contents, written by encryptSpoolContents
+func decryptSpoolContents(gcm cipher.AEAD, contents []byte) ([]byte, error) {
+	if gcm == nil {
+		return nil, errors.New("spool file is encrypted but no spool encryption key is set")
+	}
+	if len(contents) < gcm.NonceSize() {
+		return nil, errors.New("unable to decrypt spool file, it is too short")
+	}
+
+	nonce, ciphertext := contents[:gcm.NonceSize()], contents[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt spool file, either the spool encryption key is wrong or the file has been modified")
+	}
+	return plaintext, nil
+}
+
+// whether the passed in filename is one of our spool files, plaintext or encrypted
+func isSpoolFile(filename string) bool {
+	return strings.HasSuffix(filename, ".json") || strings.HasSuffix(filename, ".json"+encryptedSpoolSuffix)
+}
+
+// checkSpoolDir checks that we are able to write files to the passed in spool directory
+func checkSpoolDir(spoolDir string) error {
+	file, err := ioutil.TempFile(spoolDir, "health")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// starts our spool flusher, which every SpoolFlushInterval seconds tries to write our pending msgs and statuses
+func startSpoolFlushers(s *server) {
+	interval := time.Second * time.Duration(s.config.SpoolFlushInterval)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
 	// create our actual flushers
 	flushers = make([]*flusher, len(registeredFlushers))
 	for i, reg := range registeredFlushers {
-		flushers[i] = newSpoolFlusher(s, reg.directory, reg.flusher)
+		flushers[i] = newSpoolFlusher(reg.directory, reg.flusher)
 	}
 
+	done := s.TrackComponent("spool")
 	go func() {
-		s.WaitGroup().Add(1)
-		defer s.WaitGroup().Done()
+		defer done()
 
 		log := logrus.WithField("comp", "spool")
 		log.WithField("state", "started").Info("spool started")
 
-		// runs until stopped, checking every 30 seconds if there is anything to flush from our spool
+		// runs until stopped, checking every interval if there is anything to flush from our spool
 		for {
 			select {
 
@@ -58,16 +240,151 @@ func startSpoolFlushers(s Server) {
 				log.WithField("state", "stopped").Info("spool stopped")
 				return
 
-			// every 30 seconds we check to see if there are any files to spool
-			case <-time.After(30 * time.Second):
-				for _, flusher := range flushers {
-					filepath.Walk(flusher.directory, flusher.walker)
-				}
+			// every interval, or when asked to, we check to see if there are any files to spool
+			case <-time.After(interval):
+			case <-spoolFlushRequests:
 			}
+
+			flushSpools(s)
 		}
 	}()
 }
 
+// flushSpools drains each of our spool directories, recording how many files are left in each once done
+func flushSpools(s *server) {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	for _, flusher := range flushers {
+		drainSpool(flusher.directory, flusher.flush, s.config.SpoolFlushWorkers, s.Stopped)
+		recordFlusherDepth(s, flusher)
+	}
+}
+
+// purgeSpools removes the files in each of our spool directories, or just that of the passed in type if one is given,
+// which were spooled longer ago than olderThan, returning how many were removed
+func purgeSpools(s *server, spoolType string, olderThan time.Duration) (int, error) {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+
+	for _, flusher := range flushers {
+		if spoolType != "" && path.Base(flusher.directory) != spoolType {
+			continue
+		}
+
+		files, err := listSpoolFiles(flusher.directory)
+		if err != nil {
+			return purged, err
+		}
+		for _, file := range files {
+			if !file.spooledOn.Before(cutoff) {
+				break
+			}
+			err = os.Remove(file.filename)
+			if err != nil && !os.IsNotExist(err) {
+				return purged, err
+			}
+			logrus.WithField("comp", "spool").WithField("filename", file.filename).WithField("spooled_on", file.spooledOn).Warn("purged spool file")
+			purged++
+		}
+		recordFlusherDepth(s, flusher)
+	}
+	return purged, nil
+}
+
+// records how many files are left in the spool directory of the passed in flusher
+func recordFlusherDepth(s *server, flusher *flusher) {
+	depth := countSpoolFiles(flusher.directory)
+	s.metrics.setSpoolDepth(flusher.directory, depth)
+	recordSpoolDepth(flusher.directory, depth)
+}
+
+// spoolStats describes what is waiting to be flushed in one of our spool directories, its type being the name of
+// the directory, e.g. msgs
+type spoolStats struct {
+	Type      string     `json:"type"`
+	Count     int        `json:"count"`
+	Oldest    *time.Time `json:"oldest,omitempty"`
+	OldestAge int        `json:"oldest_age"`
+}
+
+// getSpoolStats returns the stats for each of our spool directories, in the order their flushers were registered
+func getSpoolStats() ([]*spoolStats, error) {
+	now := time.Now()
+	stats := make([]*spoolStats, 0, len(flushers))
+
+	for _, flusher := range flushers {
+		files, err := listSpoolFiles(flusher.directory)
+		if err != nil {
+			return nil, err
+		}
+
+		dirStats := &spoolStats{Type: path.Base(flusher.directory), Count: len(files)}
+		if len(files) > 0 {
+			oldest := files[0].spooledOn.UTC()
+			dirStats.Oldest = &oldest
+			dirStats.OldestAge = int(now.Sub(oldest) / time.Second)
+		}
+		stats = append(stats, dirStats)
+	}
+	return stats, nil
+}
+
+// a file in one of our spool directories and when it was spooled
+type spoolFile struct {
+	filename  string
+	spooledOn time.Time
+}
+
+// lists the spool files in the passed in directory, oldest first
+func listSpoolFiles(dir string) ([]spoolFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]spoolFile, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !isSpoolFile(info.Name()) {
+			continue
+		}
+
+		// our files are named by when they were written, falling back to when they were modified for any which aren't
+		spooledOn := info.ModTime()
+		nanos, err := strconv.ParseInt(strings.SplitN(info.Name(), ".", 2)[0], 10, 64)
+		if err == nil {
+			spooledOn = time.Unix(0, nanos)
+		}
+		files = append(files, spoolFile{filename: path.Join(dir, info.Name()), spooledOn: spooledOn})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].spooledOn.Before(files[j].spooledOn) })
+	return files, nil
+}
+
+// flushSpoolOnStop makes one last attempt to flush our spool as we stop, so anything spooled since the last flush
+// isn't left on disk until we are next started. Files which still can't be flushed, or aren't before the passed in
+// context is done, are left in place.
+func flushSpoolOnStop(ctx context.Context, s *server) {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	log := logrus.WithField("comp", "spool").WithField("state", "stopping")
+	log.Info("flushing spool before stopping")
+
+	timedOut := func() bool { return ctx.Err() != nil }
+
+	for _, flusher := range flushers {
+		err := filepath.Walk(flusher.directory, newSpoolWalker(flusher.directory, flusher.flush, timedOut))
+		if err != nil {
+			log.WithError(err).WithField("directory", flusher.directory).Error("unable to flush spool before stopping")
+		}
+	}
+}
+
 // EnsureSpoolDirPresent checks that the passed in spool directory is present and writable
 func EnsureSpoolDirPresent(spoolDir string, subdir string) (err error) {
 	msgsDir := path.Join(spoolDir, subdir)
@@ -78,14 +395,70 @@ func EnsureSpoolDirPresent(spoolDir string, subdir string) (err error) {
 }
 
 // creates a new spool flusher
-func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
-	return &flusher{func(filename string, info os.FileInfo, err error) error {
+func newSpoolFlusher(dir string, flusherFunc FlusherFunc) *flusher {
+	return &flusher{dir, flusherFunc}
+}
+
+// drainSpool flushes the files in dir, oldest first, with up to the passed in number of workers flushing at once. It
+// stops at the first file which can't be flushed, as that means our backend is still down, or once stopped returns true.
+func drainSpool(dir string, flusherFunc FlusherFunc, workers int, stopped func() bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	filenames := make(chan string)
+	var failed int32
+	var flushErr error
+	var errOnce sync.Once
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range filenames {
+				err := flushSpoolFile(filename, flusherFunc)
+				if err != nil {
+					atomic.StoreInt32(&failed, 1)
+					errOnce.Do(func() { flushErr = err })
+				}
+			}
+		}()
+	}
+
+	// ReadDir sorts by name, and our files are named by when they were written
+	for _, file := range files {
+		if atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+		if stopped() {
+			errOnce.Do(func() { flushErr = errors.New("spool flush process stopped") })
+			break
+		}
+		if file.IsDir() || !isSpoolFile(file.Name()) {
+			continue
+		}
+		filenames <- path.Join(dir, file.Name())
+	}
+	close(filenames)
+	wg.Wait()
+
+	return flushErr
+}
+
+// creates a new walker which flushes the files in dir with the passed in flusher func until stopped returns true
+func newSpoolWalker(dir string, flusherFunc FlusherFunc, stopped func() bool) filepath.WalkFunc {
+	return func(filename string, info os.FileInfo, err error) error {
 		if filename == dir {
 			return nil
 		}
 
 		// we've been stopped, exit
-		if s.Stopped() {
+		if stopped() {
 			return errors.New("spool flush process stopped")
 		}
 
@@ -94,43 +467,68 @@ func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
 			return filepath.SkipDir
 		}
 
-		// ignore non-json files
-		if !strings.HasSuffix(filename, ".json") {
+		// ignore anything which isn't a spool file
+		if !isSpoolFile(filename) {
 			return nil
 		}
 
-		log := logrus.WithField("comp", "spool").WithField("filename", filename)
+		return flushSpoolFile(filename, flusherFunc)
+	}
+}
+
+// flushes the passed in spool file with the passed in flusher func, removing it if that succeeds. Only failing to flush
+// is an error, files we can't read or decrypt are logged and left in place so they aren't lost.
+func flushSpoolFile(filename string, flusherFunc FlusherFunc) error {
+	log := logrus.WithField("comp", "spool").WithField("filename", filename)
 
-		// otherwise, read our msg json
-		contents, err := ioutil.ReadFile(filename)
-		if err != nil {
-			log.WithError(err).Error("reading spool file")
-			return nil
-		}
+	// read our msg json
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.WithError(err).Error("reading spool file")
+		return nil
+	}
+
+	// decrypting it first if it is encrypted, leaving it in place if we can't so it isn't lost
+	if strings.HasSuffix(filename, encryptedSpoolSuffix) {
+		spoolCipherMutex.RLock()
+		gcm := spoolCipher
+		spoolCipherMutex.RUnlock()
 
-		err = flusherFunc(filename, contents)
+		contents, err = decryptSpoolContents(gcm, contents)
 		if err != nil {
-			log.WithError(err).Error("flushing spool file")
-			return err
+			log.WithError(err).Error("decrypting spool file")
+			return nil
 		}
-		log.Info("flushed")
+	}
 
-		// we flushed, remove our file if it is still present
-		if _, e := os.Stat(filename); e == nil {
-			err = os.Remove(filename)
-		}
+	err = flusherFunc(filename, contents)
+	if err != nil {
+		log.WithError(err).Error("flushing spool file")
 		return err
-	}, dir}
+	}
+	log.Info("flushed")
+
+	// we flushed, remove our file if it is still present
+	if _, e := os.Stat(filename); e == nil {
+		err = os.Remove(filename)
+	}
+	return err
 }
 
-// simple struct that represents our walking function and the directory that gets walked
+// simple struct that represents a spool directory and the function its files are flushed with
 type flusher struct {
-	walker    filepath.WalkFunc
 	directory string
+	flush     FlusherFunc
 }
 
 var flushers []*flusher
 
+// held while walking our flushers so we never flush the same file twice at once
+var flushMutex sync.Mutex
+
+// requests for our spool to be flushed now, buffered so that requests made while we are already flushing aren't lost
+var spoolFlushRequests = make(chan bool, 1)
+
 // simple struct to keep track of who has registered to flush and for what directories
 type flusherRegistration struct {
 	directory string
@@ -138,3 +536,12 @@ type flusherRegistration struct {
 }
 
 var registeredFlushers []*flusherRegistration
+
+// the cipher we encrypt and decrypt spool files with, nil if they aren't encrypted
+var spoolCipher cipher.AEAD
+var spoolCipherMutex sync.RWMutex
+
+// the number of files each spool directory can hold, and how many we think each holds, 0 meaning there is no limit
+var spoolLimit int
+var spoolDepths = make(map[string]int)
+var spoolDepthMutex sync.Mutex
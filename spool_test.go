@@ -0,0 +1,272 @@
+package courier
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushSpoolOnStop(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := NewConfig()
+	config.SpoolDir = spoolDir
+
+	// one directory which flushes and one we can't flush because our backend is down
+	flushed := make([]string, 0)
+	RegisterFlusher(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error {
+		flushed = append(flushed, string(contents))
+		return nil
+	})
+	RegisterFlusher(path.Join(spoolDir, "statuses"), func(filename string, contents []byte) error {
+		return errors.New("db is down")
+	})
+
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "statuses"))
+
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	server.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	// spool some things between flushes
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "hello"}))
+	assert.NoError(t, WriteToSpool(spoolDir, "statuses", map[string]string{"status": "D"}))
+
+	server.Stop()
+
+	// our msg should have been flushed and removed
+	assert.Equal(t, []string{"{\n  \"text\": \"hello\"\n}"}, flushed)
+	msgFiles, _ := filepath.Glob(path.Join(spoolDir, "msgs", "*.json"))
+	assert.Equal(t, 0, len(msgFiles))
+
+	// but our status is left in place for next time
+	statusFiles, _ := filepath.Glob(path.Join(spoolDir, "statuses", "*.json"))
+	assert.Equal(t, 1, len(statusFiles))
+}
+
+func TestSpoolFlushInterval(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := NewConfig()
+	config.SpoolDir = spoolDir
+	config.SpoolFlushInterval = 1
+
+	flushed := make(chan string, 1)
+	RegisterFlusher(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error {
+		flushed <- string(contents)
+		return nil
+	})
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "hello"}))
+
+	// our flusher should pick it up within our interval
+	select {
+	case contents := <-flushed:
+		assert.Equal(t, "{\n  \"text\": \"hello\"\n}", contents)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "spooled msg wasn't flushed within interval")
+	}
+}
+
+func TestRequestSpoolFlush(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := NewConfig()
+	config.SpoolDir = spoolDir
+	config.SpoolFlushInterval = 60
+
+	flushed := make(chan string, 1)
+	RegisterFlusher(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error {
+		flushed <- string(contents)
+		return nil
+	})
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+
+	server := NewServerWithLogger(config, NewMockBackend(), logrus.New())
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "hello"}))
+
+	// asking for a flush, e.g. because our backend reconnected, doesn't wait for our interval
+	RequestSpoolFlush()
+	select {
+	case contents := <-flushed:
+		assert.Equal(t, "{\n  \"text\": \"hello\"\n}", contents)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "spooled msg wasn't flushed when requested")
+	}
+}
+
+func TestStartWithUnwritableSpool(t *testing.T) {
+	config := NewConfig()
+	config.SpoolDir = "/does/not/exist"
+
+	err := NewServerWithLogger(config, NewMockBackend(), logrus.New()).Start()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "spool directory '/does/not/exist' is not writable")
+}
+
+func TestEncryptedSpool(t *testing.T) {
+	defer ConfigureSpoolEncryption("")
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+
+	flushed := make([]string, 0)
+	walker := newSpoolWalker(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error {
+		flushed = append(flushed, string(contents))
+		return nil
+	}, func() bool { return false })
+	flush := func() error { return filepath.Walk(path.Join(spoolDir, "msgs"), walker) }
+
+	// keys must be base64 encoded and the right length
+	assert.EqualError(t, ConfigureSpoolEncryption("not base64!"), "spool encryption key must be base64 encoded: illegal base64 data at input byte 3")
+	assert.EqualError(t, ConfigureSpoolEncryption("c2hvcnQ="), "spool encryption key must be 32 bytes for AES-256, got 5")
+
+	// a plaintext file spooled before we had a key
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "before"}))
+
+	assert.NoError(t, ConfigureSpoolEncryption("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="))
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "hello"}))
+
+	// our encrypted file doesn't contain our msg in plaintext
+	files, _ := filepath.Glob(path.Join(spoolDir, "msgs", "*.json.enc"))
+	assert.Equal(t, 1, len(files))
+	contents, _ := ioutil.ReadFile(files[0])
+	assert.NotContains(t, string(contents), "hello")
+	assert.Equal(t, 2, countSpoolFiles(path.Join(spoolDir, "msgs")))
+
+	// but both are flushed decrypted
+	assert.NoError(t, flush())
+	assert.Equal(t, []string{"{\n  \"text\": \"before\"\n}", "{\n  \"text\": \"hello\"\n}"}, flushed)
+	assert.Equal(t, 0, countSpoolFiles(path.Join(spoolDir, "msgs")))
+
+	// a file written with a key we no longer have can't be decrypted so is left in place
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "rotated"}))
+	assert.NoError(t, ConfigureSpoolEncryption("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="))
+
+	flushed = flushed[:0]
+	assert.NoError(t, flush())
+	assert.Equal(t, []string{}, flushed)
+	assert.Equal(t, 1, countSpoolFiles(path.Join(spoolDir, "msgs")))
+
+	// as is one without any key
+	assert.NoError(t, ConfigureSpoolEncryption(""))
+	assert.NoError(t, flush())
+	assert.Equal(t, []string{}, flushed)
+	assert.Equal(t, 1, countSpoolFiles(path.Join(spoolDir, "msgs")))
+}
+
+func TestEncryptedSpoolTampering(t *testing.T) {
+	defer ConfigureSpoolEncryption("")
+	assert.NoError(t, ConfigureSpoolEncryption("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="))
+
+	encrypted, err := encryptSpoolContents(spoolCipher, []byte(`{"text": "hello"}`))
+	assert.NoError(t, err)
+
+	decrypted, err := decryptSpoolContents(spoolCipher, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text": "hello"}`, string(decrypted))
+
+	// flipping a single bit of our ciphertext is detected
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decryptSpoolContents(spoolCipher, tampered)
+	assert.EqualError(t, err, "unable to decrypt spool file, either the spool encryption key is wrong or the file has been modified")
+
+	// as is truncating it
+	_, err = decryptSpoolContents(spoolCipher, encrypted[:4])
+	assert.EqualError(t, err, "unable to decrypt spool file, it is too short")
+
+	// and without a key we can't decrypt it at all
+	_, err = decryptSpoolContents(nil, encrypted)
+	assert.EqualError(t, err, "spool file is encrypted but no spool encryption key is set")
+}
+
+func TestSpoolBackpressure(t *testing.T) {
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+	msgsDir := path.Join(spoolDir, "msgs")
+
+	ConfigureSpoolLimit(5)
+	defer ConfigureSpoolLimit(0)
+
+	// a backend which is down until we say it has recovered, which tracks how many flushes are in flight at once
+	var down int32 = 1
+	var inFlight, maxInFlight int32
+	var mutex sync.Mutex
+	flushed := make([]string, 0)
+
+	flusher := func(filename string, contents []byte) error {
+		if atomic.LoadInt32(&down) == 1 {
+			return errors.New("db is down")
+		}
+
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		flushed = append(flushed, string(contents))
+		mutex.Unlock()
+		return nil
+	}
+	stopped := func() bool { return false }
+
+	// during our outage writes are spooled until the spool is full, then they fail
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]int{"id": i}))
+	}
+	assert.Equal(t, ErrSpoolFull, WriteToSpool(spoolDir, "msgs", map[string]int{"id": 5}))
+
+	// and nothing can be flushed
+	assert.EqualError(t, drainSpool(msgsDir, flusher, 2, stopped), "db is down")
+	assert.Equal(t, 5, countSpoolFiles(msgsDir))
+
+	// once our backend recovers the spool is drained, by no more than our number of workers at once
+	atomic.StoreInt32(&down, 0)
+	assert.NoError(t, drainSpool(msgsDir, flusher, 2, stopped))
+	assert.Equal(t, 0, countSpoolFiles(msgsDir))
+	assert.Equal(t, 5, len(flushed))
+	assert.True(t, atomic.LoadInt32(&maxInFlight) <= 2)
+
+	// and once we know it has been drained, we can spool again
+	recordSpoolDepth(msgsDir, countSpoolFiles(msgsDir))
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]int{"id": 5}))
+}
@@ -0,0 +1,79 @@
+package courier
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// spoolResponse is our response to the /spool endpoints, what is left in each of our spool directories and for a
+// purge, how many files were removed
+type spoolResponse struct {
+	Spools []*spoolStats `json:"spools"`
+	Purged *int          `json:"purged,omitempty"`
+}
+
+// handleSpool returns how many files are waiting to be flushed in each of our spool directories and how long the
+// oldest of them has been waiting
+func (s *server) handleSpool(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+	s.writeSpoolResponse(w, r, nil)
+}
+
+// handleSpoolFlush tries to flush our spool now rather than waiting for the next SpoolFlushInterval, returning what
+// is left once it has been drained as far as our backend allows
+func (s *server) handleSpoolFlush(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	flushSpools(s)
+	logrus.WithField("comp", "spool").Info("spool flushed on request")
+
+	s.writeSpoolResponse(w, r, nil)
+}
+
+// handlePurgeSpool removes spool files which have been waiting longer than the older_than duration, e.g. poison msgs
+// which will never flush. It only removes files of the spool type given if there is one. As files can't be
+// recovered once purged, older_than is required.
+func (s *server) handlePurgeSpool(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	olderThan, err := time.ParseDuration(r.URL.Query().Get("older_than"))
+	if err != nil || olderThan <= 0 {
+		WriteError(r.Context(), w, r, fmt.Errorf("older_than is required and must be a positive duration, e.g. 24h"))
+		return
+	}
+
+	spoolType := r.URL.Query().Get("type")
+	purged, err := purgeSpools(s, spoolType, olderThan)
+	if err != nil {
+		logrus.WithError(err).Error("error purging spool")
+		WriteDataResponse(r.Context(), w, http.StatusInternalServerError, "Internal Server Error", []interface{}{NewErrorData(err.Error())})
+		return
+	}
+
+	logrus.WithField("comp", "spool").WithField("type", spoolType).WithField("older_than", olderThan).WithField("purged", purged).Warn("spool purged on request")
+
+	s.writeSpoolResponse(w, r, &purged)
+}
+
+func (s *server) writeSpoolResponse(w http.ResponseWriter, r *http.Request, purged *int) {
+	stats, err := getSpoolStats()
+	if err != nil {
+		logrus.WithError(err).Error("error reading spool")
+		WriteDataResponse(r.Context(), w, http.StatusInternalServerError, "Internal Server Error", []interface{}{NewErrorData(err.Error())})
+		return
+	}
+
+	err = writeJSONResponse(r.Context(), w, http.StatusOK, &spoolResponse{Spools: stats, Purged: purged})
+	if err != nil {
+		logrus.WithError(err).Error()
+	}
+}
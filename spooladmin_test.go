@@ -0,0 +1,105 @@
+package courier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoolAdmin(t *testing.T) {
+	originalFlushers := registeredFlushers
+	defer func() { registeredFlushers = originalFlushers }()
+
+	spoolDir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(spoolDir)
+
+	config := NewConfig()
+	config.SpoolDir = spoolDir
+	config.SpoolFlushInterval = 60
+	config.StatusUsername = "admin"
+	config.StatusPassword = "sesame"
+
+	// msgs flush fine, but our statuses never will
+	RegisterFlusher(path.Join(spoolDir, "msgs"), func(filename string, contents []byte) error { return nil })
+	RegisterFlusher(path.Join(spoolDir, "statuses"), func(filename string, contents []byte) error { return errors.New("poison status") })
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "msgs"))
+	assert.NoError(t, EnsureSpoolDirPresent(spoolDir, "statuses"))
+
+	s := NewServerWithLogger(config, NewMockBackend(), logrus.New()).(*server)
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+
+	request := func(handler http.HandlerFunc, method string, url string) (int, *spoolResponse) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, nil)
+		r.SetBasicAuth("admin", "sesame")
+		handler(w, r)
+
+		response := &spoolResponse{}
+		json.Unmarshal(w.Body.Bytes(), response)
+		return w.Code, response
+	}
+	counts := func(response *spoolResponse) map[string]int {
+		counts := make(map[string]int)
+		for _, stats := range response.Spools {
+			counts[stats.Type] = stats.Count
+		}
+		return counts
+	}
+
+	// spool a msg and a couple of statuses, one of them from two days ago
+	assert.NoError(t, WriteToSpool(spoolDir, "msgs", map[string]string{"text": "hello"}))
+	assert.NoError(t, WriteToSpool(spoolDir, "statuses", map[string]string{"status": "D"}))
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, ioutil.WriteFile(path.Join(spoolDir, "statuses", fmt.Sprintf("%d.json", twoDaysAgo.UnixNano())), []byte(`{"status": "S"}`), 0640))
+
+	// we need to be authenticated
+	w := httptest.NewRecorder()
+	s.handleSpool(w, httptest.NewRequest("GET", "/spool", nil))
+	assert.Equal(t, 401, w.Code)
+
+	// listing our spool gives us how many of each are waiting and for how long
+	code, response := request(s.handleSpool, "GET", "/spool")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]int{"msgs": 1, "statuses": 2}, counts(response))
+	assert.Equal(t, "msgs", response.Spools[0].Type)
+	assert.True(t, response.Spools[0].OldestAge < 60)
+	assert.Equal(t, "statuses", response.Spools[1].Type)
+	assert.Equal(t, twoDaysAgo.Unix(), response.Spools[1].Oldest.Unix())
+	assert.InDelta(t, 48*60*60, response.Spools[1].OldestAge, 60)
+	assert.Nil(t, response.Purged)
+
+	// flushing on demand flushes what it can without waiting for our interval
+	code, response = request(s.handleSpoolFlush, "POST", "/spool/flush")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, map[string]int{"msgs": 0, "statuses": 2}, counts(response))
+	assert.Nil(t, response.Spools[0].Oldest)
+
+	// purging needs to be told how old the files to purge are
+	code, _ = request(s.handlePurgeSpool, "DELETE", "/spool")
+	assert.Equal(t, 400, code)
+	code, _ = request(s.handlePurgeSpool, "DELETE", "/spool?older_than=-1h")
+	assert.Equal(t, 400, code)
+
+	// only files older than that are purged, and only of the type asked for
+	code, response = request(s.handlePurgeSpool, "DELETE", "/spool?older_than=24h&type=msgs")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 0, *response.Purged)
+	assert.Equal(t, map[string]int{"msgs": 0, "statuses": 2}, counts(response))
+
+	code, response = request(s.handlePurgeSpool, "DELETE", "/spool?older_than=24h")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, *response.Purged)
+	assert.Equal(t, map[string]int{"msgs": 0, "statuses": 1}, counts(response))
+	assert.True(t, response.Spools[1].OldestAge < 60)
+}
@@ -1,6 +1,14 @@
 package courier
 
-import "github.com/nyaruka/gocommon/urns"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+)
 
 // MsgStatusValue is the status of a message
 type MsgStatusValue string
@@ -38,6 +46,61 @@ type MsgStatus interface {
 	Status() MsgStatusValue
 	SetStatus(MsgStatusValue)
 
+	// OccurredOn returns when this status happened in UTC, which is when the provider says it did if it told us,
+	// otherwise when the status was created
+	OccurredOn() time.Time
+	SetOccurredOn(time.Time)
+
+	// ErrorCategory returns why the provider failed to send this msg, if it did
+	ErrorCategory() SendErrorCategory
+	SetErrorCategory(SendErrorCategory)
+
 	Logs() []*ChannelLog
 	AddLog(log *ChannelLog)
 }
+
+// MsgStatusesError is returned by WriteMsgStatuses when some of the passed in status updates couldn't be
+// written, any updates not in Errors were written successfully
+type MsgStatusesError struct {
+	// Errors contains the error for each failed update, keyed by its index in the batch
+	Errors map[int]error
+	Total  int
+}
+
+// Failed returns the indexes of the status updates which failed, in order
+func (e *MsgStatusesError) Failed() []int {
+	failed := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		failed = append(failed, i)
+	}
+	sort.Ints(failed)
+	return failed
+}
+
+func (e *MsgStatusesError) Error() string {
+	errs := make([]string, 0, len(e.Errors))
+	for _, i := range e.Failed() {
+		errs = append(errs, fmt.Sprintf("%d: %s", i, e.Errors[i]))
+	}
+	return fmt.Sprintf("unable to write %d of %d status updates: %s", len(e.Errors), e.Total, strings.Join(errs, ", "))
+}
+
+// WriteMsgStatusesSequentially writes each of the passed in status updates in order with WriteMsgStatus, it
+// can be used by backends which have no way of writing a batch of updates at once
+func WriteMsgStatusesSequentially(ctx context.Context, b Backend, statuses []MsgStatus) error {
+	var errs map[int]error
+	for i, status := range statuses {
+		err := b.WriteMsgStatus(ctx, status)
+		if err != nil {
+			if errs == nil {
+				errs = make(map[int]error)
+			}
+			errs[i] = err
+		}
+	}
+
+	if errs != nil {
+		return &MsgStatusesError{Errors: errs, Total: len(statuses)}
+	}
+	return nil
+}
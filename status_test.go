@@ -0,0 +1,51 @@
+package courier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMsgStatuses(t *testing.T) {
+	ctx := context.Background()
+	mb := NewMockBackend()
+	s := NewServer(NewConfig(), mb)
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+
+	newStatuses := func(ids ...MsgID) []MsgStatus {
+		statuses := make([]MsgStatus, len(ids))
+		for i, id := range ids {
+			statuses[i] = mb.NewMsgStatusForID(channel, id, MsgDelivered)
+		}
+		return statuses
+	}
+
+	// all written in the order passed in
+	statuses := newStatuses(NewMsgID(3), NewMsgID(1), NewMsgID(2))
+	err := s.WriteMsgStatuses(ctx, statuses)
+	assert.NoError(t, err)
+	assert.Equal(t, statuses, mb.msgStatuses)
+
+	// a failure mid batch doesn't stop the rest being written and is reported by index
+	mb.msgStatuses = nil
+	mb.SetErrorOnMsgStatus(NewMsgID(5))
+
+	statuses = newStatuses(NewMsgID(4), NewMsgID(5), NewMsgID(6))
+	err = s.WriteMsgStatuses(ctx, statuses)
+	if assert.IsType(t, &MsgStatusesError{}, err) {
+		statusesErr := err.(*MsgStatusesError)
+		assert.Equal(t, []int{1}, statusesErr.Failed())
+		assert.Equal(t, 3, statusesErr.Total)
+		assert.Equal(t, "unable to write 1 of 3 status updates: 1: unable to write status for msg: 5", err.Error())
+	}
+	assert.Equal(t, []MsgStatus{statuses[0], statuses[2]}, mb.msgStatuses)
+
+	// same behavior when falling back to sequential writes
+	mb.msgStatuses = nil
+	err = WriteMsgStatusesSequentially(ctx, mb, statuses)
+	if assert.IsType(t, &MsgStatusesError{}, err) {
+		assert.Equal(t, []int{1}, err.(*MsgStatusesError).Failed())
+	}
+	assert.Equal(t, []MsgStatus{statuses[0], statuses[2]}, mb.msgStatuses)
+}
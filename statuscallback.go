@@ -0,0 +1,92 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// statusCallbackPath is the path of our shared status callback, which providers with no handler specific status
+// format can post status updates to
+const statusCallbackPath = "/status/{type}/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}"
+
+// the states our shared status callback accepts and the status values they map to
+var statusCallbackStates = map[string]MsgStatusValue{
+	"sent":      MsgSent,
+	"delivered": MsgDelivered,
+	"failed":    MsgFailed,
+}
+
+// statusCallbackPayload is the payload posted to our shared status callback, e.g.
+//
+//	{"id": 12345, "status": "delivered"}
+//	{"external_id": "SM0ab4d", "status": "failed"}
+type statusCallbackPayload struct {
+	ID         int64  `json:"id"`
+	ExternalID string `json:"external_id"`
+	Status     string `json:"status"`
+}
+
+// addStatusCallbackRoute adds our shared status callback route for channels of every type
+func (s *server) addStatusCallbackRoute() {
+	getChannel := func(ctx context.Context, r *http.Request) (Channel, error) {
+		uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+		if err != nil {
+			return nil, err
+		}
+		channelType := ChannelType(strings.ToUpper(chi.URLParam(r, "type")))
+		channel, err := s.backend.GetChannel(ctx, channelType, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if channel.ChannelType() != channelType {
+			return nil, ErrChannelWrongType
+		}
+		return channel, nil
+	}
+
+	s.addChannelRoute(&channelRoute{
+		method:      "post",
+		path:        statusCallbackPath,
+		action:      "status",
+		handlerFunc: s.channelHandleWrapper(nil, "status", getChannel, s.receiveStatusCallback),
+		help:        fmt.Sprintf("%-20s - %s", s.channelPath(statusCallbackPath), "Shared status callback"),
+	})
+}
+
+func (s *server) receiveStatusCallback(ctx context.Context, channel Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+	payload := &statusCallbackPayload{}
+	err := json.NewDecoder(r.Body).Decode(payload)
+	if err != nil {
+		return nil, WriteAndLogError(ctx, w, r, channel, fmt.Errorf("unable to parse request JSON: %s", err))
+	}
+
+	value, found := statusCallbackStates[strings.ToLower(payload.Status)]
+	if !found {
+		return nil, WriteAndLogError(ctx, w, r, channel, fmt.Errorf("unknown status '%s', must be one of sent, delivered or failed", payload.Status))
+	}
+
+	var status MsgStatus
+	if payload.ID != 0 {
+		status = s.backend.NewMsgStatusForID(channel, NewMsgID(payload.ID), value)
+	} else if payload.ExternalID != "" {
+		status = s.backend.NewMsgStatusForExternalID(channel, payload.ExternalID, value)
+	} else {
+		return nil, WriteAndLogError(ctx, w, r, channel, fmt.Errorf("one of id or external_id is required"))
+	}
+
+	err = s.backend.WriteMsgStatus(ctx, status)
+	if err == ErrMsgNotFound {
+		LogRequestIgnored(r, channel, statusMsgNotFoundDetail)
+		return nil, WriteIgnored(ctx, w, r, statusMsgNotFoundDetail)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []Event{status}, WriteStatusSuccess(ctx, w, r, []MsgStatus{status})
+}
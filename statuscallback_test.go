@@ -0,0 +1,58 @@
+package courier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCallback(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	s := NewServer(testConfig(), mb).(*server)
+	s.addStatusCallbackRoute()
+
+	tcs := []struct {
+		path       string
+		body       string
+		statusCode int
+		response   string
+		id         MsgID
+		externalID string
+		status     MsgStatusValue
+	}{
+		{"/c/status/dm/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"id": 12345, "status": "sent"}`, 200, `"status":"S"`, NewMsgID(12345), "", MsgSent},
+		{"/c/status/dm/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"id": 12345, "status": "delivered"}`, 200, `"status":"D"`, NewMsgID(12345), "", MsgDelivered},
+		{"/c/status/DM/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"external_id": "SM0ab4d", "status": "FAILED"}`, 200, `"status":"F"`, NilMsgID, "SM0ab4d", MsgFailed},
+		{"/c/status/dm/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"id": 12345, "status": "read"}`, 400, "unknown status 'read', must be one of sent, delivered or failed", NilMsgID, "", NilMsgStatus},
+		{"/c/status/dm/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"status": "sent"}`, 400, "one of id or external_id is required", NilMsgID, "", NilMsgStatus},
+		{"/c/status/dm/e4bb1578-29da-4fa5-a214-9da19dd24230", `not json`, 400, "unable to parse request JSON", NilMsgID, "", NilMsgStatus},
+		{"/c/status/xx/e4bb1578-29da-4fa5-a214-9da19dd24230", `{"id": 12345, "status": "sent"}`, 400, "channel type wrong", NilMsgID, "", NilMsgStatus},
+		{"/c/status/dm/12345/receive", `{"id": 12345, "status": "sent"}`, 404, "not found", NilMsgID, "", NilMsgStatus},
+	}
+
+	for _, tc := range tcs {
+		mb.msgStatuses = nil
+
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		assert.Equal(t, tc.statusCode, w.Code, "status code mismatch for %s", tc.body)
+		assert.Contains(t, w.Body.String(), tc.response, "response mismatch for %s", tc.body)
+
+		if tc.status != NilMsgStatus {
+			status, err := mb.GetLastMsgStatus()
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.status, status.Status())
+				assert.Equal(t, tc.id, status.ID())
+				assert.Equal(t, tc.externalID, status.ExternalID())
+			}
+		} else {
+			assert.Equal(t, 0, len(mb.msgStatuses), "unexpected status written for %s", tc.body)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we remember the status callback URLs of sent msgs, after which their statuses no longer notify anyone
+const statusCallbackTTL = 72 * time.Hour
+
+// registeredCallback is what we remember for a sent msg with a status callback URL
+type registeredCallback struct {
+	URL   string `json:"url"`
+	MsgID MsgID  `json:"msg_id"`
+}
+
+// statusNotification is what we POST to the status callback URL of a msg when its status changes
+type statusNotification struct {
+	ID         MsgID          `json:"id"`
+	ExternalID string         `json:"external_id,omitempty"`
+	Status     MsgStatusValue `json:"status"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
+
+// statuses are keyed by msg ID or, for providers which only tell us their own ID, the channel and that ID
+func statusCallbackKeys(channelUUID ChannelUUID, id MsgID, externalID string) []string {
+	keys := make([]string, 0, 2)
+	if id != NilMsgID {
+		keys = append(keys, fmt.Sprintf("status_callback:%s", id))
+	}
+	if externalID != "" {
+		keys = append(keys, fmt.Sprintf("status_callback:%s|ext:%s", channelUUID, externalID))
+	}
+	return keys
+}
+
+// registerStatusCallback remembers the status callback URL of the passed in msg, if it has one, so that the statuses
+// written for it afterwards, including the passed in status of sending it, can be forwarded there
+func registerStatusCallback(backend Backend, msg Msg, status MsgStatus) error {
+	if msg.StatusCallback() == "" {
+		return nil
+	}
+
+	callbackJSON, err := json.Marshal(&registeredCallback{URL: msg.StatusCallback(), MsgID: msg.ID()})
+	if err != nil {
+		return err
+	}
+
+	rc := backend.RedisPool().Get()
+	defer rc.Close()
+
+	for _, key := range statusCallbackKeys(msg.Channel().UUID(), msg.ID(), status.ExternalID()) {
+		rc.Send("setex", key, int(statusCallbackTTL/time.Second), callbackJSON)
+	}
+	_, err = rc.Do("")
+	return err
+}
+
+// lookupStatusCallback returns the status callback registered for the msg with the passed in ID or external ID, if any
+func lookupStatusCallback(backend Backend, channelUUID ChannelUUID, id MsgID, externalID string) (*registeredCallback, error) {
+	keys := statusCallbackKeys(channelUUID, id, externalID)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	rc := backend.RedisPool().Get()
+	defer rc.Close()
+
+	// if we know which msg this is for, only look for its callback, its external ID might have been reused
+	callbackJSON, err := redis.Bytes(rc.Do("get", keys[0]))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callback := &registeredCallback{}
+	err = json.Unmarshal(callbackJSON, callback)
+	return callback, err
+}
+
+// statusNotifyBackend wraps a backend, forwarding the statuses written through it to the status callback URLs of
+// their msgs. Notifications happen in the background so they never hold up writing statuses.
+type statusNotifyBackend struct {
+	Backend
+	server *server
+}
+
+// WriteMsgStatus writes the passed in status to our wrapped backend, notifying its msg's callback URL if it has one
+func (b *statusNotifyBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	err := b.Backend.WriteMsgStatus(ctx, status)
+	if err == nil {
+		b.notify(status)
+	}
+	return err
+}
+
+// WriteMsgStatuses writes the passed in statuses to our wrapped backend, notifying for each which was written
+func (b *statusNotifyBackend) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	err := b.Backend.WriteMsgStatuses(ctx, statuses)
+
+	// if we didn't get per status errors, every status shares the same result
+	statusesErr, _ := err.(*MsgStatusesError)
+	if err != nil && statusesErr == nil {
+		return err
+	}
+
+	for i, status := range statuses {
+		if statusesErr == nil || statusesErr.Errors[i] == nil {
+			b.notify(status)
+		}
+	}
+	return err
+}
+
+// notify starts looking up the status callback for the passed in status in the background and if there is one,
+// sending it there, so that writing statuses never waits on either
+func (b *statusNotifyBackend) notify(status MsgStatus) {
+	log := logrus.WithField("comp", "status_notifier").WithField("channel_uuid", status.ChannelUUID()).WithField("msg_id", status.ID().String())
+
+	// read everything we need from our status up front, it isn't ours once we return
+	channelUUID, id, externalID := status.ChannelUUID(), status.ID(), status.ExternalID()
+	payload := &statusNotification{
+		ExternalID: externalID,
+		Status:     status.Status(),
+		Timestamp:  status.OccurredOn().UTC(),
+	}
+
+	done := b.server.TrackComponent("status_callback")
+	go func() {
+		defer done()
+
+		callback, err := lookupStatusCallback(b.Backend, channelUUID, id, externalID)
+		if err != nil {
+			log.WithError(err).Error("error looking up status callback")
+			return
+		}
+		if callback == nil {
+			return
+		}
+
+		payload.ID = callback.MsgID
+		b.server.sendStatusCallback(log.WithField("url", RedactedURL(callback.URL)), callback.URL, payload)
+	}()
+}
+
+// sendStatusCallback POSTs the passed in payload to the passed in URL, retrying with an exponential backoff if that
+// fails, giving up after StatusCallbackRetries retries or if we are stopped
+func (s *server) sendStatusCallback(log *logrus.Entry, url string, payload *statusNotification) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.WithError(err).Error("error marshalling status callback")
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		_, err = utils.MakeHTTPRequest(req)
+		if err == nil {
+			return
+		}
+
+		if attempt > s.config.StatusCallbackRetries {
+			log.WithError(err).WithField("attempts", attempt).Error("error sending status callback, giving up")
+			return
+		}
+
+		backoff := retryBackoff(s.config.StatusCallbackBackoff, attempt)
+		log.WithError(err).WithField("attempt", attempt).WithField("backoff", backoff).Warn("error sending status callback, retrying")
+
+		select {
+		case <-s.stopChan:
+			log.WithError(err).Error("stopped before status callback could be sent")
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// externalIDHandler is a handler which sends messages successfully, getting back an ID from the provider
+type externalIDHandler struct {
+	dummyHandler
+}
+
+func (h *externalIDHandler) ChannelType() ChannelType { return ChannelType("EX") }
+
+func (h *externalIDHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	status := h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
+	status.SetExternalID("ext1")
+	return status, nil
+}
+
+func TestStatusCallbacks(t *testing.T) {
+	failures := 0
+	notifications := make(chan map[string]interface{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		notification := make(map[string]interface{})
+		json.Unmarshal(body, &notification)
+		notifications <- notification
+	}))
+	defer receiver.Close()
+
+	receive := func() map[string]interface{} {
+		select {
+		case n := <-notifications:
+			return n
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	config := testConfig()
+	config.StatusCallbackRetries = 1
+	config.StatusCallbackBackoff = 1
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	sender := NewForeman(s, 1).senders[0]
+
+	handler := &externalIDHandler{dummyHandler{server: s, backend: mb}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "EX", "2020", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+
+	// sending a msg with a callback URL notifies it that the msg was wired
+	msg := &mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383"}
+	sender.sendMessage(msg.WithStatusCallback(receiver.URL))
+
+	notification := receive()
+	if assert.NotNil(t, notification) {
+		assert.Equal(t, float64(101), notification["id"])
+		assert.Equal(t, "ext1", notification["external_id"])
+		assert.Equal(t, "W", notification["status"])
+		assert.NotEmpty(t, notification["timestamp"])
+	}
+
+	// the provider later tells us when it was delivered, by its own ID, our receiver fails once but we retry
+	failures = 1
+	delivered := mb.NewMsgStatusForExternalID(channel, "ext1", MsgDelivered)
+	delivered.SetOccurredOn(time.Date(2020, 7, 1, 11, 30, 0, 0, time.FixedZone("CAT", 2*60*60)))
+	err := s.Backend().WriteMsgStatus(context.Background(), delivered)
+	assert.NoError(t, err)
+
+	notification = receive()
+	if assert.NotNil(t, notification) {
+		assert.Equal(t, float64(101), notification["id"])
+		assert.Equal(t, "D", notification["status"])
+		assert.Equal(t, "2020-07-01T09:30:00Z", notification["timestamp"])
+	}
+
+	// statuses written in batches notify too
+	err = s.WriteMsgStatuses(context.Background(), []MsgStatus{mb.NewMsgStatusForID(channel, NewMsgID(101), MsgFailed)})
+	assert.NoError(t, err)
+
+	notification = receive()
+	if assert.NotNil(t, notification) {
+		assert.Equal(t, float64(101), notification["id"])
+		assert.Equal(t, "F", notification["status"])
+	}
+
+	// but if our receiver keeps failing we give up
+	failures = 5
+	err = s.Backend().WriteMsgStatus(context.Background(), mb.NewMsgStatusForID(channel, NewMsgID(101), MsgDelivered))
+	assert.NoError(t, err)
+	s.WaitGroup().Wait()
+	assert.Equal(t, 3, failures)
+
+	// and msgs without callback URLs notify nobody, even if their provider reuses an external ID
+	failures = 0
+	sender.sendMessage(&mockMsg{channel: channel, id: NewMsgID(102), text: "hello", urn: "tel:+250788383383"})
+	s.WaitGroup().Wait()
+	assert.Equal(t, 0, len(notifications))
+}
@@ -0,0 +1,264 @@
+package courier
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigStatusPrecedence is a constant key for channel configs, when set it overrides our StatusPrecedence for the
+// statuses of msgs on the channel, e.g. for providers which send statuses in an unusual order
+const ConfigStatusPrecedence = "status_precedence"
+
+// StatusPrecedence is the order msgs progress through their statuses, statuses which aren't in it, such as errored,
+// don't progress a msg and are always written
+type StatusPrecedence []MsgStatusValue
+
+// ParseStatusPrecedence parses a comma separated list of statuses in the order msgs progress through them, e.g.
+// Q,W,S,D, anything which isn't one of our statuses is ignored
+func ParseStatusPrecedence(statuses string) StatusPrecedence {
+	precedence := make(StatusPrecedence, 0, 4)
+	for _, status := range strings.Split(statuses, ",") {
+		value := MsgStatusValue(strings.ToUpper(strings.TrimSpace(status)))
+		switch value {
+		case MsgQueued, MsgWired, MsgSent, MsgDelivered, MsgErrored, MsgFailed:
+			if precedence.Rank(value) == 0 {
+				precedence = append(precedence, value)
+			}
+		case "":
+		default:
+			logrus.WithField("status", status).Error("ignoring unknown status in status precedence")
+		}
+	}
+	return precedence
+}
+
+// Rank returns the rank of the passed in status in this precedence, starting at 1, or 0 if it isn't in it
+func (p StatusPrecedence) Rank(status MsgStatusValue) int {
+	for i, value := range p {
+		if value == status {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// a msg is remembered by both its ID and its external ID, as statuses we write after sending have its ID but those
+// from the provider often only have its external ID
+type statusKey struct {
+	channelUUID ChannelUUID
+	id          MsgID
+	externalID  string
+}
+
+type writtenStatus struct {
+	status     MsgStatusValue
+	expiration time.Time
+}
+
+// the precedence of a channel, which is our own unless it overrides it
+type channelPrecedence struct {
+	precedence StatusPrecedence
+	expiration time.Time
+}
+
+// how long we remember the precedence of a channel before looking it up again
+const channelPrecedenceTimeout = time.Minute
+
+// statusPrecedenceBackend wraps a backend, dropping status updates which don't progress a msg past the last status
+// written for it within our window, i.e. those which are out of order like a sent after delivered, or duplicates
+// like a repeated delivered. This stops the status of msgs flapping in downstream systems.
+type statusPrecedenceBackend struct {
+	Backend
+	precedence StatusPrecedence
+	window     time.Duration
+
+	mutex      sync.Mutex
+	written    map[statusKey]*writtenStatus
+	channels   map[ChannelUUID]*channelPrecedence
+	lastPruned time.Time
+}
+
+func newStatusPrecedenceBackend(backend Backend, precedence StatusPrecedence, window time.Duration) *statusPrecedenceBackend {
+	return &statusPrecedenceBackend{
+		Backend:    backend,
+		precedence: precedence,
+		window:     window,
+		written:    make(map[statusKey]*writtenStatus),
+		channels:   make(map[ChannelUUID]*channelPrecedence),
+		lastPruned: time.Now(),
+	}
+}
+
+// WriteMsgStatus writes the passed in status to our wrapped backend, unless it doesn't progress its msg. Statuses
+// written by our senders are always written, e.g. a msg which was requeued after being sent is wired again.
+func (b *statusPrecedenceBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	senderStatus, _ := ctx.Value(contextSenderStatus).(bool)
+
+	precedence := b.precedenceFor(ctx, status.ChannelUUID())
+	if !senderStatus && !b.progresses(precedence, status) {
+		b.logDropped(status)
+		return nil
+	}
+
+	err := b.Backend.WriteMsgStatus(ctx, status)
+	if err == nil {
+		b.remember(precedence, status)
+	}
+	return err
+}
+
+// WriteMsgStatuses writes the passed in statuses to our wrapped backend, leaving out those which don't progress their
+// msgs. Any errors are returned for the indexes of the passed in statuses.
+func (b *statusPrecedenceBackend) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	progressing := make([]MsgStatus, 0, len(statuses))
+	indexes := make([]int, 0, len(statuses))
+
+	for i, status := range statuses {
+		precedence := b.precedenceFor(ctx, status.ChannelUUID())
+		if !b.progresses(precedence, status) {
+			b.logDropped(status)
+			continue
+		}
+
+		// the same batch can progress a msg more than once, so what each status progresses past includes those before it
+		b.remember(precedence, status)
+		progressing = append(progressing, status)
+		indexes = append(indexes, i)
+	}
+
+	if len(progressing) == 0 {
+		return nil
+	}
+
+	err := b.Backend.WriteMsgStatuses(ctx, progressing)
+	if err == nil {
+		return nil
+	}
+
+	// the statuses which failed to be written weren't, so forget them and map their errors back to their indexes
+	statusesErr, isStatusesErr := err.(*MsgStatusesError)
+	errs := make(map[int]error)
+	for i, status := range progressing {
+		statusErr := err
+		if isStatusesErr {
+			statusErr = statusesErr.Errors[i]
+		}
+		if statusErr != nil {
+			b.forget(status)
+			errs[indexes[i]] = statusErr
+		}
+	}
+
+	if !isStatusesErr && len(progressing) == len(statuses) {
+		return err
+	}
+	return &MsgStatusesError{Errors: errs, Total: len(statuses)}
+}
+
+// returns the precedence for statuses of the passed in channel, its own if it has one. Channels are only looked up
+// and their precedence parsed every channelPrecedenceTimeout, not for every status.
+func (b *statusPrecedenceBackend) precedenceFor(ctx context.Context, channelUUID ChannelUUID) StatusPrecedence {
+	now := time.Now()
+
+	b.mutex.Lock()
+	cached, found := b.channels[channelUUID]
+	b.mutex.Unlock()
+
+	if found && cached.expiration.After(now) {
+		return cached.precedence
+	}
+
+	// if we can't look up the channel use our own precedence, but try again for its next status
+	channel, err := b.Backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		return b.precedence
+	}
+
+	precedence := b.precedence
+	if override := channel.StringConfigForKey(ConfigStatusPrecedence, ""); override != "" {
+		precedence = ParseStatusPrecedence(override)
+	}
+
+	b.mutex.Lock()
+	b.channels[channelUUID] = &channelPrecedence{precedence: precedence, expiration: now.Add(channelPrecedenceTimeout)}
+	b.mutex.Unlock()
+
+	return precedence
+}
+
+// whether the passed in status progresses its msg past the last status we wrote for it
+func (b *statusPrecedenceBackend) progresses(precedence StatusPrecedence, status MsgStatus) bool {
+	rank := precedence.Rank(status.Status())
+	if rank == 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range statusKeys(status) {
+		written, found := b.written[key]
+		if found && written.expiration.After(now) && precedence.Rank(written.status) >= rank {
+			return false
+		}
+	}
+	return true
+}
+
+// remembers the passed in status as the last written for its msg, statuses without a rank, e.g. an error which will be
+// retried, mean we forget the msg so that it can progress through the same statuses again
+func (b *statusPrecedenceBackend) remember(precedence StatusPrecedence, status MsgStatus) {
+	if precedence.Rank(status.Status()) == 0 {
+		b.forget(status)
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range statusKeys(status) {
+		b.written[key] = &writtenStatus{status: status.Status(), expiration: now.Add(b.window)}
+	}
+
+	// every so often, drop the msgs which have passed our window so we don't grow forever
+	if now.Sub(b.lastPruned) > b.window {
+		for key, written := range b.written {
+			if !written.expiration.After(now) {
+				delete(b.written, key)
+			}
+		}
+		b.lastPruned = now
+	}
+}
+
+// forgets the last status written for the msg of the passed in status
+func (b *statusPrecedenceBackend) forget(status MsgStatus) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, key := range statusKeys(status) {
+		delete(b.written, key)
+	}
+}
+
+func (b *statusPrecedenceBackend) logDropped(status MsgStatus) {
+	logrus.WithField("channel_uuid", status.ChannelUUID()).WithField("msg_id", status.ID().String()).WithField("external_id", status.ExternalID()).WithField("status", status.Status()).Debug("dropping status which doesn't progress msg")
+}
+
+// returns the keys the msg of the passed in status is remembered by
+func statusKeys(status MsgStatus) []statusKey {
+	keys := make([]statusKey, 0, 2)
+	if status.ID() != NilMsgID {
+		keys = append(keys, statusKey{channelUUID: status.ChannelUUID(), id: status.ID()})
+	}
+	if status.ExternalID() != "" {
+		keys = append(keys, statusKey{channelUUID: status.ChannelUUID(), externalID: status.ExternalID()})
+	}
+	return keys
+}
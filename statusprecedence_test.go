@@ -0,0 +1,128 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPrecedence(t *testing.T) {
+	ctx := context.Background()
+
+	// status precedence is off by default
+	mb := NewMockBackend()
+	_, isPrecedence := NewServer(testConfig(), mb).(*server).backend.(*statusPrecedenceBackend)
+	assert.False(t, isPrecedence)
+
+	config := testConfig()
+	config.StatusPrecedence = "Q,W,S,D"
+	config.StatusDedupeWindow = 300
+	s := NewServer(config, mb).(*server)
+
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "DM", "2020", "US", nil)
+	quirky := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "DM", "2021", "US", map[string]interface{}{ConfigStatusPrecedence: "Q,W,D,S"})
+	mb.AddChannel(channel)
+	mb.AddChannel(quirky)
+
+	// writes a status for the msg with the passed in external ID, returning the statuses which made it to our backend
+	written := func(channel Channel, externalID string, statuses ...MsgStatusValue) []MsgStatusValue {
+		mb.msgStatuses = nil
+		for _, value := range statuses {
+			status := mb.NewMsgStatusForExternalID(channel, externalID, value)
+			assert.NoError(t, s.backend.WriteMsgStatus(ctx, status))
+		}
+		values := make([]MsgStatusValue, 0, len(mb.msgStatuses))
+		for _, status := range mb.msgStatuses {
+			values = append(values, status.Status())
+		}
+		return values
+	}
+
+	// statuses in order are all written
+	assert.Equal(t, []MsgStatusValue{MsgWired, MsgSent, MsgDelivered}, written(channel, "ext1", MsgWired, MsgSent, MsgDelivered))
+
+	// a repeated delivered is dropped, as is a sent after it
+	assert.Equal(t, []MsgStatusValue{}, written(channel, "ext1", MsgDelivered, MsgSent, MsgWired))
+
+	// statuses which skip ahead are written, but not those they skipped
+	assert.Equal(t, []MsgStatusValue{MsgWired, MsgDelivered}, written(channel, "ext2", MsgWired, MsgDelivered, MsgSent))
+
+	// errors are always written, and mean the msg can progress again once retried
+	assert.Equal(t, []MsgStatusValue{MsgWired, MsgErrored, MsgErrored, MsgWired, MsgSent}, written(channel, "ext3", MsgWired, MsgErrored, MsgErrored, MsgWired, MsgSent))
+
+	// msgs are remembered separately for each channel, which can have their own precedence
+	assert.Equal(t, []MsgStatusValue{MsgDelivered, MsgSent}, written(quirky, "ext1", MsgDelivered, MsgSent, MsgDelivered))
+
+	// channels are only looked up once, not for every status
+	mb.SetErrorOnGetChannel(errors.New("boom"))
+	assert.Equal(t, []MsgStatusValue{MsgDelivered, MsgSent}, written(quirky, "ext2", MsgDelivered, MsgSent, MsgDelivered))
+	mb.SetErrorOnGetChannel(nil)
+
+	// statuses written by our senders are always written, but still progress their msgs
+	mb.msgStatuses = nil
+	status := mb.NewMsgStatusForID(channel, NewMsgID(101), MsgSent)
+	assert.NoError(t, s.backend.WriteMsgStatus(ctx, status))
+	status = mb.NewMsgStatusForID(channel, NewMsgID(101), MsgWired)
+	assert.NoError(t, s.backend.WriteMsgStatus(context.WithValue(ctx, contextSenderStatus, true), status))
+	status = mb.NewMsgStatusForID(channel, NewMsgID(101), MsgWired)
+	assert.NoError(t, s.backend.WriteMsgStatus(ctx, status))
+	assert.Equal(t, 2, len(mb.msgStatuses))
+
+	// batches leave out statuses which don't progress their msgs, including those earlier in the same batch
+	mb.msgStatuses = nil
+	err := s.backend.WriteMsgStatuses(ctx, []MsgStatus{
+		mb.NewMsgStatusForExternalID(channel, "ext4", MsgSent),
+		mb.NewMsgStatusForExternalID(channel, "ext1", MsgDelivered),
+		mb.NewMsgStatusForExternalID(channel, "ext4", MsgSent),
+		mb.NewMsgStatusForExternalID(channel, "ext4", MsgDelivered),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(mb.msgStatuses))
+	assert.Equal(t, MsgSent, mb.msgStatuses[0].Status())
+	assert.Equal(t, MsgDelivered, mb.msgStatuses[1].Status())
+
+	// and any errors are for the indexes of the statuses passed in
+	mb.SetErrorOnMsgStatus(NewMsgID(102))
+	err = s.backend.WriteMsgStatuses(ctx, []MsgStatus{
+		mb.NewMsgStatusForExternalID(channel, "ext1", MsgDelivered),
+		mb.NewMsgStatusForID(channel, NewMsgID(102), MsgSent),
+	})
+	assert.Equal(t, &MsgStatusesError{Errors: map[int]error{1: assert.AnError}, Total: 2}, normalizeStatusesError(err))
+
+	// a status which failed to be written isn't remembered, so can be written again
+	pb := s.backend.(*statusPrecedenceBackend)
+	assert.True(t, pb.progresses(pb.precedence, mb.NewMsgStatusForID(channel, NewMsgID(102), MsgSent)))
+
+	// and an empty precedence means every status is written
+	config.StatusPrecedence = ""
+	s = NewServer(config, mb).(*server)
+	assert.Equal(t, []MsgStatusValue{MsgDelivered, MsgDelivered}, written(channel, "ext1", MsgDelivered, MsgDelivered))
+}
+
+func TestParseStatusPrecedence(t *testing.T) {
+	assert.Equal(t, StatusPrecedence{MsgQueued, MsgWired, MsgSent, MsgDelivered}, ParseStatusPrecedence("Q,W,S,D"))
+	assert.Equal(t, StatusPrecedence{MsgWired, MsgDelivered}, ParseStatusPrecedence(" w, d ,"))
+	assert.Equal(t, StatusPrecedence{}, ParseStatusPrecedence(""))
+
+	// anything which isn't one of our statuses is ignored, as are repeats
+	assert.Equal(t, StatusPrecedence{MsgSent, MsgDelivered}, ParseStatusPrecedence("S,X,D,S,'; DROP TABLE msgs_msg"))
+
+	precedence := ParseStatusPrecedence("Q,W,S,D")
+	assert.Equal(t, 1, precedence.Rank(MsgQueued))
+	assert.Equal(t, 4, precedence.Rank(MsgDelivered))
+	assert.Equal(t, 0, precedence.Rank(MsgErrored))
+}
+
+// replaces the errors in the passed in statuses error with assert.AnError so only their indexes are compared
+func normalizeStatusesError(err error) error {
+	statusesErr, isStatusesErr := err.(*MsgStatusesError)
+	if !isStatusesErr {
+		return err
+	}
+	for i := range statusesErr.Errors {
+		statusesErr.Errors[i] = assert.AnError
+	}
+	return statusesErr
+}
@@ -0,0 +1,49 @@
+package courier
+
+import (
+	"context"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+)
+
+// stoppedURNBackend wraps a backend, recording the URNs which have stopped receiving msgs on each channel from the stop
+// events written through it. A URN which re-engages with the channel, by sending a msg or starting a new conversation,
+// is no longer stopped.
+type stoppedURNBackend struct {
+	Backend
+}
+
+// WriteMsg writes the passed in incoming msg to our wrapped backend, unstopping the URN which sent it
+func (b *stoppedURNBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	err := b.Backend.WriteMsg(ctx, msg)
+	if err == nil {
+		b.setStopped(ctx, msg.Channel().UUID(), msg.URN(), false)
+	}
+	return err
+}
+
+// WriteChannelEvent writes the passed in event to our wrapped backend, stopping or unstopping its URN if it is a stop
+// event or one where the URN re-engaged with the channel
+func (b *stoppedURNBackend) WriteChannelEvent(ctx context.Context, event ChannelEvent) error {
+	err := b.Backend.WriteChannelEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	switch event.EventType() {
+	case StopContact:
+		b.setStopped(ctx, event.ChannelUUID(), event.URN(), true)
+	case NewConversation, Referral, OptIn, WelcomeMessage:
+		b.setStopped(ctx, event.ChannelUUID(), event.URN(), false)
+	}
+	return nil
+}
+
+// records whether the passed in URN is stopped, failing to do so is logged but doesn't fail what was written
+func (b *stoppedURNBackend) setStopped(ctx context.Context, channelUUID ChannelUUID, urn urns.URN, stopped bool) {
+	err := b.Backend.SetURNStopped(ctx, channelUUID, urn, stopped)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channelUUID).WithField("stopped", stopped).Error("error recording whether urn is stopped")
+	}
+}
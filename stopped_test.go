@@ -0,0 +1,74 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoppedURNs(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "RS", "2020", "US", map[string]interface{}{})
+	other := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "RS", "2021", "US", map[string]interface{}{})
+	mb.AddChannel(channel)
+	mb.AddChannel(other)
+
+	s := NewServer(testConfig(), mb).(*server)
+	foreman := NewForeman(s, 1)
+
+	handler := &resolverHandler{dummyHandler{server: s, backend: s.Backend()}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	// a provider telling us a contact texted STOP, and a contact sending us a msg
+	s.AddHandlerRoute(handler, http.MethodPost, "stopped", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		event := handler.backend.NewChannelEvent(c, StopContact, urns.URN("tel:"+r.URL.Query().Get("from")))
+		return []Event{event}, handler.backend.WriteChannelEvent(ctx, event)
+	})
+	s.AddHandlerRoute(handler, http.MethodGet, "receive", handler.receiveMsg)
+
+	request := func(method string, channel Channel, action string, query string) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(method, "/c/rs/"+channel.UUID().String()+"/"+action+"?"+query, nil))
+		assert.Equal(t, 200, w.Code)
+	}
+
+	// sends the passed in msg, returning the status it was left with
+	send := func(channel Channel, id int64, urn urns.URN) MsgStatus {
+		foreman.senders[0].sendMessage(&mockMsg{channel: channel, id: NewMsgID(id), text: "hello", urn: urn})
+		status, err := mb.GetLastMsgStatus()
+		assert.NoError(t, err)
+		assert.Equal(t, NewMsgID(id), status.ID())
+		return status
+	}
+
+	assert.Equal(t, MsgSent, send(channel, 101, "tel:+250788383383").Status())
+
+	request(http.MethodPost, channel, "stopped", "from=%2B250788383383")
+
+	// once stopped, msgs to the contact are failed without being sent
+	status := send(channel, 102, "tel:+250788383383")
+	assert.Equal(t, MsgFailed, status.Status())
+	if assert.Equal(t, 1, len(status.Logs())) {
+		assert.Equal(t, "Contact Stopped", status.Logs()[0].Description)
+		assert.Equal(t, "contact has stopped receiving messages on this channel, failing message without send", status.Logs()[0].Error)
+	}
+
+	// but other contacts, or the same contact on other channels, are still sent to
+	assert.Equal(t, MsgSent, send(channel, 103, "tel:+250788383384").Status())
+	assert.Equal(t, MsgSent, send(other, 104, "tel:+250788383383").Status())
+
+	// until the contact messages us again
+	request(http.MethodGet, channel, "receive", "from=%2B250788383383&text=hi")
+	assert.Equal(t, MsgSent, send(channel, 105, "tel:+250788383383").Status())
+
+	// starting a new conversation also counts as coming back
+	request(http.MethodPost, channel, "stopped", "from=%2B250788383383")
+	assert.Equal(t, MsgFailed, send(channel, 106, "tel:+250788383383").Status())
+	assert.NoError(t, s.Backend().WriteChannelEvent(context.Background(), mb.NewChannelEvent(channel, NewConversation, "tel:+250788383383")))
+	assert.Equal(t, MsgSent, send(channel, 107, "tel:+250788383383").Status())
+}
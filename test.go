@@ -1,12 +1,15 @@
 package courier
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 
 	"time"
@@ -28,16 +31,25 @@ type MockBackend struct {
 	contacts          map[urns.URN]Contact
 	queueMsgs         []Msg
 	errorOnQueue      bool
+	errorOnGetChannel error
+	errorOnStatuses   map[MsgID]bool
+	writtenMsgs       map[string]MsgUUID
 
 	mutex           sync.RWMutex
 	outgoingMsgs    []Msg
+	requeuedMsgs    []Msg
+	requeuedUntil   map[Msg]time.Time
+	completedMsgs   []Msg
+	completedOn     map[MsgID]time.Time
+	attachments     [][]byte
 	msgStatuses     []MsgStatus
 	channelEvents   []ChannelEvent
 	channelLogs     []*ChannelLog
 	lastContactName string
 
-	sentMsgs  map[MsgID]bool
-	redisPool *redis.Pool
+	sentMsgs    map[MsgID]bool
+	stoppedURNs map[ChannelUUID]map[urns.URN]bool
+	redisPool   *redis.Pool
 
 	seenExternalIDs []string
 }
@@ -71,6 +83,10 @@ func NewMockBackend() *MockBackend {
 		channelsByAddress: make(map[ChannelAddress]Channel),
 		contacts:          make(map[urns.URN]Contact),
 		sentMsgs:          make(map[MsgID]bool),
+		stoppedURNs:       make(map[ChannelUUID]map[urns.URN]bool),
+		writtenMsgs:       make(map[string]MsgUUID),
+		requeuedUntil:     make(map[Msg]time.Time),
+		completedOn:       make(map[MsgID]time.Time),
 		redisPool:         redisPool,
 	}
 }
@@ -107,6 +123,22 @@ func (mb *MockBackend) GetLastMsgStatus() (MsgStatus, error) {
 	return mb.msgStatuses[len(mb.msgStatuses)-1], nil
 }
 
+// WrittenMsgStatuses returns the msg statuses written so far, safe to call while senders are still writing them
+func (mb *MockBackend) WrittenMsgStatuses() []MsgStatus {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return append([]MsgStatus(nil), mb.msgStatuses...)
+}
+
+// ClearMsgStatuses clears the msg statuses written so far
+func (mb *MockBackend) ClearMsgStatuses() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.msgStatuses = nil
+}
+
 // GetLastContactName returns the contact name set on the last msg or channel event written
 func (mb *MockBackend) GetLastContactName() string {
 	return mb.lastContactName
@@ -114,7 +146,8 @@ func (mb *MockBackend) GetLastContactName() string {
 
 // NewIncomingMsg creates a new message from the given params
 func (mb *MockBackend) NewIncomingMsg(channel Channel, urn urns.URN, text string) Msg {
-	return &mockMsg{channel: channel, urn: urn, text: text}
+	receivedOn := time.Now().UTC()
+	return &mockMsg{channel: channel, urn: urn, text: text, receivedOn: &receivedOn, createdOn: receivedOn}
 }
 
 // NewOutgoingMsg creates a new outgoing message from the given params
@@ -124,7 +157,12 @@ func (mb *MockBackend) NewOutgoingMsg(channel Channel, id MsgID, urn urns.URN, t
 		msgResponseToID = NewMsgID(responseToID)
 	}
 
-	return &mockMsg{channel: channel, id: id, urn: urn, text: text, highPriority: highPriority, quickReplies: quickReplies, topic: topic, responseToID: msgResponseToID, responseToExternalID: responseToExternalID}
+	priority := MsgPriorityBulk
+	if highPriority {
+		priority = MsgPriorityNormal
+	}
+
+	return &mockMsg{channel: channel, id: id, urn: urn, text: text, priority: priority, quickReplies: quickReplies, topic: topic, responseToID: msgResponseToID, responseToExternalID: responseToExternalID, createdOn: time.Now().UTC()}
 }
 
 // PushOutgoingMsg is a test method to add a message to our queue of messages to send
@@ -135,14 +173,40 @@ func (mb *MockBackend) PushOutgoingMsg(msg Msg) {
 	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
 }
 
-// PopNextOutgoingMsg returns the next message that should be sent, or nil if there are none to send
+// OutgoingQueueDepth returns the number of outgoing msgs we have queued
+func (mb *MockBackend) OutgoingQueueDepth(ctx context.Context) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	return len(mb.outgoingMsgs), nil
+}
+
+// MediaDownloads returns the number of attachments being downloaded, we never download any
+func (mb *MockBackend) MediaDownloads() int { return 0 }
+
+// PopNextOutgoingMsg returns the next message that should be sent, or nil if there are none to send. This is the
+// first queued message of the highest priority.
 func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (Msg, error) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
-	if len(mb.outgoingMsgs) > 0 {
-		msg, rest := mb.outgoingMsgs[0], mb.outgoingMsgs[1:]
-		mb.outgoingMsgs = rest
+	// msgs requeued with a delay can't be popped until it has passed
+	now := time.Now()
+
+	next := -1
+	for i, msg := range mb.outgoingMsgs {
+		if mb.requeuedUntil[msg].After(now) {
+			continue
+		}
+		if next == -1 || msg.Priority() > mb.outgoingMsgs[next].Priority() {
+			next = i
+		}
+	}
+
+	if next >= 0 {
+		msg := mb.outgoingMsgs[next]
+		mb.outgoingMsgs = append(mb.outgoingMsgs[:next:next], mb.outgoingMsgs[next+1:]...)
+		delete(mb.requeuedUntil, msg)
 		return msg, nil
 	}
 
@@ -162,12 +226,103 @@ func (mb *MockBackend) IsMsgLoop(ctx context.Context, msg Msg) (bool, error) {
 	return false, nil
 }
 
+// IsURNStopped returns whether the passed in URN has stopped receiving msgs on the passed in channel
+func (mb *MockBackend) IsURNStopped(ctx context.Context, channelUUID ChannelUUID, urn urns.URN) (bool, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.stoppedURNs[channelUUID][urn.Identity()], nil
+}
+
+// SetURNStopped records whether the passed in URN has stopped receiving msgs on the passed in channel
+func (mb *MockBackend) SetURNStopped(ctx context.Context, channelUUID ChannelUUID, urn urns.URN, stopped bool) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.stoppedURNs[channelUUID] == nil {
+		mb.stoppedURNs[channelUUID] = make(map[urns.URN]bool)
+	}
+	if stopped {
+		mb.stoppedURNs[channelUUID][urn.Identity()] = true
+	} else {
+		delete(mb.stoppedURNs[channelUUID], urn.Identity())
+	}
+	return nil
+}
+
 // MarkOutgoingMsgComplete marks the passed msg as having been dealt with
 func (mb *MockBackend) MarkOutgoingMsgComplete(ctx context.Context, msg Msg, s MsgStatus) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
-	mb.sentMsgs[msg.ID()] = true
+	// like our real backend, only msgs which were actually sent count as sent
+	if s != nil && (s.Status() == MsgSent || s.Status() == MsgWired) {
+		mb.sentMsgs[msg.ID()] = true
+	}
+
+	if _, completed := mb.completedOn[msg.ID()]; !completed {
+		mb.completedMsgs = append(mb.completedMsgs, msg)
+	}
+	mb.completedOn[msg.ID()] = time.Now()
+}
+
+// RequeueOutgoingMsg puts the passed in msg back at the end of our outgoing queue, to be popped once the delay has passed
+func (mb *MockBackend) RequeueOutgoingMsg(ctx context.Context, msg Msg, delay time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
+	mb.requeuedMsgs = append(mb.requeuedMsgs, msg)
+	mb.requeuedUntil[msg] = time.Now().Add(delay)
+	return nil
+}
+
+// GetOutgoingMsgs returns the msgs we've finished sending which match the passed in query, the status of each being
+// the last status written for it
+func (mb *MockBackend) GetOutgoingMsgs(ctx context.Context, query *OutgoingMsgQuery) ([]Msg, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	statuses := make(map[MsgID]MsgStatusValue)
+	for _, status := range mb.msgStatuses {
+		statuses[status.ID()] = status.Status()
+	}
+
+	matches := func(msg Msg) bool {
+		if len(query.IDs) > 0 {
+			for _, id := range query.IDs {
+				if id == msg.ID() {
+					return true
+				}
+			}
+			return false
+		}
+		completedOn := mb.completedOn[msg.ID()]
+		return msg.Channel().UUID() == query.ChannelUUID && !completedOn.Before(query.After) && completedOn.Before(query.Before)
+	}
+
+	msgs := make([]Msg, 0)
+	for _, msg := range mb.completedMsgs {
+		if !matches(msg) {
+			continue
+		}
+		for _, status := range query.Statuses {
+			if statuses[msg.ID()] == status {
+				msgs = append(msgs, msg)
+				break
+			}
+		}
+	}
+	return msgs, nil
+}
+
+// QueueOutgoingMsg puts the passed in msg at the end of our outgoing queue
+func (mb *MockBackend) QueueOutgoingMsg(ctx context.Context, msg Msg) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
+	return nil
 }
 
 // WriteChannelLogs writes the passed in channel logs to the DB
@@ -199,11 +354,47 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m Msg) error {
 		return errors.New("unable to queue message")
 	}
 
+	// we already have a msg with the same external id (or content and timestamp), treat this as a duplicate
+	dedupeKey := MsgDedupeKey(m)
+	if prevUUID, found := mb.writtenMsgs[dedupeKey]; found && dedupeKey != "" {
+		mock.uuid = prevUUID
+		mock.alreadyWritten = true
+		return nil
+	}
+
+	if dedupeKey != "" {
+		mb.writtenMsgs[dedupeKey] = m.UUID()
+	}
 	mb.queueMsgs = append(mb.queueMsgs, m)
 	mb.lastContactName = m.(*mockMsg).contactName
 	return nil
 }
 
+// SaveAttachment saves the passed in attachment data in memory, returning a fake URL for it
+func (mb *MockBackend) SaveAttachment(ctx context.Context, channel Channel, contentType string, data []byte) (string, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	url := fmt.Sprintf("https://backend.com/attachments/%d", len(mb.attachments)+1)
+	mb.attachments = append(mb.attachments, data)
+	return url, nil
+}
+
+// FetchMedia makes the passed in request for media, reading at most maxSize bytes of its body
+func (mb *MockBackend) FetchMedia(ctx context.Context, req *http.Request, maxSize int) (*http.Response, []byte, error) {
+	resp, err := utils.GetHTTPClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ReadAttachment(resp.Body, maxSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
 // NewMsgStatusForID creates a new Status object for the given message id
 func (mb *MockBackend) NewMsgStatusForID(channel Channel, id MsgID, status MsgStatusValue) MsgStatus {
 	return &mockMsgStatus{
@@ -224,11 +415,49 @@ func (mb *MockBackend) NewMsgStatusForExternalID(channel Channel, externalID str
 	}
 }
 
+// SetErrorOnMsgStatus is a mock method which makes writing status updates for the passed in msg id fail
+func (mb *MockBackend) SetErrorOnMsgStatus(id MsgID) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.errorOnStatuses == nil {
+		mb.errorOnStatuses = make(map[MsgID]bool)
+	}
+	mb.errorOnStatuses[id] = true
+}
+
 // WriteMsgStatus writes the status update to our queue
 func (mb *MockBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
+	return mb.writeMsgStatus(status)
+}
+
+// WriteMsgStatuses writes the status updates to our queue in a single batch
+func (mb *MockBackend) WriteMsgStatuses(ctx context.Context, statuses []MsgStatus) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	errs := make(map[int]error)
+	for i, status := range statuses {
+		err := mb.writeMsgStatus(status)
+		if err != nil {
+			errs[i] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MsgStatusesError{Errors: errs, Total: len(statuses)}
+	}
+	return nil
+}
+
+func (mb *MockBackend) writeMsgStatus(status MsgStatus) error {
+	if mb.errorOnStatuses[status.ID()] {
+		return fmt.Errorf("unable to write status for msg: %s", status.ID())
+	}
+
 	mb.msgStatuses = append(mb.msgStatuses, status)
 	return nil
 }
@@ -252,8 +481,18 @@ func (mb *MockBackend) WriteChannelEvent(ctx context.Context, event ChannelEvent
 	return nil
 }
 
+// SetErrorOnGetChannel is a mock method which makes GetChannel and GetChannelByAddress return the passed in error,
+// pass nil to have them look up channels again
+func (mb *MockBackend) SetErrorOnGetChannel(err error) {
+	mb.errorOnGetChannel = err
+}
+
 // GetChannel returns the channel with the passed in type and channel uuid
 func (mb *MockBackend) GetChannel(ctx context.Context, cType ChannelType, uuid ChannelUUID) (Channel, error) {
+	if mb.errorOnGetChannel != nil {
+		return nil, mb.errorOnGetChannel
+	}
+
 	channel, found := mb.channels[uuid]
 	if !found {
 		return nil, ErrChannelNotFound
@@ -263,6 +502,10 @@ func (mb *MockBackend) GetChannel(ctx context.Context, cType ChannelType, uuid C
 
 // GetChannelByAddress returns the channel with the passed in type and channel address
 func (mb *MockBackend) GetChannelByAddress(ctx context.Context, cType ChannelType, address ChannelAddress) (Channel, error) {
+	if mb.errorOnGetChannel != nil {
+		return nil, mb.errorOnGetChannel
+	}
+
 	channel, found := mb.channelsByAddress[address]
 	if !found {
 		return nil, ErrChannelNotFound
@@ -325,6 +568,7 @@ func (mb *MockBackend) ClearQueueMsgs() {
 // ClearSeenExternalIDs clears our mock seen external ids
 func (mb *MockBackend) ClearSeenExternalIDs() {
 	mb.seenExternalIDs = nil
+	mb.writtenMsgs = make(map[string]MsgUUID)
 }
 
 // LenQueuedMsgs Get the length of queued msgs
@@ -355,9 +599,37 @@ func (mb *MockBackend) Health() string {
 	return ""
 }
 
-// Status returns a string describing the status of the service, queue size etc..
+// HealthDetails checks our connection to redis, the only service our mock depends on
+func (mb *MockBackend) HealthDetails() map[string]HealthStatus {
+	redis := CheckHealthOf("redis", func() error {
+		rc := mb.redisPool.Get()
+		defer rc.Close()
+		_, err := rc.Do("PING")
+		return err
+	})
+
+	return map[string]HealthStatus{"redis": redis}
+}
+
+// Status returns a string describing the status of the service, for our mock the number of queued msgs of each priority
 func (mb *MockBackend) Status() string {
-	return ""
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if len(mb.outgoingMsgs) == 0 {
+		return ""
+	}
+
+	sizes := make(map[MsgPriority]int)
+	for _, msg := range mb.outgoingMsgs {
+		sizes[msg.Priority()]++
+	}
+
+	status := bytes.Buffer{}
+	for _, priority := range MsgPriorities {
+		status.WriteString(fmt.Sprintf("%-6s queue: %d\n", priority, sizes[priority]))
+	}
+	return status.String()
 }
 
 // Heartbeat is a noop for our mock backend
@@ -440,7 +712,7 @@ func (c *MockChannel) CallbackDomain(fallbackDomain string) string {
 func (c *MockChannel) ConfigForKey(key string, defaultValue interface{}) interface{} {
 	value, found := c.config[key]
 	if !found {
-		return defaultValue
+		return DefaultConfigForKey(c.channelType, key, defaultValue)
 	}
 	return value
 }
@@ -459,10 +731,19 @@ func (c *MockChannel) StringConfigForKey(key string, defaultValue string) string
 func (c *MockChannel) BoolConfigForKey(key string, defaultValue bool) bool {
 	val := c.ConfigForKey(key, defaultValue)
 	b, isBool := val.(bool)
-	if !isBool {
-		return defaultValue
+	if isBool {
+		return b
 	}
-	return b
+
+	// config set through forms may have saved it as a string
+	str, isStr := val.(string)
+	if isStr {
+		b, err := strconv.ParseBool(strings.TrimSpace(str))
+		if err == nil {
+			return b
+		}
+	}
+	return defaultValue
 }
 
 // IntConfigForKey returns the config value for the passed in key
@@ -475,15 +756,17 @@ func (c *MockChannel) IntConfigForKey(key string, defaultValue int) int {
 		return int(f)
 	}
 
-	// test authors may use literal ints
-	i, isInt := val.(int)
-	if isInt {
-		return i
+	// test authors and handler defaults may use literal ints
+	switch v := val.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
 	}
 
 	str, isStr := val.(string)
 	if isStr {
-		i, err := strconv.Atoi(str)
+		i, err := strconv.Atoi(strings.TrimSpace(str))
 		if err == nil {
 			return i
 		}
@@ -491,6 +774,17 @@ func (c *MockChannel) IntConfigForKey(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// RequireConfig returns an error for the first of the passed in keys which we have no value for
+func (c *MockChannel) RequireConfig(keys ...string) error {
+	for _, key := range keys {
+		val := c.ConfigForKey(key, nil)
+		if val == nil || val == "" {
+			return &MissingConfigError{ChannelType: c.channelType, Key: key}
+		}
+	}
+	return nil
+}
+
 // OrgConfigForKey returns the org config value for the passed in key
 func (c *MockChannel) OrgConfigForKey(key string, defaultValue interface{}) interface{} {
 	value, found := c.orgConfig[key]
@@ -530,64 +824,103 @@ type mockMsg struct {
 	urn                  urns.URN
 	urnAuth              string
 	contactName          string
-	highPriority         bool
+	priority             MsgPriority
 	quickReplies         []string
 	topic                string
 	responseToID         MsgID
 	responseToExternalID string
 	metadata             json.RawMessage
 	alreadyWritten       bool
+	idempotencyKey       string
+	statusCallback       string
+	location             *LatLon
+	contactCards         []ContactCard
 
 	receivedOn *time.Time
 	sentOn     *time.Time
 	wiredOn    *time.Time
+	sendAt     *time.Time
+	expiresAt  *time.Time
+	createdOn  time.Time
 }
 
-func (m *mockMsg) Channel() Channel             { return m.channel }
-func (m *mockMsg) ID() MsgID                    { return m.id }
-func (m *mockMsg) EventID() int64               { return int64(m.id) }
-func (m *mockMsg) UUID() MsgUUID                { return m.uuid }
-func (m *mockMsg) Text() string                 { return m.text }
-func (m *mockMsg) Attachments() []string        { return m.attachments }
-func (m *mockMsg) ExternalID() string           { return m.externalID }
-func (m *mockMsg) URN() urns.URN                { return m.urn }
-func (m *mockMsg) URNAuth() string              { return m.urnAuth }
-func (m *mockMsg) ContactName() string          { return m.contactName }
-func (m *mockMsg) HighPriority() bool           { return m.highPriority }
-func (m *mockMsg) QuickReplies() []string       { return m.quickReplies }
-func (m *mockMsg) Topic() string                { return m.topic }
-func (m *mockMsg) ResponseToID() MsgID          { return m.responseToID }
-func (m *mockMsg) ResponseToExternalID() string { return m.responseToExternalID }
-func (m *mockMsg) Metadata() json.RawMessage    { return m.metadata }
+func (m *mockMsg) Channel() Channel                    { return m.channel }
+func (m *mockMsg) ID() MsgID                           { return m.id }
+func (m *mockMsg) EventID() int64                      { return int64(m.id) }
+func (m *mockMsg) UUID() MsgUUID                       { return m.uuid }
+func (m *mockMsg) Text() string                        { return m.text }
+func (m *mockMsg) Attachments() []string               { return m.attachments }
+func (m *mockMsg) ExternalID() string                  { return m.externalID }
+func (m *mockMsg) URN() urns.URN                       { return m.urn }
+func (m *mockMsg) URNAuth() string                     { return m.urnAuth }
+func (m *mockMsg) ContactName() string                 { return m.contactName }
+func (m *mockMsg) HighPriority() bool                  { return m.priority >= MsgPriorityNormal }
+func (m *mockMsg) Priority() MsgPriority               { return m.priority }
+func (m *mockMsg) StatusCallback() string              { return m.statusCallback }
+func (m *mockMsg) AlreadyWritten() bool                { return m.alreadyWritten }
+func (m *mockMsg) QuickReplies() []string              { return m.quickReplies }
+func (m *mockMsg) Topic() string                       { return m.topic }
+func (m *mockMsg) ResponseToID() MsgID                 { return m.responseToID }
+func (m *mockMsg) ResponseToExternalID() string        { return m.responseToExternalID }
+func (m *mockMsg) Metadata() json.RawMessage           { return m.metadata }
+func (m *mockMsg) Templating() (*MsgTemplating, error) { return MsgTemplatingFromMetadata(m.metadata) }
+func (m *mockMsg) Location() *LatLon                   { return m.location }
+func (m *mockMsg) ContactCards() []ContactCard         { return m.contactCards }
 
 func (m *mockMsg) ReceivedOn() *time.Time { return m.receivedOn }
 func (m *mockMsg) SentOn() *time.Time     { return m.sentOn }
 func (m *mockMsg) WiredOn() *time.Time    { return m.wiredOn }
-
-func (m *mockMsg) WithContactName(name string) Msg   { m.contactName = name; return m }
-func (m *mockMsg) WithURNAuth(auth string) Msg       { m.urnAuth = auth; return m }
-func (m *mockMsg) WithReceivedOn(date time.Time) Msg { m.receivedOn = &date; return m }
-func (m *mockMsg) WithExternalID(id string) Msg      { m.externalID = id; return m }
-func (m *mockMsg) WithID(id MsgID) Msg               { m.id = id; return m }
-func (m *mockMsg) WithUUID(uuid MsgUUID) Msg         { m.uuid = uuid; return m }
+func (m *mockMsg) SendAt() *time.Time     { return m.sendAt }
+func (m *mockMsg) ExpiresAt() *time.Time  { return m.expiresAt }
+func (m *mockMsg) CreatedOn() time.Time   { return m.createdOn }
+
+func (m *mockMsg) WithContactName(name string) Msg { m.contactName = name; return m }
+func (m *mockMsg) WithURNAuth(auth string) Msg     { m.urnAuth = auth; return m }
+func (m *mockMsg) WithReceivedOn(date time.Time) Msg {
+	date = date.UTC()
+	m.receivedOn = &date
+	return m
+}
+func (m *mockMsg) WithExternalID(id string) Msg { m.externalID = id; return m }
+func (m *mockMsg) WithID(id MsgID) Msg          { m.id = id; return m }
+func (m *mockMsg) WithUUID(uuid MsgUUID) Msg    { m.uuid = uuid; return m }
 func (m *mockMsg) WithAttachment(url string) Msg {
 	m.attachments = append(m.attachments, url)
 	return m
 }
 func (m *mockMsg) WithMetadata(metadata json.RawMessage) Msg { m.metadata = metadata; return m }
+func (m *mockMsg) WithIdempotencyKey(key string) Msg         { m.idempotencyKey = key; return m }
+func (m *mockMsg) WithPriority(priority MsgPriority) Msg     { m.priority = priority; return m }
+func (m *mockMsg) WithStatusCallback(url string) Msg         { m.statusCallback = url; return m }
+func (m *mockMsg) WithSendAt(date time.Time) Msg             { m.sendAt = &date; return m }
+func (m *mockMsg) WithExpiresAt(date time.Time) Msg          { m.expiresAt = &date; return m }
+func (m *mockMsg) WithLocation(location LatLon) Msg          { m.location = &location; return m }
+func (m *mockMsg) WithContactCard(card ContactCard) Msg {
+	m.contactCards = append(m.contactCards, card)
+	return m
+}
+
+func (m *mockMsg) IdempotencyKey() string {
+	if m.idempotencyKey != "" {
+		return m.idempotencyKey
+	}
+	return m.externalID
+}
 
 //-----------------------------------------------------------------------------
 // Mock status implementation
 //-----------------------------------------------------------------------------
 
 type mockMsgStatus struct {
-	channel    Channel
-	id         MsgID
-	oldURN     urns.URN
-	newURN     urns.URN
-	externalID string
-	status     MsgStatusValue
-	createdOn  time.Time
+	channel       Channel
+	id            MsgID
+	oldURN        urns.URN
+	newURN        urns.URN
+	externalID    string
+	status        MsgStatusValue
+	errorCategory SendErrorCategory
+	createdOn     time.Time
+	occurredOn    *time.Time
 
 	logs []*ChannelLog
 }
@@ -617,6 +950,17 @@ func (m *mockMsgStatus) SetExternalID(id string) { m.externalID = id }
 func (m *mockMsgStatus) Status() MsgStatusValue          { return m.status }
 func (m *mockMsgStatus) SetStatus(status MsgStatusValue) { m.status = status }
 
+func (m *mockMsgStatus) OccurredOn() time.Time {
+	if m.occurredOn != nil {
+		return *m.occurredOn
+	}
+	return m.createdOn
+}
+func (m *mockMsgStatus) SetOccurredOn(date time.Time) { date = date.UTC(); m.occurredOn = &date }
+
+func (m *mockMsgStatus) ErrorCategory() SendErrorCategory            { return m.errorCategory }
+func (m *mockMsgStatus) SetErrorCategory(category SendErrorCategory) { m.errorCategory = category }
+
 func (m *mockMsgStatus) Logs() []*ChannelLog    { return m.logs }
 func (m *mockMsgStatus) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
 
@@ -0,0 +1,148 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the name of the tracer all our spans are created by
+const tracerName = "github.com/nyaruka/courier"
+
+// StartSpan starts a new span as a child of the span in the passed in context, returning a context with the new
+// span in it. If the context has no span, e.g. because tracing isn't enabled, the returned span is a no-op.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name)
+}
+
+// setSpanError records the passed in error on the passed in span, if there is one
+func setSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// newTracerProvider creates the provider of our tracer, spans aren't exported until an exporter is registered on it
+func newTracerProvider() *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String("courier"))))
+}
+
+// newSpanExporter creates an exporter which sends spans over OTLP/HTTP to the passed in URL,
+// e.g. http://localhost:4318/v1/traces
+func newSpanExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid tracing endpoint '%s'", endpoint)
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Path != "" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// startTracing starts exporting our spans in batches to our tracing endpoint until we are stopped, when we export
+// whatever is left and calls done
+func (s *server) startTracing(done func()) error {
+	exporter, err := newSpanExporter(s.config.TracingEndpoint)
+	if err != nil {
+		return err
+	}
+	s.tracerProvider.RegisterSpanProcessor(sdktrace.NewBatchSpanProcessor(exporter))
+
+	go func() {
+		defer done()
+		<-s.stopChan
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		if err := s.tracerProvider.Shutdown(ctx); err != nil {
+			logrus.WithField("comp", "tracer").WithError(err).Error("error exporting spans")
+		}
+	}()
+	return nil
+}
+
+// spanTracer returns the tracer our spans are created by, a no-op one if we aren't tracing
+func (s *server) spanTracer() trace.Tracer {
+	if s.tracerProvider == nil {
+		return trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+	return s.tracerProvider.Tracer(tracerName)
+}
+
+// traceRequests is our middleware which starts a root span for each request, tagged with its request ID so traces
+// and logs can be correlated. Requests with a W3C traceparent header continue the trace in it.
+func (s *server) traceRequests(next http.Handler) http.Handler {
+	tracer := s.spanTracer()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("request_id", middleware.GetReqID(ctx)),
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.Path),
+		))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(ww.Status()))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(ww.Status()))
+	})
+}
+
+// tracingBackend wraps a backend, recording spans for the msgs and statuses written through it
+type tracingBackend struct {
+	Backend
+}
+
+// WriteMsg writes the passed in msg to our wrapped backend as a child span of the span in the passed in context
+func (b *tracingBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	ctx, span := StartSpan(ctx, "write msg")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("channel_uuid", msg.Channel().UUID().String()),
+		attribute.String("msg_uuid", msg.UUID().String()),
+	)
+
+	err := b.Backend.WriteMsg(ctx, msg)
+	setSpanError(span, err)
+	return err
+}
+
+// WriteMsgStatus writes the passed in status to our wrapped backend as a child span of the span in the passed in context
+func (b *tracingBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	ctx, span := StartSpan(ctx, "write msg status")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("channel_uuid", status.ChannelUUID().String()),
+		attribute.String("msg_id", status.ID().String()),
+		attribute.String("status", string(status.Status())),
+	)
+
+	err := b.Backend.WriteMsgStatus(ctx, status)
+	setSpanError(span, err)
+	return err
+}
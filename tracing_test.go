@@ -0,0 +1,158 @@
+package courier
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// records the spans of the passed in server in memory as they finish
+func recordSpans(s *server) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	s.tracerProvider.RegisterSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter))
+	return exporter
+}
+
+// returns the exported span with the passed in name
+func findSpan(exporter *tracetest.InMemoryExporter, name string) *tracetest.SpanStub {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return &span
+		}
+	}
+	return nil
+}
+
+// returns the value of the attribute of the passed in span with the passed in key
+func spanAttribute(span *tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == attribute.Key(key) {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTraceRequests(t *testing.T) {
+	config := testConfig()
+	config.EnableTracing = true
+	config.TracingEndpoint = "http://localhost:4318/v1/traces"
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb).(*server)
+	exporter := recordSpans(s)
+
+	handler := &dummyHandler{}
+	handler.Initialize(s)
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 3, len(exporter.GetSpans()))
+
+	// our request is the root of our trace, tagged with its request ID
+	root := findSpan(exporter, "GET /c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive")
+	if assert.NotNil(t, root) {
+		assert.False(t, root.Parent.IsValid())
+		assert.NotEqual(t, "", spanAttribute(root, "request_id"))
+		assert.Equal(t, "200", spanAttribute(root, "http.status_code"))
+		assert.Equal(t, codes.Unset, root.Status.Code)
+	}
+
+	// handling it is a child of that
+	handle := findSpan(exporter, "handle DM receive")
+	if assert.NotNil(t, handle) {
+		assert.Equal(t, root.SpanContext.TraceID(), handle.SpanContext.TraceID())
+		assert.Equal(t, root.SpanContext.SpanID(), handle.Parent.SpanID())
+		assert.Equal(t, "e4bb1578-29da-4fa5-a214-9da19dd24230", spanAttribute(handle, "channel_uuid"))
+	}
+
+	// and writing our msg a child of handling
+	write := findSpan(exporter, "write msg")
+	if assert.NotNil(t, write) {
+		assert.Equal(t, root.SpanContext.TraceID(), write.SpanContext.TraceID())
+		assert.Equal(t, handle.SpanContext.SpanID(), write.Parent.SpanID())
+		assert.False(t, write.EndTime.Before(write.StartTime))
+	}
+
+	// requests which are already part of a trace continue it
+	exporter.Reset()
+	r := httptest.NewRequest("GET", "/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive?from=2065551212&text=hello", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.router.ServeHTTP(httptest.NewRecorder(), r)
+
+	root = findSpan(exporter, "GET /c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive")
+	if assert.NotNil(t, root) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", root.Parent.SpanID().String())
+		assert.True(t, root.Parent.IsRemote())
+	}
+
+	// and requests which error have errored spans
+	exporter.Reset()
+	s.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive", nil))
+
+	handle = findSpan(exporter, "handle DM receive")
+	if assert.NotNil(t, handle) {
+		assert.Equal(t, codes.Error, handle.Status.Code)
+	}
+}
+
+func TestTraceSends(t *testing.T) {
+	config := testConfig()
+	config.EnableTracing = true
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb).(*server)
+	exporter := recordSpans(s)
+
+	handler := &dummyHandler{server: s, backend: s.Backend()}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	msg := &mockMsg{channel: channel, id: NewMsgID(101), text: "hello", urn: "tel:+250788383383"}
+	NewForeman(s, 1).senders[0].sendMessage(msg)
+
+	assert.Equal(t, 3, len(exporter.GetSpans()))
+
+	// each send is a trace of its own, with sending to the provider and writing the status within it
+	root := findSpan(exporter, "send msg")
+	if assert.NotNil(t, root) {
+		assert.False(t, root.Parent.IsValid())
+		assert.Equal(t, "101", spanAttribute(root, "msg_id"))
+	}
+	for _, name := range []string{"send to provider", "write msg status"} {
+		span := findSpan(exporter, name)
+		if assert.NotNil(t, span, name) {
+			assert.Equal(t, root.SpanContext.TraceID(), span.SpanContext.TraceID())
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID())
+		}
+	}
+	assert.Equal(t, "S", spanAttribute(findSpan(exporter, "write msg status"), "status"))
+}
+
+func TestNoTracing(t *testing.T) {
+	s := NewServer(testConfig(), NewMockBackend()).(*server)
+	assert.Nil(t, s.tracerProvider)
+
+	// without tracing, spans are no-ops
+	r := httptest.NewRequest("GET", "/", nil)
+	_, span := StartSpan(r.Context(), "write msg")
+	assert.False(t, span.IsRecording())
+	assert.False(t, span.SpanContext().IsValid())
+	span.End()
+
+	// and enabling it without a valid endpoint is an error
+	config := testConfig()
+	config.EnableTracing = true
+	assert.EqualError(t, NewServer(config, NewMockBackend()).Start(), "tracing is enabled but no tracing endpoint is set")
+
+	config.TracingEndpoint = "localhost:4318"
+	assert.EqualError(t, NewServer(config, NewMockBackend()).Start(), "invalid tracing endpoint 'localhost:4318'")
+}
@@ -0,0 +1,89 @@
+package courier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we remember what a provider told us about a URN, so a burst of msgs from a contact only looks them up once
+const urnDescriptionTTL = 5 * time.Minute
+
+type urnDescriptionKey struct {
+	channelUUID ChannelUUID
+	urn         urns.URN
+}
+
+type urnDescription struct {
+	attributes map[string]string
+	expiration time.Time
+}
+
+// urnDescriberBackend wraps a backend, describing the URNs of incoming msgs written through it which don't have a
+// contact name using their handler, if it is a URNDescriber, and setting the name it returns on them
+type urnDescriberBackend struct {
+	Backend
+
+	mutex        sync.RWMutex
+	descriptions map[urnDescriptionKey]*urnDescription
+}
+
+func newURNDescriberBackend(backend Backend) *urnDescriberBackend {
+	return &urnDescriberBackend{
+		Backend:      backend,
+		descriptions: make(map[urnDescriptionKey]*urnDescription),
+	}
+}
+
+// WriteMsg writes the passed in msg to our wrapped backend, once we've described its URN if it needs it
+func (b *urnDescriberBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	if msg.ContactName() == "" {
+		attributes := b.describeURN(ctx, msg.Channel(), msg.URN())
+		if attributes["name"] != "" {
+			msg.WithContactName(attributes["name"])
+		}
+	}
+	return b.Backend.WriteMsg(ctx, msg)
+}
+
+// describeURN returns the attributes of the passed in URN, from our cache if we described it recently, or nil if
+// its handler can't describe URNs or fails to
+func (b *urnDescriberBackend) describeURN(ctx context.Context, channel Channel, urn urns.URN) map[string]string {
+	describer, isDescriber := activeHandlers[channel.ChannelType()].(URNDescriber)
+	if !isDescriber {
+		return nil
+	}
+
+	key := urnDescriptionKey{channel.UUID(), urn}
+
+	b.mutex.RLock()
+	description, found := b.descriptions[key]
+	b.mutex.RUnlock()
+
+	if found && description.expiration.After(time.Now()) {
+		return description.attributes
+	}
+
+	attributes, err := describer.DescribeURN(ctx, channel, urn)
+
+	// in the case of errors, we log the error but write the msg anyways
+	if err != nil {
+		logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("urn", urn.Identity()).WithError(err).Error("unable to describe URN")
+		return nil
+	}
+
+	b.mutex.Lock()
+	now := time.Now()
+	for k, d := range b.descriptions {
+		if d.expiration.Before(now) {
+			delete(b.descriptions, k)
+		}
+	}
+	b.descriptions[key] = &urnDescription{attributes: attributes, expiration: now.Add(urnDescriptionTTL)}
+	b.mutex.Unlock()
+
+	return attributes
+}
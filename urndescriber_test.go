@@ -0,0 +1,71 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// describerHandler is a handler which describes URNs by looking up a display name for them
+type describerHandler struct {
+	dummyHandler
+	names        map[urns.URN]string
+	descriptions int
+}
+
+func (h *describerHandler) ChannelType() ChannelType { return ChannelType("DS") }
+
+func (h *describerHandler) DescribeURN(ctx context.Context, channel Channel, urn urns.URN) (map[string]string, error) {
+	h.descriptions++
+	name, found := h.names[urn]
+	if !found {
+		return nil, errors.New("profile not found")
+	}
+	return map[string]string{"name": name}, nil
+}
+
+func TestDescribeIncomingURNs(t *testing.T) {
+	ctx := context.Background()
+	mb := NewMockBackend()
+	channel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DS", "2020", "US", map[string]interface{}{})
+	bob := urns.URN("facebook:12345")
+	unknown := urns.URN("facebook:67890")
+
+	config := testConfig()
+	config.DescribeIncomingURNs = true
+	s := NewServerWithLogger(config, mb, logrus.New()).(*server)
+
+	handler := &describerHandler{dummyHandler: dummyHandler{server: s, backend: s.Backend()}, names: map[urns.URN]string{bob: "Bob"}}
+	activeHandlers[handler.ChannelType()] = handler
+	defer delete(activeHandlers, handler.ChannelType())
+
+	// msgs without a contact name get the one our handler describes
+	assert.NoError(t, s.Backend().WriteMsg(ctx, mb.NewIncomingMsg(channel, bob, "hi")))
+	assert.Equal(t, "Bob", mb.GetLastContactName())
+	assert.Equal(t, 1, handler.descriptions)
+
+	// which we remember for the next msg from them
+	assert.NoError(t, s.Backend().WriteMsg(ctx, mb.NewIncomingMsg(channel, bob, "how are you?")))
+	assert.Equal(t, "Bob", mb.GetLastContactName())
+	assert.Equal(t, 1, handler.descriptions)
+
+	// msgs which already have one are left alone
+	assert.NoError(t, s.Backend().WriteMsg(ctx, mb.NewIncomingMsg(channel, urns.URN("facebook:11111"), "hi").WithContactName("Ann")))
+	assert.Equal(t, "Ann", mb.GetLastContactName())
+	assert.Equal(t, 1, handler.descriptions)
+
+	// and if we can't describe a URN we still write the msg
+	assert.NoError(t, s.Backend().WriteMsg(ctx, mb.NewIncomingMsg(channel, unknown, "hi")))
+	assert.Equal(t, "", mb.GetLastContactName())
+	assert.Equal(t, 2, handler.descriptions)
+
+	// msgs for handlers which can't describe URNs are unaffected
+	other := NewMockChannel("5b9b2d4e-3f6c-4b8e-9f7a-1c2d3e4f5a6b", "DM", "2021", "US", map[string]interface{}{})
+	assert.NoError(t, s.Backend().WriteMsg(ctx, mb.NewIncomingMsg(other, bob, "hi")))
+	assert.Equal(t, "", mb.GetLastContactName())
+	assert.Equal(t, 2, handler.descriptions)
+}
@@ -152,14 +152,7 @@ func newRRFromResponse(method string, requestTrace string, r *http.Response) (*R
 // GetHTTPClient returns the shared HTTP client used by all Courier threads
 func GetHTTPClient() *http.Client {
 	once.Do(func() {
-		transport = http.DefaultTransport.(*http.Transport).Clone()
-		transport.MaxIdleConns = 64
-		transport.MaxIdleConnsPerHost = 8
-		transport.IdleConnTimeout = 15 * time.Second
-		client = &http.Client{
-			Transport: transport,
-			Timeout:   60 * time.Second,
-		}
+		transport, client = newHTTPClient(defaultMaxIdleConns, defaultMaxIdleConnsPerHost, defaultHTTPClientTimeout, nil)
 	})
 
 	return client
@@ -168,20 +161,41 @@ func GetHTTPClient() *http.Client {
 // GetInsecureHTTPClient returns the shared HTTP client used by all Courier threads
 func GetInsecureHTTPClient() *http.Client {
 	insecureOnce.Do(func() {
-		insecureTransport = http.DefaultTransport.(*http.Transport).Clone()
-		insecureTransport.MaxIdleConns = 64
-		insecureTransport.MaxIdleConnsPerHost = 8
-		insecureTransport.IdleConnTimeout = 15 * time.Second
-		insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		insecureClient = &http.Client{
-			Transport: insecureTransport,
-			Timeout:   60 * time.Second,
-		}
+		insecureTransport, insecureClient = newHTTPClient(defaultMaxIdleConns, defaultMaxIdleConnsPerHost, defaultHTTPClientTimeout, &tls.Config{InsecureSkipVerify: true})
 	})
 
 	return insecureClient
 }
 
+// ConfigureHTTPClients replaces our shared HTTP clients with ones which keep at most maxIdleConns idle connections,
+// and at most maxIdleConnsPerHost to any one host so a single busy provider can't take all of them, and which time out
+// requests after timeout. Like HTTPUserAgent, this needs to be set before any requests are made.
+func ConfigureHTTPClients(maxIdleConns int, maxIdleConnsPerHost int, timeout time.Duration) {
+	once.Do(func() {})
+	transport, client = newHTTPClient(maxIdleConns, maxIdleConnsPerHost, timeout, nil)
+
+	insecureOnce.Do(func() {})
+	insecureTransport, insecureClient = newHTTPClient(maxIdleConns, maxIdleConnsPerHost, timeout, &tls.Config{InsecureSkipVerify: true})
+}
+
+func newHTTPClient(maxIdleConns int, maxIdleConnsPerHost int, timeout time.Duration, tlsConfig *tls.Config) (*http.Transport, *http.Client) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = maxIdleConns
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.IdleConnTimeout = 15 * time.Second
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+
+	return t, &http.Client{Transport: t, Timeout: timeout}
+}
+
+const (
+	defaultMaxIdleConns        = 64
+	defaultMaxIdleConnsPerHost = 8
+	defaultHTTPClientTimeout   = 60 * time.Second
+)
+
 var (
 	transport *http.Transport
 	client    *http.Client
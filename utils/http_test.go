@@ -1,6 +1,13 @@
 package utils
 
-import "testing"
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestClient(t *testing.T) {
 	client := GetHTTPClient()
@@ -22,3 +29,43 @@ func TestClient(t *testing.T) {
 		t.Error("GetHTTPClient should always return same client")
 	}
 }
+
+func TestClientReusesConnections(t *testing.T) {
+	var mutex sync.Mutex
+	conns := 0
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mutex.Lock()
+			conns++
+			mutex.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	ConfigureHTTPClients(16, 2, 10*time.Second)
+	defer ConfigureHTTPClients(defaultMaxIdleConns, defaultMaxIdleConnsPerHost, defaultHTTPClientTimeout)
+
+	transport := GetHTTPClient().Transport.(*http.Transport)
+	if transport.MaxIdleConns != 16 || transport.MaxIdleConnsPerHost != 2 || GetHTTPClient().Timeout != 10*time.Second {
+		t.Errorf("client not configured, got %d idle conns, %d per host and %s timeout", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, GetHTTPClient().Timeout)
+	}
+
+	// two sends to the same host should share a connection
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/send", nil)
+		if _, err := MakeHTTPRequest(req); err != nil {
+			t.Fatalf("unexpected error making request: %s", err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if conns != 1 {
+		t.Errorf("expected requests to reuse 1 connection, got %d", conns)
+	}
+}